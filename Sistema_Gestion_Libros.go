@@ -9,52 +9,76 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
 //Definicion de Estructuras
 
-// Administrador
-type Administrador struct {
-	AdministradorID int       `json:"id"`
-	Nombre          string    `json:"nombre"`
-	Mail            string    `json:"mail"`
-	Contrasena      string    `json:"contrasena"`
-	Rol             string    `json:"rol"`
-	FechaCreacion   time.Time `json:"fecha_creacion"`
-	UltimoAcceso    time.Time `json:"ultimo_acceso"`
-}
+// Rol de una cuenta
+type Rol string
+
+const (
+	RolAdministrador Rol = "administrador"
+	RolUsuario       Rol = "usuario"
+)
 
-// Usuario
-type Usuario struct {
-	UsuarioID  int    `json:"id"`
-	Nombre     string `json:"nombre"`
-	Mail       string `json:"mail"`
-	Contrasena string `json:"contrasena"`
-	Rol        string `json:"rol"`
+// Cuenta (administradores y usuarios). Una cuenta puede tener varios roles,
+// por ejemplo un administrador que tambien solicita prestamos como usuario.
+type Cuenta struct {
+	CuentaID          int       `json:"id"`
+	Nombre            string    `json:"nombre"`
+	Mail              string    `json:"mail"`
+	Contrasena        string    `json:"-"` // cifrada; solo se guarda en cuentas.json (ver cuentaArchivo)
+	Roles             []Rol     `json:"roles"`
+	FechaCreacion     time.Time `json:"fecha_creacion"`
+	UltimoAcceso      time.Time `json:"ultimo_acceso"`
+	MailVerificado    bool      `json:"mail_verificado"`
+	Estado            string    `json:"estado"`
+	MotivoRechazo     string    `json:"motivo_rechazo,omitempty"`
+	SujetoOIDC        string    `json:"sujeto_oidc,omitempty"`
+	RolesOIDC         []Rol     `json:"roles_oidc,omitempty"`         // roles que otorgo el proveedor de identidad
+	ConservarProgreso bool      `json:"conservar_progreso,omitempty"` // progreso de lectura al terminar el prestamo
 }
 
 // Inventario
 type Inventario struct {
-	InventarioId int  `json:"id"`
-	LibroID      int  `json:"libro_id"`
-	Disponible   bool `json:"disponible"`
+	InventarioId int    `json:"id"`
+	LibroID      int    `json:"libro_id"`
+	Disponible   bool   `json:"disponible"`
+	CodigoBarras string `json:"codigo_barras,omitempty"`
+	Ubicacion    string `json:"ubicacion,omitempty"`
 }
 
 // Libro
 type Libro struct {
-	LibroID          int    `json:"id"`
-	Titulo           string `json:"titulo"`
-	Autor            string `json:"autor"`
-	FechaPublicacion string `json:"fecha_publicacion"`
-	Genero           string `json:"genero"`
-	Url              string `json:"url"`
+	LibroID          int               `json:"id"`
+	Titulo           string            `json:"titulo"`
+	Autor            string            `json:"autor"`
+	FechaPublicacion FechaParcial      `json:"fecha_publicacion"`
+	Genero           string            `json:"genero"`
+	Url              string            `json:"url"`
+	Subtitulo        string            `json:"subtitulo,omitempty"`
+	Descripcion      string            `json:"descripcion,omitempty"`
+	ISBN             string            `json:"isbn,omitempty"` // ISBN-13 sin guiones
+	Autores          []Participacion   `json:"autores,omitempty"`
+	Materias         []int             `json:"materias,omitempty"` // IDs del vocabulario de materias
+	ObraID           int               `json:"obra_id,omitempty"`  // obra de la que es una edicion
+	Edicion          string            `json:"edicion,omitempty"`
+	Idioma           string            `json:"idioma,omitempty"`
+	Editorial        string            `json:"editorial,omitempty"`
+	Archivos         []ArchivoLibro    `json:"archivos,omitempty"`   // EPUB o PDF en el almacen de archivos
+	Licencias        int               `json:"licencias,omitempty"`  // prestamos digitales simultaneos
+	Portada          string            `json:"portada,omitempty"`    // hash de la imagen en el almacen de archivos
+	Miniaturas       map[string]string `json:"miniaturas,omitempty"` // hash de cada miniatura de la portada por tamano
 }
 
 // Prestamo
@@ -64,6 +88,9 @@ type Prestamo struct {
 	UsuarioID       int       `json:"usuario_id"`
 	FechaReserva    time.Time `json:"fecha_reserva"`
 	FechaDevolucion time.Time `json:"fecha_devolucion"`
+	Digital         bool      `json:"digital,omitempty"`       // usa una licencia digital en lugar de un ejemplar
+	InventarioID    int       `json:"inventario_id,omitempty"` // ejemplar prestado, en los prestamos fisicos
+	Devuelto        bool      `json:"devuelto,omitempty"`
 }
 
 // Respuesta de JSON
@@ -80,10 +107,10 @@ type Permisos interface {
 	AdministrarUsuario() bool
 }
 
-// Interfaz para realizar busquedas
-type Busqueda interface {
-	BuscarID(id int) (interface{}, error)
-	BuscarNombre(nombre string) ([]interface{}, error)
+// Interfaz para realizar busquedas; T es el tipo que se busca, por ejemplo *Libro
+type Busqueda[T any] interface {
+	BuscarID(id int) (T, error)
+	BuscarNombre(nombre string) ([]T, error)
 }
 
 // Interfaz para realizar serializacion
@@ -117,15 +144,30 @@ var homeTemplate = template.Must(template.New("Home").Parse(`
 		<li><a href="/visualizar-admin">Visualizar Administrador</a></li> 
 		<li><a href="/visualizar-libro">Visualizar Libro</a></li> 
 		<li><a href="/visualizar-user">Visualizar Usuario</a></li>
+		<li><a href="/visualizar-cuentas">Visualizar Cuentas</a></li>
 	</ul>
 	<h2>Búsqueda: </h2> 
 	<ul>
 		<li><a href="/buscar-libro">Buscar Libro por ID</a></li>
+		<li><a href="/buscar-libro-nombre">Buscar Libro por Nombre</a></li>
+		<li><a href="/buscar">Buscar en el Catálogo</a></li>
+		<li><a href="/catalogo">Navegar el Catálogo</a></li>
 	</ul>
 	<h2>Validar Permisos: </h2> 
 	<ul>
 		<li><a href="/validar-permisos">Consultar permisos</a></li>
 	</ul>
+	<h2>Cuenta: </h2> 
+	<ul>
+		<li><a href="/registro">Solicitar una cuenta</a></li>
+		<li><a href="/login">Iniciar sesión</a></li>
+		<li><a href="/olvide-contrasena">Olvidé mi contraseña</a></li>
+		<li><a href="/solicitudes">Solicitudes de registro pendientes</a></li>
+	</ul>
+	<form action="/logout" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<button type="submit">Cerrar sesión</button>
+	</form>
 	<footer>
 	<p>Vuelve pronto</p>
 	</footer>
@@ -144,6 +186,7 @@ var createAdmin = template.Must(template.New("create").Parse(`
 <body>
     <h1>Crear Nuevo Administrador</h1>
     <form action="/crear-admin" method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
         <label for="id">ID:</label>
         <input type="number" id="id" name="id" required><br>
         <label for="nombre">Nombre:</label>
@@ -152,8 +195,6 @@ var createAdmin = template.Must(template.New("create").Parse(`
         <input type="email" id="mail" name="mail" required><br>
         <label for="contrasena">Contraseña:</label>
         <input type="password" id="contrasena" name="contrasena" required><br>
-        <label for="rol">Rol:</label>
-        <input type="text" id="rol" name="rol" required><br>
         <button type="submit">Crear</button>
     </form>
     <footer>
@@ -174,6 +215,7 @@ var createUser = template.Must(template.New("create").Parse(`
 <body>
     <h1>Crear Nuevo Usuario</h1>
     <form action="/crear-user" method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
         <label for="id">ID:</label>
         <input type="number" id="id" name="id" required><br>
         <label for="nombre">Nombre:</label>
@@ -183,7 +225,10 @@ var createUser = template.Must(template.New("create").Parse(`
         <label for="contrasena">Contraseña:</label>
         <input type="password" id="contrasena" name="contrasena" required><br>
         <label for="rol">Rol:</label>
-        <input type="text" id="rol" name="rol" required><br>
+        <select id="rol" name="rol">
+            <option value="usuario">Usuario</option>
+            <option value="administrador">Administrador</option>
+        </select><br>
         <button type="submit">Crear</button>
     </form>
     <footer>
@@ -202,20 +247,53 @@ var createBook = template.Must(template.New("create").Parse(`
     <title>Crear Libro</title>
 </head>
 <body>
-	<h1>Crear Nuevo Libro</h1> 
-	<form action="/crear-book" method="post"> 
+	<h1>{{if .Propuesta}}Revisar Libro Importado{{else}}Crear Nuevo Libro{{end}}</h1> 
+	{{with .Propuesta}}
+	<p>Datos leídos de {{.Archivo.Nombre}}. Revíselos antes de crear el libro.</p>
+	{{if .Avisos}}<ul>{{range .Avisos}}<li>{{.}}</li>{{end}}</ul>{{end}}
+	{{end}}
+	<form action="/crear-book" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		{{with .Propuesta}}
+		<input type="hidden" name="importacion" value="{{.Importacion}}">
+		{{end}}
 		<label for="id">ID:</label> 
-		<input type="number" id="id" name="id" required><br>
+		<input type="number" id="id" name="id" value="{{with .Propuesta}}{{.LibroID}}{{end}}" required><br>
 		<label for="titulo">Título:</label> 
-		<input type="text" id="titulo" name="titulo" required><br> 
+		<input type="text" id="titulo" name="titulo" value="{{with .Propuesta}}{{.Titulo}}{{end}}" required><br> 
 		<label for="autor">Autor:</label> 
-		<input type="text" id="autor" name="autor" required><br> 
-		<label for="fechaPublicacion">Fecha de Publicación (YYYY - MONTH):</label> 
-		<input type="text" id="fechaPublicacion" name="fechaPublicacion" required><br> 
-		<label for="genero">Género:</label> 
-		<input type="text" id="genero" name="genero" required><br> 
+		<input type="text" id="autor" name="autor" value="{{with .Propuesta}}{{.Autor}}{{end}}" required><br> 
+		<label for="traductor">Traductor:</label> 
+		<input type="text" id="traductor" name="traductor" value="{{with .Propuesta}}{{.Traductor}}{{end}}"><br> 
+		<label for="editor">Editor:</label> 
+		<input type="text" id="editor" name="editor" value="{{with .Propuesta}}{{.Editor}}{{end}}"><br> 
+		<label for="fechaPublicacion">Fecha de Publicación (2024, 2024-09, 2024-09-21 o 21 de septiembre de 2024):</label> 
+		<input type="text" id="fechaPublicacion" name="fechaPublicacion" value="{{with .Propuesta}}{{.Fecha}}{{end}}" required><br> 
+		<label for="materias">Materias:</label> 
+		<select id="materias" name="materias" multiple required>
+			{{range .Materias}}<option value="{{.ID}}"{{if .Seleccionada}} selected{{end}}>{{.Etiqueta}}</option>{{end}}
+		</select><br> 
 		<label for="url">URL:</label> 
 		<input type="text" id="url" name="url" required><br> 
+		<label for="subtitulo">Subtítulo:</label> 
+		<input type="text" id="subtitulo" name="subtitulo"><br> 
+		<label for="isbn">ISBN (10 o 13 dígitos):</label> 
+		<input type="text" id="isbn" name="isbn" value="{{with .Propuesta}}{{.ISBN}}{{end}}"><br> 
+		<label for="obra">Obra:</label> 
+		<select id="obra" name="obra">
+			<option value="0">(nueva obra o la del mismo título y autor)</option>
+			{{range .Obras}}<option value="{{.ObraID}}">{{.Titulo}} - {{.Autor}}</option>{{end}}
+		</select><br> 
+		<label for="edicion">Edición:</label> 
+		<input type="text" id="edicion" name="edicion"><br> 
+		<label for="idioma">Idioma:</label> 
+		<input type="text" id="idioma" name="idioma" value="{{with .Propuesta}}{{.Idioma}}{{end}}"><br> 
+		<label for="editorial">Editorial:</label> 
+		<input type="text" id="editorial" name="editorial" value="{{with .Propuesta}}{{.Editorial}}{{end}}"><br> 
+		<label for="licencias">Licencias digitales simultáneas:</label> 
+		<input type="number" id="licencias" name="licencias" min="0" value="0"><br> 
+		<label for="descripcion">Descripción:</label><br> 
+		<textarea id="descripcion" name="descripcion" rows="4" cols="50">{{with .Propuesta}}{{.Descripcion}}{{end}}</textarea><br> 
 		<button type="submit">Crear</button>
     </form>
     <footer>
@@ -225,6 +303,68 @@ var createBook = template.Must(template.New("create").Parse(`
 </html>
 `))
 
+// Codigo HTML para la pagina de edicion de libros
+var editBook = template.Must(template.New("edit").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+    <meta charset="UTF-8">
+    <title>Editar Libro</title>
+</head>
+<body>
+	<h1>Editar Libro {{.Libro.LibroID}}</h1> 
+	<p>{{with .Libro.URLPortada "mediana"}}<img src="{{.}}" alt="Portada"><br>{{end}}<a href="/subir-portada?libroID={{.Libro.LibroID}}">Cambiar portada</a></p>
+	<form action="/editar-book" method="post"> 
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<input type="hidden" name="id" value="{{.Libro.LibroID}}">
+		<label for="titulo">Título:</label> 
+		<input type="text" id="titulo" name="titulo" value="{{.Libro.Titulo}}" required><br> 
+		<label for="subtitulo">Subtítulo:</label> 
+		<input type="text" id="subtitulo" name="subtitulo" value="{{.Libro.Subtitulo}}"><br> 
+		<label for="autor">Autor:</label> 
+		<input type="text" id="autor" name="autor" value="{{.Libro.Autor}}" required><br> 
+		<label for="traductor">Traductor:</label> 
+		<input type="text" id="traductor" name="traductor" value="{{.Libro.NombresRol "traductor"}}"><br> 
+		<label for="editor">Editor:</label> 
+		<input type="text" id="editor" name="editor" value="{{.Libro.NombresRol "editor"}}"><br> 
+		<label for="fechaPublicacion">Fecha de Publicación (2024, 2024-09, 2024-09-21 o 21 de septiembre de 2024):</label> 
+		<input type="text" id="fechaPublicacion" name="fechaPublicacion" value="{{.Libro.FechaPublicacion.ISO}}" required><br> 
+		<label for="materias">Materias:</label> 
+		<select id="materias" name="materias" multiple required>
+			{{range .Materias}}<option value="{{.ID}}"{{if .Seleccionada}} selected{{end}}>{{.Etiqueta}}</option>{{end}}
+		</select><br> 
+		<label for="url">URL:</label> 
+		<input type="text" id="url" name="url" value="{{.Libro.Url}}" required><br> 
+		<label for="isbn">ISBN (10 o 13 dígitos):</label> 
+		<input type="text" id="isbn" name="isbn" value="{{.Libro.ISBN}}"><br> 
+		<label for="obra">Obra:</label> 
+		<select id="obra" name="obra">
+			{{range .Obras}}<option value="{{.ObraID}}"{{if eq .ObraID $.Libro.ObraID}} selected{{end}}>{{.Titulo}} - {{.Autor}}</option>{{end}}
+		</select><br> 
+		<label for="edicion">Edición:</label> 
+		<input type="text" id="edicion" name="edicion" value="{{.Libro.Edicion}}"><br> 
+		<label for="idioma">Idioma:</label> 
+		<input type="text" id="idioma" name="idioma" value="{{.Libro.Idioma}}"><br> 
+		<label for="editorial">Editorial:</label> 
+		<input type="text" id="editorial" name="editorial" value="{{.Libro.Editorial}}"><br> 
+		<label for="licencias">Licencias digitales simultáneas:</label> 
+		<input type="number" id="licencias" name="licencias" min="0" value="{{.Libro.Licencias}}"><br> 
+		<label for="descripcion">Descripción:</label><br> 
+		<textarea id="descripcion" name="descripcion" rows="4" cols="50">{{.Libro.Descripcion}}</textarea><br> 
+		<button type="submit">Guardar</button>
+	</form>
+	<form action="/eliminar-book" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<input type="hidden" name="id" value="{{.Libro.LibroID}}">
+		<button type="submit">Eliminar libro</button>
+	</form>
+    <footer>
+        <p>Vuelve pronto</p>
+    </footer>
+</body>
+</html>
+`))
+
 // Codigo HTML para la pagina de busqueda
 var searchTemplate = template.Must(template.New("busqueda").Parse(`
 <!DOCTYPE html>
@@ -236,6 +376,7 @@ var searchTemplate = template.Must(template.New("busqueda").Parse(`
 <body>
 	<h1>Buscar Libro por ID</h1>
 	<form action="/buscar-libro" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
 		<label for="libroID">Ingrese el ID del libro:</label>
 		<input type="number" id="libroID" name="libroID" required>
 		<button type="submit">Buscar</button>
@@ -258,6 +399,7 @@ var verPermisos = template.Must(template.New("permisos").Parse(`
 <body>
 	<h1>Consultar permisos</h1>
 	<form action="/validar-permisos" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
 		<label for="tipo">Seleccione el tipo de usuario:</label>
 		<select name="tipo" id="tipo">
 			<option value="administrador">Administrador</option>
@@ -292,32 +434,32 @@ var away = template.Must(template.New("Away").Parse(`
 
 // Aplicacion de metodos getter para poder acceder a las propiedades que estan encapsuladas
 
-// Administrador
-func (a *Administrador) GetNombre() string {
-	return a.Nombre
-}
-func (a *Administrador) GetMail() string {
-	return a.Mail
+// Cuenta
+func (c *Cuenta) GetNombre() string {
+	return c.Nombre
 }
-func (a *Administrador) GetRol() string {
-	return a.Rol
+func (c *Cuenta) GetMail() string {
+	return c.Mail
 }
-func (a *Administrador) GetFechaCreacion() time.Time {
-	return a.FechaCreacion
+func (c *Cuenta) GetRoles() []Rol {
+	return c.Roles
 }
-func (a *Administrador) GetUltimoAcceso() time.Time {
-	return a.UltimoAcceso
+func (c *Cuenta) TieneRol(rol Rol) bool {
+	for _, r := range c.Roles {
+		if r == rol {
+			return true
+		}
+	}
+	return false
 }
-
-// Usuario
-func (u *Usuario) GetNombre() string {
-	return u.Nombre
+func (c *Cuenta) GetFechaCreacion() time.Time {
+	return c.FechaCreacion
 }
-func (u *Usuario) GetMail() string {
-	return u.Mail
+func (c *Cuenta) GetUltimoAcceso() time.Time {
+	return c.UltimoAcceso
 }
-func (u *Usuario) GetRol() string {
-	return u.Rol
+func (c *Cuenta) IsMailVerificado() bool {
+	return c.MailVerificado
 }
 
 // Inventario
@@ -338,7 +480,7 @@ func (l *Libro) GetTitulo() string {
 func (l *Libro) GetAutor() string {
 	return l.Autor
 }
-func (l *Libro) GetFechaPublicacion() string {
+func (l *Libro) GetFechaPublicacion() FechaParcial {
 	return l.FechaPublicacion
 }
 func (l *Libro) GetGenero() string {
@@ -347,6 +489,30 @@ func (l *Libro) GetGenero() string {
 func (l *Libro) GetURL() string {
 	return l.Url
 }
+func (l *Libro) GetSubtitulo() string {
+	return l.Subtitulo
+}
+func (l *Libro) GetDescripcion() string {
+	return l.Descripcion
+}
+func (l *Libro) GetISBN() string {
+	return l.ISBN
+}
+func (l *Libro) GetMaterias() []int {
+	return l.Materias
+}
+func (l *Libro) GetEdicion() string {
+	return l.Edicion
+}
+func (l *Libro) GetIdioma() string {
+	return l.Idioma
+}
+func (l *Libro) GetEditorial() string {
+	return l.Editorial
+}
+func (l *Libro) GetLicencias() int {
+	return l.Licencias
+}
 
 // Prestamo
 func (p *Prestamo) GetLibroID() int {
@@ -364,29 +530,29 @@ func (p *Prestamo) GetFechaDevolucion() time.Time {
 
 // Aplicacion de metodo setter para poder modificar las propiedades que estan encapsuladas
 
-// Adminsitrador
-func (a *Administrador) SetNombre(nombre string) {
-	a.Nombre = nombre
+// Cuenta
+func (c *Cuenta) SetNombre(nombre string) {
+	c.Nombre = nombre
 }
-func (a *Administrador) SetMail(mail string) {
-	a.Mail = mail
+func (c *Cuenta) SetMail(mail string) {
+	c.Mail = mail
 }
-func (a *Administrador) SetRol(rol string) {
-	a.Rol = rol
-}
-func (a *Administrador) SetUltimoAcceso(t time.Time) {
-	a.UltimoAcceso = t
-}
-
-// Usuario
-func (u *Usuario) SetNombre(nombre string) {
-	u.Nombre = nombre
+func (c *Cuenta) AgregarRol(rol Rol) {
+	if !c.TieneRol(rol) {
+		c.Roles = append(c.Roles, rol)
+	}
 }
-func (u *Usuario) SetMail(mail string) {
-	u.Mail = mail
+func (c *Cuenta) QuitarRol(rol Rol) {
+	roles := c.Roles[:0]
+	for _, r := range c.Roles {
+		if r != rol {
+			roles = append(roles, r)
+		}
+	}
+	c.Roles = roles
 }
-func (u *Usuario) SetRol(rol string) {
-	u.Rol = rol
+func (c *Cuenta) SetUltimoAcceso(t time.Time) {
+	c.UltimoAcceso = t
 }
 
 // Inventario
@@ -401,7 +567,7 @@ func (l *Libro) SetTirulo(titulo string) {
 func (l *Libro) SetAutor(autor string) {
 	l.Autor = autor
 }
-func (l *Libro) SetFechaPublicacion(fecha string) {
+func (l *Libro) SetFechaPublicacion(fecha FechaParcial) {
 	l.FechaPublicacion = fecha
 }
 func (l *Libro) SetGenero(genero string) {
@@ -410,6 +576,21 @@ func (l *Libro) SetGenero(genero string) {
 func (l *Libro) SetURL(url string) {
 	l.Url = url
 }
+func (l *Libro) SetSubtitulo(subtitulo string) {
+	l.Subtitulo = subtitulo
+}
+func (l *Libro) SetDescripcion(descripcion string) {
+	l.Descripcion = descripcion
+}
+func (l *Libro) SetISBN(isbn string) {
+	l.ISBN = isbn
+}
+func (l *Libro) SetMaterias(materias []int) {
+	l.Materias = materias
+}
+func (l *Libro) SetLicencias(licencias int) {
+	l.Licencias = licencias
+}
 
 // Prestamo
 func (p *Prestamo) SetFechaDevolucion(fecha time.Time) {
@@ -418,33 +599,22 @@ func (p *Prestamo) SetFechaDevolucion(fecha time.Time) {
 
 // Implementacion de validacion de permisos con la Interface "Permisos"
 
-// Administrador
-func (a *Administrador) Prestar() bool {
-	return true
+// Cuenta: los permisos dependen de los roles asignados
+func (c *Cuenta) Prestar() bool {
+	return c.TieneRol(RolUsuario) || c.TieneRol(RolAdministrador)
 }
-func (a *Administrador) Devolver() bool {
-	return true
+func (c *Cuenta) Devolver() bool {
+	return c.TieneRol(RolUsuario) || c.TieneRol(RolAdministrador)
 }
-func (a *Administrador) AdministrarUsuario() bool {
-	return true
-}
-
-// Usuario
-func (u *Usuario) Prestar() bool {
-	return true
-}
-func (u *Usuario) Devolver() bool {
-	return true
-}
-func (u *Usuario) AdministrarUsuario() bool {
-	return false
+func (c *Cuenta) AdministrarUsuario() bool {
+	return c.TieneRol(RolAdministrador)
 }
 
 //Funcion para validar permisos
 
 func consultarPermisos(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		if err := verPermisos.Execute(w, nil); err != nil {
+		if err := verPermisos.Execute(w, formulario(r)); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
@@ -457,18 +627,12 @@ func consultarPermisos(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Obtener el tipo de usuario
-	tipo := r.FormValue("tipo")
-	var permisos Permisos
-
-	switch tipo {
-	case "administrador":
-		permisos = &Administrador{}
-	case "usuario":
-		permisos = &Usuario{}
-	default:
+	rol, err := parseRol(r.FormValue("tipo"))
+	if err != nil {
 		http.Error(w, "Tipo de usuario inválido", http.StatusBadRequest)
 		return
 	}
+	var permisos Permisos = &Cuenta{Roles: []Rol{rol}}
 
 	// Crear la respuesta JSON con los permisos
 	respuesta := map[string]bool{
@@ -483,20 +647,58 @@ func consultarPermisos(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Manejo de errores en creacion de administradores
-func nuevoAdministrador(id int, nombre, mail, contrasena, rol string) (*Administrador, error) {
-	if id <= 0 || nombre == "" || mail == "" || contrasena == "" {
-		return nil, errors.New("error en los datos para crear un administrador")
+// Manejo de errores en creacion de cuentas
+func nuevaCuenta(id int, nombre, mail, contrasena string, roles ...Rol) (*Cuenta, error) {
+	if id <= 0 || nombre == "" || mail == "" || contrasena == "" || len(roles) == 0 {
+		return nil, errors.New("error en los datos para crear una cuenta")
 	}
-	return &Administrador{
-		AdministradorID: id,
-		Nombre:          nombre,
-		Mail:            mail,
-		Contrasena:      contrasena,
-		Rol:             rol,
-		FechaCreacion:   time.Now(),
-		UltimoAcceso:    time.Now(),
-	}, nil
+	for _, rol := range roles {
+		if _, err := parseRol(string(rol)); err != nil {
+			return nil, err
+		}
+	}
+	c := &Cuenta{
+		CuentaID:      id,
+		Nombre:        nombre,
+		Mail:          mail,
+		Roles:         roles,
+		FechaCreacion: time.Now(),
+		UltimoAcceso:  time.Now(),
+		Estado:        EstadoActiva,
+	}
+	if err := c.SetContrasena(contrasena); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Agrega una cuenta creada por un administrador si su ID y su correo no estan en uso
+func agregarCuenta(c *Cuenta) (int, error) {
+	muCuentas.Lock()
+	defer muCuentas.Unlock()
+	if buscarCuentaID(c.CuentaID) != nil {
+		return http.StatusConflict, errors.New("ya existe una cuenta con ese ID")
+	}
+	if buscarCuentaMail(c.Mail) != nil {
+		return http.StatusConflict, errors.New("ya existe una cuenta con ese correo")
+	}
+	listadocuenta.Cuentas = append(listadocuenta.Cuentas, c)
+	if err := guardarCuentas(listadocuenta.Cuentas); err != nil {
+		listadocuenta.Cuentas = listadocuenta.Cuentas[:len(listadocuenta.Cuentas)-1]
+		return http.StatusInternalServerError, errors.New("error al guardar las cuentas")
+	}
+	return 0, nil
+}
+
+// Convierte el texto de un formulario en un rol valido
+func parseRol(texto string) (Rol, error) {
+	switch strings.ToLower(strings.TrimSpace(texto)) {
+	case "administrador", "admin":
+		return RolAdministrador, nil
+	case "usuario", "user":
+		return RolUsuario, nil
+	}
+	return "", errors.New("rol inválido: " + texto)
 }
 
 func crearAdminstrador(w http.ResponseWriter, r *http.Request) {
@@ -505,14 +707,16 @@ func crearAdminstrador(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(respuesta)
 }
 
-// Creamos la estructura adminsitrador con slice para guardar los nuevos administradores
-type Listadoadmin struct {
-	Administradores []*Administrador
+// Creamos la estructura cuenta con slice para guardar administradores y usuarios
+type Listadocuenta struct {
+	Cuentas []*Cuenta
 }
 
+var listadocuenta Listadocuenta
+
 func crearAdministrador(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		if err := createAdmin.Execute(w, nil); err != nil {
+		if err := createAdmin.Execute(w, formulario(r)); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
@@ -534,15 +738,17 @@ func crearAdministrador(w http.ResponseWriter, r *http.Request) {
 		nombre := r.FormValue("nombre")
 		mail := r.FormValue("mail")
 		contrasena := r.FormValue("contrasena")
-		rol := r.FormValue("rol")
 
-		admin, err := nuevoAdministrador(id, nombre, mail, contrasena, rol)
+		// Los administradores tambien pueden solicitar prestamos como usuarios
+		admin, err := nuevaCuenta(id, nombre, mail, contrasena, RolAdministrador, RolUsuario)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		listadoadmin.Administradores = append(listadoadmin.Administradores, admin)
+		if codigo, err := agregarCuenta(admin); err != nil {
+			http.Error(w, err.Error(), codigo)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(admin); err != nil {
@@ -551,30 +757,9 @@ func crearAdministrador(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-var listadoadmin Listadoadmin
-
-// Manejo de errores en creacion de usuarios
-func nuevoUsuario(id int, nombre, mail, contrasena, rol string) (*Usuario, error) {
-	if id <= 0 || nombre == "" || mail == "" || contrasena == "" {
-		return nil, errors.New("error en los datos para crear un usuario")
-	}
-	return &Usuario{
-		UsuarioID:  id,
-		Nombre:     nombre,
-		Mail:       mail,
-		Contrasena: contrasena,
-		Rol:        rol,
-	}, nil
-}
-
-// Creamos la estructura adminsitrador con slice para guardar los nuevos usuarios
-type Listadouser struct {
-	Usuarios []*Usuario
-}
-
 func crearUsuario(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		if err := createUser.Execute(w, nil); err != nil {
+		if err := createUser.Execute(w, formulario(r)); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
@@ -596,15 +781,26 @@ func crearUsuario(w http.ResponseWriter, r *http.Request) {
 		nombre := r.FormValue("nombre")
 		mail := r.FormValue("mail")
 		contrasena := r.FormValue("contrasena")
-		rol := r.FormValue("rol")
+		rol, err := parseRol(r.FormValue("rol"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		user, err := nuevoUsuario(id, nombre, mail, contrasena, rol)
+		user, err := nuevaCuenta(id, nombre, mail, contrasena, rol)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if codigo, err := agregarCuenta(user); err != nil {
+			http.Error(w, err.Error(), codigo)
+			return
+		}
 
-		listadouser.Usuarios = append(listadouser.Usuarios, user)
+		// El correo queda sin verificar hasta que el usuario abra el enlace enviado
+		if err := enviarVerificacion(user); err != nil {
+			log.Println("Error al enviar el correo de verificación:", err)
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(user); err != nil {
@@ -613,31 +809,38 @@ func crearUsuario(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-var listadouser Listadouser
-
 // Manejo de errores en creacion de libros
 func nuevoLibro(id int, titulo, autor string, fecha string, genero, url string) (*Libro, error) {
 	if id <= 0 || titulo == "" || autor == "" {
 		return nil, errors.New("error en los datos para crear un libro")
 	}
+	// La fecha puede quedar vacia si no se conoce, pero si se indica debe ser valida
+	var publicacion FechaParcial
+	if strings.TrimSpace(fecha) != "" {
+		var err error
+		if publicacion, err = ParseFechaParcial(fecha); err != nil {
+			return nil, err
+		}
+	}
 	return &Libro{
 		LibroID:          id,
 		Titulo:           titulo,
 		Autor:            autor,
-		FechaPublicacion: fecha,
+		FechaPublicacion: publicacion,
 		Genero:           genero,
 		Url:              url,
 	}, nil
 }
 
-// Creaamos la estructura adminsitrador con slice para guardar los nuevos libros
-type Listadobook struct {
-	Libros []*Libro
-}
-
 func crearLibro(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		if err := createBook.Execute(w, nil); err != nil {
+		datos := struct {
+			CSRF      string
+			Materias  []OpcionMateria
+			Obras     []*Obra
+			Propuesta *PropuestaLibro
+		}{tokenCSRF(r), opcionesMaterias(nil), obrasOrdenadas(), nil}
+		if err := createBook.Execute(w, datos); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
@@ -659,16 +862,197 @@ func crearLibro(w http.ResponseWriter, r *http.Request) {
 		titulo := r.FormValue("titulo")
 		autor := r.FormValue("autor")
 		fechaPublicacion := r.FormValue("fechaPublicacion")
-		genero := r.FormValue("genero")
 		url := r.FormValue("url")
+		materias, err := materiasFormulario(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		licencias, err := licenciasFormulario(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// El genero se conserva como la primera materia elegida
+		primera, _ := listadomateria.BuscarID(materias[0])
+		book, err := nuevoLibro(id, titulo, autor, fechaPublicacion, primera.Nombre, url)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		book.SetMaterias(materias)
+		book.SetLicencias(licencias)
+		if _, err := libreria.BuscarID(id); err == nil {
+			http.Error(w, "Ya existe un libro con ese ID", http.StatusConflict)
+			return
+		}
+
+		// Si el ISBN ya existe se ofrece agregar un ejemplar al inventario en lugar de duplicar el libro
+		if texto := r.FormValue("isbn"); texto != "" {
+			isbn, err := NormalizarISBN(texto)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if existente, err := libreria.BuscarISBN(isbn); err == nil {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusConflict)
+				datos := struct {
+					CSRF  string
+					Libro *Libro
+				}{tokenCSRF(r), existente}
+				if err := duplicateBook.Execute(w, datos); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			book.SetISBN(isbn)
+		}
+		book.SetSubtitulo(r.FormValue("subtitulo"))
+		book.SetDescripcion(r.FormValue("descripcion"))
+		// La importacion puede fallar, asi que se adjunta antes de crear la obra y los autores
+		if err := adjuntarImportados(r, book); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := obraFormulario(r, book); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		vincularAutores(book, map[RolAutor]string{RolAutorPrincipal: autor,
+			RolTraductor: r.FormValue("traductor"), RolEditor: r.FormValue("editor")})
+		if err := saveToJSON(listadoautor.Autores, "autores.json"); err != nil {
+			http.Error(w, "Error al guardar los autores", http.StatusInternalServerError)
+			return
+		}
+		if err := saveToJSON(listadoobra.Obras, "obras.json"); err != nil {
+			http.Error(w, "Error al guardar las obras", http.StatusInternalServerError)
+			return
+		}
+
+		// Los libros nuevos se guardan en la libreria y se agregan al indice de busqueda
+		libreria.Libros = append(libreria.Libros, book)
+		indice.Agregar(book)
+		prefijos.Invalidar()
+		if err := saveToJSON(libreria.Libros, "libros.json"); err != nil {
+			http.Error(w, "Error al guardar los libros", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(book); err != nil {
+			http.Error(w, "Error al codificar la respuesta", http.StatusInternalServerError)
+		}
+	}
+}
+
+// Funcion para editar un libro existente
+func editarLibro(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		libro, err := libreria.BuscarID(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		datos := struct {
+			CSRF     string
+			Libro    *Libro
+			Materias []OpcionMateria
+			Obras    []*Obra
+		}{tokenCSRF(r), libro, opcionesMaterias(libro.Materias), obrasOrdenadas()}
+		if err := editBook.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
 
-		book, err := nuevoLibro(id, titulo, autor, fechaPublicacion, genero, url)
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Error al procesar el formulario", http.StatusBadRequest)
+			return
+		}
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		book, err := libreria.BuscarID(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		materias, err := materiasFormulario(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		primera, _ := listadomateria.BuscarID(materias[0])
+		licencias, err := licenciasFormulario(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Se valida con las mismas reglas de la creacion antes de modificar el libro
+		datos, err := nuevoLibro(id, r.FormValue("titulo"), r.FormValue("autor"),
+			r.FormValue("fechaPublicacion"), primera.Nombre, r.FormValue("url"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		listadobook.Libros = append(listadobook.Libros, book)
+		isbn := ""
+		if texto := r.FormValue("isbn"); texto != "" {
+			if isbn, err = NormalizarISBN(texto); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if existente, err := libreria.BuscarISBN(isbn); err == nil && existente.LibroID != id {
+				http.Error(w, fmt.Sprintf("El ISBN ya pertenece al libro %d", existente.LibroID), http.StatusConflict)
+				return
+			}
+		}
+
+		if err := obraFormulario(r, book); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		book.SetTirulo(datos.Titulo)
+		book.SetAutor(datos.Autor)
+		book.SetFechaPublicacion(datos.FechaPublicacion)
+		book.SetGenero(datos.Genero)
+		book.SetMaterias(materias)
+		book.SetLicencias(licencias)
+		book.SetURL(datos.Url)
+		book.SetSubtitulo(r.FormValue("subtitulo"))
+		book.SetDescripcion(r.FormValue("descripcion"))
+		book.SetISBN(isbn)
+		vincularAutores(book, map[RolAutor]string{RolAutorPrincipal: datos.Autor,
+			RolTraductor: r.FormValue("traductor"), RolEditor: r.FormValue("editor")})
+		if err := saveToJSON(listadoautor.Autores, "autores.json"); err != nil {
+			http.Error(w, "Error al guardar los autores", http.StatusInternalServerError)
+			return
+		}
+		if err := saveToJSON(listadoobra.Obras, "obras.json"); err != nil {
+			http.Error(w, "Error al guardar las obras", http.StatusInternalServerError)
+			return
+		}
+
+		indice.Agregar(book)
+		prefijos.Invalidar()
+		if err := saveToJSON(libreria.Libros, "libros.json"); err != nil {
+			http.Error(w, "Error al guardar los libros", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(book); err != nil {
@@ -677,14 +1061,111 @@ func crearLibro(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-var listadobook Listadobook
+// Motivo por el que el libro no se puede eliminar, o "" si nada depende de el
+func libroEnUso(id int) string {
+	for _, inv := range listadoinventario.Inventarios {
+		if inv.LibroID == id {
+			return "tiene ejemplares en el inventario"
+		}
+	}
+	for _, p := range listadoprestamo.Prestamos {
+		if p.LibroID == id {
+			return "tiene préstamos registrados"
+		}
+	}
+	// Las reservas de cualquier edicion solo impiden borrar la ultima edicion de la obra
+	libro, err := libreria.BuscarID(id)
+	ultima := err == nil && ultimaEdicion(libro)
+	for _, res := range listadoreserva.Reservas {
+		if res.Activa() && (res.LibroID == id || (res.LibroID == 0 && ultima && res.ObraID == libro.ObraID)) {
+			return "tiene reservas en curso"
+		}
+	}
+	return ""
+}
+
+// Indica si el libro es la unica edicion de su obra en el catalogo
+func ultimaEdicion(libro *Libro) bool {
+	if libro.ObraID == 0 {
+		return false
+	}
+	for _, l := range libreria.Libros {
+		if l.ObraID == libro.ObraID && l.LibroID != libro.LibroID {
+			return false
+		}
+	}
+	return true
+}
+
+// Funcion para eliminar un libro que no tiene ejemplares ni prestamos
+func eliminarLibro(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+
+	eliminado, err := libreria.BuscarID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// Sin la circulacion tomada podria prestarse o reservarse el libro mientras se elimina
+	muCirculacion.Lock()
+	defer muCirculacion.Unlock()
+	if motivo := libroEnUso(id); motivo != "" {
+		http.Error(w, "No se puede eliminar el libro: "+motivo, http.StatusConflict)
+		return
+	}
+	// Una obra sin ediciones no se puede ver ni reservar, asi que se va con su ultimo libro
+	sinEdiciones := ultimaEdicion(eliminado)
+
+	// Se arma una lista nueva para no modificar la que otros pudieron copiar
+	libros := make([]*Libro, 0, len(libreria.Libros)-1)
+	for _, libro := range libreria.Libros {
+		if libro.LibroID != id {
+			libros = append(libros, libro)
+		}
+	}
+	libreria.Libros = libros
+	if sinEdiciones {
+		obras := make([]*Obra, 0, len(listadoobra.Obras))
+		for _, o := range listadoobra.Obras {
+			if o.ObraID != eliminado.ObraID {
+				obras = append(obras, o)
+			}
+		}
+		listadoobra.Obras = obras
+	}
+
+	indice.Eliminar(id)
+	prefijos.Invalidar()
+	if err := saveToJSON(libreria.Libros, "libros.json"); err != nil {
+		http.Error(w, "Error al guardar los libros", http.StatusInternalServerError)
+		return
+	}
+	if sinEdiciones {
+		if err := saveToJSON(listadoobra.Obras, "obras.json"); err != nil {
+			http.Error(w, "Error al guardar las obras", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Respuesta{"Libro eliminado correctamente"})
+}
 
 // Busqueda de libros
 type Libreria struct {
 	Libros []*Libro
 }
 
-func (lib *Libreria) BuscarID(id int) (interface{}, error) {
+func (lib *Libreria) BuscarID(id int) (*Libro, error) {
 	for _, libro := range lib.Libros {
 		if libro.LibroID == id {
 			return libro, nil
@@ -693,12 +1174,11 @@ func (lib *Libreria) BuscarID(id int) (interface{}, error) {
 	return nil, errors.New("libro no encontrado con el ID digitado")
 }
 
-func (lib *Libreria) BuscarNombre(nombre string) ([]interface{}, error) {
-	var resultados []interface{}
-	for _, libro := range lib.Libros {
-		if libro.Titulo == nombre {
-			resultados = append(resultados, libro)
-		}
+// Busqueda por palabras sin distinguir mayusculas ni acentos, ordenada por relevancia
+func (lib *Libreria) BuscarNombre(nombre string) ([]*Libro, error) {
+	var resultados []*Libro
+	for _, res := range lib.BuscarRelevancia(nombre) {
+		resultados = append(resultados, res.Libro)
 	}
 	if len(resultados) == 0 {
 		return nil, errors.New("no existen libros con ese nombre")
@@ -708,7 +1188,7 @@ func (lib *Libreria) BuscarNombre(nombre string) ([]interface{}, error) {
 
 func buscarLibro(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		if err := searchTemplate.Execute(w, nil); err != nil {
+		if err := searchTemplate.Execute(w, formulario(r)); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
@@ -741,6 +1221,43 @@ func buscarLibro(w http.ResponseWriter, r *http.Request) {
 
 var libreria *Libreria
 
+/*
+Carga el catalogo de libros.json; solo si aun no existe se usan los libros de la
+semilla. Devuelve si se uso la semilla.
+*/
+func cargarLibros(semilla []*Libro) (bool, error) {
+	var libros []*Libro
+	err := loadFromJSON("libros.json", &libros)
+	if os.IsNotExist(err) {
+		libreria = &Libreria{Libros: semilla}
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	libreria = &Libreria{Libros: libros}
+	return false, nil
+}
+
+// Carga el inventario de inventario.json; solo si aun no existe se usa la semilla
+func cargarInventario(semilla []*Inventario) error {
+	err := loadFromJSON("inventario.json", &listadoinventario.Inventarios)
+	if os.IsNotExist(err) {
+		listadoinventario.Inventarios = semilla
+		return nil
+	}
+	return err
+}
+
+// Carga los prestamos de prestamos.json; solo si aun no existe se usa la semilla
+func cargarPrestamos(semilla []*Prestamo) error {
+	err := loadFromJSON("prestamos.json", &listadoprestamo.Prestamos)
+	if os.IsNotExist(err) {
+		listadoprestamo.Prestamos = semilla
+		return nil
+	}
+	return err
+}
+
 // Manejo de errores para registro de inventarios
 func nuevoInventario(id, libroID int, disponible bool) (*Inventario, error) {
 	if id <= 0 || libroID <= 0 {
@@ -753,6 +1270,20 @@ func nuevoInventario(id, libroID int, disponible bool) (*Inventario, error) {
 	}, nil
 }
 
+// Creamos la estructura inventario con slice para guardar los ejemplares
+type Listadoinventario struct {
+	Inventarios []*Inventario
+}
+
+var listadoinventario Listadoinventario
+
+// Creamos la estructura prestamo con slice para guardar el historial de prestamos
+type Listadoprestamo struct {
+	Prestamos []*Prestamo
+}
+
+var listadoprestamo Listadoprestamo
+
 // Manejo de errores para registro de prestamos
 func nuevoPrestamo(id, libroID, usuarioID int, fechaReserva, fechaDevolucion time.Time) (*Prestamo, error) {
 	if id <= 0 || libroID <= 0 || usuarioID <= 0 {
@@ -768,12 +1299,37 @@ func nuevoPrestamo(id, libroID, usuarioID int, fechaReserva, fechaDevolucion tim
 }
 
 // Funciones para guardar y cargar en archivos JSON la información incluyendo manejo de errores
+// Guarda el JSON en un archivo temporal y lo renombra, para que un error no deje el archivo a medio escribir
 func saveToJSON(data interface{}, filename string) error {
-	bytes, err := json.MarshalIndent(data, "", " ")
+	temporal, err := escribirTemporalJSON(data, filename)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename, bytes, 0644)
+	return os.Rename(temporal, filename)
+}
+
+// Escribe el JSON en un archivo temporal en la carpeta del destino y devuelve su ruta
+func escribirTemporalJSON(data interface{}, filename string) (string, error) {
+	bytes, err := json.MarshalIndent(data, "", " ")
+	if err != nil {
+		return "", err
+	}
+	return escribirTemporal(bytes, filename)
+}
+
+// Escribe el contenido en un archivo temporal en la carpeta del destino y devuelve su ruta
+func escribirTemporal(bytes []byte, filename string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(bytes)
+	err = errors.Join(err, f.Chmod(0644), f.Close())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
 }
 func loadFromJSON(filename string, v interface{}) error {
 	data, err := os.ReadFile(filename)
@@ -783,17 +1339,24 @@ func loadFromJSON(filename string, v interface{}) error {
 	return json.Unmarshal(data, v)
 }
 
-// Funcion para visualizar el archivo json con los administradores
-func visualizarAdministrador(w http.ResponseWriter, r *http.Request) {
-	var administradores []*Administrador
-	if err := loadFromJSON("administradores.json", &administradores); err != nil {
+// Funcion para visualizar las cuentas del archivo json que tienen un rol
+func visualizarCuentasRol(w http.ResponseWriter, rol Rol) {
+	cuentas, err := leerCuentas("cuentas.json")
+	if err != nil {
 		http.Error(w, "Error al cargar el archivo", http.StatusInternalServerError)
 		return
 	}
 
-	jsonBytes, err := json.Marshal(administradores)
+	filtradas := []*Cuenta{}
+	for _, c := range cuentas {
+		if rol == "" || c.TieneRol(rol) {
+			filtradas = append(filtradas, c)
+		}
+	}
+
+	jsonBytes, err := json.Marshal(filtradas)
 	if err != nil {
-		http.Error(w, "Error al serializar los administradores", http.StatusInternalServerError)
+		http.Error(w, "Error al serializar las cuentas", http.StatusInternalServerError)
 		return
 	}
 
@@ -801,22 +1364,19 @@ func visualizarAdministrador(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonBytes)
 }
 
-// Funcion para visualizar el archivo json con los usuarios
-func visualizarUsuario(w http.ResponseWriter, r *http.Request) {
-	var usuarios []*Usuario
-	if err := loadFromJSON("usuarios.json", &usuarios); err != nil {
-		http.Error(w, "Error al cargar el archivo", http.StatusInternalServerError)
-		return
-	}
+// Funcion para visualizar los administradores
+func visualizarAdministrador(w http.ResponseWriter, r *http.Request) {
+	visualizarCuentasRol(w, RolAdministrador)
+}
 
-	jsonBytes, err := json.Marshal(usuarios)
-	if err != nil {
-		http.Error(w, "Error al serializar los usuarios", http.StatusInternalServerError)
-		return
-	}
+// Funcion para visualizar los usuarios
+func visualizarUsuario(w http.ResponseWriter, r *http.Request) {
+	visualizarCuentasRol(w, RolUsuario)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonBytes)
+// Funcion para visualizar todas las cuentas
+func visualizarCuentas(w http.ResponseWriter, r *http.Request) {
+	visualizarCuentasRol(w, "")
 }
 
 // Funcion para visualizar el archivo json con los libros
@@ -875,7 +1435,7 @@ func visualizarPrestamos(w http.ResponseWriter, r *http.Request) {
 
 // Funcion para pagina de bienvenida y de despedida
 func homePage(w http.ResponseWriter, r *http.Request) {
-	if err := homeTemplate.Execute(w, nil); err != nil {
+	if err := homeTemplate.Execute(w, formulario(r)); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -889,28 +1449,55 @@ func awayPage(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 
+	//Opciones de linea de comandos para tareas de mantenimiento
+	migrar := flag.Bool("migrar-cuentas", false, "une administradores.json y usuarios.json en cuentas.json y termina")
+	migrarLibros := flag.Bool("migrar-libros", false, "convierte las fechas de publicacion de libros.json al formato AAAA-MM-DD y termina")
+	unificarAutores := flag.Bool("migrar-autores", false, "crea autores.json a partir de los autores de libros.json, unificando los nombres repetidos, y termina")
+	comprobarMARC := flag.String("comprobar-marc", "", "importa el archivo MARC21 o MARCXML indicado, lo exporta e importa de nuevo, muestra los datos que cambian y termina")
+	exportarEntidad := flag.String("exportar-csv", "", "escribe en la salida estandar el CSV de la entidad indicada (libros, cuentas, inventario, autores, materias, obras, prestamos o reservas) y termina")
+	flag.Parse()
+
+	if *migrar {
+		if err := ejecutarMigracionCuentas(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *migrarLibros {
+		if err := ejecutarMigracionLibros(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *unificarAutores {
+		if err := ejecutarMigracionAutores(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	/*Creacion de administradores
 	Utilizamos un slice [] para crear varios administradores y pueda ser dinamico
 	en caso de que se requiera crear mas en el futuro*/
 
-	administradores := []*Administrador{
+	administradores := []*Cuenta{
 		{
-			AdministradorID: 100,
-			Nombre:          "Kevin Lopez",
-			Mail:            "kevin.lopez@correo.com",
-			Contrasena:      "contrasena100",
-			Rol:             "Administrador",
-			FechaCreacion:   time.Now(),
-			UltimoAcceso:    time.Now(),
+			CuentaID:      100,
+			Nombre:        "Kevin Lopez",
+			Mail:          "kevin.lopez@correo.com",
+			Contrasena:    "contrasena100",
+			Roles:         []Rol{RolAdministrador, RolUsuario},
+			FechaCreacion: time.Now(),
+			UltimoAcceso:  time.Now(),
 		},
 		{
-			AdministradorID: 200,
-			Nombre:          "Jazmin Chillagana",
-			Mail:            "jazmin.chillagana@correo.com",
-			Contrasena:      "contrasena200",
-			Rol:             "Administrador",
-			FechaCreacion:   time.Now(),
-			UltimoAcceso:    time.Now(),
+			CuentaID:      200,
+			Nombre:        "Jazmin Chillagana",
+			Mail:          "jazmin.chillagana@correo.com",
+			Contrasena:    "contrasena200",
+			Roles:         []Rol{RolAdministrador, RolUsuario},
+			FechaCreacion: time.Now(),
+			UltimoAcceso:  time.Now(),
 		},
 	}
 
@@ -918,41 +1505,51 @@ func main() {
 	Utilizamos un slice [] para crear varios usuarios ya que constantemente se puede
 	requerir crear mas en el futuro*/
 
-	usuarios := []Usuario{
+	usuarios := []*Cuenta{
 		{
-			UsuarioID:  001,
-			Nombre:     "Juan Perez",
-			Mail:       "juan.perez@correo.com",
-			Contrasena: "librosjuan1",
-			Rol:        "Usuario",
+			CuentaID:      001,
+			Nombre:        "Juan Perez",
+			Mail:          "juan.perez@correo.com",
+			Contrasena:    "librosjuan1",
+			Roles:         []Rol{RolUsuario},
+			FechaCreacion: time.Now(),
+			UltimoAcceso:  time.Now(),
 		},
 		{
-			UsuarioID:  002,
-			Nombre:     "Maria Enriquez",
-			Mail:       "maria.enriquez@correo.com",
-			Contrasena: "mislibros123",
-			Rol:        "Usuario",
+			CuentaID:      002,
+			Nombre:        "Maria Enriquez",
+			Mail:          "maria.enriquez@correo.com",
+			Contrasena:    "mislibros123",
+			Roles:         []Rol{RolUsuario},
+			FechaCreacion: time.Now(),
+			UltimoAcceso:  time.Now(),
 		},
 		{
-			UsuarioID:  003,
-			Nombre:     "Pedro Alvarez",
-			Mail:       "pedro.alvarez@correo.com",
-			Contrasena: "miperro5",
-			Rol:        "Usuario",
+			CuentaID:      003,
+			Nombre:        "Pedro Alvarez",
+			Mail:          "pedro.alvarez@correo.com",
+			Contrasena:    "miperro5",
+			Roles:         []Rol{RolUsuario},
+			FechaCreacion: time.Now(),
+			UltimoAcceso:  time.Now(),
 		},
 		{
-			UsuarioID:  004,
-			Nombre:     "Pablo Hernandez",
-			Mail:       "pablo.hernandez@correo.com",
-			Contrasena: "contra123",
-			Rol:        "Usuario",
+			CuentaID:      004,
+			Nombre:        "Pablo Hernandez",
+			Mail:          "pablo.hernandez@correo.com",
+			Contrasena:    "contra123",
+			Roles:         []Rol{RolUsuario},
+			FechaCreacion: time.Now(),
+			UltimoAcceso:  time.Now(),
 		},
 		{
-			UsuarioID:  005,
-			Nombre:     "Samantha Rivera",
-			Mail:       "samy.rivera@correo.com",
-			Contrasena: "riosol159",
-			Rol:        "Usuario",
+			CuentaID:      005,
+			Nombre:        "Samantha Rivera",
+			Mail:          "samy.rivera@correo.com",
+			Contrasena:    "riosol159",
+			Roles:         []Rol{RolUsuario},
+			FechaCreacion: time.Now(),
+			UltimoAcceso:  time.Now(),
 		},
 	}
 
@@ -965,45 +1562,212 @@ func main() {
 			LibroID:          001,
 			Titulo:           "Cartas de un Estoico",
 			Autor:            "Lucio A. Séneca",
-			FechaPublicacion: "2024 September",
+			FechaPublicacion: FechaParcial{Anio: 2024, Mes: 9},
 			Genero:           "Filosofía",
 			Url:              "www.libros.com/cartas_estoico",
+			Materias:         []int{2, 3},
+			Descripcion:      "Correspondencia de Séneca con Lucilio sobre la amistad, la muerte y la vida virtuosa según el estoicismo.",
+			ObraID:           1,
+			Idioma:           "español",
 		},
 		{
 			LibroID:          002,
 			Titulo:           "Los Discursos de Epicteto",
 			Autor:            "Epicteto",
-			FechaPublicacion: "2024 September",
+			FechaPublicacion: FechaParcial{Anio: 2024, Mes: 9},
 			Genero:           "Filosofía",
 			Url:              "www.libros.com/discursos_epicteto",
+			Materias:         []int{2},
+			Descripcion:      "Enseñanzas de Epicteto recogidas por su discípulo Arriano sobre la libertad interior y lo que depende de nosotros.",
+			ObraID:           2,
+			Idioma:           "español",
 		},
 		{
 			LibroID:          003,
 			Titulo:           "Manual de Epicteto",
 			Autor:            "Epicteto",
-			FechaPublicacion: "1980 May",
+			FechaPublicacion: FechaParcial{Anio: 1980, Mes: 5},
 			Genero:           "Filosofía",
 			Url:              "www.libros.com/manual_epicteto",
+			Materias:         []int{2, 3},
+			Descripcion:      "Breve compendio de las máximas estoicas de Epicteto para afrontar la adversidad con serenidad.",
+			ObraID:           3,
+			Idioma:           "español",
 		},
 		{
 			LibroID:          004,
 			Titulo:           "Meditaciones",
 			Autor:            "Marco Aurelio",
-			FechaPublicacion: "2023 October",
+			FechaPublicacion: FechaParcial{Anio: 2023, Mes: 10},
 			Genero:           "Filosofía",
 			Url:              "www.libros.com/meditaciones",
+			Licencias:        2,
+			Materias:         []int{2, 3},
+			Descripcion:      "Reflexiones personales del emperador Marco Aurelio sobre el deber, la razón y la naturaleza.",
+			ObraID:           4,
+			Idioma:           "español",
 		},
 		{
 			LibroID:          005,
 			Titulo:           "Sobre la brevedad de la vida",
 			Autor:            "Lucio A. Séneca",
-			FechaPublicacion: "2024 September",
+			FechaPublicacion: FechaParcial{Anio: 2024, Mes: 9},
 			Genero:           "Filosofía",
 			Url:              "www.libros.com/brevedad_vida",
+			Materias:         []int{2},
+			Descripcion:      "Ensayo de Séneca sobre el valor del tiempo y cómo vivir plenamente en lugar de desperdiciar la vida.",
+			ObraID:           5,
+			Idioma:           "español",
+		},
+		{
+			LibroID:          006,
+			Titulo:           "Letters from a Stoic",
+			Autor:            "Lucio A. Séneca",
+			FechaPublicacion: FechaParcial{Anio: 1969},
+			Genero:           "Filosofía",
+			Url:              "www.libros.com/letters_stoic",
+			Materias:         []int{2, 3},
+			Descripcion:      "Selección de las cartas de Séneca a Lucilio traducida al inglés.",
+			ISBN:             "9780140442106",
+			ObraID:           1,
+			Idioma:           "inglés",
+			Editorial:        "Penguin Classics",
 		},
 	}
 
-	libreria = &Libreria{Libros: libros}
+	/*El catalogo se carga de libros.json. Si no se puede leer el servidor no arranca,
+	para no reemplazar el archivo con la semilla al guardar*/
+	sembrado, err := cargarLibros(libros)
+	if err != nil {
+		log.Fatalf("Error al cargar libros.json: %v (si tiene fechas en el formato anterior, ejecute con -migrar-libros)", err)
+	}
+
+	/*Creacion de autores
+	Los autores se cargan de autores.json o de la semilla, y los libros que solo tienen
+	el texto Autor se vinculan con ellos unificando las distintas formas del nombre*/
+
+	autores := []*Autor{
+		{
+			AutorID:       1,
+			Nombre:        "Lucio Anneo Séneca",
+			Variantes:     []string{"Séneca"},
+			Fallecimiento: &FechaParcial{Anio: 65},
+			Biografia:     "Filósofo estoico, político y dramaturgo romano nacido en Córdoba, consejero del emperador Nerón.",
+		},
+		{
+			AutorID:   2,
+			Nombre:    "Epicteto",
+			Biografia: "Filósofo estoico griego que vivió como esclavo en Roma; sus enseñanzas fueron recogidas por su discípulo Arriano.",
+		},
+		{
+			AutorID:       3,
+			Nombre:        "Marco Aurelio",
+			Variantes:     []string{"Marcus Aurelius"},
+			Nacimiento:    &FechaParcial{Anio: 121, Mes: 4, Dia: 26},
+			Fallecimiento: &FechaParcial{Anio: 180, Mes: 3, Dia: 17},
+			Biografia:     "Emperador romano y filósofo estoico, autor de las Meditaciones.",
+		},
+	}
+
+	if err := cargarAutores(autores, libreria.Libros); err != nil {
+		fmt.Fprintln(os.Stderr, "Error al cargar los autores:", err)
+	}
+
+	/*Creacion de materias
+	Vocabulario controlado con jerarquia; los libros que solo tienen el texto Genero
+	se vinculan con la materia que corresponde a ese nombre o a una de sus variantes*/
+
+	materias := []*Materia{
+		{
+			MateriaID: 1,
+			Nombre:    "Filosofía",
+			Variantes: []string{"Philosophy"},
+			Dewey:     "100",
+			CDU:       "1",
+		},
+		{
+			MateriaID: 2,
+			Nombre:    "Estoicismo",
+			PadreID:   1,
+			Variantes: []string{"Stoicism", "Estoica"},
+			Dewey:     "188",
+			CDU:       "1(38)",
+		},
+		{
+			MateriaID: 3,
+			Nombre:    "Ética",
+			PadreID:   1,
+			Variantes: []string{"Ethics", "Moral"},
+			Dewey:     "170",
+			CDU:       "17",
+		},
+	}
+
+	if err := cargarMaterias(materias, libreria.Libros); err != nil {
+		fmt.Fprintln(os.Stderr, "Error al cargar las materias:", err)
+	}
+
+	/*Creacion de obras
+	Cada libro es una edicion o traduccion de una obra; los libros sin obra se agrupan
+	con las ediciones del mismo titulo y autor o crean su propia obra*/
+
+	obras := []*Obra{
+		{
+			ObraID:         1,
+			Titulo:         "Cartas de un Estoico",
+			TituloOriginal: "Epistulae morales ad Lucilium",
+			Autor:          "Lucio Anneo Séneca",
+			IdiomaOriginal: "latín",
+		},
+		{
+			ObraID:         2,
+			Titulo:         "Los Discursos de Epicteto",
+			TituloOriginal: "Diatribai",
+			Autor:          "Epicteto",
+			IdiomaOriginal: "griego",
+		},
+		{
+			ObraID:         3,
+			Titulo:         "Manual de Epicteto",
+			TituloOriginal: "Enchiridion",
+			Autor:          "Epicteto",
+			IdiomaOriginal: "griego",
+		},
+		{
+			ObraID:         4,
+			Titulo:         "Meditaciones",
+			TituloOriginal: "Ta eis heauton",
+			Autor:          "Marco Aurelio",
+			IdiomaOriginal: "griego",
+		},
+		{
+			ObraID:         5,
+			Titulo:         "Sobre la brevedad de la vida",
+			TituloOriginal: "De brevitate vitae",
+			Autor:          "Lucio Anneo Séneca",
+			IdiomaOriginal: "latín",
+		},
+	}
+
+	if err := cargarObras(obras, libreria.Libros); err != nil {
+		fmt.Fprintln(os.Stderr, "Error al cargar las obras:", err)
+	}
+	// La traduccion inglesa de las cartas de la semilla se vincula tambien con su traductor
+	if sembrado {
+		vincularAutores(libros[5], map[RolAutor]string{RolAutorPrincipal: libros[5].Autor, RolTraductor: "Robin Campbell"})
+	}
+
+	for _, libro := range libreria.Libros {
+		indice.Agregar(libro)
+	}
+
+	// La comprobacion de MARC necesita las materias, los autores y las obras ya cargados
+	if *comprobarMARC != "" {
+		if err := ejecutarComprobacionMARC(*comprobarMARC); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	/*Creacion de inventario
 	Utilizamos un slice [] para crear varios libros ya que constantemente se puede
@@ -1014,29 +1778,54 @@ func main() {
 			InventarioId: 001,
 			LibroID:      libros[0].LibroID,
 			Disponible:   true,
+			CodigoBarras: "BIB-0001",
+			Ubicacion:    "Sala A - Estante 1",
 		},
 		{
 			InventarioId: 002,
 			LibroID:      libros[1].LibroID,
 			Disponible:   true,
+			CodigoBarras: "BIB-0002",
+			Ubicacion:    "Sala A - Estante 1",
 		},
 		{
 			InventarioId: 003,
 			LibroID:      libros[2].LibroID,
 			Disponible:   true,
+			CodigoBarras: "BIB-0003",
+			Ubicacion:    "Sala A - Estante 2",
 		},
 		{
 			InventarioId: 004,
 			LibroID:      libros[3].LibroID,
 			Disponible:   true,
+			CodigoBarras: "BIB-0004",
+			Ubicacion:    "Sala B - Estante 1",
 		},
 		{
 			InventarioId: 005,
 			LibroID:      libros[4].LibroID,
 			Disponible:   true,
+			CodigoBarras: "BIB-0005",
+			Ubicacion:    "Sala B - Estante 2",
 		},
 	}
 
+	/*El inventario y los prestamos se cargan de sus archivos y la semilla solo se usa
+	si no existen; si no se pueden leer el servidor no arranca para no reemplazarlos*/
+	if err := cargarInventario(inventario); err != nil {
+		log.Fatal("Error al cargar inventario.json: ", err)
+	}
+
+	if err := cargarDescargas(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error al cargar el registro de descargas:", err)
+	}
+
+	// Las reservas se cargan despues del inventario para volver a apartar sus ejemplares
+	if err := cargarReservas(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error al cargar las reservas:", err)
+	}
+
 	/*Creacion de prestamos
 	Utilizamos un slice [] para crear varios prestamos ya que constantemente se puede
 	requerir crear mas en el futuro*/
@@ -1045,83 +1834,192 @@ func main() {
 		{
 			PrestamoID:      001,
 			LibroID:         libros[0].LibroID,
-			UsuarioID:       usuarios[0].UsuarioID,
+			UsuarioID:       usuarios[0].CuentaID,
 			FechaReserva:    time.Now(),
 			FechaDevolucion: time.Now().AddDate(0, 0, 5),
 		},
 		{
 			PrestamoID:      002,
 			LibroID:         libros[1].LibroID,
-			UsuarioID:       usuarios[1].UsuarioID,
+			UsuarioID:       usuarios[1].CuentaID,
 			FechaReserva:    time.Now(),
 			FechaDevolucion: time.Now().AddDate(0, 0, 5),
 		},
 		{
 			PrestamoID:      003,
 			LibroID:         libros[2].LibroID,
-			UsuarioID:       usuarios[2].UsuarioID,
+			UsuarioID:       usuarios[2].CuentaID,
 			FechaReserva:    time.Now(),
 			FechaDevolucion: time.Now().AddDate(0, 0, 5),
 		},
 		{
 			PrestamoID:      004,
 			LibroID:         libros[3].LibroID,
-			UsuarioID:       usuarios[3].UsuarioID,
+			UsuarioID:       usuarios[3].CuentaID,
 			FechaReserva:    time.Now(),
 			FechaDevolucion: time.Now().AddDate(0, 0, 5),
 		},
 		{
 			PrestamoID:      005,
 			LibroID:         libros[4].LibroID,
-			UsuarioID:       usuarios[4].UsuarioID,
+			UsuarioID:       usuarios[4].CuentaID,
 			FechaReserva:    time.Now(),
 			FechaDevolucion: time.Now().AddDate(0, 0, 5),
 		},
 	}
 
+	if err := cargarPrestamos(prestamos); err != nil {
+		log.Fatal("Error al cargar prestamos.json: ", err)
+	}
+
+	/*Las cuentas se cargan de cuentas.json; si aun no existe se migran los archivos
+	anteriores de administradores y usuarios, y si tampoco existen se usan las anteriores*/
+	if err := cargarCuentas(append(administradores, usuarios...)); err != nil {
+		fmt.Fprintln(os.Stderr, "Error al cargar las cuentas:", err)
+	}
+
+	/*La exportacion a CSV usa los mismos datos que sirve la web. Termina antes de
+	guardar los listados, y los avisos de la carga van a la salida de errores para
+	que la salida estandar tenga solo el CSV*/
+	if *exportarEntidad != "" {
+		entidad, err := buscarEntidadCSV(*exportarEntidad)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := escribirCSV(os.Stdout, entidad); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	//Progreso de lectura; se borra el de los prestamos que ya terminaron
+	if err := cargarProgresos(); err != nil {
+		fmt.Println("Error al cargar el progreso de lectura:", err)
+	} else if err := limpiarProgresos(time.Now()); err != nil {
+		fmt.Println("Error al guardar el progreso de lectura:", err)
+	}
+	go limpiarProgresosPeriodicamente()
+
+	//Servicio de correo y tokens enviados previamente
+	correo = nuevoCorreo()
+	if err := loadFromJSON("tokens.json", &listadotoken.Tokens); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error al cargar los tokens:", err)
+	}
+
+	//Inicio de sesion institucional (opcional)
+	if os.Getenv("OIDC_EMISOR") != "" {
+		p, err := descubrirOIDC(configOIDCEntorno())
+		if err != nil {
+			fmt.Println("Error al configurar OIDC:", err)
+		} else {
+			proveedorOIDC = p
+		}
+	}
+
 	//Funcion para guardar la informacion en los archivos JSON
-	//Administradores
-	if err := saveToJSON(administradores, "administradores.json"); err != nil {
-		fmt.Println("Error al guardar los administradores:", err)
+	//Cuentas
+	if err := guardarCuentas(listadocuenta.Cuentas); err != nil {
+		fmt.Println("Error al guardar las cuentas:", err)
 	}
 
-	//Usuarios
-	if err := saveToJSON(usuarios, "usuarios.json"); err != nil {
-		fmt.Println("Error al guardar los usuarios:", err)
+	//Autores
+	if err := saveToJSON(listadoautor.Autores, "autores.json"); err != nil {
+		fmt.Println("Error al guardar los autores:", err)
+	}
+	if err := saveToJSON(listadomateria.Materias, "materias.json"); err != nil {
+		fmt.Println("Error al guardar las materias:", err)
+	}
+	if err := saveToJSON(listadoobra.Obras, "obras.json"); err != nil {
+		fmt.Println("Error al guardar las obras:", err)
 	}
 
 	//Libros
-	if err := saveToJSON(libros, "libros.json"); err != nil {
+	if err := saveToJSON(libreria.Libros, "libros.json"); err != nil {
 		fmt.Println("Error al guardar los registros de libros:", err)
 	}
 
 	//Inventarios
-	if err := saveToJSON(inventario, "inventario.json"); err != nil {
+	if err := saveToJSON(listadoinventario.Inventarios, "inventario.json"); err != nil {
 		fmt.Println("Error al guardar el inventario:", err)
 	}
 
 	//Prestamos
-	if err := saveToJSON(prestamos, "prestamos.json"); err != nil {
+	if err := saveToJSON(listadoprestamo.Prestamos, "prestamos.json"); err != nil {
 		fmt.Println("Error al guardar los prestamos:", err)
 	}
 
 	//Generamos el servicio web para ver nuestras funcionalidades
 
 	http.HandleFunc("/", homePage)
-	http.HandleFunc("/crear-admin", crearAdministrador)
-	http.HandleFunc("/crear-user", crearUsuario)
-	http.HandleFunc("/crear-book", crearLibro)
-	http.HandleFunc("/visualizar-admin", visualizarAdministrador)
-	http.HandleFunc("/visualizar-user", visualizarUsuario)
-	http.HandleFunc("/visualizar-libro", visualizarLibro)
+	http.HandleFunc("/crear-admin", requiereRol(RolAdministrador, crearAdministrador))
+	http.HandleFunc("/crear-user", requiereRol(RolAdministrador, crearUsuario))
+	http.HandleFunc("/crear-book", requiereRol(RolAdministrador, escrituraCatalogo(crearLibro)))
+	http.HandleFunc("/editar-book", requiereRol(RolAdministrador, escrituraCatalogo(editarLibro)))
+	http.HandleFunc("/eliminar-book", requiereRol(RolAdministrador, escrituraCatalogo(eliminarLibro)))
+	http.HandleFunc("/visualizar-admin", requiereRol(RolAdministrador, visualizarAdministrador))
+	http.HandleFunc("/visualizar-user", requiereRol(RolAdministrador, visualizarUsuario))
+	http.HandleFunc("/visualizar-cuentas", requiereRol(RolAdministrador, visualizarCuentas))
+	http.HandleFunc("/visualizar-libro", lecturaCatalogo(visualizarLibro))
 	http.HandleFunc("/visualizar-inv", visualizarInventario)
 	http.HandleFunc("/visualizar-pres", visualizarPrestamos)
-	http.HandleFunc("/buscar-libro", buscarLibro)
+	http.HandleFunc("/buscar-libro", lecturaCatalogo(buscarLibro))
+	http.HandleFunc("/buscar-libro-nombre", lecturaCatalogo(buscarLibroNombre))
+	http.HandleFunc("/buscar-cuenta", requiereRol(RolAdministrador, buscarCuenta))
+	http.HandleFunc("/buscar-prestamo", requiereRol(RolAdministrador, lecturaCatalogo(buscarPrestamo)))
+	http.HandleFunc("/buscar-inventario", buscarInventario)
+	http.HandleFunc("/buscar-isbn", lecturaCatalogo(buscarISBN))
+	http.HandleFunc("/autor", lecturaCatalogo(verAutor))
+	http.HandleFunc("/editar-autor", requiereRol(RolAdministrador, escrituraCatalogo(editarAutor)))
+	http.HandleFunc("/materias", lecturaCatalogo(explorarMaterias))
+	http.HandleFunc("/editar-materia", requiereRol(RolAdministrador, escrituraCatalogo(editarMateria)))
+	http.HandleFunc("/subir-archivo", requiereRol(RolAdministrador, subirArchivo))
+	http.HandleFunc("/importar-epub", requiereRol(RolAdministrador, importarEPUB))
+	http.HandleFunc("/importar-marc", requiereRol(RolAdministrador, importarMARC))
+	http.HandleFunc("/exportar-marc", lecturaCatalogo(exportarMARC))
+	http.HandleFunc("/importar-csv", requiereRol(RolAdministrador, importarCSV))
+	http.HandleFunc("/exportar-csv", requiereRol(RolAdministrador, lecturaCatalogo(exportarCSV)))
+	http.HandleFunc("/subir-portada", requiereRol(RolAdministrador, subirPortada))
+	http.HandleFunc("/portada", servirPortada)
+	http.HandleFunc("/descargar", requiereRol(RolUsuario, descargarArchivo))
+	http.HandleFunc("/descargas", requiereRol(RolAdministrador, verDescargas))
+	http.HandleFunc("/prestamo-digital", requiereRol(RolUsuario, lecturaCatalogo(prestarDigital)))
+	http.HandleFunc("/leer", leerPrestamo)
+	http.HandleFunc("/lector", requiereRol(RolUsuario, lecturaCatalogo(lector)))
+	http.HandleFunc("/lector/", requiereRol(RolUsuario, recursoLector))
+	http.HandleFunc("/progreso", requiereRol(RolUsuario, lecturaCatalogo(progresoLectura)))
+	http.HandleFunc("/privacidad", requiereRol(RolUsuario, privacidad))
+	http.HandleFunc("/static/lector.js", scriptLectorJS)
+	http.HandleFunc("/opds", catalogoOPDS)
+	http.HandleFunc("/opds/", catalogoOPDS)
+	http.HandleFunc("/opds/prestar", prestarOPDS)
+	http.HandleFunc("/opds/descargar", descargarOPDS)
+	http.HandleFunc("/obra", lecturaCatalogo(verObra))
+	http.HandleFunc("/editar-obra", requiereRol(RolAdministrador, escrituraCatalogo(editarObra)))
+	http.HandleFunc("/reservar", requiereRol(RolUsuario, lecturaCatalogo(reservar)))
+	http.HandleFunc("/mis-reservas", requiereRol(RolUsuario, lecturaCatalogo(misReservas)))
+	http.HandleFunc("/cancelar-reserva", requiereRol(RolUsuario, lecturaCatalogo(cancelarReserva)))
+	http.HandleFunc("/agregar-ejemplar", requiereRol(RolAdministrador, lecturaCatalogo(agregarEjemplar)))
+	http.HandleFunc("/prestar-ejemplar", requiereRol(RolAdministrador, lecturaCatalogo(prestarEjemplar)))
+	http.HandleFunc("/devolver-ejemplar", requiereRol(RolAdministrador, lecturaCatalogo(devolverEjemplar)))
+	http.HandleFunc("/buscar", lecturaCatalogo(buscarCatalogo))
+	http.HandleFunc("/buscar-avanzada", lecturaCatalogo(buscarAvanzada))
+	http.HandleFunc("/catalogo", lecturaCatalogo(catalogo))
+	http.HandleFunc("/autocompletar", lecturaCatalogo(autocompletar))
+	http.HandleFunc("/static/autocompletar.js", scriptAutocompletado)
 	http.HandleFunc("/validar-permisos", consultarPermisos)
+	http.HandleFunc("/verificar-correo", verificarCorreo)
+	http.HandleFunc("/olvide-contrasena", olvideContrasena)
+	http.HandleFunc("/restablecer-contrasena", restablecerContrasena)
+	http.HandleFunc("/registro", registrarUsuario)
+	http.HandleFunc("/solicitudes", requiereRol(RolAdministrador, revisarSolicitudes))
+	http.HandleFunc("/login", login)
+	http.HandleFunc("/logout", logout)
+	http.HandleFunc("/oidc/login", loginOIDC)
+	http.HandleFunc("/oidc/callback", callbackOIDC)
 	http.HandleFunc("/away", awayPage)
 
 	fmt.Println("Servidor iniciado en el puerto 8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", seguridad(http.DefaultServeMux)))
 
 	//Imprimir detalles de administradores
 	for _, admin := range administradores {
@@ -1144,21 +2042,21 @@ func main() {
 	}
 
 	// Prueba de manejo de errores intentando crear un prestamo sin datos
-	admin, err := nuevoAdministrador(0, "", "", "", "")
+	admin, err := nuevaCuenta(0, "", "", "", RolAdministrador)
 	if err != nil {
 		fmt.Println("Error:", err)
 	} else {
 		fmt.Printf("Préstamo registrado: %+v\n", admin)
 	}
 
-	user, err := nuevoUsuario(0, "", "", "", "")
+	user, err := nuevaCuenta(0, "", "", "", RolUsuario)
 	if err != nil {
 		fmt.Println("Error:", err)
 	} else {
 		fmt.Printf("Préstamo registrado: %+v\n", user)
 	}
 
-	libro, err := nuevoLibro(0, "", "", time.Date(2024, time.September, 21, 0, 0, 0, 0, time.UTC), "", "")
+	libro, err := nuevoLibro(0, "", "", "21 de septiembre de 2024", "", "")
 	if err != nil {
 		fmt.Println("Error:", err)
 	} else {
@@ -1172,7 +2070,7 @@ func main() {
 		fmt.Printf("Préstamo registrado: %+v\n", invent)
 	}
 
-	prestamo, err := nuevoPrestamo(0, libros[0].libroID, usuarios[0].usuarioID, time.Now(), time.Now().AddDate(0, 0, 5))
+	prestamo, err := nuevoPrestamo(0, libros[0].libroID, usuarios[0].CuentaID, time.Now(), time.Now().AddDate(0, 0, 5))
 	if err != nil {
 		fmt.Println("Error:", err)
 	} else {
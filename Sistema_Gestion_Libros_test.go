@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Cambia al directorio temporal de la prueba, porque las funciones guardan sus JSON en el directorio actual
+func enDirectorioTemporal(t *testing.T) {
+	t.Helper()
+	anterior, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(anterior) })
+}
+
+// Envia un formulario por POST al handler y devuelve la respuesta
+func enviarFormulario(manejador http.HandlerFunc, datos url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(datos.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	manejador(w, r)
+	return w
+}
+
+// Correo enviado durante una prueba
+type correoEnviado struct {
+	Para, Asunto, Cuerpo string
+}
+
+// Servicio de correo que guarda los mensajes en memoria
+type correoPrueba struct {
+	mu       sync.Mutex
+	enviados []correoEnviado
+}
+
+func (c *correoPrueba) Enviar(para, asunto, cuerpo string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enviados = append(c.enviados, correoEnviado{para, asunto, cuerpo})
+	return nil
+}
+
+// Reemplaza el servicio de correo por uno en memoria mientras dura la prueba
+func capturarCorreos(t *testing.T) *correoPrueba {
+	t.Helper()
+	anterior := correo
+	prueba := &correoPrueba{}
+	correo = prueba
+	t.Cleanup(func() { correo = anterior })
+	return prueba
+}
+
+func TestEliminarLibro(t *testing.T) {
+	enDirectorioTemporal(t)
+	anterior, anteriorIndice := libreria, indice
+	anteriorInventario, anteriorPrestamos := listadoinventario.Inventarios, listadoprestamo.Prestamos
+	t.Cleanup(func() {
+		libreria, indice = anterior, anteriorIndice
+		listadoinventario.Inventarios, listadoprestamo.Prestamos = anteriorInventario, anteriorPrestamos
+	})
+
+	libros := []*Libro{
+		{LibroID: 1, Titulo: "Meditaciones", Autor: "Marco Aurelio"},
+		{LibroID: 2, Titulo: "Cartas a Lucilio", Autor: "Séneca"},
+		{LibroID: 3, Titulo: "Manual de Epicteto", Autor: "Epicteto"},
+	}
+	libreria = &Libreria{Libros: libros}
+	indice = nuevoIndice()
+	for _, l := range libros {
+		indice.Agregar(l)
+	}
+	listadoinventario.Inventarios = []*Inventario{{InventarioId: 1, LibroID: 1, Disponible: true}}
+	listadoprestamo.Prestamos = []*Prestamo{{PrestamoID: 1, LibroID: 2, UsuarioID: 1}}
+
+	for id, want := range map[string]int{"1": http.StatusConflict, "2": http.StatusConflict, "9": http.StatusNotFound, "x": http.StatusBadRequest} {
+		if w := enviarFormulario(eliminarLibro, url.Values{"id": {id}}); w.Code != want {
+			t.Errorf("eliminar el libro %s = %d, se esperaba %d", id, w.Code, want)
+		}
+	}
+	if w := enviarFormulario(eliminarLibro, url.Values{"id": {"3"}}); w.Code != http.StatusOK {
+		t.Fatalf("eliminar = %d: %s", w.Code, w.Body)
+	}
+
+	// La lista anterior queda intacta para quien la estuviera recorriendo
+	if len(libreria.Libros) != 2 || libros[2].LibroID != 3 || libros[1].LibroID != 2 {
+		t.Errorf("libros %v, lista anterior %v", idsLibros(libreria.Libros), idsLibros(libros))
+	}
+	if len(indice.Buscar("epicteto", 10)) != 0 {
+		t.Error("el libro eliminado sigue en el índice")
+	}
+	var guardados []*Libro
+	if err := loadFromJSON("libros.json", &guardados); err != nil || len(guardados) != 2 {
+		t.Errorf("libros.json tiene %d libros: %v", len(guardados), err)
+	}
+}
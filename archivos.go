@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Carpeta del almacen de archivos y tamano maximo de cada archivo subido
+const (
+	dirArchivos       = "archivos"
+	maxTamanoArchivo  = 100 << 20
+	formatoEPUB       = "epub"
+	formatoPDF        = "pdf"
+	tipoContenidoEPUB = "application/epub+zip"
+	tipoContenidoPDF  = "application/pdf"
+)
+
+// Archivo electronico de un libro. El contenido se guarda en el almacen con su hash SHA-256
+type ArchivoLibro struct {
+	Hash    string    `json:"hash"`
+	Formato string    `json:"formato"`
+	Nombre  string    `json:"nombre"`
+	Tamano  int64     `json:"tamano"`
+	Subido  time.Time `json:"subido"`
+}
+
+/*
+Almacen direccionado por contenido: cada archivo se guarda en archivos/ab/abcdef...
+segun el hash SHA-256 de sus bytes, por lo que subir dos veces el mismo archivo
+no ocupa espacio adicional y el contenido no puede cambiar sin cambiar su hash.
+*/
+func rutaBlob(hash string) string {
+	return filepath.Join(dirArchivos, hash[:2], hash)
+}
+
+func esHash(texto string) bool {
+	if len(texto) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(texto)
+	return err == nil
+}
+
+// Guarda el contenido en el almacen y devuelve su hash y tamano
+func guardarBlob(contenido io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(dirArchivos, 0o750); err != nil {
+		return "", 0, err
+	}
+	temporal, err := os.CreateTemp(dirArchivos, "subida-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(temporal.Name())
+	defer temporal.Close()
+
+	h := sha256.New()
+	tamano, err := io.Copy(io.MultiWriter(temporal, h), contenido)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := temporal.Close(); err != nil {
+		return "", 0, err
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	destino := rutaBlob(hash)
+	if _, err := os.Stat(destino); err == nil {
+		return hash, tamano, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(destino), 0o750); err != nil {
+		return "", 0, err
+	}
+	return hash, tamano, os.Rename(temporal.Name(), destino)
+}
+
+// Abre un archivo del almacen
+func abrirBlob(hash string) (*os.File, error) {
+	if !esHash(hash) {
+		return nil, errors.New("hash de archivo inválido")
+	}
+	return os.Open(rutaBlob(hash))
+}
+
+/*
+Reconoce el formato por los primeros bytes: un PDF empieza con "%PDF-" y un EPUB es
+un ZIP cuya primera entrada es "mimetype" con el texto application/epub+zip.
+*/
+func detectarFormato(inicio []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(inicio, []byte("%PDF-")):
+		return formatoPDF, nil
+	case bytes.HasPrefix(inicio, []byte("PK\x03\x04")) && len(inicio) >= 58 &&
+		string(inicio[30:38]) == "mimetype" && string(inicio[38:58]) == tipoContenidoEPUB:
+		return formatoEPUB, nil
+	}
+	return "", errors.New("el archivo debe ser un EPUB o un PDF")
+}
+
+func tipoContenido(formato string) string {
+	if formato == formatoEPUB {
+		return tipoContenidoEPUB
+	}
+	return tipoContenidoPDF
+}
+
+// Archivo del libro en el formato indicado (el primero si no se indica)
+func (l *Libro) BuscarArchivo(formato string) (*ArchivoLibro, error) {
+	for i := range l.Archivos {
+		if formato == "" || l.Archivos[i].Formato == formato {
+			return &l.Archivos[i], nil
+		}
+	}
+	return nil, errors.New("el libro no tiene un archivo en ese formato")
+}
+
+/*
+Copia del archivo del libro en el formato pedido. Se busca con el catalogo tomado para
+lectura y se envia despues de soltarlo, para que una descarga lenta no frene a quien
+edita el catalogo.
+*/
+func archivoCatalogo(libroID int, formato string) (ArchivoLibro, error) {
+	muCatalogo.RLock()
+	defer muCatalogo.RUnlock()
+	libro, err := libreria.BuscarID(libroID)
+	if err != nil {
+		return ArchivoLibro{}, err
+	}
+	archivo, err := libro.BuscarArchivo(formato)
+	if err != nil {
+		return ArchivoLibro{}, err
+	}
+	return *archivo, nil
+}
+
+// Prestamo vigente del usuario para el libro, o nil si no tiene; no se llama con muCirculacion tomado
+func prestamoActivo(usuarioID, libroID int, ahora time.Time) *Prestamo {
+	muCirculacion.RLock()
+	defer muCirculacion.RUnlock()
+	for _, p := range listadoprestamo.Prestamos {
+		if p.UsuarioID == usuarioID && p.LibroID == libroID && p.Vigente(ahora) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Registro de auditoria de cada intento de descarga, permitido o no
+type RegistroDescarga struct {
+	Fecha     time.Time `json:"fecha"`
+	UsuarioID int       `json:"usuario_id"`
+	LibroID   int       `json:"libro_id"`
+	Hash      string    `json:"hash,omitempty"`
+	Rango     string    `json:"rango,omitempty"`
+	Estado    int       `json:"estado"`
+	IP        string    `json:"ip"`
+}
+
+/*
+Registro de descargas en memoria y en descargas.log, con un registro JSON por linea.
+Cada descarga solo agrega su linea al archivo en lugar de reescribir todo el registro.
+*/
+type Listadodescarga struct {
+	mu        sync.Mutex
+	Descargas []*RegistroDescarga
+}
+
+var listadodescarga Listadodescarga
+
+const archivoDescargas = "descargas.log"
+
+// Agrega el registro y su linea al final de descargas.log
+func (ld *Listadodescarga) registrar(d *RegistroDescarga) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	ld.Descargas = append(ld.Descargas, d)
+	if err := agregarDescargas(d); err != nil {
+		fmt.Println("Error al guardar el registro de descargas:", err)
+	}
+}
+
+// Registros que cumplen el filtro, copiados para leerlos sin el candado
+func (ld *Listadodescarga) filtrar(cumple func(d *RegistroDescarga) bool) []*RegistroDescarga {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	descargas := []*RegistroDescarga{}
+	for _, d := range ld.Descargas {
+		if cumple(d) {
+			descargas = append(descargas, d)
+		}
+	}
+	return descargas
+}
+
+func agregarDescargas(descargas ...*RegistroDescarga) error {
+	f, err := os.OpenFile(archivoDescargas, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	codificador := json.NewEncoder(f)
+	for _, d := range descargas {
+		if err := codificador.Encode(d); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+/*
+Carga el registro de descargas al iniciar. Si solo existe el descargas.json de las
+versiones anteriores, sus registros se copian a descargas.log.
+*/
+func cargarDescargas() error {
+	f, err := os.Open(archivoDescargas)
+	if os.IsNotExist(err) {
+		err = loadFromJSON("descargas.json", &listadodescarga.Descargas)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return agregarDescargas(listadodescarga.Descargas...)
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decodificador := json.NewDecoder(f)
+	for {
+		d := &RegistroDescarga{}
+		if err := decodificador.Decode(d); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", archivoDescargas, err)
+		}
+		listadodescarga.Descargas = append(listadodescarga.Descargas, d)
+	}
+}
+
+// Guarda el codigo de estado que se envio para registrarlo en la auditoria
+type respuestaConEstado struct {
+	http.ResponseWriter
+	estado int
+}
+
+func (rw *respuestaConEstado) WriteHeader(estado int) {
+	rw.estado = estado
+	rw.ResponseWriter.WriteHeader(estado)
+}
+
+// Codigo HTML para subir el archivo electronico de un libro
+var uploadTemplate = template.Must(template.New("subir").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Subir Archivo</title>
+</head>
+<body>
+	<h1>Subir archivo de {{.Libro.Titulo}}</h1>
+	{{if .Libro.Archivos}}
+	<ul>
+		{{range .Libro.Archivos}}<li>{{.Formato}}: {{.Nombre}} ({{.Tamano}} bytes)</li>{{end}}
+	</ul>
+	{{end}}
+	<form action="/subir-archivo" method="post" enctype="multipart/form-data">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<input type="hidden" name="libroID" value="{{.Libro.LibroID}}">
+		<label for="archivo">Archivo EPUB o PDF:</label>
+		<input type="file" id="archivo" name="archivo" accept=".epub,.pdf,application/epub+zip,application/pdf" required><br>
+		<button type="submit">Subir</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para subir un EPUB o PDF de un libro; reemplaza el archivo anterior del mismo formato
+func subirArchivo(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		muCatalogo.RLock()
+		defer muCatalogo.RUnlock()
+		id, err := strconv.Atoi(r.URL.Query().Get("libroID"))
+		if err != nil {
+			http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		libro, err := libreria.BuscarID(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		datos := struct {
+			CSRF  string
+			Libro *Libro
+		}{tokenCSRF(r), libro}
+		if err := uploadTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archivo, cabecera, err := r.FormFile("archivo")
+	if err != nil {
+		http.Error(w, "Adjunte un archivo EPUB o PDF de hasta 100 MB", http.StatusBadRequest)
+		return
+	}
+	defer archivo.Close()
+
+	id, err := strconv.Atoi(r.FormValue("libroID"))
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	muCatalogo.RLock()
+	_, err = libreria.BuscarID(id)
+	muCatalogo.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if cabecera.Size > maxTamanoArchivo {
+		http.Error(w, "El archivo supera los 100 MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	inicio := make([]byte, 58)
+	n, _ := io.ReadFull(archivo, inicio)
+	formato, err := detectarFormato(inicio[:n])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := archivo.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Error al leer el archivo", http.StatusInternalServerError)
+		return
+	}
+	hash, tamano, err := guardarBlob(archivo)
+	if err != nil {
+		http.Error(w, "Error al guardar el archivo", http.StatusInternalServerError)
+		return
+	}
+
+	// El archivo se guarda sin el candado del catalogo; solo el cambio del libro lo toma
+	muCatalogo.Lock()
+	defer muCatalogo.Unlock()
+	libro, err := libreria.BuscarID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	nuevo := ArchivoLibro{Hash: hash, Formato: formato, Nombre: filepath.Base(cabecera.Filename), Tamano: tamano, Subido: time.Now()}
+	archivos := []ArchivoLibro{nuevo}
+	for _, a := range libro.Archivos {
+		if a.Formato != formato {
+			archivos = append(archivos, a)
+		}
+	}
+	libro.Archivos = archivos
+	if err := saveToJSON(libreria.Libros, "libros.json"); err != nil {
+		http.Error(w, "Error al guardar los libros", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(nuevo)
+}
+
+/*
+Funcion para descargar o leer el archivo de un libro (/descargar?libroID=&formato=).
+Solo se entrega a quien tiene un prestamo vigente del libro. Admite peticiones Range
+para leer por partes y cada intento queda en el registro de descargas.
+*/
+func descargarArchivo(w http.ResponseWriter, r *http.Request) {
+	entregarArchivo(w, r, cuentaActual(r))
+}
+
+// Entrega el archivo a la cuenta si tiene un prestamo vigente; la usan la descarga y el catalogo OPDS
+func entregarArchivo(w http.ResponseWriter, r *http.Request, cuenta *Cuenta) {
+	registro := &RegistroDescarga{Fecha: time.Now(), UsuarioID: cuenta.CuentaID, Rango: r.Header.Get("Range"), IP: r.RemoteAddr}
+	rw := &respuestaConEstado{ResponseWriter: w, estado: http.StatusOK}
+	defer func() {
+		registro.Estado = rw.estado
+		listadodescarga.registrar(registro)
+	}()
+
+	id, err := strconv.Atoi(r.URL.Query().Get("libroID"))
+	if err != nil {
+		http.Error(rw, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	registro.LibroID = id
+	if prestamoActivo(cuenta.CuentaID, id, registro.Fecha) == nil {
+		http.Error(rw, "Necesita un préstamo vigente de este libro para descargarlo", http.StatusForbidden)
+		return
+	}
+	archivo, err := archivoCatalogo(id, strings.ToLower(r.URL.Query().Get("formato")))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	servirArchivo(rw, r, archivo, "attachment", registro)
+}
+
+/*
+Envia el archivo con soporte de Range y anota su hash en el registro. La disposicion
+es "attachment" para descargarlo o "inline" para el lector.
+*/
+func servirArchivo(w http.ResponseWriter, r *http.Request, archivo ArchivoLibro, disposicion string, registro *RegistroDescarga) {
+	registro.Hash = archivo.Hash
+
+	contenido, err := abrirBlob(archivo.Hash)
+	if err != nil {
+		http.Error(w, "El archivo no está disponible", http.StatusNotFound)
+		return
+	}
+	defer contenido.Close()
+
+	w.Header().Set("Content-Type", tipoContenido(archivo.Formato))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposicion, archivo.Nombre))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("ETag", `"`+archivo.Hash+`"`)
+	http.ServeContent(w, r, archivo.Nombre, archivo.Subido, contenido)
+}
+
+// Funcion para consultar el registro de descargas (filtros opcionales libroID y usuario)
+func verDescargas(w http.ResponseWriter, r *http.Request) {
+	libroID, _ := strconv.Atoi(r.URL.Query().Get("libroID"))
+	usuarioID, _ := strconv.Atoi(r.URL.Query().Get("usuario"))
+	responderJSON(w, listadodescarga.filtrar(func(d *RegistroDescarga) bool {
+		return (libroID == 0 || d.LibroID == libroID) && (usuarioID == 0 || d.UsuarioID == usuarioID)
+	}))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Cantidad maxima de sugerencias guardadas en cada nodo del indice de prefijos
+const maxSugerencias = 10
+
+// Sugerencia de autocompletado: un titulo o un autor del catalogo
+type SugerenciaAuto struct {
+	Texto   string `json:"texto"`
+	Tipo    string `json:"tipo"`
+	LibroID int    `json:"libro_id,omitempty"`
+	Peso    int    `json:"peso"`
+}
+
+type nodoPrefijo struct {
+	hijos   map[rune]*nodoPrefijo
+	mejores []int // posiciones en entradas, ordenadas por peso
+}
+
+/*
+Indice de prefijos (trie) con los titulos y autores. Cada nodo guarda las mejores
+sugerencias para su prefijo, por lo que completar solo recorre las letras escritas.
+Se reconstruye cuando cambian los libros o los prestamos.
+*/
+type IndicePrefijos struct {
+	mu             sync.Mutex
+	raiz           *nodoPrefijo
+	entradas       []SugerenciaAuto
+	desactualizado bool
+}
+
+var prefijos = &IndicePrefijos{desactualizado: true}
+
+// Marca el indice para reconstruirlo en la siguiente consulta
+func (ip *IndicePrefijos) Invalidar() {
+	ip.mu.Lock()
+	ip.desactualizado = true
+	ip.mu.Unlock()
+}
+
+// Compara dos sugerencias: mayor peso primero y luego orden alfabetico
+func (ip *IndicePrefijos) antes(a, b int) bool {
+	if ip.entradas[a].Peso != ip.entradas[b].Peso {
+		return ip.entradas[a].Peso > ip.entradas[b].Peso
+	}
+	return ip.entradas[a].Texto < ip.entradas[b].Texto
+}
+
+func (ip *IndicePrefijos) insertar(clave string, entrada int) {
+	nodo := ip.raiz
+	for _, r := range clave {
+		hijo, ok := nodo.hijos[r]
+		if !ok {
+			hijo = &nodoPrefijo{hijos: map[rune]*nodoPrefijo{}}
+			nodo.hijos[r] = hijo
+		}
+		nodo = hijo
+		if contieneEntero(nodo.mejores, entrada) {
+			continue
+		}
+		nodo.mejores = append(nodo.mejores, entrada)
+		sort.Slice(nodo.mejores, func(i, j int) bool { return ip.antes(nodo.mejores[i], nodo.mejores[j]) })
+		if len(nodo.mejores) > maxSugerencias {
+			nodo.mejores = nodo.mejores[:maxSugerencias]
+		}
+	}
+}
+
+func contieneEntero(lista []int, valor int) bool {
+	for _, v := range lista {
+		if v == valor {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconstruye el indice con el catalogo, ponderado por la cantidad de prestamos
+func (ip *IndicePrefijos) reconstruir(libros []*Libro, prestamos []*Prestamo) {
+	popularidad := map[int]int{}
+	for _, p := range prestamos {
+		popularidad[p.LibroID]++
+	}
+
+	ip.raiz = &nodoPrefijo{hijos: map[rune]*nodoPrefijo{}}
+	ip.entradas = nil
+	autores := map[string]int{}
+	for _, l := range libros {
+		ip.entradas = append(ip.entradas, SugerenciaAuto{
+			Texto:   l.Titulo,
+			Tipo:    "titulo",
+			LibroID: l.LibroID,
+			Peso:    popularidad[l.LibroID] + 1,
+		})
+		for _, nombre := range l.NombresAutores() {
+			pos, ok := autores[normalizarTexto(nombre)]
+			if !ok {
+				pos = len(ip.entradas)
+				autores[normalizarTexto(nombre)] = pos
+				ip.entradas = append(ip.entradas, SugerenciaAuto{Texto: nombre, Tipo: "autor"})
+			}
+			ip.entradas[pos].Peso += popularidad[l.LibroID] + 1
+		}
+	}
+
+	// Cada entrada se inserta desde cada una de sus palabras para completar "brev" con
+	// "Sobre la brevedad de la vida"
+	for i, e := range ip.entradas {
+		palabras := tokenizar(e.Texto)
+		for j := range palabras {
+			ip.insertar(strings.Join(palabras[j:], " "), i)
+		}
+	}
+	ip.desactualizado = false
+}
+
+// Devuelve las n mejores sugerencias para el prefijo escrito
+func (ip *IndicePrefijos) Completar(prefijo string, n int) []SugerenciaAuto {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	if ip.desactualizado {
+		ip.reconstruir(libreria.Libros, listadoprestamo.Prestamos)
+	}
+
+	clave := strings.Join(tokenizar(prefijo), " ")
+	if clave == "" {
+		return nil
+	}
+	nodo := ip.raiz
+	for _, r := range clave {
+		if nodo = nodo.hijos[r]; nodo == nil {
+			return nil
+		}
+	}
+
+	res := make([]SugerenciaAuto, 0, min(n, len(nodo.mejores)))
+	for _, pos := range nodo.mejores {
+		if len(res) == n {
+			break
+		}
+		res = append(res, ip.entradas[pos])
+	}
+	return res
+}
+
+// Funcion para completar titulos y autores mientras el usuario escribe
+func autocompletar(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 || n > maxSugerencias {
+		n = 5
+	}
+	sugerencias := prefijos.Completar(r.URL.Query().Get("q"), n)
+	if sugerencias == nil {
+		sugerencias = []SugerenciaAuto{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if err := json.NewEncoder(w).Encode(sugerencias); err != nil {
+		http.Error(w, "Error al codificar la respuesta", http.StatusInternalServerError)
+	}
+}
+
+// Script que consulta /autocompletar en cada tecla y llena la lista de sugerencias del campo
+const scriptAutocompletar = `document.querySelectorAll("input[data-autocompletar]").forEach(function (campo) {
+	var lista = document.getElementById(campo.getAttribute("list"));
+	campo.addEventListener("input", function () {
+		if (campo.value.length < 2) { return; }
+		fetch("/autocompletar?q=" + encodeURIComponent(campo.value))
+			.then(function (r) { return r.json(); })
+			.then(function (sugerencias) {
+				lista.innerHTML = "";
+				sugerencias.forEach(function (s) {
+					var opcion = document.createElement("option");
+					opcion.value = s.texto;
+					lista.appendChild(opcion);
+				});
+			});
+	});
+});
+`
+
+func scriptAutocompletado(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(scriptAutocompletar))
+}
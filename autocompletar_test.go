@@ -0,0 +1,81 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// Indice de prefijos con cuatro libros; "Senderos de gloria" es el mas prestado
+func prefijosPrueba() *IndicePrefijos {
+	libros := []*Libro{
+		{LibroID: 1, Titulo: "Cartas a Lucilio", Autor: "Séneca"},
+		{LibroID: 2, Titulo: "Sobre la brevedad de la vida", Autor: "Séneca"},
+		{LibroID: 3, Titulo: "Sobre la ira", Autor: "Seneca"},
+		{LibroID: 4, Titulo: "Senderos de gloria", Autor: "Humphrey Cobb"},
+	}
+	var prestamos []*Prestamo
+	for _, id := range []int{4, 4, 4, 4, 2} {
+		prestamos = append(prestamos, &Prestamo{PrestamoID: len(prestamos) + 1, LibroID: id})
+	}
+	ip := &IndicePrefijos{}
+	ip.reconstruir(libros, prestamos)
+	return ip
+}
+
+func textosSugerencias(sugerencias []SugerenciaAuto) []string {
+	textos := []string{}
+	for _, s := range sugerencias {
+		textos = append(textos, s.Texto)
+	}
+	return textos
+}
+
+func TestCompletar(t *testing.T) {
+	ip := prefijosPrueba()
+	casos := []struct {
+		prefijo string
+		n       int
+		want    []string
+	}{
+		// El peso suma los prestamos de cada libro; el autor suma los de todos sus libros
+		{"sen", 5, []string{"Senderos de gloria", "Séneca"}},
+		{"SÉNE", 5, []string{"Séneca"}},
+		{"sobre", 5, []string{"Sobre la brevedad de la vida", "Sobre la ira"}},
+		{"brev", 5, []string{"Sobre la brevedad de la vida"}},
+		{"la v", 5, []string{"Sobre la brevedad de la vida"}},
+		{"s", 1, []string{"Senderos de gloria"}},
+		{"cobb", 5, []string{"Humphrey Cobb"}},
+		{"xyz", 5, []string{}},
+		{" ¿? ", 5, []string{}},
+	}
+	for _, caso := range casos {
+		if got := textosSugerencias(ip.Completar(caso.prefijo, caso.n)); !slices.Equal(got, caso.want) {
+			t.Errorf("Completar(%q, %d) = %q, se esperaba %q", caso.prefijo, caso.n, got, caso.want)
+		}
+	}
+
+	seneca := ip.Completar("seneca", 1)
+	if len(seneca) != 1 || seneca[0].Tipo != "autor" || seneca[0].Peso != 4 {
+		t.Errorf("sugerencia del autor %+v", seneca)
+	}
+	senderos := ip.Completar("senderos", 1)
+	if len(senderos) != 1 || senderos[0].Tipo != "titulo" || senderos[0].LibroID != 4 || senderos[0].Peso != 5 {
+		t.Errorf("sugerencia del título %+v", senderos)
+	}
+}
+
+func TestCompletarInvalidar(t *testing.T) {
+	anterior, anterioresPrestamos := libreria, listadoprestamo.Prestamos
+	t.Cleanup(func() { libreria, listadoprestamo.Prestamos = anterior, anterioresPrestamos })
+	libreria = &Libreria{Libros: []*Libro{{LibroID: 1, Titulo: "Meditaciones", Autor: "Marco Aurelio"}}}
+	listadoprestamo.Prestamos = nil
+
+	ip := prefijosPrueba()
+	if got := ip.Completar("medit", 5); len(got) != 0 {
+		t.Fatalf("el índice usó el catálogo antes de invalidarse: %+v", got)
+	}
+	ip.Invalidar()
+	if got := textosSugerencias(ip.Completar("medit", 5)); !slices.Equal(got, []string{"Meditaciones"}) {
+		t.Errorf("tras invalidar = %q", got)
+	}
+}
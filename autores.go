@@ -0,0 +1,534 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rol de una persona en un libro
+type RolAutor string
+
+const (
+	RolAutorPrincipal RolAutor = "autor"
+	RolTraductor      RolAutor = "traductor"
+	RolEditor         RolAutor = "editor"
+)
+
+/*
+Autor: una persona que participa en uno o mas libros. Nombre es la forma preferida
+y Variantes las otras formas en que aparece en el catalogo ("Séneca", "Lucio A. Séneca").
+*/
+type Autor struct {
+	AutorID       int           `json:"id"`
+	Nombre        string        `json:"nombre"`
+	Variantes     []string      `json:"variantes,omitempty"`
+	Nacimiento    *FechaParcial `json:"nacimiento,omitempty"`
+	Fallecimiento *FechaParcial `json:"fallecimiento,omitempty"`
+	Biografia     string        `json:"biografia,omitempty"`
+}
+
+// Relacion entre un libro y un autor con el rol que cumple
+type Participacion struct {
+	AutorID int      `json:"autor_id"`
+	Rol     RolAutor `json:"rol"`
+}
+
+// Autor de un libro junto con su rol, para mostrar en las paginas
+type ParticipanteLibro struct {
+	Autor *Autor
+	Rol   RolAutor
+}
+
+// Creamos la estructura autor con slice para guardar los autores
+type Listadoautor struct {
+	Autores []*Autor
+}
+
+var listadoautor Listadoautor
+
+var _ Busqueda[*Autor] = (*Listadoautor)(nil)
+
+// Autor
+func (a *Autor) GetNombre() string {
+	return a.Nombre
+}
+func (a *Autor) GetVariantes() []string {
+	return a.Variantes
+}
+func (a *Autor) GetBiografia() string {
+	return a.Biografia
+}
+func (a *Autor) SetNombre(nombre string) {
+	a.Nombre = nombre
+}
+func (a *Autor) SetBiografia(biografia string) {
+	a.Biografia = biografia
+}
+
+// Indica si el nombre es la forma preferida o una variante del autor
+func (a *Autor) TieneNombre(nombre string) bool {
+	buscado := strings.Join(tokenizar(nombre), " ")
+	for _, n := range append([]string{a.Nombre}, a.Variantes...) {
+		if strings.Join(tokenizar(n), " ") == buscado {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Autor) agregarVariante(nombre string) {
+	if !a.TieneNombre(nombre) {
+		a.Variantes = append(a.Variantes, nombre)
+	}
+}
+
+// Busqueda de autores
+func (la *Listadoautor) BuscarID(id int) (*Autor, error) {
+	for _, a := range la.Autores {
+		if a.AutorID == id {
+			return a, nil
+		}
+	}
+	return nil, errors.New("autor no encontrado con el ID digitado")
+}
+
+// Busqueda por el nombre preferido o cualquiera de sus variantes
+func (la *Listadoautor) BuscarNombre(nombre string) ([]*Autor, error) {
+	var resultados []*Autor
+	for _, a := range la.Autores {
+		if contienePalabras(nombre, append([]string{a.Nombre}, a.Variantes...)...) {
+			resultados = append(resultados, a)
+		}
+	}
+	if len(resultados) == 0 {
+		return nil, errors.New("no existen autores con ese nombre")
+	}
+	return resultados, nil
+}
+
+// Palabras de un nombre sin iniciales, por ejemplo "Lucio A. Séneca" -> lucio, seneca
+func palabrasNombre(nombre string) []string {
+	var palabras []string
+	for _, p := range tokenizar(nombre) {
+		if len([]rune(p)) > 1 {
+			palabras = append(palabras, p)
+		}
+	}
+	return palabras
+}
+
+/*
+Dos nombres corresponden a la misma persona si terminan con el mismo apellido y
+todas las palabras del nombre mas corto aparecen en el mas largo: "Séneca" y
+"Lucio A. Séneca" coinciden, pero "Marco Aurelio" y "Aurelio Prudencio" no.
+*/
+func mismoAutor(a, b string) bool {
+	pa, pb := palabrasNombre(a), palabrasNombre(b)
+	if len(pa) == 0 || len(pb) == 0 || pa[len(pa)-1] != pb[len(pb)-1] {
+		return false
+	}
+	if len(pa) > len(pb) {
+		pa, pb = pb, pa
+	}
+	for _, p := range pa {
+		if !contiene(pb, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Separa varios autores escritos en un mismo campo ("Autor Uno; Autor Dos")
+func dividirAutores(texto string) []string {
+	var nombres []string
+	for _, n := range strings.FieldsFunc(texto, func(r rune) bool { return r == ';' || r == '&' }) {
+		if n = strings.TrimSpace(n); n != "" {
+			nombres = append(nombres, n)
+		}
+	}
+	return nombres
+}
+
+func (la *Listadoautor) siguienteID() int {
+	id := 0
+	for _, a := range la.Autores {
+		id = max(id, a.AutorID)
+	}
+	return id + 1
+}
+
+/*
+Devuelve el autor con ese nombre. Si es una forma nueva de un autor existente se
+agrega como variante (y pasa a ser la forma preferida si es mas completa); si no
+coincide con nadie se crea el autor. El texto indica lo que se hizo para el reporte.
+*/
+func (la *Listadoautor) resolver(nombre string) (*Autor, string) {
+	for _, a := range la.Autores {
+		if a.TieneNombre(nombre) {
+			return a, ""
+		}
+	}
+	for _, a := range la.Autores {
+		if !mismoAutor(a.Nombre, nombre) {
+			continue
+		}
+		if len(palabrasNombre(nombre)) > len(palabrasNombre(a.Nombre)) {
+			a.Variantes = append(a.Variantes, a.Nombre)
+			a.Nombre = nombre
+		} else {
+			a.agregarVariante(nombre)
+		}
+		return a, fmt.Sprintf("%q se unió al autor %d (%s)", nombre, a.AutorID, a.Nombre)
+	}
+	a := &Autor{AutorID: la.siguienteID(), Nombre: nombre}
+	la.Autores = append(la.Autores, a)
+	return a, fmt.Sprintf("autor %d creado: %s", a.AutorID, nombre)
+}
+
+/*
+Reemplaza los autores del libro por los nombres indicados para cada rol. Los
+nombres de cada rol pueden separarse con ";" o "&". Devuelve los cambios hechos
+en la lista de autores.
+*/
+func vincularAutores(l *Libro, nombres map[RolAutor]string) []string {
+	var cambios []string
+	l.Autores = nil
+	for _, rol := range []RolAutor{RolAutorPrincipal, RolTraductor, RolEditor} {
+		for _, nombre := range dividirAutores(nombres[rol]) {
+			a, cambio := listadoautor.resolver(nombre)
+			if cambio != "" {
+				cambios = append(cambios, cambio)
+			}
+			l.Autores = append(l.Autores, Participacion{AutorID: a.AutorID, Rol: rol})
+		}
+	}
+	return cambios
+}
+
+// Autores del libro con su rol
+func (l *Libro) Participantes() []ParticipanteLibro {
+	var participantes []ParticipanteLibro
+	for _, p := range l.Autores {
+		if a, err := listadoautor.BuscarID(p.AutorID); err == nil {
+			participantes = append(participantes, ParticipanteLibro{a, p.Rol})
+		}
+	}
+	return participantes
+}
+
+// Nombres preferidos de los autores principales; si el libro no tiene autores vinculados se usa Autor
+func (l *Libro) NombresAutores() []string {
+	var nombres []string
+	for _, p := range l.Participantes() {
+		if p.Rol == RolAutorPrincipal {
+			nombres = append(nombres, p.Autor.Nombre)
+		}
+	}
+	if len(nombres) == 0 && l.Autor != "" {
+		nombres = append(nombres, l.Autor)
+	}
+	return nombres
+}
+
+// Texto con todos los nombres y variantes de los autores, para buscar
+func (l *Libro) textoAutores() string {
+	textos := []string{l.Autor}
+	for _, p := range l.Participantes() {
+		textos = append(textos, p.Autor.Nombre)
+		textos = append(textos, p.Autor.Variantes...)
+	}
+	return strings.Join(textos, " ")
+}
+
+// Nombres de un rol escritos como en el formulario ("Autor Uno; Autor Dos")
+func (l *Libro) NombresRol(rol RolAutor) string {
+	var nombres []string
+	for _, p := range l.Participantes() {
+		if p.Rol == rol {
+			nombres = append(nombres, p.Autor.Nombre)
+		}
+	}
+	return strings.Join(nombres, "; ")
+}
+
+// Resultado de la migracion de autores
+type ReporteAutores struct {
+	Libros  int
+	Cambios []string
+}
+
+// Vincula con autores los libros que solo tienen el texto Autor, unificando los nombres repetidos
+func migrarAutores(libros []*Libro) *ReporteAutores {
+	reporte := &ReporteAutores{}
+	for _, l := range libros {
+		if len(l.Autores) > 0 || l.Autor == "" {
+			continue
+		}
+		reporte.Libros++
+		reporte.Cambios = append(reporte.Cambios, vincularAutores(l, map[RolAutor]string{RolAutorPrincipal: l.Autor})...)
+	}
+	return reporte
+}
+
+func imprimirReporteAutores(w io.Writer, reporte *ReporteAutores) {
+	fmt.Fprintf(w, "Libros vinculados con sus autores: %d\n", reporte.Libros)
+	for _, cambio := range reporte.Cambios {
+		fmt.Fprintln(w, cambio)
+	}
+}
+
+// Migra los autores de libros.json a autores.json (opcion -migrar-autores)
+func ejecutarMigracionAutores() error {
+	var libros []*Libro
+	if err := loadFromJSON("libros.json", &libros); err != nil {
+		return fmt.Errorf("error al leer libros.json: %w", err)
+	}
+	if err := loadFromJSON("autores.json", &listadoautor.Autores); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error al leer autores.json: %w", err)
+	}
+	imprimirReporteAutores(os.Stdout, migrarAutores(libros))
+	if err := saveToJSON(listadoautor.Autores, "autores.json"); err != nil {
+		return err
+	}
+	return saveToJSON(libros, "libros.json")
+}
+
+// Carga los autores al iniciar (autores.json o la semilla) y vincula los libros que aun no tienen autores
+func cargarAutores(semilla []*Autor, libros []*Libro) error {
+	err := loadFromJSON("autores.json", &listadoautor.Autores)
+	if os.IsNotExist(err) {
+		listadoautor.Autores = semilla
+	} else if err != nil {
+		return err
+	}
+	if reporte := migrarAutores(libros); len(reporte.Cambios) > 0 {
+		imprimirReporteAutores(os.Stderr, reporte)
+	}
+	return nil
+}
+
+// Obras de un autor agrupadas por rol
+type ObrasAutor struct {
+	Rol    RolAutor `json:"rol"`
+	Libros []*Libro `json:"libros"`
+}
+
+func obrasAutor(a *Autor) []ObrasAutor {
+	var obras []ObrasAutor
+	for _, rol := range []RolAutor{RolAutorPrincipal, RolTraductor, RolEditor} {
+		grupo := ObrasAutor{Rol: rol}
+		for _, l := range libreria.Libros {
+			for _, p := range l.Autores {
+				if p.AutorID == a.AutorID && p.Rol == rol {
+					grupo.Libros = append(grupo.Libros, l)
+					break
+				}
+			}
+		}
+		if len(grupo.Libros) > 0 {
+			sort.SliceStable(grupo.Libros, func(i, j int) bool {
+				return grupo.Libros[i].FechaPublicacion.Comparar(grupo.Libros[j].FechaPublicacion) < 0
+			})
+			obras = append(obras, grupo)
+		}
+	}
+	return obras
+}
+
+// Codigo HTML para la pagina de un autor y la lista de autores
+var authorTemplate = template.Must(template.New("autor").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>{{if .Autor}}{{.Autor.Nombre}}{{else}}Autores{{end}}</title>
+</head>
+<body>
+	{{if .Autor}}
+	<h1>{{.Autor.Nombre}}</h1>
+	{{if or .Autor.Nacimiento .Autor.Fallecimiento}}<p>({{with .Autor.Nacimiento}}{{.}}{{else}}?{{end}} – {{with .Autor.Fallecimiento}}{{.}}{{end}})</p>{{end}}
+	{{if .Autor.Variantes}}<p>También aparece como: {{range $i, $v := .Autor.Variantes}}{{if $i}}, {{end}}{{$v}}{{end}}</p>{{end}}
+	{{if .Autor.Biografia}}<p>{{.Autor.Biografia}}</p>{{end}}
+	{{range .Obras}}
+	<h2>Como {{.Rol}}</h2>
+	<ul>
+		{{range .Libros}}<li>{{with .URLPortada "pequena"}}<img src="{{.}}" alt="" width="80"> {{end}}{{.Titulo}}{{with .FechaPublicacion.ISO}} ({{.}}){{end}}</li>{{end}}
+	</ul>
+	{{else}}
+	<p>No hay libros de este autor en el catálogo.</p>
+	{{end}}
+	<p><a href="/autor">Todos los autores</a></p>
+	{{else}}
+	<h1>Autores</h1>
+	<ul>
+		{{range .Autores}}<li><a href="/autor?id={{.AutorID}}">{{.Nombre}}</a></li>{{end}}
+	</ul>
+	{{end}}
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para ver la pagina de un autor con todas sus obras (?id=) o la lista de autores (formato=json para JSON)
+func verAutor(w http.ResponseWriter, r *http.Request) {
+	datos := struct {
+		Autor   *Autor       `json:"autor,omitempty"`
+		Obras   []ObrasAutor `json:"obras,omitempty"`
+		Autores []*Autor     `json:"autores,omitempty"`
+	}{}
+
+	if texto := r.URL.Query().Get("id"); texto != "" {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		if datos.Autor, err = listadoautor.BuscarID(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		datos.Obras = obrasAutor(datos.Autor)
+	} else {
+		datos.Autores = append([]*Autor(nil), listadoautor.Autores...)
+		sort.Slice(datos.Autores, func(i, j int) bool {
+			return normalizarTexto(datos.Autores[i].Nombre) < normalizarTexto(datos.Autores[j].Nombre)
+		})
+	}
+
+	if r.URL.Query().Get("formato") == "json" {
+		responderJSON(w, datos)
+		return
+	}
+	if err := authorTemplate.Execute(w, datos); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Codigo HTML para editar un autor
+var editAuthorTemplate = template.Must(template.New("editarAutor").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Editar Autor</title>
+</head>
+<body>
+	<h1>Editar Autor {{.Autor.AutorID}}</h1>
+	<form action="/editar-autor" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<input type="hidden" name="id" value="{{.Autor.AutorID}}">
+		<label for="nombre">Nombre preferido:</label>
+		<input type="text" id="nombre" name="nombre" value="{{.Autor.Nombre}}" required><br>
+		<label for="variantes">Variantes (una por línea):</label><br>
+		<textarea id="variantes" name="variantes" rows="3" cols="50">{{range .Autor.Variantes}}{{.}}
+{{end}}</textarea><br>
+		<label for="nacimiento">Nacimiento:</label>
+		<input type="text" id="nacimiento" name="nacimiento" value="{{with .Autor.Nacimiento}}{{.ISO}}{{end}}"><br>
+		<label for="fallecimiento">Fallecimiento:</label>
+		<input type="text" id="fallecimiento" name="fallecimiento" value="{{with .Autor.Fallecimiento}}{{.ISO}}{{end}}"><br>
+		<label for="biografia">Biografía:</label><br>
+		<textarea id="biografia" name="biografia" rows="4" cols="50">{{.Autor.Biografia}}</textarea><br>
+		<button type="submit">Guardar</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Fecha opcional del formulario: vacia si no se indica
+func fechaOpcional(texto string) (*FechaParcial, error) {
+	if strings.TrimSpace(texto) == "" {
+		return nil, nil
+	}
+	fecha, err := ParseFechaParcial(texto)
+	if err != nil {
+		return nil, err
+	}
+	return &fecha, nil
+}
+
+// Funcion para editar el nombre, las variantes, las fechas y la biografia de un autor
+func editarAutor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	autor, err := listadoautor.BuscarID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		datos := struct {
+			CSRF  string
+			Autor *Autor
+		}{tokenCSRF(r), autor}
+		if err := editAuthorTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	nombre := strings.TrimSpace(r.FormValue("nombre"))
+	if nombre == "" {
+		http.Error(w, "El nombre es obligatorio", http.StatusBadRequest)
+		return
+	}
+	nacimiento, err := fechaOpcional(r.FormValue("nacimiento"))
+	if err != nil {
+		http.Error(w, "Nacimiento: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	fallecimiento, err := fechaOpcional(r.FormValue("fallecimiento"))
+	if err != nil {
+		http.Error(w, "Fallecimiento: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if nacimiento != nil && fallecimiento != nil && fallecimiento.Comparar(*nacimiento) < 0 {
+		http.Error(w, "La fecha de fallecimiento es anterior al nacimiento", http.StatusBadRequest)
+		return
+	}
+
+	autor.SetNombre(nombre)
+	autor.Variantes = nil
+	for _, v := range strings.Split(r.FormValue("variantes"), "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			autor.agregarVariante(v)
+		}
+	}
+	autor.Nacimiento, autor.Fallecimiento = nacimiento, fallecimiento
+	autor.SetBiografia(strings.TrimSpace(r.FormValue("biografia")))
+
+	// Los nombres del autor se indexan con sus libros
+	for _, l := range libreria.Libros {
+		for _, p := range l.Autores {
+			if p.AutorID == autor.AutorID {
+				indice.Agregar(l)
+				break
+			}
+		}
+	}
+	prefijos.Invalidar()
+	if err := saveToJSON(listadoautor.Autores, "autores.json"); err != nil {
+		http.Error(w, "Error al guardar los autores", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/autor?id="+strconv.Itoa(autor.AutorID), http.StatusSeeOther)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// Reemplaza los autores, el catalogo y el indice mientras dura la prueba
+func autoresPrueba(t *testing.T, autores []*Autor, libros []*Libro) {
+	t.Helper()
+	enDirectorioTemporal(t)
+	anterior, anteriorIndice, anteriores := libreria, indice, listadoautor.Autores
+	t.Cleanup(func() { libreria, indice, listadoautor.Autores = anterior, anteriorIndice, anteriores })
+	listadoautor.Autores = autores
+	libreria = &Libreria{Libros: libros}
+	indice = nuevoIndice()
+	for _, l := range libros {
+		indice.Agregar(l)
+	}
+}
+
+func TestMismoAutor(t *testing.T) {
+	casos := []struct {
+		a, b string
+		want bool
+	}{
+		{"Séneca", "Lucio A. Séneca", true},
+		{"Lucio Anneo Séneca", "SENECA, Lucio", false}, // el apellido debe ir al final
+		{"Marco Aurelio", "Aurelio Prudencio", false},
+		{"Marco Aurelio", "Aurelio", true},
+		{"J. R. R. Tolkien", "Tolkien", true},
+		{"A.", "A.", false}, // solo iniciales
+	}
+	for _, caso := range casos {
+		if got := mismoAutor(caso.a, caso.b); got != caso.want {
+			t.Errorf("mismoAutor(%q, %q) = %v", caso.a, caso.b, got)
+		}
+	}
+	if got := dividirAutores(" Epicteto; Arriano &  ; "); !slices.Equal(got, []string{"Epicteto", "Arriano"}) {
+		t.Errorf("dividirAutores = %q", got)
+	}
+}
+
+func TestVincularAutores(t *testing.T) {
+	autoresPrueba(t, []*Autor{{AutorID: 1, Nombre: "Séneca"}}, nil)
+
+	libro := &Libro{LibroID: 1, Titulo: "Cartas a Lucilio", Autor: "Lucio Anneo Séneca"}
+	cambios := vincularAutores(libro, map[RolAutor]string{
+		RolAutorPrincipal: "Lucio Anneo Séneca",
+		RolTraductor:      "Ramón Bultó; Séneca",
+	})
+	if len(listadoautor.Autores) != 2 || len(cambios) != 2 {
+		t.Fatalf("%d autores, cambios %q", len(listadoautor.Autores), cambios)
+	}
+	// La forma mas completa pasa a ser la preferida y la anterior queda como variante
+	seneca := listadoautor.Autores[0]
+	if seneca.Nombre != "Lucio Anneo Séneca" || !slices.Equal(seneca.Variantes, []string{"Séneca"}) || !seneca.TieneNombre("SENECA") {
+		t.Errorf("autor unido %+v", seneca)
+	}
+	want := []Participacion{{1, RolAutorPrincipal}, {2, RolTraductor}, {1, RolTraductor}}
+	if !slices.Equal(libro.Autores, want) {
+		t.Errorf("participaciones %+v", libro.Autores)
+	}
+	if got := libro.NombresRol(RolTraductor); got != "Ramón Bultó; Lucio Anneo Séneca" {
+		t.Errorf("traductores = %q", got)
+	}
+	if got := libro.NombresAutores(); !slices.Equal(got, []string{"Lucio Anneo Séneca"}) {
+		t.Errorf("autores = %q", got)
+	}
+
+	// Sin autores vinculados se usa el texto del libro
+	if got := (&Libro{Autor: "Anónimo"}).NombresAutores(); !slices.Equal(got, []string{"Anónimo"}) {
+		t.Errorf("autores sin vincular = %q", got)
+	}
+}
+
+func TestMigrarAutores(t *testing.T) {
+	libros := []*Libro{
+		{LibroID: 1, Autor: "Séneca"},
+		{LibroID: 2, Autor: "Lucio A. Séneca"},
+		{LibroID: 3, Autor: "Epicteto"},
+		{LibroID: 4, Autor: "Marco Aurelio", Autores: []Participacion{{AutorID: 9, Rol: RolAutorPrincipal}}},
+	}
+	autoresPrueba(t, nil, libros)
+
+	reporte := migrarAutores(libros)
+	if reporte.Libros != 3 || len(listadoautor.Autores) != 2 {
+		t.Fatalf("%d libros vinculados y %d autores; cambios %q", reporte.Libros, len(listadoautor.Autores), reporte.Cambios)
+	}
+	if libros[0].Autores[0].AutorID != libros[1].Autores[0].AutorID || libros[3].Autores[0].AutorID != 9 {
+		t.Errorf("participaciones %+v %+v %+v", libros[0].Autores, libros[1].Autores, libros[3].Autores)
+	}
+}
+
+func TestEditarAutor(t *testing.T) {
+	libro := &Libro{LibroID: 1, Titulo: "Manual", Autor: "Epicteto", Autores: []Participacion{{AutorID: 1, Rol: RolAutorPrincipal}}}
+	autoresPrueba(t, []*Autor{{AutorID: 1, Nombre: "Epicteto"}}, []*Libro{libro})
+
+	editar := func(datos url.Values) int {
+		return enviarFormulario(editarAutor, datos).Code
+	}
+	if codigo := editar(url.Values{"id": {"1"}, "nombre": {"Epicteto"}, "nacimiento": {"135"}, "fallecimiento": {"50"}}); codigo != http.StatusBadRequest {
+		t.Errorf("fallecimiento antes del nacimiento = %d", codigo)
+	}
+	if codigo := editar(url.Values{"id": {"2"}, "nombre": {"Arriano"}}); codigo != http.StatusNotFound {
+		t.Errorf("autor inexistente = %d", codigo)
+	}
+	if codigo := editar(url.Values{"id": {"1"}, "nombre": {"Epicteto de Hierápolis"}, "variantes": {"Epictetus\n\nEpíktētos\n"},
+		"nacimiento": {"50"}, "biografia": {"Filósofo estoico."}}); codigo != http.StatusSeeOther {
+		t.Fatalf("editar = %d", codigo)
+	}
+
+	autor := listadoautor.Autores[0]
+	if autor.Nombre != "Epicteto de Hierápolis" || !slices.Equal(autor.Variantes, []string{"Epictetus", "Epíktētos"}) ||
+		autor.Nacimiento == nil || autor.Nacimiento.Anio != 50 || autor.Fallecimiento != nil {
+		t.Errorf("autor editado %+v", autor)
+	}
+	// Las variantes nuevas se buscan en el indice con los libros del autor
+	if res := indice.Buscar("epictetus", 10); len(res) != 1 || res[0].Libro != libro {
+		t.Errorf("búsqueda por la variante: %+v", res)
+	}
+	var guardados []*Autor
+	if err := loadFromJSON("autores.json", &guardados); err != nil || len(guardados) != 1 || guardados[0].Nombre != autor.Nombre {
+		t.Errorf("autores.json: %v", err)
+	}
+}
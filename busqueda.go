@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Reemplazo de letras con tilde o diéresis por su version sin acento
+var sinAcentos = strings.NewReplacer(
+	"á", "a", "à", "a", "ä", "a", "â", "a", "ã", "a",
+	"é", "e", "è", "e", "ë", "e", "ê", "e",
+	"í", "i", "ì", "i", "ï", "i", "î", "i",
+	"ó", "o", "ò", "o", "ö", "o", "ô", "o", "õ", "o",
+	"ú", "u", "ù", "u", "ü", "u", "û", "u",
+	"ñ", "n", "ç", "c",
+)
+
+// Convierte el texto a minusculas y sin acentos para comparar
+func normalizarTexto(texto string) string {
+	return sinAcentos.Replace(strings.ToLower(texto))
+}
+
+// Divide el texto normalizado en palabras
+func tokenizar(texto string) []string {
+	return strings.FieldsFunc(normalizarTexto(texto), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Puntaje de una palabra buscada contra las palabras de un campo
+func puntajePalabra(buscada string, palabras []string) int {
+	mejor := 0
+	for _, p := range palabras {
+		switch {
+		case p == buscada:
+			return 3
+		case strings.HasPrefix(p, buscada):
+			mejor = max(mejor, 2)
+		case strings.Contains(p, buscada), coincideAproximado(buscada, p):
+			mejor = max(mejor, 1)
+		}
+	}
+	return mejor
+}
+
+// Resultado de busqueda con su puntaje de relevancia
+type ResultadoLibro struct {
+	Libro   *Libro `json:"libro"`
+	Puntaje int    `json:"puntaje"`
+}
+
+/*
+Busca libros cuyo titulo o autor contenga todas las palabras buscadas, sin
+distinguir mayusculas ni acentos. Las coincidencias en el titulo pesan mas que
+en el autor, y una palabra completa mas que un prefijo o una parte de ella.
+*/
+func (lib *Libreria) BuscarRelevancia(consulta string) []ResultadoLibro {
+	buscadas := tokenizar(consulta)
+	if len(buscadas) == 0 {
+		return nil
+	}
+	frase := strings.Join(buscadas, " ")
+
+	var resultados []ResultadoLibro
+	for _, libro := range lib.Libros {
+		titulo := tokenizar(libro.Titulo)
+		autor := tokenizar(libro.Autor)
+
+		total := 0
+		for _, b := range buscadas {
+			pt := puntajePalabra(b, titulo) * 2
+			pa := puntajePalabra(b, autor)
+			if pt == 0 && pa == 0 {
+				total = 0
+				break
+			}
+			total += max(pt, pa)
+		}
+		if total == 0 {
+			continue
+		}
+
+		tituloNormal := strings.Join(titulo, " ")
+		if tituloNormal == frase {
+			total += 10
+		} else if strings.Contains(tituloNormal, frase) {
+			total += 4
+		}
+		resultados = append(resultados, ResultadoLibro{Libro: libro, Puntaje: total})
+	}
+
+	sort.SliceStable(resultados, func(i, j int) bool {
+		return resultados[i].Puntaje > resultados[j].Puntaje
+	})
+	return resultados
+}
+
+// Codigo HTML para la pagina de busqueda por nombre
+var searchNameTemplate = template.Must(template.New("busquedaNombre").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Buscar Libro por Nombre</title>
+</head>
+<body>
+	<h1>Buscar Libro por Nombre</h1>
+	<form action="/buscar-libro-nombre" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<label for="nombre">Título o autor:</label>
+		<input type="text" id="nombre" name="nombre" list="sugerencias" autocomplete="off" data-autocompletar required>
+		<datalist id="sugerencias"></datalist>
+		<button type="submit">Buscar</button>
+	</form>
+	<script src="/static/autocompletar.js"></script>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+func buscarLibroNombre(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if err := searchNameTemplate.Execute(w, formulario(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Error al procesar el formulario", http.StatusBadRequest)
+			return
+		}
+
+		nombre := r.FormValue("nombre")
+		resultados := libreria.BuscarRelevancia(nombre)
+		if len(resultados) == 0 {
+			mensaje := "no existen libros con ese nombre"
+			if sugerencia := indice.Sugerir(nombre); sugerencia != "" {
+				mensaje += ". ¿Quiso decir: " + sugerencia + "?"
+			}
+			http.Error(w, mensaje, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resultados); err != nil {
+			http.Error(w, "Error al codificar la respuesta", http.StatusInternalServerError)
+		}
+	}
+}
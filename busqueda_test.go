@@ -0,0 +1,76 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// Catalogo de prueba para las busquedas por titulo y autor
+func libreriaBusquedaPrueba() *Libreria {
+	return &Libreria{Libros: []*Libro{
+		{LibroID: 1, Titulo: "Cartas a Lucilio", Autor: "Séneca"},
+		{LibroID: 2, Titulo: "Sobre Séneca y los estoicos", Autor: "Pierre Grimal"},
+		{LibroID: 3, Titulo: "Cien años de soledad", Autor: "Gabriel García Márquez"},
+		{LibroID: 4, Titulo: "Cartas", Autor: "Epicuro"},
+	}}
+}
+
+func idsLibros(libros []*Libro) []int {
+	ids := []int{}
+	for _, l := range libros {
+		ids = append(ids, l.LibroID)
+	}
+	return ids
+}
+
+func TestNormalizarTexto(t *testing.T) {
+	casos := map[string]string{
+		"Séneca":     "seneca",
+		"AÑOS":       "anos",
+		"Pingüino":   "pinguino",
+		"García M.":  "garcia m.",
+		"sin cambio": "sin cambio",
+	}
+	for texto, want := range casos {
+		if got := normalizarTexto(texto); got != want {
+			t.Errorf("normalizarTexto(%q) = %q, se esperaba %q", texto, got, want)
+		}
+	}
+}
+
+func TestBuscarNombre(t *testing.T) {
+	lib := libreriaBusquedaPrueba()
+	casos := []struct {
+		consulta string
+		want     []int
+	}{
+		{"SENECA", []int{2, 1}},            // el titulo pesa mas que el autor
+		{"soledad años", []int{3}},         // todas las palabras, en cualquier orden y sin acentos
+		{"marq", []int{3}},                 // prefijo de una palabra
+		{"cartas", []int{4, 1}},            // el titulo exacto va primero
+		{"cartas epicuro", []int{4}},       // titulo y autor a la vez
+		{"  cien,  AÑOS!  ", []int{3}},     // la puntuacion y los espacios no cuentan
+		{"ucili", []int{1}},                // parte de una palabra
+		{"cartas aristoteles", []int(nil)}, // falta una palabra
+	}
+	for _, caso := range casos {
+		libros, err := lib.BuscarNombre(caso.consulta)
+		if caso.want == nil {
+			if err == nil {
+				t.Errorf("BuscarNombre(%q) = %v, se esperaba un error", caso.consulta, idsLibros(libros))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("BuscarNombre(%q): %v", caso.consulta, err)
+			continue
+		}
+		if got := idsLibros(libros); !slices.Equal(got, caso.want) {
+			t.Errorf("BuscarNombre(%q) = %v, se esperaba %v", caso.consulta, got, caso.want)
+		}
+	}
+
+	if _, err := lib.BuscarNombre("  ¿? "); err == nil {
+		t.Error("una búsqueda sin palabras devolvió resultados")
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cada listado implementa la busqueda con su propio tipo, sin conversiones en los handlers
+var (
+	_ Busqueda[*Libro]      = (*Libreria)(nil)
+	_ Busqueda[*Cuenta]     = (*Listadocuenta)(nil)
+	_ Busqueda[*Prestamo]   = (*Listadoprestamo)(nil)
+	_ Busqueda[*Inventario] = (*Listadoinventario)(nil)
+)
+
+// Indica si todas las palabras buscadas aparecen en alguno de los textos
+func contienePalabras(consulta string, textos ...string) bool {
+	buscadas := tokenizar(consulta)
+	if len(buscadas) == 0 {
+		return false
+	}
+	normal := normalizarTexto(strings.Join(textos, " "))
+	for _, b := range buscadas {
+		if !strings.Contains(normal, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// Busqueda de cuentas
+func (lc *Listadocuenta) BuscarID(id int) (*Cuenta, error) {
+	for _, c := range lc.Cuentas {
+		if c.CuentaID == id {
+			return c, nil
+		}
+	}
+	return nil, errors.New("cuenta no encontrada con el ID digitado")
+}
+
+// Busqueda por nombre o correo sin distinguir mayusculas ni acentos
+func (lc *Listadocuenta) BuscarNombre(nombre string) ([]*Cuenta, error) {
+	var resultados []*Cuenta
+	for _, c := range lc.Cuentas {
+		if contienePalabras(nombre, c.Nombre, c.Mail) {
+			resultados = append(resultados, c)
+		}
+	}
+	if len(resultados) == 0 {
+		return nil, errors.New("no existen cuentas con ese nombre o correo")
+	}
+	return resultados, nil
+}
+
+// Busqueda de prestamos
+func (lp *Listadoprestamo) BuscarID(id int) (*Prestamo, error) {
+	for _, p := range lp.Prestamos {
+		if p.PrestamoID == id {
+			return p, nil
+		}
+	}
+	return nil, errors.New("préstamo no encontrado con el ID digitado")
+}
+
+// Busqueda por el titulo del libro o el nombre del usuario del prestamo
+func (lp *Listadoprestamo) BuscarNombre(nombre string) ([]*Prestamo, error) {
+	var resultados []*Prestamo
+	for _, p := range lp.Prestamos {
+		var textos []string
+		if libro, err := libreria.BuscarID(p.LibroID); err == nil {
+			textos = append(textos, libro.Titulo)
+		}
+		if cuenta := buscarCuentaID(p.UsuarioID); cuenta != nil {
+			textos = append(textos, cuenta.Nombre)
+		}
+		if contienePalabras(nombre, textos...) {
+			resultados = append(resultados, p)
+		}
+	}
+	if len(resultados) == 0 {
+		return nil, errors.New("no existen préstamos con ese libro o usuario")
+	}
+	return resultados, nil
+}
+
+// Prestamos que cumplen la condicion indicada
+func (lp *Listadoprestamo) filtrar(cumple func(p *Prestamo) bool) []*Prestamo {
+	var resultados []*Prestamo
+	for _, p := range lp.Prestamos {
+		if cumple(p) {
+			resultados = append(resultados, p)
+		}
+	}
+	return resultados
+}
+
+func (lp *Listadoprestamo) BuscarUsuario(usuarioID int) []*Prestamo {
+	return lp.filtrar(func(p *Prestamo) bool { return p.UsuarioID == usuarioID })
+}
+
+func (lp *Listadoprestamo) BuscarLibro(libroID int) []*Prestamo {
+	return lp.filtrar(func(p *Prestamo) bool { return p.LibroID == libroID })
+}
+
+// Prestamos vigentes en algun momento entre las dos fechas
+func (lp *Listadoprestamo) BuscarRango(desde, hasta time.Time) []*Prestamo {
+	return lp.filtrar(func(p *Prestamo) bool {
+		return !p.FechaReserva.After(hasta) && !p.FechaDevolucion.Before(desde)
+	})
+}
+
+// Busqueda de inventario
+func (li *Listadoinventario) BuscarID(id int) (*Inventario, error) {
+	for _, inv := range li.Inventarios {
+		if inv.InventarioId == id {
+			return inv, nil
+		}
+	}
+	return nil, errors.New("ejemplar no encontrado con el ID digitado")
+}
+
+// Busqueda por codigo de barras o ubicacion
+func (li *Listadoinventario) BuscarNombre(nombre string) ([]*Inventario, error) {
+	var resultados []*Inventario
+	for _, inv := range li.Inventarios {
+		if contienePalabras(nombre, inv.CodigoBarras, inv.Ubicacion) {
+			resultados = append(resultados, inv)
+		}
+	}
+	if len(resultados) == 0 {
+		return nil, errors.New("no existen ejemplares con ese código o ubicación")
+	}
+	return resultados, nil
+}
+
+func (li *Listadoinventario) BuscarCodigo(codigo string) (*Inventario, error) {
+	for _, inv := range li.Inventarios {
+		if inv.CodigoBarras != "" && strings.EqualFold(inv.CodigoBarras, strings.TrimSpace(codigo)) {
+			return inv, nil
+		}
+	}
+	return nil, errors.New("ejemplar no encontrado con el código de barras digitado")
+}
+
+func (li *Listadoinventario) BuscarUbicacion(ubicacion string) []*Inventario {
+	var resultados []*Inventario
+	for _, inv := range li.Inventarios {
+		if contienePalabras(ubicacion, inv.Ubicacion) {
+			resultados = append(resultados, inv)
+		}
+	}
+	return resultados
+}
+
+func responderJSON(w http.ResponseWriter, datos any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(datos); err != nil {
+		http.Error(w, "Error al codificar la respuesta", http.StatusInternalServerError)
+	}
+}
+
+/*
+Responde la busqueda por id o por q de cualquier listado. Devuelve false si la
+solicitud no trae ninguno de los dos para que el handler pruebe sus otros filtros.
+*/
+func responderBusqueda[T any](w http.ResponseWriter, r *http.Request, b Busqueda[T]) bool {
+	if texto := r.URL.Query().Get("id"); texto != "" {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+			return true
+		}
+		encontrado, err := b.BuscarID(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return true
+		}
+		responderJSON(w, encontrado)
+		return true
+	}
+	if q := r.URL.Query().Get("q"); q != "" {
+		resultados, err := b.BuscarNombre(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return true
+		}
+		responderJSON(w, resultados)
+		return true
+	}
+	return false
+}
+
+// Funcion para buscar cuentas por ID (?id=) o por nombre y correo (?q=)
+func buscarCuenta(w http.ResponseWriter, r *http.Request) {
+	if !responderBusqueda[*Cuenta](w, r, &listadocuenta) {
+		http.Error(w, "Indique id o q", http.StatusBadRequest)
+	}
+}
+
+// Funcion para buscar prestamos por ID, libro o usuario (?q=), IDs (?usuario=, ?libro=) o fechas (?desde=&hasta=)
+func buscarPrestamo(w http.ResponseWriter, r *http.Request) {
+	if responderBusqueda[*Prestamo](w, r, &listadoprestamo) {
+		return
+	}
+
+	params := r.URL.Query()
+	var resultados []*Prestamo
+	switch {
+	case params.Get("usuario") != "":
+		id, err := strconv.Atoi(params.Get("usuario"))
+		if err != nil {
+			http.Error(w, "El ID del usuario debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		resultados = listadoprestamo.BuscarUsuario(id)
+	case params.Get("libro") != "":
+		id, err := strconv.Atoi(params.Get("libro"))
+		if err != nil {
+			http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		resultados = listadoprestamo.BuscarLibro(id)
+	case params.Get("desde") != "" || params.Get("hasta") != "":
+		desde, hasta := time.Time{}, time.Now().AddDate(100, 0, 0)
+		var err error
+		if texto := params.Get("desde"); texto != "" {
+			if desde, err = time.Parse(time.DateOnly, texto); err != nil {
+				http.Error(w, "La fecha desde debe tener el formato AAAA-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+		if texto := params.Get("hasta"); texto != "" {
+			if hasta, err = time.Parse(time.DateOnly, texto); err != nil {
+				http.Error(w, "La fecha hasta debe tener el formato AAAA-MM-DD", http.StatusBadRequest)
+				return
+			}
+			// Se incluye todo el dia indicado
+			hasta = hasta.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		if hasta.Before(desde) {
+			http.Error(w, "La fecha hasta no puede ser anterior a desde", http.StatusBadRequest)
+			return
+		}
+		resultados = listadoprestamo.BuscarRango(desde, hasta)
+	default:
+		http.Error(w, "Indique id, q, usuario, libro o un rango desde/hasta", http.StatusBadRequest)
+		return
+	}
+
+	if resultados == nil {
+		resultados = []*Prestamo{}
+	}
+	responderJSON(w, resultados)
+}
+
+// Funcion para buscar ejemplares por ID, codigo de barras (?codigo=) o ubicacion (?ubicacion=)
+func buscarInventario(w http.ResponseWriter, r *http.Request) {
+	if responderBusqueda[*Inventario](w, r, &listadoinventario) {
+		return
+	}
+
+	params := r.URL.Query()
+	switch {
+	case params.Get("codigo") != "":
+		inv, err := listadoinventario.BuscarCodigo(params.Get("codigo"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		responderJSON(w, inv)
+	case params.Get("ubicacion") != "":
+		resultados := listadoinventario.BuscarUbicacion(params.Get("ubicacion"))
+		if resultados == nil {
+			resultados = []*Inventario{}
+		}
+		responderJSON(w, resultados)
+	default:
+		http.Error(w, "Indique id, q, codigo o ubicacion", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Dos cuentas, dos libros, un prestamo de cada uno en enero y febrero y un ejemplar de cada libro
+func listadosBusquedaPrueba(t *testing.T) {
+	t.Helper()
+	anterior, anterioresCuentas := libreria, listadocuenta.Cuentas
+	anterioresPrestamos, anteriorInventario := listadoprestamo.Prestamos, listadoinventario.Inventarios
+	t.Cleanup(func() {
+		libreria, listadocuenta.Cuentas = anterior, anterioresCuentas
+		listadoprestamo.Prestamos, listadoinventario.Inventarios = anterioresPrestamos, anteriorInventario
+	})
+
+	dia := func(mes, dia int) time.Time { return time.Date(2024, time.Month(mes), dia, 10, 0, 0, 0, time.UTC) }
+	libreria = &Libreria{Libros: []*Libro{{LibroID: 1, Titulo: "Meditaciones"}, {LibroID: 2, Titulo: "Cartas a Lucilio"}}}
+	listadocuenta.Cuentas = []*Cuenta{
+		{CuentaID: 1, Nombre: "Ana Pérez", Mail: "ana@correo.com"},
+		{CuentaID: 2, Nombre: "Luis Gómez", Mail: "luis@correo.com"},
+	}
+	listadoprestamo.Prestamos = []*Prestamo{
+		{PrestamoID: 1, LibroID: 1, UsuarioID: 1, FechaReserva: dia(1, 1), FechaDevolucion: dia(1, 15)},
+		{PrestamoID: 2, LibroID: 2, UsuarioID: 2, FechaReserva: dia(2, 1), FechaDevolucion: dia(2, 15)},
+	}
+	listadoinventario.Inventarios = []*Inventario{
+		{InventarioId: 1, LibroID: 1, CodigoBarras: "BIB-0001", Ubicacion: "Sala A, estante 3"},
+		{InventarioId: 2, LibroID: 2, CodigoBarras: "BIB-0002", Ubicacion: "Depósito"},
+	}
+}
+
+// Pide la busqueda y devuelve el codigo y los IDs de la respuesta, sea un objeto o una lista
+func buscarIDs(t *testing.T, manejador http.HandlerFunc, consulta string) (int, []int) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	manejador(w, httptest.NewRequest(http.MethodGet, "/?"+consulta, nil))
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+	var lista []struct{ ID int }
+	if err := json.Unmarshal(w.Body.Bytes(), &lista); err != nil {
+		var uno struct{ ID int }
+		if err := json.Unmarshal(w.Body.Bytes(), &uno); err != nil {
+			t.Fatalf("%s: respuesta %s", consulta, w.Body)
+		}
+		lista = append(lista, uno)
+	}
+	ids := []int{}
+	for _, e := range lista {
+		ids = append(ids, e.ID)
+	}
+	return w.Code, ids
+}
+
+func TestBuscarListados(t *testing.T) {
+	listadosBusquedaPrueba(t)
+	casos := []struct {
+		nombre    string
+		manejador http.HandlerFunc
+		consulta  string
+		codigo    int
+		ids       []int
+	}{
+		{"cuenta por id", buscarCuenta, "id=2", http.StatusOK, []int{2}},
+		{"cuenta por nombre", buscarCuenta, "q=PEREZ", http.StatusOK, []int{1}},
+		{"cuenta por correo", buscarCuenta, "q=correo", http.StatusOK, []int{1, 2}},
+		{"cuenta inexistente", buscarCuenta, "id=9", http.StatusNotFound, nil},
+		{"id no numérico", buscarCuenta, "id=x", http.StatusBadRequest, nil},
+		{"cuenta sin filtro", buscarCuenta, "", http.StatusBadRequest, nil},
+		{"préstamo por título", buscarPrestamo, "q=meditaciones", http.StatusOK, []int{1}},
+		{"préstamo por usuario", buscarPrestamo, "q=gomez", http.StatusOK, []int{2}},
+		{"préstamo sin coincidencias", buscarPrestamo, "q=platon", http.StatusNotFound, nil},
+		{"préstamos de un usuario", buscarPrestamo, "usuario=1", http.StatusOK, []int{1}},
+		{"préstamos de un libro", buscarPrestamo, "libro=2", http.StatusOK, []int{2}},
+		{"préstamos hasta el día de inicio", buscarPrestamo, "hasta=2024-01-01", http.StatusOK, []int{1}},
+		{"préstamos vigentes en el rango", buscarPrestamo, "desde=2024-01-15&hasta=2024-01-31", http.StatusOK, []int{1}},
+		{"préstamos posteriores", buscarPrestamo, "desde=2024-03-01", http.StatusOK, []int{}},
+		{"rango invertido", buscarPrestamo, "desde=2024-02-01&hasta=2024-01-01", http.StatusBadRequest, nil},
+		{"fecha inválida", buscarPrestamo, "desde=01/02/2024", http.StatusBadRequest, nil},
+		{"ejemplar por código", buscarInventario, "codigo=bib-0002", http.StatusOK, []int{2}},
+		{"ejemplar por ubicación", buscarInventario, "ubicacion=sala", http.StatusOK, []int{1}},
+		{"ejemplar por texto", buscarInventario, "q=deposito", http.StatusOK, []int{2}},
+		{"código inexistente", buscarInventario, "codigo=BIB-9", http.StatusNotFound, nil},
+		{"ejemplar sin filtro", buscarInventario, "", http.StatusBadRequest, nil},
+	}
+	for _, caso := range casos {
+		codigo, ids := buscarIDs(t, caso.manejador, caso.consulta)
+		if codigo != caso.codigo || !slices.Equal(ids, caso.ids) {
+			t.Errorf("%s: %d %v, se esperaba %d %v", caso.nombre, codigo, ids, caso.codigo, caso.ids)
+		}
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+Lenguaje de consultas para la busqueda avanzada del catalogo, por ejemplo:
+
+	autor:Epicteto AND genero:Filosofía AND year>=2000 NOT disponible:false
+
+Admite campos (campo:valor), frases entre comillas, comparaciones y rangos en los
+campos numericos (anio>=2000, anio:1990..2000), AND, OR, NOT o "-" y parentesis.
+Dos terminos seguidos sin operador se unen con AND; AND tiene prioridad sobre OR.
+Un valor terminado en * busca por prefijo (medit*). Para no agotar la pila con
+consultas armadas a proposito se limitan el largo y los niveles de anidacion.
+*/
+
+// Largo maximo de la consulta en caracteres y niveles de parentesis o negaciones
+const (
+	maxLargoConsulta     = 1000
+	maxAnidacionConsulta = 32
+)
+
+// Error de la consulta con la posicion (en caracteres, desde 1) donde se encontro
+type ErrorConsulta struct {
+	Posicion int
+	Mensaje  string
+}
+
+func (e *ErrorConsulta) Error() string {
+	return fmt.Sprintf("posición %d: %s", e.Posicion, e.Mensaje)
+}
+
+type tipoCampo int
+
+const (
+	campoTexto tipoCampo = iota
+	campoNumero
+	campoBooleano
+)
+
+// Campo que se puede consultar, con sus nombres en español e ingles
+type campoConsulta struct {
+	nombres  []string
+	tipo     tipoCampo
+	texto    func(l *Libro) string
+	numero   func(l *Libro) int
+	booleano func(l *Libro) bool
+}
+
+var camposConsulta = []campoConsulta{
+	{nombres: []string{"titulo", "title"}, texto: func(l *Libro) string { return l.Titulo + " " + l.Subtitulo }},
+	{nombres: []string{"autor", "author"}, texto: func(l *Libro) string { return l.textoAutores() }},
+	{nombres: []string{"genero", "genre", "materia", "subject"}, texto: func(l *Libro) string { return l.textoMaterias() }},
+	{nombres: []string{"descripcion", "description"}, texto: func(l *Libro) string { return l.Descripcion }},
+	{nombres: []string{"anio", "year"}, tipo: campoNumero, numero: func(l *Libro) int { return l.FechaPublicacion.Anio }},
+	{nombres: []string{"id"}, tipo: campoNumero, numero: func(l *Libro) int { return l.LibroID }},
+	{nombres: []string{"disponible", "available"}, tipo: campoBooleano, booleano: func(l *Libro) bool { return libroDisponible(l.LibroID) }},
+}
+
+// Texto en el que se buscan los terminos sin campo
+func textoLibro(l *Libro) string {
+	return strings.Join([]string{l.Titulo, l.Subtitulo, l.textoAutores(), l.textoMaterias(), l.Descripcion}, " ")
+}
+
+func buscarCampoConsulta(nombre string) *campoConsulta {
+	nombre = normalizarTexto(nombre)
+	for i := range camposConsulta {
+		if contiene(camposConsulta[i].nombres, nombre) {
+			return &camposConsulta[i]
+		}
+	}
+	return nil
+}
+
+// Tipos de elementos de la consulta
+type tipoElemento int
+
+const (
+	elemPalabra tipoElemento = iota
+	elemFrase
+	elemCampo
+	elemAbre
+	elemCierra
+	elemAnd
+	elemOr
+	elemNot
+	elemFin
+)
+
+type elementoConsulta struct {
+	tipo     tipoElemento
+	texto    string
+	operador string // solo en elemCampo: ":", "=", ">", ">=", "<" o "<="
+	posicion int
+}
+
+// Caracteres que terminan una palabra
+func separador(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(`()":<>=`, r)
+}
+
+// Divide la consulta en palabras, frases, campos, operadores y parentesis
+func dividirConsulta(consulta string) ([]elementoConsulta, error) {
+	runas := []rune(consulta)
+	var elementos []elementoConsulta
+	for i := 0; i < len(runas); {
+		r := runas[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			elementos = append(elementos, elementoConsulta{tipo: elemAbre, texto: "(", posicion: pos})
+			i++
+		case r == ')':
+			elementos = append(elementos, elementoConsulta{tipo: elemCierra, texto: ")", posicion: pos})
+			i++
+		case r == '"':
+			fin := i + 1
+			for fin < len(runas) && runas[fin] != '"' {
+				fin++
+			}
+			if fin == len(runas) {
+				return nil, &ErrorConsulta{pos, "comillas sin cerrar"}
+			}
+			elementos = append(elementos, elementoConsulta{tipo: elemFrase, texto: string(runas[i+1 : fin]), posicion: pos})
+			i = fin + 1
+		case r == '-' && i+1 < len(runas) && !unicode.IsSpace(runas[i+1]) && !strings.ContainsRune(":<>=)", runas[i+1]) &&
+			(len(elementos) == 0 || elementos[len(elementos)-1].tipo != elemCampo):
+			// Despues de un campo el guion es el signo del valor (anio>-5), no una negacion
+			elementos = append(elementos, elementoConsulta{tipo: elemNot, texto: "-", posicion: pos})
+			i++
+		case strings.ContainsRune(":<>=", r):
+			return nil, &ErrorConsulta{pos, fmt.Sprintf("falta el nombre del campo antes de %q", r)}
+		default:
+			fin := i
+			for fin < len(runas) && !separador(runas[fin]) {
+				fin++
+			}
+			palabra := string(runas[i:fin])
+			i = fin
+
+			if i < len(runas) && strings.ContainsRune(":<>=", runas[i]) {
+				operador := string(runas[i])
+				i++
+				if i < len(runas) && runas[i] == '=' && (operador == ">" || operador == "<") {
+					operador += "="
+					i++
+				}
+				elementos = append(elementos, elementoConsulta{tipo: elemCampo, texto: palabra, operador: operador, posicion: pos})
+				continue
+			}
+			switch palabra {
+			case "AND", "&&":
+				elementos = append(elementos, elementoConsulta{tipo: elemAnd, texto: palabra, posicion: pos})
+			case "OR", "||":
+				elementos = append(elementos, elementoConsulta{tipo: elemOr, texto: palabra, posicion: pos})
+			case "NOT":
+				elementos = append(elementos, elementoConsulta{tipo: elemNot, texto: palabra, posicion: pos})
+			default:
+				elementos = append(elementos, elementoConsulta{tipo: elemPalabra, texto: palabra, posicion: pos})
+			}
+		}
+	}
+	return append(elementos, elementoConsulta{tipo: elemFin, posicion: len(runas) + 1}), nil
+}
+
+// Condicion que debe cumplir un libro
+type filtroLibro func(l *Libro) bool
+
+// Analizador recursivo de la consulta
+type analizadorConsulta struct {
+	elementos []elementoConsulta
+	actual    int
+	palabras  []string // texto buscado sin negar, para ordenar por relevancia
+	negado    bool
+	anidacion int // parentesis y negaciones abiertos
+}
+
+// Entra en un nivel de parentesis o negacion; falla si se supera el maximo
+func (a *analizadorConsulta) anidar(e elementoConsulta) error {
+	a.anidacion++
+	if a.anidacion > maxAnidacionConsulta {
+		return &ErrorConsulta{e.posicion, fmt.Sprintf("la consulta tiene más de %d niveles de paréntesis o negaciones", maxAnidacionConsulta)}
+	}
+	return nil
+}
+
+func (a *analizadorConsulta) ver() elementoConsulta {
+	return a.elementos[a.actual]
+}
+
+func (a *analizadorConsulta) avanzar() elementoConsulta {
+	e := a.elementos[a.actual]
+	if e.tipo != elemFin {
+		a.actual++
+	}
+	return e
+}
+
+// expresion := terminoY (OR terminoY)*
+func (a *analizadorConsulta) expresion() (filtroLibro, error) {
+	izq, err := a.terminoY()
+	if err != nil {
+		return nil, err
+	}
+	for a.ver().tipo == elemOr {
+		a.avanzar()
+		der, err := a.terminoY()
+		if err != nil {
+			return nil, err
+		}
+		izq = func(i, d filtroLibro) filtroLibro {
+			return func(l *Libro) bool { return i(l) || d(l) }
+		}(izq, der)
+	}
+	return izq, nil
+}
+
+// terminoY := negacion ([AND] negacion)*
+func (a *analizadorConsulta) terminoY() (filtroLibro, error) {
+	izq, err := a.negacion()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch a.ver().tipo {
+		case elemAnd:
+			a.avanzar()
+		case elemPalabra, elemFrase, elemCampo, elemAbre, elemNot:
+		default:
+			return izq, nil
+		}
+		der, err := a.negacion()
+		if err != nil {
+			return nil, err
+		}
+		izq = func(i, d filtroLibro) filtroLibro {
+			return func(l *Libro) bool { return i(l) && d(l) }
+		}(izq, der)
+	}
+}
+
+// negacion := (NOT | -) negacion | primario
+func (a *analizadorConsulta) negacion() (filtroLibro, error) {
+	if a.ver().tipo != elemNot {
+		return a.primario()
+	}
+	if err := a.anidar(a.avanzar()); err != nil {
+		return nil, err
+	}
+	a.negado = !a.negado
+	hijo, err := a.negacion()
+	a.negado = !a.negado
+	a.anidacion--
+	if err != nil {
+		return nil, err
+	}
+	return func(l *Libro) bool { return !hijo(l) }, nil
+}
+
+// primario := "(" expresion ")" | campo valor | palabra | frase
+func (a *analizadorConsulta) primario() (filtroLibro, error) {
+	e := a.avanzar()
+	switch e.tipo {
+	case elemAbre:
+		if err := a.anidar(e); err != nil {
+			return nil, err
+		}
+		filtro, err := a.expresion()
+		if err != nil {
+			return nil, err
+		}
+		if a.ver().tipo != elemCierra {
+			return nil, &ErrorConsulta{e.posicion, "paréntesis sin cerrar"}
+		}
+		a.avanzar()
+		a.anidacion--
+		return filtro, nil
+	case elemCampo:
+		return a.filtroCampo(e)
+	case elemPalabra, elemFrase:
+		if !a.negado {
+			a.palabras = append(a.palabras, e.texto)
+		}
+		return filtroTexto(textoLibro, e.texto, e.tipo == elemFrase), nil
+	case elemCierra:
+		return nil, &ErrorConsulta{e.posicion, "paréntesis de cierre sin abrir"}
+	case elemFin:
+		return nil, &ErrorConsulta{e.posicion, "la consulta termina antes de lo esperado"}
+	default:
+		return nil, &ErrorConsulta{e.posicion, fmt.Sprintf("se esperaba un término y se encontró %s", e.texto)}
+	}
+}
+
+// Filtro de un campo con su operador y el valor que le sigue
+func (a *analizadorConsulta) filtroCampo(e elementoConsulta) (filtroLibro, error) {
+	campo := buscarCampoConsulta(e.texto)
+	if campo == nil {
+		var nombres []string
+		for _, c := range camposConsulta {
+			nombres = append(nombres, c.nombres[0])
+		}
+		return nil, &ErrorConsulta{e.posicion, fmt.Sprintf("campo desconocido %q; campos válidos: %s", e.texto, strings.Join(nombres, ", "))}
+	}
+
+	v := a.avanzar()
+	if v.tipo != elemPalabra && v.tipo != elemFrase {
+		return nil, &ErrorConsulta{v.posicion, fmt.Sprintf("se esperaba un valor después de %s%s", e.texto, e.operador)}
+	}
+
+	switch campo.tipo {
+	case campoNumero:
+		return filtroNumero(campo.numero, e.operador, v)
+	case campoBooleano:
+		if e.operador != ":" && e.operador != "=" {
+			return nil, &ErrorConsulta{e.posicion, fmt.Sprintf("el campo %s solo admite ':'", e.texto)}
+		}
+		var esperado bool
+		switch normalizarTexto(v.texto) {
+		case "si", "true", "1":
+			esperado = true
+		case "no", "false", "0":
+			esperado = false
+		default:
+			return nil, &ErrorConsulta{v.posicion, fmt.Sprintf("valor %q no válido para %s; use si o no", v.texto, e.texto)}
+		}
+		return func(l *Libro) bool { return campo.booleano(l) == esperado }, nil
+	default:
+		if e.operador != ":" && e.operador != "=" {
+			return nil, &ErrorConsulta{e.posicion, fmt.Sprintf("el campo %s es de texto y no admite %q", e.texto, e.operador)}
+		}
+		if !a.negado {
+			a.palabras = append(a.palabras, v.texto)
+		}
+		return filtroTexto(campo.texto, v.texto, v.tipo == elemFrase), nil
+	}
+}
+
+// Comparacion o rango (desde..hasta) sobre un campo numerico
+func filtroNumero(valor func(l *Libro) int, operador string, v elementoConsulta) (filtroLibro, error) {
+	numero := func(texto string, pos int) (int, error) {
+		n, err := strconv.Atoi(texto)
+		if err != nil {
+			return 0, &ErrorConsulta{pos, fmt.Sprintf("se esperaba un número y se encontró %q", texto)}
+		}
+		return n, nil
+	}
+
+	if desdeTexto, hastaTexto, esRango := strings.Cut(v.texto, ".."); esRango {
+		if operador != ":" && operador != "=" {
+			return nil, &ErrorConsulta{v.posicion, "un rango solo se puede usar con ':'"}
+		}
+		desde, err := numero(desdeTexto, v.posicion)
+		if err != nil {
+			return nil, err
+		}
+		hasta, err := numero(hastaTexto, v.posicion+len([]rune(desdeTexto))+2)
+		if err != nil {
+			return nil, err
+		}
+		if hasta < desde {
+			return nil, &ErrorConsulta{v.posicion, "el rango termina antes de empezar"}
+		}
+		return func(l *Libro) bool { n := valor(l); return n != 0 && n >= desde && n <= hasta }, nil
+	}
+
+	n, err := numero(v.texto, v.posicion)
+	if err != nil {
+		return nil, err
+	}
+	comparar := map[string]func(a int) bool{
+		":":  func(a int) bool { return a == n },
+		"=":  func(a int) bool { return a == n },
+		">":  func(a int) bool { return a > n },
+		">=": func(a int) bool { return a >= n },
+		"<":  func(a int) bool { return a < n },
+		"<=": func(a int) bool { return a <= n },
+	}[operador]
+	// Los libros sin valor (por ejemplo sin año conocido) no cumplen ninguna comparacion
+	return func(l *Libro) bool { a := valor(l); return a != 0 && comparar(a) }, nil
+}
+
+/*
+Filtro de texto: una frase debe aparecer con sus palabras seguidas; si no, cada
+palabra debe aparecer en el campo con la misma raiz, o como prefijo si termina en *.
+*/
+func filtroTexto(texto func(l *Libro) string, valor string, frase bool) filtroLibro {
+	buscadas := tokenizar(valor)
+	prefijo := !frase && strings.HasSuffix(valor, "*")
+	return func(l *Libro) bool {
+		palabras := tokenizar(texto(l))
+		if frase {
+			return strings.Contains(" "+strings.Join(palabras, " ")+" ", " "+strings.Join(buscadas, " ")+" ")
+		}
+		for i, b := range buscadas {
+			encontrada := false
+			for _, p := range palabras {
+				if p == b || raiz(p) == raiz(b) || prefijo && i == len(buscadas)-1 && strings.HasPrefix(p, b) {
+					encontrada = true
+					break
+				}
+			}
+			if !encontrada {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Analiza la consulta y devuelve el filtro junto con las palabras buscadas
+func analizarConsulta(consulta string) (filtroLibro, []string, error) {
+	if len([]rune(consulta)) > maxLargoConsulta {
+		return nil, nil, &ErrorConsulta{maxLargoConsulta + 1, fmt.Sprintf("la consulta supera los %d caracteres", maxLargoConsulta)}
+	}
+	elementos, err := dividirConsulta(consulta)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(elementos) == 1 {
+		return nil, nil, &ErrorConsulta{1, "la consulta está vacía"}
+	}
+	a := &analizadorConsulta{elementos: elementos}
+	filtro, err := a.expresion()
+	if err != nil {
+		return nil, nil, err
+	}
+	if e := a.ver(); e.tipo != elemFin {
+		if e.tipo == elemCierra {
+			return nil, nil, &ErrorConsulta{e.posicion, "paréntesis de cierre sin abrir"}
+		}
+		return nil, nil, &ErrorConsulta{e.posicion, fmt.Sprintf("no se esperaba %s", e.texto)}
+	}
+	return filtro, a.palabras, nil
+}
+
+/*
+Evalua la consulta sobre el catalogo. Los libros se ordenan por la relevancia de las
+palabras buscadas en el indice y, si no hay palabras, por ID.
+*/
+func (lib *Libreria) BuscarAvanzada(consulta string) ([]*Libro, error) {
+	filtro, palabras, err := analizarConsulta(consulta)
+	if err != nil {
+		return nil, err
+	}
+
+	puntajes := map[int]float64{}
+	if len(palabras) > 0 {
+		for _, res := range indice.Buscar(strings.Join(palabras, " "), 0) {
+			puntajes[res.Libro.LibroID] = res.Puntaje
+		}
+	}
+
+	resultados := []*Libro{}
+	for _, l := range lib.Libros {
+		if filtro(l) {
+			resultados = append(resultados, l)
+		}
+	}
+	sort.SliceStable(resultados, func(i, j int) bool {
+		if puntajes[resultados[i].LibroID] != puntajes[resultados[j].LibroID] {
+			return puntajes[resultados[i].LibroID] > puntajes[resultados[j].LibroID]
+		}
+		return resultados[i].LibroID < resultados[j].LibroID
+	})
+	return resultados, nil
+}
+
+// Codigo HTML para la busqueda avanzada
+var advancedSearchTemplate = template.Must(template.New("avanzada").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Búsqueda Avanzada</title>
+</head>
+<body>
+	<h1>Búsqueda Avanzada</h1>
+	<form action="/buscar-avanzada" method="get">
+		<label for="q">Consulta:</label>
+		<input type="search" id="q" name="q" size="60" required>
+		<button type="submit">Buscar</button>
+	</form>
+	<p>Ejemplo: <code>autor:Epicteto AND genero:Filosofía AND year&gt;=2000 NOT disponible:false</code></p>
+	<p>Campos: titulo, autor, genero (materia), descripcion, anio (year), id y disponible. Use comillas para frases,
+	anio:1990..2000 para rangos, OR, NOT o "-" y paréntesis para agrupar.</p>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para la busqueda avanzada con el lenguaje de consultas (/buscar-avanzada?q=)
+func buscarAvanzada(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		if err := advancedSearchTemplate.Execute(w, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resultados, err := libreria.BuscarAvanzada(q)
+	if errConsulta, ok := err.(*ErrorConsulta); ok {
+		// El indicador marca con ^ el caracter donde se encontro el error
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(struct {
+			Consulta  string `json:"consulta"`
+			Error     string `json:"error"`
+			Posicion  int    `json:"posicion"`
+			Indicador string `json:"indicador"`
+		}{q, errConsulta.Mensaje, errConsulta.Posicion, strings.Repeat(" ", errConsulta.Posicion-1) + "^"})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responderJSON(w, struct {
+		Consulta   string   `json:"consulta"`
+		Total      int      `json:"total"`
+		Resultados []*Libro `json:"resultados"`
+	}{q, len(resultados), resultados})
+}
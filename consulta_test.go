@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Catalogo e inventario de prueba; el libro 3 no tiene ejemplares disponibles
+func catalogoConsultaPrueba(t *testing.T) []*Libro {
+	libros := []*Libro{
+		{LibroID: 1, Titulo: "Cartas de un Estoico", Autor: "Séneca", Genero: "Filosofía", FechaPublicacion: FechaParcial{Anio: 2024, Mes: 9}},
+		{LibroID: 2, Titulo: "Los Discursos de Epicteto", Autor: "Epicteto", Genero: "Filosofía", FechaPublicacion: FechaParcial{Anio: 2004}},
+		{LibroID: 3, Titulo: "Manual de Epicteto", Autor: "Epicteto", Genero: "Filosofía", FechaPublicacion: FechaParcial{Anio: 1980, Mes: 5}},
+		{LibroID: 4, Titulo: "Meditaciones", Autor: "Marco Aurelio", Genero: "Filosofía", FechaPublicacion: FechaParcial{Anio: 2023}},
+		{LibroID: 5, Titulo: "Historia de Roma", Autor: "Tito Livio", Genero: "Historia"},
+	}
+	anterior := listadoinventario.Inventarios
+	t.Cleanup(func() { listadoinventario.Inventarios = anterior })
+	listadoinventario.Inventarios = []*Inventario{
+		{InventarioId: 1, LibroID: 1, Disponible: true},
+		{InventarioId: 2, LibroID: 2, Disponible: true},
+		{InventarioId: 3, LibroID: 3, Disponible: false},
+		{InventarioId: 4, LibroID: 4, Disponible: true},
+		{InventarioId: 5, LibroID: 5, Disponible: true},
+	}
+	return libros
+}
+
+func TestAnalizarConsulta(t *testing.T) {
+	libros := catalogoConsultaPrueba(t)
+	casos := []struct {
+		consulta string
+		want     []int
+	}{
+		{"autor:Epicteto AND genero:Filosofía AND year>=2000 NOT disponible:false", []int{2}},
+		{"autor:epicteto", []int{2, 3}},
+		{"autor:Epicteto OR autor:Séneca", []int{1, 2, 3}},
+		{"autor:Epicteto autor:Séneca", nil}, // sin operador se unen con AND
+		{"filosofia AND NOT epicteto", []int{1, 4}},
+		{"filosofia -epicteto", []int{1, 4}},
+		{"(autor:Epicteto OR autor:Livio) AND disponible:si", []int{2, 5}},
+		{"autor:Livio OR autor:Epicteto AND anio<2000", []int{3, 5}}, // AND tiene prioridad sobre OR
+		{`titulo:"de Epicteto"`, []int{2, 3}},
+		{`"Epicteto Discursos"`, nil}, // una frase exige las palabras seguidas
+		{"anio:1980..2010", []int{2, 3}},
+		{"anio:2023", []int{4}},
+		{"year<2000", []int{3}}, // los libros sin año no cumplen ninguna comparacion
+		{"titulo:medit*", []int{4}},
+		{"estoicos", []int{1}}, // misma raiz
+		{"id>=4", []int{4, 5}},
+		{"available:no", []int{3}},
+		{"anio>-5", []int{1, 2, 3, 4}}, // el guion despues de un campo es el signo del valor
+		{"id:-1..2 -epicteto", []int{1}},
+	}
+	for _, caso := range casos {
+		filtro, _, err := analizarConsulta(caso.consulta)
+		if err != nil {
+			t.Errorf("analizarConsulta(%q): %v", caso.consulta, err)
+			continue
+		}
+		var ids []int
+		for _, l := range libros {
+			if filtro(l) {
+				ids = append(ids, l.LibroID)
+			}
+		}
+		if !reflect.DeepEqual(ids, caso.want) {
+			t.Errorf("%q encontró %v, se esperaba %v", caso.consulta, ids, caso.want)
+		}
+	}
+}
+
+func TestErroresConsulta(t *testing.T) {
+	casos := []struct {
+		consulta string
+		posicion int
+		mensaje  string
+	}{
+		{"", 1, "vacía"},
+		{`titulo:"cartas`, 8, "comillas sin cerrar"},
+		{"(autor:Epicteto", 1, "paréntesis sin cerrar"},
+		{"autor:Epicteto)", 15, "paréntesis de cierre sin abrir"},
+		{"editorial:Penguin", 1, "campo desconocido"},
+		{"anio>=dos mil", 7, "se esperaba un número"},
+		{"anio:2010..2000", 6, "termina antes de empezar"},
+		{"anio:1990..x", 12, "se esperaba un número"},
+		{"titulo>carta", 1, "no admite"},
+		{"disponible:quizas", 12, "use si o no"},
+		{"epicteto AND", 13, "termina antes de lo esperado"},
+		{"autor: OR x", 8, "se esperaba un valor"},
+		{":valor", 1, "falta el nombre del campo"},
+		{"anio>- 5", 6, "se esperaba un número"},
+		{strings.Repeat("(", 40) + "epicteto" + strings.Repeat(")", 40), 33, "niveles de paréntesis"},
+		{strings.Repeat("NOT ", 40) + "epicteto", 129, "niveles de paréntesis o negaciones"},
+		{strings.Repeat("-(", 20) + "x", 33, "niveles"},
+		{strings.Repeat("epicteto ", 200), 1001, "supera los 1000 caracteres"},
+	}
+	for _, caso := range casos {
+		_, _, err := analizarConsulta(caso.consulta)
+		var errConsulta *ErrorConsulta
+		if !errors.As(err, &errConsulta) {
+			t.Errorf("analizarConsulta(%q) = %v, se esperaba un error", caso.consulta, err)
+			continue
+		}
+		if errConsulta.Posicion != caso.posicion || !strings.Contains(errConsulta.Mensaje, caso.mensaje) {
+			t.Errorf("analizarConsulta(%q) = %v; se esperaba la posición %d con %q", caso.consulta, err, caso.posicion, caso.mensaje)
+		}
+	}
+}
+
+func TestPalabrasConsulta(t *testing.T) {
+	// Las palabras negadas no cuentan para ordenar por relevancia
+	_, palabras, err := analizarConsulta(`estoico titulo:cartas NOT epicteto -(autor:Livio) anio>2000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"estoico", "cartas"}; !reflect.DeepEqual(palabras, want) {
+		t.Errorf("palabras = %q, se esperaba %q", palabras, want)
+	}
+}
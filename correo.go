@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Interfaz para el envio de correos electronicos
+type Correo interface {
+	Enviar(para, asunto, cuerpo string) error
+}
+
+// Envio de correos mediante un servidor SMTP
+type CorreoSMTP struct {
+	Host       string
+	Puerto     string
+	Usuario    string
+	Contrasena string
+	Remitente  string
+}
+
+func (c *CorreoSMTP) Enviar(para, asunto, cuerpo string) error {
+	var auth smtp.Auth
+	if c.Usuario != "" {
+		auth = smtp.PlainAuth("", c.Usuario, c.Contrasena, c.Host)
+	}
+	mensaje := strings.Join([]string{
+		"From: " + c.Remitente,
+		"To: " + para,
+		"Subject: " + asunto,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		cuerpo,
+	}, "\r\n")
+	return smtp.SendMail(c.Host+":"+c.Puerto, auth, c.Remitente, []string{para}, []byte(mensaje))
+}
+
+// Envio de correos a un archivo de registro, util para pruebas locales
+type CorreoArchivo struct {
+	Ruta string
+}
+
+func (c *CorreoArchivo) Enviar(para, asunto, cuerpo string) error {
+	f, err := os.OpenFile(c.Ruta, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	log.Printf("Correo para %s: %s", para, asunto)
+	_, err = fmt.Fprintf(f, "Fecha: %s\nPara: %s\nAsunto: %s\n\n%s\n\n----\n",
+		time.Now().Format(time.RFC3339), para, asunto, cuerpo)
+	return err
+}
+
+// Seleccion del servicio de correo segun las variables de entorno
+func nuevoCorreo() Correo {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return &CorreoArchivo{Ruta: "correos.log"}
+	}
+	puerto := os.Getenv("SMTP_PUERTO")
+	if puerto == "" {
+		puerto = "587"
+	}
+	return &CorreoSMTP{
+		Host:       host,
+		Puerto:     puerto,
+		Usuario:    os.Getenv("SMTP_USUARIO"),
+		Contrasena: os.Getenv("SMTP_CONTRASENA"),
+		Remitente:  os.Getenv("SMTP_REMITENTE"),
+	}
+}
+
+// URL publica del sistema para construir los enlaces de los correos
+func urlBase() string {
+	if base := os.Getenv("URL_BASE"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "http://localhost:8080"
+}
+
+var correo Correo = &CorreoArchivo{Ruta: "correos.log"}
@@ -0,0 +1,1009 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tamano maximo del CSV a importar
+const maxTamanoCSV = 20 << 20
+
+// Columna que se importa, con los encabezados que se reconocen para ella ademas de su nombre
+type columnaCSV struct {
+	nombre    string
+	requerida bool
+	alias     []string
+}
+
+// Fila de datos con el valor de cada columna segun la correspondencia de encabezados
+type filaCSV struct {
+	numero  int // linea del archivo, para los errores
+	valores map[string]string
+}
+
+func (f filaCSV) valor(columna string) string {
+	return f.valores[columna]
+}
+
+// Error de validacion; sin columna es un error de la fila completa o del archivo (fila 1, el encabezado)
+type ErrorCSV struct {
+	Fila    int    `json:"fila"`
+	Columna string `json:"columna,omitempty"`
+	Mensaje string `json:"mensaje"`
+}
+
+/*
+Entidad que se importa o exporta en CSV. validar revisa todas las filas sin cambiar
+ningun dato y devuelve la funcion que las guarda, que solo se usa si ninguna fila
+tiene errores. Las entidades sin columnas solo se exportan.
+*/
+type entidadCSV struct {
+	nombre   string
+	columnas []columnaCSV
+	validar  func(filas []filaCSV) (func() error, []ErrorCSV)
+	exportar func() [][]string // la primera fila son los encabezados
+}
+
+var entidadesCSV = []entidadCSV{
+	{"libros", columnasLibrosCSV, validarLibrosCSV, exportarLibrosCSV},
+	{"cuentas", columnasCuentasCSV, validarCuentasCSV, exportarCuentasCSV},
+	{"inventario", columnasInventarioCSV, validarInventarioCSV, exportarInventarioCSV},
+	{"autores", nil, nil, exportarAutoresCSV},
+	{"materias", nil, nil, exportarMateriasCSV},
+	{"obras", nil, nil, exportarObrasCSV},
+	{"prestamos", nil, nil, exportarPrestamosCSV},
+	{"reservas", nil, nil, exportarReservasCSV},
+}
+
+func buscarEntidadCSV(nombre string) (*entidadCSV, error) {
+	for i, e := range entidadesCSV {
+		if e.nombre == nombre {
+			return &entidadesCSV[i], nil
+		}
+	}
+	var nombres []string
+	for _, e := range entidadesCSV {
+		nombres = append(nombres, e.nombre)
+	}
+	return nil, fmt.Errorf("entidad desconocida %q; use %s", nombre, strings.Join(nombres, ", "))
+}
+
+// Encabezado comparable: "Fecha de publicación" es fecha_de_publicacion
+func claveCSV(encabezado string) string {
+	return strings.Join(tokenizar(encabezado), "_")
+}
+
+/*
+Las hojas de calculo ejecutan como formula las celdas que empiezan con =, +, - o @,
+por lo que al exportar se les antepone un apostrofo, que se quita al importar.
+*/
+func celdaCSV(valor string) string {
+	if valor != "" && strings.ContainsRune("=+-@\t\r", rune(valor[0])) {
+		return "'" + valor
+	}
+	return valor
+}
+
+func valorCSV(celda string) string {
+	if len(celda) > 1 && celda[0] == '\'' && strings.ContainsRune("=+-@", rune(celda[1])) {
+		return celda[1:]
+	}
+	return celda
+}
+
+// Valores separados por ";" dentro de una celda, como las materias o los roles
+func dividirCeldaCSV(celda string) []string {
+	var valores []string
+	for _, v := range strings.Split(celda, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			valores = append(valores, v)
+		}
+	}
+	return valores
+}
+
+func siNoCSV(valor bool) string {
+	if valor {
+		return "si"
+	}
+	return "no"
+}
+
+func booleanoCSV(celda string, predeterminado bool) (bool, error) {
+	switch normalizarTexto(strings.TrimSpace(celda)) {
+	case "":
+		return predeterminado, nil
+	case "si", "s", "true", "1", "yes":
+		return true, nil
+	case "no", "n", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q no es si ni no", celda)
+}
+
+// Mayor ID en uso entre los existentes y los que piden las filas, para asignar los que faltan
+func mayorIDCSV(actual int, filas []filaCSV) int {
+	for _, f := range filas {
+		if id, err := strconv.Atoi(f.valor("id")); err == nil {
+			actual = max(actual, id)
+		}
+	}
+	return actual
+}
+
+// ID de la fila o el siguiente libre; existe indica si el ID ya esta en uso fuera del archivo
+func idFilaCSV(f filaCSV, siguiente *int, usados map[int]int, existe func(int) bool) (int, error) {
+	texto := f.valor("id")
+	if texto == "" {
+		*siguiente++
+		usados[*siguiente] = f.numero
+		return *siguiente, nil
+	}
+	id, err := strconv.Atoi(texto)
+	switch {
+	case err != nil || id <= 0:
+		return 0, errors.New("el ID debe ser un número entero positivo")
+	case existe(id):
+		return 0, fmt.Errorf("ya existe el ID %d", id)
+	case usados[id] != 0:
+		return 0, fmt.Errorf("el ID %d se repite en la fila %d", id, usados[id])
+	}
+	usados[id] = f.numero
+	return id, nil
+}
+
+// Listado que se guarda en un archivo JSON
+type archivoJSON struct {
+	datos   any
+	archivo string
+}
+
+/*
+Guarda los listados juntos. Primero se escriben todos en archivos temporales y solo
+si ninguno falla se renombran sobre los originales. Si falla un renombre, los archivos
+ya renombrados vuelven a su contenido anterior, por lo que el error no deja unos
+archivos con los datos nuevos y otros con los anteriores; solo si tampoco se pueden
+restaurar el error devuelto lo indica junto al del guardado.
+*/
+func guardarListados(listados ...archivoJSON) error {
+	anteriores := make([]contenidoAnterior, len(listados))
+	for i, l := range listados {
+		datos, err := os.ReadFile(l.archivo)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error al guardar %s: %w", l.archivo, err)
+		}
+		anteriores[i] = contenidoAnterior{l.archivo, datos, err == nil}
+	}
+
+	var temporales []string
+	descartar := func() {
+		for _, t := range temporales {
+			os.Remove(t)
+		}
+	}
+	for _, l := range listados {
+		temporal, err := escribirTemporalJSON(l.datos, l.archivo)
+		if err != nil {
+			descartar()
+			return fmt.Errorf("error al guardar %s: %w", l.archivo, err)
+		}
+		temporales = append(temporales, temporal)
+	}
+	for i, l := range listados {
+		if err := os.Rename(temporales[i], l.archivo); err != nil {
+			temporales = temporales[i:]
+			descartar()
+			err = fmt.Errorf("error al guardar %s: %w", l.archivo, err)
+			return errors.Join(err, restaurarListados(anteriores[:i]))
+		}
+	}
+	return nil
+}
+
+// Contenido de un archivo antes de guardar los listados; existia indica si habia archivo
+type contenidoAnterior struct {
+	archivo string
+	datos   []byte
+	existia bool
+}
+
+// Devuelve los archivos a su contenido anterior, o los borra si no existian
+func restaurarListados(anteriores []contenidoAnterior) error {
+	var errs []error
+	for _, a := range anteriores {
+		if !a.existia {
+			if err := os.Remove(a.archivo); err != nil {
+				errs = append(errs, fmt.Errorf("no se pudo restaurar %s: %w", a.archivo, err))
+			}
+			continue
+		}
+		temporal, err := escribirTemporal(a.datos, a.archivo)
+		if err == nil {
+			if err = os.Rename(temporal, a.archivo); err != nil {
+				os.Remove(temporal)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("no se pudo restaurar %s: %w", a.archivo, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var columnasLibrosCSV = []columnaCSV{
+	{"id", false, []string{"libro_id"}},
+	{"titulo", true, []string{"title"}},
+	{"subtitulo", false, []string{"subtitle"}},
+	{"autor", true, []string{"autores", "author", "authors"}},
+	{"traductor", false, []string{"traductores", "translator"}},
+	{"editor", false, []string{"editores"}},
+	{"fecha", false, []string{"fecha_publicacion", "fecha_de_publicacion", "publicacion", "date", "year", "anio"}},
+	{"materias", false, []string{"materia", "genero", "subject", "subjects"}},
+	{"isbn", false, nil},
+	{"edicion", false, []string{"edition"}},
+	{"idioma", false, []string{"language", "lengua"}},
+	{"editorial", false, []string{"publisher"}},
+	{"descripcion", false, []string{"description", "resumen"}},
+	{"url", false, nil},
+	{"licencias", false, []string{"licenses"}},
+	{"obra", false, []string{"obra_id"}},
+}
+
+// Materia del vocabulario por ID o por nombre o variante
+func materiaCSV(texto string) *Materia {
+	if id, err := strconv.Atoi(texto); err == nil {
+		m, _ := listadomateria.BuscarID(id)
+		return m
+	}
+	return listadomateria.resolver(texto)
+}
+
+func validarLibrosCSV(filas []filaCSV) (func() error, []ErrorCSV) {
+	type libroCSV struct {
+		id        int
+		propuesta *PropuestaLibro
+		licencias int
+	}
+	var nuevos []libroCSV
+	var errores []ErrorCSV
+	siguiente := 0
+	for _, l := range libreria.Libros {
+		siguiente = max(siguiente, l.LibroID)
+	}
+	siguiente = mayorIDCSV(siguiente, filas)
+	usados, isbns := map[int]int{}, map[string]int{}
+
+	for _, f := range filas {
+		agregarError := func(columna, mensaje string) {
+			errores = append(errores, ErrorCSV{f.numero, columna, mensaje})
+		}
+		id, err := idFilaCSV(f, &siguiente, usados, func(id int) bool { _, err := libreria.BuscarID(id); return err == nil })
+		if err != nil {
+			agregarError("id", err.Error())
+		}
+		p := &PropuestaLibro{Titulo: f.valor("titulo"), Subtitulo: f.valor("subtitulo"), Autor: f.valor("autor"),
+			Traductor: f.valor("traductor"), Editor: f.valor("editor"), Edicion: f.valor("edicion"),
+			Idioma: f.valor("idioma"), Editorial: f.valor("editorial"), Descripcion: f.valor("descripcion"), Url: f.valor("url")}
+		if p.Titulo == "" {
+			agregarError("titulo", "falta el título")
+		}
+		if p.Autor == "" {
+			agregarError("autor", "falta el autor")
+		}
+		if texto := f.valor("fecha"); texto != "" {
+			if fecha, err := ParseFechaParcial(texto); err != nil {
+				agregarError("fecha", err.Error())
+			} else {
+				p.Fecha = fecha.ISO()
+			}
+		}
+		if texto := f.valor("isbn"); texto != "" {
+			isbn, err := NormalizarISBN(texto)
+			switch existente, errISBN := libreria.BuscarISBN(texto); {
+			case err != nil:
+				agregarError("isbn", err.Error())
+			case errISBN == nil:
+				agregarError("isbn", fmt.Sprintf("ya existe el libro %d con el ISBN %s", existente.LibroID, isbn))
+			case isbns[isbn] != 0:
+				agregarError("isbn", fmt.Sprintf("el ISBN %s se repite en la fila %d", isbn, isbns[isbn]))
+			default:
+				p.ISBN = isbn
+				isbns[isbn] = f.numero
+			}
+		}
+		for _, nombre := range dividirCeldaCSV(f.valor("materias")) {
+			if m := materiaCSV(nombre); m == nil {
+				agregarError("materias", fmt.Sprintf("la materia %q no está en el vocabulario", nombre))
+			} else if !contieneEntero(p.Materias, m.MateriaID) {
+				p.Materias = append(p.Materias, m.MateriaID)
+			}
+		}
+		licencias := 0
+		if texto := f.valor("licencias"); texto != "" {
+			if licencias, err = strconv.Atoi(texto); err != nil || licencias < 0 {
+				agregarError("licencias", "las licencias digitales deben ser un número entero positivo")
+			}
+		}
+		if texto := f.valor("obra"); texto != "" {
+			obra, err := strconv.Atoi(texto)
+			if err == nil {
+				_, err = listadoobra.BuscarID(obra)
+			}
+			if err != nil {
+				agregarError("obra", fmt.Sprintf("la obra %q no existe", texto))
+			}
+			p.ObraID = obra
+		}
+		nuevos = append(nuevos, libroCSV{id, p, licencias})
+	}
+
+	// Los libros se agregan solo si se crean todos y se guardan los archivos
+	aplicar := func() error {
+		return agregarLibros(func() ([]*Libro, error) {
+			var agregados []*Libro
+			for _, n := range nuevos {
+				libro, err := libroPropuesta(n.propuesta, n.id)
+				if err != nil {
+					return nil, err
+				}
+				libro.SetLicencias(n.licencias)
+				agregados = append(agregados, libro)
+			}
+			return agregados, nil
+		})
+	}
+	return aplicar, errores
+}
+
+func exportarLibrosCSV() [][]string {
+	filas := [][]string{{"id", "titulo", "subtitulo", "autor", "traductor", "editor", "fecha", "materias", "isbn",
+		"edicion", "idioma", "editorial", "descripcion", "url", "licencias", "obra"}}
+	for _, l := range libreria.Libros {
+		nombres := map[RolAutor][]string{}
+		for _, p := range l.Participantes() {
+			nombres[p.Rol] = append(nombres[p.Rol], p.Autor.Nombre)
+		}
+		autor := strings.Join(nombres[RolAutorPrincipal], "; ")
+		if autor == "" {
+			autor = l.Autor
+		}
+		var materias []string
+		for _, m := range l.MateriasLibro() {
+			materias = append(materias, m.Nombre)
+		}
+		obra := ""
+		if l.ObraID != 0 {
+			obra = strconv.Itoa(l.ObraID)
+		}
+		filas = append(filas, []string{strconv.Itoa(l.LibroID), l.Titulo, l.Subtitulo, autor,
+			strings.Join(nombres[RolTraductor], "; "), strings.Join(nombres[RolEditor], "; "), l.FechaPublicacion.ISO(),
+			strings.Join(materias, "; "), l.ISBN, l.Edicion, l.Idioma, l.Editorial, l.Descripcion, l.Url,
+			strconv.Itoa(l.Licencias), obra})
+	}
+	return filas
+}
+
+var columnasCuentasCSV = []columnaCSV{
+	{"id", false, []string{"cuenta_id", "usuario_id"}},
+	{"nombre", true, []string{"name"}},
+	{"mail", true, []string{"correo", "email", "e_mail", "correo_electronico"}},
+	{"contrasena", true, []string{"password", "clave"}},
+	{"roles", false, []string{"rol", "role"}},
+}
+
+// Las cuentas importadas quedan activas, con el correo sin verificar, como las que crea un administrador
+func validarCuentasCSV(filas []filaCSV) (func() error, []ErrorCSV) {
+	type cuentaCSV struct {
+		id                       int
+		nombre, mail, contrasena string
+		roles                    []Rol
+	}
+	var filasValidas []cuentaCSV
+	var errores []ErrorCSV
+	siguiente := mayorIDCSV(siguienteCuentaID()-1, filas)
+	usados, mails := map[int]int{}, map[string]int{}
+
+	for _, f := range filas {
+		agregarError := func(columna, mensaje string) {
+			errores = append(errores, ErrorCSV{f.numero, columna, mensaje})
+		}
+		id, err := idFilaCSV(f, &siguiente, usados, func(id int) bool { return buscarCuentaID(id) != nil })
+		if err != nil {
+			agregarError("id", err.Error())
+		}
+		nombre, mail, contrasena := f.valor("nombre"), f.valor("mail"), f.valor("contrasena")
+		for _, c := range []struct{ columna, valor, mensaje string }{{"nombre", nombre, "falta el nombre"},
+			{"mail", mail, "falta el correo"}, {"contrasena", contrasena, "falta la contraseña"}} {
+			if c.valor == "" {
+				agregarError(c.columna, c.mensaje)
+			}
+		}
+		switch clave := strings.ToLower(mail); {
+		case mail == "":
+		case !strings.Contains(mail, "@"):
+			agregarError("mail", fmt.Sprintf("el correo %q no es válido", mail))
+		case buscarCuentaMail(mail) != nil:
+			agregarError("mail", "ya existe una cuenta con el correo "+mail)
+		case mails[clave] != 0:
+			agregarError("mail", fmt.Sprintf("el correo %s se repite en la fila %d", mail, mails[clave]))
+		default:
+			mails[clave] = f.numero
+		}
+		roles := []Rol{}
+		for _, texto := range dividirCeldaCSV(f.valor("roles")) {
+			rol, err := parseRol(texto)
+			if err != nil {
+				agregarError("roles", err.Error())
+			} else if !contiene(rolesTexto(roles), string(rol)) {
+				roles = append(roles, rol)
+			}
+		}
+		if len(roles) == 0 {
+			roles = append(roles, RolUsuario)
+		}
+		filasValidas = append(filasValidas, cuentaCSV{id, nombre, mail, contrasena, roles})
+	}
+
+	// Las contrasenas se cifran al guardar, porque cifrarlas es lento y la revision no las necesita
+	aplicar := func() error {
+		var nuevas []*Cuenta
+		for _, f := range filasValidas {
+			cuenta, err := nuevaCuenta(f.id, f.nombre, f.mail, f.contrasena, f.roles...)
+			if err != nil {
+				return err
+			}
+			nuevas = append(nuevas, cuenta)
+		}
+		cuentas := append(slices.Clip(listadocuenta.Cuentas), nuevas...)
+		if err := guardarCuentas(cuentas); err != nil {
+			return err
+		}
+		listadocuenta.Cuentas = cuentas
+		// Cada cuenta nueva recibe el enlace para verificar su correo
+		for _, c := range nuevas {
+			if err := enviarVerificacion(c); err != nil {
+				log.Println("Error al enviar el correo de verificación:", err)
+			}
+		}
+		return nil
+	}
+	return aplicar, errores
+}
+
+func rolesTexto(roles []Rol) []string {
+	var textos []string
+	for _, r := range roles {
+		textos = append(textos, string(r))
+	}
+	return textos
+}
+
+// La contrasena no se exporta
+func exportarCuentasCSV() [][]string {
+	filas := [][]string{{"id", "nombre", "mail", "roles", "estado", "mail_verificado", "fecha_creacion", "ultimo_acceso"}}
+	for _, c := range listadocuenta.Cuentas {
+		filas = append(filas, []string{strconv.Itoa(c.CuentaID), c.Nombre, c.Mail, strings.Join(rolesTexto(c.Roles), "; "),
+			cmp.Or(c.Estado, EstadoActiva), siNoCSV(c.MailVerificado), c.FechaCreacion.Format(time.RFC3339), c.UltimoAcceso.Format(time.RFC3339)})
+	}
+	return filas
+}
+
+var columnasInventarioCSV = []columnaCSV{
+	{"id", false, []string{"inventario_id"}},
+	{"libro_id", false, []string{"libro"}},
+	{"isbn", false, nil},
+	{"codigo_barras", false, []string{"codigo", "codigo_de_barras", "barcode"}},
+	{"ubicacion", false, []string{"location", "estante"}},
+	{"disponible", false, []string{"available"}},
+}
+
+// El libro del ejemplar se indica con libro_id o con el ISBN de un libro del catalogo
+func validarInventarioCSV(filas []filaCSV) (func() error, []ErrorCSV) {
+	var nuevos []*Inventario
+	var errores []ErrorCSV
+	siguiente := 0
+	for _, inv := range listadoinventario.Inventarios {
+		siguiente = max(siguiente, inv.InventarioId)
+	}
+	siguiente = mayorIDCSV(siguiente, filas)
+	usados, codigos := map[int]int{}, map[string]int{}
+
+	for _, f := range filas {
+		agregarError := func(columna, mensaje string) {
+			errores = append(errores, ErrorCSV{f.numero, columna, mensaje})
+		}
+		id, err := idFilaCSV(f, &siguiente, usados, func(id int) bool {
+			for _, inv := range listadoinventario.Inventarios {
+				if inv.InventarioId == id {
+					return true
+				}
+			}
+			return false
+		})
+		if err != nil {
+			agregarError("id", err.Error())
+		}
+		var libro *Libro
+		switch texto, isbn := f.valor("libro_id"), f.valor("isbn"); {
+		case texto != "":
+			libroID, err := strconv.Atoi(texto)
+			if err != nil {
+				agregarError("libro_id", "el ID del libro debe ser un número entero")
+			} else if libro, err = libreria.BuscarID(libroID); err != nil {
+				agregarError("libro_id", fmt.Sprintf("no existe el libro %d", libroID))
+			}
+		case isbn != "":
+			if libro, err = libreria.BuscarISBN(isbn); err != nil {
+				agregarError("isbn", fmt.Sprintf("no hay un libro con el ISBN %s", isbn))
+			}
+		default:
+			agregarError("libro_id", "indique el ID o el ISBN del libro")
+		}
+		codigo := f.valor("codigo_barras")
+		if clave := strings.ToLower(codigo); codigo != "" {
+			if _, err := listadoinventario.BuscarCodigo(codigo); err == nil {
+				agregarError("codigo_barras", "ya existe un ejemplar con el código de barras "+codigo)
+			} else if codigos[clave] != 0 {
+				agregarError("codigo_barras", fmt.Sprintf("el código de barras %s se repite en la fila %d", codigo, codigos[clave]))
+			}
+			codigos[clave] = f.numero
+		}
+		disponible, err := booleanoCSV(f.valor("disponible"), true)
+		if err != nil {
+			agregarError("disponible", err.Error())
+		}
+		if libro == nil {
+			continue
+		}
+		if ejemplar, err := nuevoInventario(max(id, 1), libro.LibroID, disponible); err == nil {
+			ejemplar.CodigoBarras = codigo
+			ejemplar.Ubicacion = f.valor("ubicacion")
+			nuevos = append(nuevos, ejemplar)
+		}
+	}
+
+	// Los ejemplares disponibles se apartan para las reservas que los esperan, como al agregar uno,
+	// sobre una copia de la cola que solo reemplaza a la anterior si se guardaron los dos archivos
+	aplicar := func() error {
+		inventario := append(slices.Clip(listadoinventario.Inventarios), nuevos...)
+		reservas := Listadoreserva{Reservas: make([]*Reserva, len(listadoreserva.Reservas))}
+		for i, res := range listadoreserva.Reservas {
+			copia := *res
+			reservas.Reservas[i] = &copia
+		}
+		for _, e := range nuevos {
+			if e.Disponible {
+				reservas.atender(e)
+			}
+		}
+		if err := guardarListados(archivoJSON{reservas.Reservas, "reservas.json"},
+			archivoJSON{inventario, "inventario.json"}); err != nil {
+			return err
+		}
+		listadoinventario.Inventarios = inventario
+		listadoreserva.Reservas = reservas.Reservas
+		return nil
+	}
+	return aplicar, errores
+}
+
+func exportarInventarioCSV() [][]string {
+	filas := [][]string{{"id", "libro_id", "isbn", "codigo_barras", "ubicacion", "disponible"}}
+	for _, inv := range listadoinventario.Inventarios {
+		isbn := ""
+		if l, err := libreria.BuscarID(inv.LibroID); err == nil {
+			isbn = l.ISBN
+		}
+		filas = append(filas, []string{strconv.Itoa(inv.InventarioId), strconv.Itoa(inv.LibroID), isbn,
+			inv.CodigoBarras, inv.Ubicacion, siNoCSV(inv.Disponible)})
+	}
+	return filas
+}
+
+func exportarAutoresCSV() [][]string {
+	filas := [][]string{{"id", "nombre", "variantes", "nacimiento", "fallecimiento", "biografia"}}
+	for _, a := range listadoautor.Autores {
+		fechas := [2]string{}
+		for i, f := range []*FechaParcial{a.Nacimiento, a.Fallecimiento} {
+			if f != nil {
+				fechas[i] = f.ISO()
+			}
+		}
+		filas = append(filas, []string{strconv.Itoa(a.AutorID), a.Nombre, strings.Join(a.Variantes, "; "),
+			fechas[0], fechas[1], a.Biografia})
+	}
+	return filas
+}
+
+func exportarMateriasCSV() [][]string {
+	filas := [][]string{{"id", "nombre", "padre_id", "variantes", "dewey", "cdu"}}
+	for _, m := range listadomateria.Materias {
+		padre := ""
+		if m.PadreID != 0 {
+			padre = strconv.Itoa(m.PadreID)
+		}
+		filas = append(filas, []string{strconv.Itoa(m.MateriaID), m.Nombre, padre, strings.Join(m.Variantes, "; "), m.Dewey, m.CDU})
+	}
+	return filas
+}
+
+func exportarObrasCSV() [][]string {
+	filas := [][]string{{"id", "titulo", "titulo_original", "autor", "idioma_original", "serie", "numero_serie"}}
+	for _, o := range listadoobra.Obras {
+		numero := ""
+		if o.NumeroSerie != 0 {
+			numero = strconv.Itoa(o.NumeroSerie)
+		}
+		filas = append(filas, []string{strconv.Itoa(o.ObraID), o.Titulo, o.TituloOriginal, o.Autor, o.IdiomaOriginal, o.Serie, numero})
+	}
+	return filas
+}
+
+func exportarPrestamosCSV() [][]string {
+	filas := [][]string{{"id", "libro_id", "inventario_id", "usuario_id", "fecha_reserva", "fecha_devolucion", "digital", "devuelto"}}
+	for _, p := range listadoprestamo.Prestamos {
+		inventario := ""
+		if p.InventarioID != 0 {
+			inventario = strconv.Itoa(p.InventarioID)
+		}
+		filas = append(filas, []string{strconv.Itoa(p.PrestamoID), strconv.Itoa(p.LibroID), inventario, strconv.Itoa(p.UsuarioID),
+			p.FechaReserva.Format(time.RFC3339), p.FechaDevolucion.Format(time.RFC3339), siNoCSV(p.Digital), siNoCSV(p.Devuelto)})
+	}
+	return filas
+}
+
+func exportarReservasCSV() [][]string {
+	filas := [][]string{{"id", "usuario_id", "obra_id", "libro_id", "inventario_id", "estado", "fecha_solicitud", "vence"}}
+	for _, r := range listadoreserva.Reservas {
+		opcional := func(id int) string {
+			if id == 0 {
+				return ""
+			}
+			return strconv.Itoa(id)
+		}
+		vence := ""
+		if r.Vence != nil {
+			vence = r.Vence.Format(time.RFC3339)
+		}
+		filas = append(filas, []string{strconv.Itoa(r.ReservaID), strconv.Itoa(r.UsuarioID), strconv.Itoa(r.ObraID),
+			opcional(r.LibroID), opcional(r.InventarioID), string(r.Estado), r.FechaSolicitud.Format(time.RFC3339), vence})
+	}
+	return filas
+}
+
+// Escribe la entidad en CSV separado por comas y en UTF-8
+func escribirCSV(w io.Writer, e *entidadCSV) error {
+	escritor := csv.NewWriter(w)
+	for _, fila := range e.exportar() {
+		celdas := make([]string, len(fila))
+		for i, v := range fila {
+			celdas[i] = celdaCSV(v)
+		}
+		if err := escritor.Write(celdas); err != nil {
+			return err
+		}
+	}
+	escritor.Flush()
+	return escritor.Error()
+}
+
+// Columna de la entidad con el encabezado del archivo que se le asigno
+type ColumnaAsignada struct {
+	Nombre     string `json:"nombre"`
+	Requerida  bool   `json:"requerida"`
+	Encabezado string `json:"encabezado,omitempty"`
+}
+
+// Informe de la revision o importacion de un CSV
+type InformeCSV struct {
+	Entidad     string            `json:"entidad"`
+	Accion      string            `json:"accion"` // revisar o importar
+	Encabezados []string          `json:"encabezados"`
+	Columnas    []ColumnaAsignada `json:"columnas"`
+	SinUsar     []string          `json:"sin_usar,omitempty"` // encabezados del archivo que no se importan
+	Filas       int               `json:"filas"`
+	Importados  int               `json:"importados"`
+	Errores     []ErrorCSV        `json:"errores"`
+}
+
+/*
+Lee el CSV, asigna sus encabezados a las columnas de la entidad y valida todas las
+filas. asignados indica para cada columna el encabezado del archivo elegido a mano
+("-" para no importarla); las demas se buscan por su nombre y sus alias sin distinguir
+mayusculas ni acentos. El separador puede ser coma o punto y coma, como guardan las
+hojas de calculo en espanol. Devuelve la funcion que guarda las filas si no hubo errores.
+*/
+func revisarCSV(e *entidadCSV, contenido []byte, asignados map[string]string) (*InformeCSV, func() error) {
+	informe := &InformeCSV{Entidad: e.nombre, Errores: []ErrorCSV{}}
+	contenido = bytes.TrimPrefix(contenido, []byte("\xef\xbb\xbf"))
+	primera, _, _ := bytes.Cut(contenido, []byte("\n"))
+	lector := csv.NewReader(bytes.NewReader(contenido))
+	if bytes.Count(primera, []byte(";")) > bytes.Count(primera, []byte(",")) {
+		lector.Comma = ';'
+	}
+	lector.FieldsPerRecord = -1
+
+	encabezados, err := lector.Read()
+	if err != nil {
+		mensaje := "el archivo está vacío"
+		if err != io.EOF {
+			mensaje = "el encabezado no es válido: " + err.Error()
+		}
+		informe.Errores = append(informe.Errores, ErrorCSV{Fila: 1, Mensaje: mensaje})
+		return informe, nil
+	}
+	for i := range encabezados {
+		encabezados[i] = strings.TrimSpace(encabezados[i])
+	}
+	informe.Encabezados = encabezados
+
+	indices := map[string]int{}
+	usado := make([]bool, len(encabezados))
+	for _, c := range e.columnas {
+		indice := -1
+		switch elegido := asignados[c.nombre]; elegido {
+		case "-":
+		case "":
+			for i, h := range encabezados {
+				if clave := claveCSV(h); !usado[i] && (clave == c.nombre || contiene(c.alias, clave)) {
+					indice = i
+					break
+				}
+			}
+		default:
+			for i, h := range encabezados {
+				if h == elegido {
+					indice = i
+				}
+			}
+			if indice < 0 {
+				informe.Errores = append(informe.Errores, ErrorCSV{1, c.nombre, fmt.Sprintf("el archivo no tiene el encabezado %q", elegido)})
+			}
+		}
+		asignada := ColumnaAsignada{Nombre: c.nombre, Requerida: c.requerida}
+		if indice >= 0 {
+			indices[c.nombre], usado[indice], asignada.Encabezado = indice, true, encabezados[indice]
+		} else if c.requerida {
+			informe.Errores = append(informe.Errores, ErrorCSV{1, c.nombre, "ninguna columna del archivo corresponde a " + c.nombre})
+		}
+		informe.Columnas = append(informe.Columnas, asignada)
+	}
+	for i, h := range encabezados {
+		if !usado[i] {
+			informe.SinUsar = append(informe.SinUsar, h)
+		}
+	}
+	if len(informe.Errores) > 0 {
+		return informe, nil
+	}
+
+	var filas []filaCSV
+	for {
+		registro, err := lector.Read()
+		if err == io.EOF {
+			break
+		}
+		var errCSV *csv.ParseError
+		if errors.As(err, &errCSV) {
+			informe.Errores = append(informe.Errores, ErrorCSV{Fila: errCSV.Line, Mensaje: errCSV.Err.Error()})
+			continue
+		}
+		if strings.TrimSpace(strings.Join(registro, "")) == "" {
+			continue
+		}
+		linea, _ := lector.FieldPos(0)
+		if len(registro) != len(encabezados) {
+			informe.Errores = append(informe.Errores, ErrorCSV{Fila: linea,
+				Mensaje: fmt.Sprintf("la fila tiene %d columnas y el encabezado %d", len(registro), len(encabezados))})
+			continue
+		}
+		fila := filaCSV{numero: linea, valores: map[string]string{}}
+		for columna, i := range indices {
+			fila.valores[columna] = valorCSV(strings.TrimSpace(registro[i]))
+		}
+		filas = append(filas, fila)
+	}
+	informe.Filas = len(filas)
+	if len(filas) == 0 && len(informe.Errores) == 0 {
+		informe.Errores = append(informe.Errores, ErrorCSV{Fila: 2, Mensaje: "el archivo no tiene filas de datos"})
+	}
+
+	aplicar, errores := e.validar(filas)
+	informe.Errores = append(informe.Errores, errores...)
+	if len(informe.Errores) > 0 {
+		return informe, nil
+	}
+	return informe, aplicar
+}
+
+// Codigo HTML para importar y exportar CSV, con el informe de la ultima revision
+var csvTemplate = template.Must(template.New("csv").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Importar y exportar CSV</title>
+</head>
+<body>
+	<h1>Importar CSV</h1>
+	{{with .Informe}}
+	{{if .Errores}}
+	<p>{{len .Errores}} error(es) en {{.Filas}} fila(s) de {{.Entidad}}; no se importó ninguna fila.</p>
+	<table>
+		<tr><th>Fila</th><th>Columna</th><th>Error</th></tr>
+		{{range .Errores}}<tr><td>{{.Fila}}</td><td>{{.Columna}}</td><td>{{.Mensaje}}</td></tr>{{end}}
+	</table>
+	{{else if eq .Accion "importar"}}
+	<p>Se importaron {{.Importados}} fila(s) de {{.Entidad}}.</p>
+	{{else}}
+	<p>Las {{.Filas}} fila(s) de {{.Entidad}} son válidas. Vuelva a elegir el archivo y pulse Importar para guardarlas.</p>
+	{{end}}
+	{{with .SinUsar}}<p>Columnas del archivo que no se importan: {{range $i, $h := .}}{{if $i}}, {{end}}{{$h}}{{end}}</p>{{end}}
+	{{end}}
+	<form action="/importar-csv" method="post" enctype="multipart/form-data">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<label for="entidad">Datos:</label>
+		<select id="entidad" name="entidad">
+			{{range .Importables}}<option value="{{.}}"{{if and $.Informe (eq . $.Informe.Entidad)}} selected{{end}}>{{.}}</option>{{end}}
+		</select><br>
+		{{with .Informe}}{{if .Encabezados}}
+		<table>
+			<tr><th>Columna</th><th>Encabezado del archivo</th></tr>
+			{{range .Columnas}}
+			{{$columna := .}}
+			<tr>
+				<td><label for="columna_{{.Nombre}}">{{.Nombre}}{{if .Requerida}} *{{end}}</label></td>
+				<td><select id="columna_{{.Nombre}}" name="columna_{{.Nombre}}">
+					<option value="">automático</option>
+					<option value="-"{{if not .Encabezado}} selected{{end}}>sin importar</option>
+					{{range $.Informe.Encabezados}}<option value="{{.}}"{{if eq . $columna.Encabezado}} selected{{end}}>{{.}}</option>{{end}}
+				</select></td>
+			</tr>
+			{{end}}
+		</table>
+		{{end}}{{end}}
+		<label for="archivo">Archivo CSV (hasta 20 MB, separado por coma o punto y coma):</label>
+		<input type="file" id="archivo" name="archivo" accept=".csv,text/csv" required><br>
+		<button type="submit" name="accion" value="revisar">Revisar</button>
+		<button type="submit" name="accion" value="importar">Importar</button>
+	</form>
+	<h2>Exportar CSV</h2>
+	<ul>
+		{{range .Entidades}}<li><a href="/exportar-csv?entidad={{.}}">{{.}}</a></li>{{end}}
+	</ul>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+/*
+Funcion para importar libros, cuentas o ejemplares desde un CSV. Con accion=revisar
+(por omision) solo valida el archivo e informa los errores de cada fila; con
+accion=importar guarda todas las filas o, si alguna tiene errores, ninguna.
+formato=json responde con el informe en JSON.
+*/
+func importarCSV(w http.ResponseWriter, r *http.Request) {
+	datos := struct {
+		CSRF        string
+		Importables []string
+		Entidades   []string
+		Informe     *InformeCSV
+	}{CSRF: tokenCSRF(r)}
+	for _, e := range entidadesCSV {
+		if e.columnas != nil {
+			datos.Importables = append(datos.Importables, e.nombre)
+		}
+		datos.Entidades = append(datos.Entidades, e.nombre)
+	}
+	if r.Method == http.MethodGet {
+		if err := csvTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entidad, err := buscarEntidadCSV(r.FormValue("entidad"))
+	if err == nil && entidad.columnas == nil {
+		err = fmt.Errorf("%s solo se puede exportar", entidad.nombre)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	archivo, _, err := r.FormFile("archivo")
+	if err != nil {
+		http.Error(w, "Adjunte un archivo CSV de hasta 20 MB", http.StatusBadRequest)
+		return
+	}
+	defer archivo.Close()
+	contenido, err := io.ReadAll(io.LimitReader(archivo, maxTamanoCSV+1))
+	if err != nil {
+		http.Error(w, "Error al leer el archivo", http.StatusInternalServerError)
+		return
+	}
+	if len(contenido) > maxTamanoCSV {
+		http.Error(w, "El archivo supera los 20 MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	asignados := map[string]string{}
+	for _, c := range entidad.columnas {
+		asignados[c.nombre] = r.FormValue("columna_" + c.nombre)
+	}
+	/*La revision y el guardado se hacen con los candados de los demas que cambian el
+	catalogo, las cuentas y la circulacion, tomados en ese orden, para que nadie use
+	los IDs, ISBN, correos o codigos de barras revisados antes de guardar las filas*/
+	muCatalogo.Lock()
+	defer muCatalogo.Unlock()
+	muCuentas.Lock()
+	defer muCuentas.Unlock()
+	muCirculacion.Lock()
+	defer muCirculacion.Unlock()
+	informe, aplicar := revisarCSV(entidad, contenido, asignados)
+	informe.Accion = "revisar"
+	estado := http.StatusOK
+	if aplicar == nil {
+		estado = http.StatusUnprocessableEntity
+	} else if r.FormValue("accion") == "importar" {
+		if err := aplicar(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		informe.Accion, informe.Importados = "importar", informe.Filas
+	}
+
+	if r.FormValue("formato") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(estado)
+		json.NewEncoder(w).Encode(informe)
+		return
+	}
+	datos.Informe = informe
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(estado)
+	if err := csvTemplate.Execute(w, datos); err != nil {
+		log.Println("Error al mostrar el informe del CSV:", err)
+	}
+}
+
+// Funcion para descargar una entidad en CSV (/exportar-csv?entidad=libros)
+func exportarCSV(w http.ResponseWriter, r *http.Request) {
+	entidad, err := buscarEntidadCSV(r.URL.Query().Get("entidad"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// El catalogo llega tomado; las cuentas y la circulacion se toman para no leer una lista a medio cambiar
+	var buf bytes.Buffer
+	muCuentas.Lock()
+	muCirculacion.RLock()
+	err = escribirCSV(&buf, entidad)
+	muCirculacion.RUnlock()
+	muCuentas.Unlock()
+	if err != nil {
+		http.Error(w, "Error al exportar: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+entidad.nombre+`.csv"`)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+/*
+Catalogo con un libro, su ejemplar, dos cuentas y el vocabulario de materias, sin
+autores ni obras; guarda los archivos en un directorio temporal
+*/
+func catalogoCSVPrueba(t *testing.T) *Libro {
+	enDirectorioTemporal(t)
+	anterior, anteriorIndice, materias, autores, obras := libreria, indice, listadomateria.Materias, listadoautor.Autores, listadoobra.Obras
+	inventario, reservas, prestamos, cuentas := listadoinventario.Inventarios, listadoreserva.Reservas, listadoprestamo.Prestamos, listadocuenta.Cuentas
+	t.Cleanup(func() {
+		libreria, indice, listadomateria.Materias, listadoautor.Autores, listadoobra.Obras = anterior, anteriorIndice, materias, autores, obras
+		listadoinventario.Inventarios, listadoreserva.Reservas, listadoprestamo.Prestamos, listadocuenta.Cuentas = inventario, reservas, prestamos, cuentas
+	})
+
+	listadomateria.Materias = []*Materia{
+		{MateriaID: 1, Nombre: "Filosofía", Dewey: "100"},
+		{MateriaID: 2, Nombre: "Estoicismo", PadreID: 1, Variantes: []string{"Stoicism"}, Dewey: "188"},
+	}
+	listadoautor.Autores, listadoobra.Obras = nil, nil
+	libro := &Libro{LibroID: 1, Titulo: "Meditaciones", Autor: "Marco Aurelio", ISBN: "9788420412146"}
+	libreria = &Libreria{Libros: []*Libro{libro}}
+	indice = nuevoIndice()
+	indice.Agregar(libro)
+	listadoinventario.Inventarios = []*Inventario{{InventarioId: 1, LibroID: 1, Disponible: true, CodigoBarras: "BIB-0001"}}
+	listadoreserva.Reservas, listadoprestamo.Prestamos = nil, nil
+	listadocuenta.Cuentas = []*Cuenta{{CuentaID: 1, Nombre: "Ana"}, {CuentaID: 2, Nombre: "Luis"}}
+	return libro
+}
+
+// Filas y columnas con errores del informe, sin los mensajes
+func erroresCSV(informe *InformeCSV) map[ErrorCSV]bool {
+	errores := map[ErrorCSV]bool{}
+	for _, e := range informe.Errores {
+		errores[ErrorCSV{Fila: e.Fila, Columna: e.Columna}] = true
+	}
+	return errores
+}
+
+func TestRevisarLibrosCSV(t *testing.T) {
+	catalogoCSVPrueba(t)
+	entidad, _ := buscarEntidadCSV("libros")
+	contenido := "Título;Autores;Fecha de publicación;ISBN;Materias;id\n" +
+		"Manual de Epicteto;Epicteto;1980;978-0-306-40615-7;Estoicismo;\n" +
+		";;no es fecha;978-84-204-1214-6;Alquimia;\n" +
+		"Enquiridión;Epicteto;;9780306406157;;1\n" +
+		"Disertaciones;Epicteto;;978-0-306-40615-8;;x\n"
+
+	informe, aplicar := revisarCSV(entidad, []byte(contenido), nil)
+	if aplicar != nil {
+		t.Fatal("se puede importar un archivo con errores")
+	}
+	if informe.Filas != 4 {
+		t.Errorf("filas = %d, se esperaban 4", informe.Filas)
+	}
+	errores := erroresCSV(informe)
+	for _, e := range []ErrorCSV{{Fila: 3, Columna: "titulo"}, {Fila: 3, Columna: "autor"}, {Fila: 3, Columna: "fecha"},
+		{Fila: 3, Columna: "isbn"}, {Fila: 3, Columna: "materias"}, {Fila: 4, Columna: "isbn"}, {Fila: 4, Columna: "id"},
+		{Fila: 5, Columna: "isbn"}, {Fila: 5, Columna: "id"}} {
+		if !errores[e] {
+			t.Errorf("falta el error de la fila %d en la columna %s; errores: %+v", e.Fila, e.Columna, informe.Errores)
+		}
+	}
+	if errores[ErrorCSV{Fila: 2, Columna: "isbn"}] || len(errores) != 9 {
+		t.Errorf("errores inesperados: %+v", informe.Errores)
+	}
+}
+
+func TestRevisarCuentasEInventarioCSV(t *testing.T) {
+	catalogoCSVPrueba(t)
+	listadocuenta.Cuentas[0].Mail = "ana@correo.com"
+
+	cuentas, _ := buscarEntidadCSV("cuentas")
+	informe, aplicar := revisarCSV(cuentas, []byte("nombre,mail,contrasena,roles\n"+
+		"Eva,ANA@correo.com,secreta123,usuario\n"+
+		"Juan,juan@correo.com,,jefe\n"+
+		"Rosa,juan@correo.com,secreta123,\n"), nil)
+	errores := erroresCSV(informe)
+	if aplicar != nil || len(errores) != 4 || !errores[ErrorCSV{Fila: 2, Columna: "mail"}] || !errores[ErrorCSV{Fila: 3, Columna: "contrasena"}] ||
+		!errores[ErrorCSV{Fila: 3, Columna: "roles"}] || !errores[ErrorCSV{Fila: 4, Columna: "mail"}] {
+		t.Errorf("errores de cuentas: %+v", informe.Errores)
+	}
+
+	inventario, _ := buscarEntidadCSV("inventario")
+	informe, aplicar = revisarCSV(inventario, []byte("libro_id,isbn,codigo_barras,disponible\n"+
+		"1,,bib-0001,si\n"+
+		"9,,BIB-0002,tal vez\n"+
+		",9780306406157,BIB-0002,\n"), nil)
+	errores = erroresCSV(informe)
+	if aplicar != nil || len(errores) != 5 || !errores[ErrorCSV{Fila: 2, Columna: "codigo_barras"}] || !errores[ErrorCSV{Fila: 3, Columna: "libro_id"}] ||
+		!errores[ErrorCSV{Fila: 3, Columna: "disponible"}] || !errores[ErrorCSV{Fila: 4, Columna: "isbn"}] ||
+		!errores[ErrorCSV{Fila: 4, Columna: "codigo_barras"}] {
+		t.Errorf("errores de inventario: %+v", informe.Errores)
+	}
+}
+
+func TestImportarLibrosCSV(t *testing.T) {
+	catalogoCSVPrueba(t)
+	entidad, _ := buscarEntidadCSV("libros")
+	contenido := []byte("titulo,autor,materias,licencias\nManual de Epicteto,Epicteto,Estoicismo,2\n")
+
+	// Si un archivo no se puede reemplazar el catalogo, los autores y las obras no cambian
+	if err := os.Mkdir("libros.json", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, aplicar := revisarCSV(entidad, contenido, nil)
+	if aplicar == nil {
+		t.Fatal("el archivo válido tiene errores")
+	}
+	if err := aplicar(); err == nil {
+		t.Fatal("se importó sin poder guardar libros.json")
+	}
+	if len(libreria.Libros) != 1 || len(listadoautor.Autores) != 0 || len(listadoobra.Obras) != 0 {
+		t.Errorf("tras el error quedan %d libros, %d autores y %d obras", len(libreria.Libros), len(listadoautor.Autores), len(listadoobra.Obras))
+	}
+	if temporales, _ := filepath.Glob("*.tmp"); len(temporales) != 0 {
+		t.Errorf("quedaron los archivos temporales %v", temporales)
+	}
+	if _, err := os.Stat("autores.json"); !os.IsNotExist(err) {
+		t.Errorf("se guardó autores.json sin libros.json: %v", err)
+	}
+
+	if err := os.Remove("libros.json"); err != nil {
+		t.Fatal(err)
+	}
+	informe, aplicar := revisarCSV(entidad, contenido, nil)
+	if aplicar == nil {
+		t.Fatalf("errores: %+v", informe.Errores)
+	}
+	if err := aplicar(); err != nil {
+		t.Fatal(err)
+	}
+	if len(libreria.Libros) != 2 || len(listadoautor.Autores) != 1 || len(listadoobra.Obras) != 1 {
+		t.Fatalf("tras importar hay %d libros, %d autores y %d obras", len(libreria.Libros), len(listadoautor.Autores), len(listadoobra.Obras))
+	}
+	libro := libreria.Libros[1]
+	if libro.LibroID != 2 || libro.Licencias != 2 || !slices.Equal(libro.Materias, []int{2}) {
+		t.Errorf("libro importado %+v", libro)
+	}
+	var guardados []*Libro
+	if err := loadFromJSON("libros.json", &guardados); err != nil || len(guardados) != 2 {
+		t.Errorf("libros.json tiene %d libros: %v", len(guardados), err)
+	}
+}
+
+func TestImportarInventarioCSV(t *testing.T) {
+	catalogoCSVPrueba(t)
+	reserva := &Reserva{ReservaID: 1, UsuarioID: 1, ObraID: 1, LibroID: 1, Estado: ReservaPendiente}
+	listadoreserva.Reservas = []*Reserva{reserva}
+	listadoinventario.Inventarios[0].Disponible = false
+	entidad, _ := buscarEntidadCSV("inventario")
+	contenido := []byte("isbn,codigo_barras\n9788420412146,BIB-0002\n")
+
+	// Si el inventario no se guarda la reserva sigue pendiente
+	if err := os.Mkdir("inventario.json", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, aplicar := revisarCSV(entidad, contenido, nil)
+	if err := aplicar(); err == nil {
+		t.Fatal("se importó sin poder guardar inventario.json")
+	}
+	if len(listadoinventario.Inventarios) != 1 || reserva.Estado != ReservaPendiente || len(listadoreserva.Reservas) != 1 || listadoreserva.Reservas[0] != reserva {
+		t.Errorf("tras el error hay %d ejemplares y la reserva está %s", len(listadoinventario.Inventarios), reserva.Estado)
+	}
+
+	if err := os.Remove("inventario.json"); err != nil {
+		t.Fatal(err)
+	}
+	_, aplicar = revisarCSV(entidad, contenido, nil)
+	if err := aplicar(); err != nil {
+		t.Fatal(err)
+	}
+	if len(listadoinventario.Inventarios) != 2 {
+		t.Fatalf("tras importar hay %d ejemplares", len(listadoinventario.Inventarios))
+	}
+	ejemplar := listadoinventario.Inventarios[1]
+	if res := listadoreserva.Reservas[0]; res.Estado != ReservaLista || res.InventarioID != ejemplar.InventarioId || ejemplar.IsDisponible() {
+		t.Errorf("reserva %+v, ejemplar disponible %v", res, ejemplar.IsDisponible())
+	}
+	var guardadas []*Reserva
+	if err := loadFromJSON("reservas.json", &guardadas); err != nil || len(guardadas) != 1 || guardadas[0].Estado != ReservaLista {
+		t.Errorf("reservas.json: %+v %v", guardadas, err)
+	}
+}
+
+func TestImportarCuentasCSV(t *testing.T) {
+	catalogoCSVPrueba(t)
+	correos := capturarCorreos(t)
+	anteriorTokens, anteriorIteraciones := listadotoken.Tokens, iteracionesContrasena
+	t.Cleanup(func() { listadotoken.Tokens, iteracionesContrasena = anteriorTokens, anteriorIteraciones })
+	iteracionesContrasena = 1000
+
+	var cuerpo bytes.Buffer
+	m := multipart.NewWriter(&cuerpo)
+	m.WriteField("entidad", "cuentas")
+	m.WriteField("accion", "importar")
+	m.WriteField("formato", "json")
+	archivo, _ := m.CreateFormFile("archivo", "cuentas.csv")
+	archivo.Write([]byte("nombre,mail,contrasena,roles\nEva,eva@correo.com,secreta123,usuario\n"))
+	m.Close()
+	r := httptest.NewRequest(http.MethodPost, "/importar-csv", &cuerpo)
+	r.Header.Set("Content-Type", m.FormDataContentType())
+	w := httptest.NewRecorder()
+	importarCSV(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("importar = %d: %s", w.Code, w.Body)
+	}
+
+	// La cuenta recibe el ID siguiente, la contraseña cifrada y el correo de verificacion
+	eva := buscarCuentaMail("eva@correo.com")
+	if eva == nil || eva.CuentaID != 3 || eva.Contrasena == "secreta123" || !eva.ComprobarContrasena("secreta123") {
+		t.Fatalf("cuenta importada %+v", eva)
+	}
+	if len(correos.enviados) != 1 || correos.enviados[0].Para != "eva@correo.com" {
+		t.Errorf("correos %+v", correos.enviados)
+	}
+	var guardadas []*Cuenta
+	if err := loadFromJSON("cuentas.json", &guardadas); err != nil || len(guardadas) != 3 {
+		t.Errorf("cuentas.json tiene %d cuentas: %v", len(guardadas), err)
+	}
+}
+
+func TestGuardarListados(t *testing.T) {
+	enDirectorioTemporal(t)
+	if err := os.WriteFile("a.json", []byte("[1]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir("b.json", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := guardarListados(archivoJSON{[]int{2}, "a.json"}, archivoJSON{[]int{2}, "b.json"}); err == nil {
+		t.Fatal("se guardó sobre un directorio")
+	}
+	if datos, _ := os.ReadFile("a.json"); string(datos) != "[1]" {
+		t.Errorf("a.json = %s", datos)
+	}
+
+	// Si falla un renombre los archivos ya renombrados vuelven a su contenido anterior
+	os.WriteFile("a.json", []byte("[2]"), 0o644)
+	os.WriteFile("c.json", []byte("[2]"), 0o644)
+	if err := restaurarListados([]contenidoAnterior{{"a.json", []byte("[1]"), true}, {"c.json", nil, false}}); err != nil {
+		t.Fatal(err)
+	}
+	if datos, _ := os.ReadFile("a.json"); string(datos) != "[1]" {
+		t.Errorf("a.json restaurado = %s", datos)
+	}
+	if _, err := os.Stat("c.json"); !os.IsNotExist(err) {
+		t.Errorf("c.json no se borró: %v", err)
+	}
+	if temporales, _ := filepath.Glob("*.tmp"); len(temporales) != 0 {
+		t.Errorf("quedaron los archivos temporales %v", temporales)
+	}
+}
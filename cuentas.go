@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Busqueda de cuentas por ID y por correo
+func buscarCuentaID(id int) *Cuenta {
+	c, _ := listadocuenta.BuscarID(id)
+	return c
+}
+
+func buscarCuentaMail(mail string) *Cuenta {
+	for _, c := range listadocuenta.Cuentas {
+		if strings.EqualFold(c.Mail, mail) {
+			return c
+		}
+	}
+	return nil
+}
+
+/*
+Las contrasenas se guardan derivadas con PBKDF2-HMAC-SHA256 (RFC 8018) y una sal
+aleatoria, en el formato pbkdf2-sha256$iteraciones$sal$clave con la sal y la clave
+en base64. Las iteraciones quedan en cada contrasena para poder aumentarlas sin
+invalidar las anteriores.
+*/
+const prefijoContrasena = "pbkdf2-sha256$"
+
+var iteracionesContrasena = 600000
+
+// PBKDF2 con un solo bloque, que da una clave del largo de SHA-256
+func derivarContrasena(contrasena string, sal []byte, iteraciones int) []byte {
+	prf := hmac.New(sha256.New, []byte(contrasena))
+	prf.Write(sal)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	clave := slices.Clone(u)
+	for i := 1; i < iteraciones; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range clave {
+			clave[j] ^= u[j]
+		}
+	}
+	return clave
+}
+
+func cifrarContrasena(contrasena string) (string, error) {
+	sal := make([]byte, 16)
+	if _, err := rand.Read(sal); err != nil {
+		return "", err
+	}
+	clave := derivarContrasena(contrasena, sal, iteracionesContrasena)
+	return fmt.Sprintf("%s%d$%s$%s", prefijoContrasena, iteracionesContrasena,
+		base64.RawStdEncoding.EncodeToString(sal), base64.RawStdEncoding.EncodeToString(clave)), nil
+}
+
+func (c *Cuenta) SetContrasena(contrasena string) error {
+	cifrada, err := cifrarContrasena(contrasena)
+	if err != nil {
+		return err
+	}
+	c.Contrasena = cifrada
+	return nil
+}
+
+// Indica si la contrasena coincide; las cuentas sin contrasena (solo OIDC) no la aceptan
+func (c *Cuenta) ComprobarContrasena(contrasena string) bool {
+	partes := strings.Split(strings.TrimPrefix(c.Contrasena, prefijoContrasena), "$")
+	if !strings.HasPrefix(c.Contrasena, prefijoContrasena) || len(partes) != 3 {
+		return false
+	}
+	iteraciones, err1 := strconv.Atoi(partes[0])
+	sal, err2 := base64.RawStdEncoding.DecodeString(partes[1])
+	clave, err3 := base64.RawStdEncoding.DecodeString(partes[2])
+	if err1 != nil || err2 != nil || err3 != nil || iteraciones <= 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare(derivarContrasena(contrasena, sal, iteraciones), clave) == 1
+}
+
+/*
+Cifra las contrasenas que aun estan en texto plano, como las de los archivos
+anteriores o la semilla. Devuelve cuantas cifro.
+*/
+func cifrarContrasenas(cuentas []*Cuenta) (int, error) {
+	cifradas := 0
+	for _, c := range cuentas {
+		if c.Contrasena == "" || strings.HasPrefix(c.Contrasena, prefijoContrasena) {
+			continue
+		}
+		if err := c.SetContrasena(c.Contrasena); err != nil {
+			return cifradas, err
+		}
+		cifradas++
+	}
+	return cifradas, nil
+}
+
+/*
+Cuenta como se guarda en cuentas.json. La contrasena de Cuenta no se serializa para
+que ninguna respuesta JSON la incluya; solo este formato la escribe en el archivo.
+*/
+type cuentaArchivo struct {
+	*Cuenta
+	Contrasena string `json:"contrasena"`
+}
+
+func guardarCuentas(cuentas []*Cuenta) error {
+	registros := make([]cuentaArchivo, len(cuentas))
+	for i, c := range cuentas {
+		registros[i] = cuentaArchivo{c, c.Contrasena}
+	}
+	return saveToJSON(registros, "cuentas.json")
+}
+
+func leerCuentas(archivo string) ([]*Cuenta, error) {
+	var registros []cuentaArchivo
+	if err := loadFromJSON(archivo, &registros); err != nil {
+		return nil, err
+	}
+	cuentas := make([]*Cuenta, 0, len(registros))
+	for _, r := range registros {
+		if r.Cuenta == nil {
+			continue
+		}
+		r.Cuenta.Contrasena = r.Contrasena
+		cuentas = append(cuentas, r.Cuenta)
+	}
+	return cuentas, nil
+}
+
+// Formatos anteriores de administradores.json y usuarios.json
+type administradorAnterior struct {
+	ID            int       `json:"id"`
+	Nombre        string    `json:"nombre"`
+	Mail          string    `json:"mail"`
+	Contrasena    string    `json:"contrasena"`
+	Rol           string    `json:"rol"`
+	FechaCreacion time.Time `json:"fecha_creacion"`
+	UltimoAcceso  time.Time `json:"ultimo_acceso"`
+}
+
+type usuarioAnterior struct {
+	ID             int    `json:"id"`
+	Nombre         string `json:"nombre"`
+	Mail           string `json:"mail"`
+	Contrasena     string `json:"contrasena"`
+	Rol            string `json:"rol"`
+	MailVerificado bool   `json:"mail_verificado"`
+}
+
+// Resultado de la migracion de cuentas
+type ReporteMigracion struct {
+	Cuentas     []*Cuenta
+	Fusionadas  []string    // correos presentes en ambos archivos, que conservan la contrasena del administrador
+	Reasignados map[int]int // ID anterior del administrador -> ID de la cuenta
+}
+
+/*
+Une administradores.json y usuarios.json en una sola lista de cuentas.
+Los usuarios conservan su ID porque los prestamos hacen referencia a ellos. Un
+administrador con el mismo correo que un usuario se fusiona con esa cuenta, que
+conserva la contrasena del administrador para no perder el acceso de administracion,
+y si su ID ya esta ocupado se le asigna el siguiente ID libre.
+*/
+func migrarCuentas(archivoAdmin, archivoUser string) (*ReporteMigracion, error) {
+	var admins []administradorAnterior
+	if err := loadFromJSON(archivoAdmin, &admins); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error al leer %s: %w", archivoAdmin, err)
+	}
+	var usuarios []usuarioAnterior
+	if err := loadFromJSON(archivoUser, &usuarios); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error al leer %s: %w", archivoUser, err)
+	}
+
+	reporte := &ReporteMigracion{Reasignados: map[int]int{}}
+	porID := map[int]*Cuenta{}
+	porMail := map[string]*Cuenta{}
+	maxID := 0
+	ahora := time.Now()
+
+	for _, u := range usuarios {
+		if _, ok := porID[u.ID]; ok {
+			return nil, fmt.Errorf("ID de usuario duplicado: %d", u.ID)
+		}
+		rol, err := parseRol(u.Rol)
+		if err != nil {
+			rol = RolUsuario
+		}
+		c := &Cuenta{
+			CuentaID:       u.ID,
+			Nombre:         u.Nombre,
+			Mail:           u.Mail,
+			Contrasena:     u.Contrasena,
+			Roles:          []Rol{rol},
+			FechaCreacion:  ahora,
+			UltimoAcceso:   ahora,
+			MailVerificado: u.MailVerificado,
+		}
+		porID[c.CuentaID] = c
+		porMail[strings.ToLower(c.Mail)] = c
+		reporte.Cuentas = append(reporte.Cuentas, c)
+		if c.CuentaID > maxID {
+			maxID = c.CuentaID
+		}
+	}
+	for _, a := range admins {
+		if a.ID > maxID {
+			maxID = a.ID
+		}
+	}
+
+	for _, a := range admins {
+		if c, ok := porMail[strings.ToLower(a.Mail)]; ok {
+			c.AgregarRol(RolAdministrador)
+			if a.Contrasena != "" {
+				c.Contrasena = a.Contrasena
+			}
+			if !a.FechaCreacion.IsZero() && a.FechaCreacion.Before(c.FechaCreacion) {
+				c.FechaCreacion = a.FechaCreacion
+			}
+			if a.UltimoAcceso.After(c.UltimoAcceso) {
+				c.UltimoAcceso = a.UltimoAcceso
+			}
+			reporte.Fusionadas = append(reporte.Fusionadas, c.Mail)
+			if a.ID != c.CuentaID {
+				reporte.Reasignados[a.ID] = c.CuentaID
+			}
+			continue
+		}
+
+		id := a.ID
+		if _, ocupado := porID[id]; ocupado || id <= 0 {
+			maxID++
+			id = maxID
+			reporte.Reasignados[a.ID] = id
+		}
+		c := &Cuenta{
+			CuentaID:      id,
+			Nombre:        a.Nombre,
+			Mail:          a.Mail,
+			Contrasena:    a.Contrasena,
+			Roles:         []Rol{RolAdministrador, RolUsuario},
+			FechaCreacion: a.FechaCreacion,
+			UltimoAcceso:  a.UltimoAcceso,
+		}
+		if c.FechaCreacion.IsZero() {
+			c.FechaCreacion = ahora
+		}
+		porID[id] = c
+		porMail[strings.ToLower(c.Mail)] = c
+		reporte.Cuentas = append(reporte.Cuentas, c)
+	}
+
+	sort.Slice(reporte.Cuentas, func(i, j int) bool {
+		return reporte.Cuentas[i].CuentaID < reporte.Cuentas[j].CuentaID
+	})
+	return reporte, nil
+}
+
+func imprimirReporteMigracion(w io.Writer, reporte *ReporteMigracion) {
+	fmt.Fprintf(w, "Cuentas migradas: %d\n", len(reporte.Cuentas))
+	for _, mail := range reporte.Fusionadas {
+		fmt.Fprintln(w, "Administrador y usuario fusionados con la contraseña del administrador:", mail)
+	}
+	for anterior, nuevo := range reporte.Reasignados {
+		fmt.Fprintf(w, "Administrador %d ahora tiene el ID %d\n", anterior, nuevo)
+	}
+}
+
+// Migra los archivos anteriores a cuentas.json (opcion -migrar-cuentas)
+func ejecutarMigracionCuentas() error {
+	reporte, err := migrarCuentas("administradores.json", "usuarios.json")
+	if err != nil {
+		return err
+	}
+	imprimirReporteMigracion(os.Stdout, reporte)
+	if _, err := cifrarContrasenas(reporte.Cuentas); err != nil {
+		return err
+	}
+	return guardarCuentas(reporte.Cuentas)
+}
+
+/*
+Carga las cuentas al iniciar: cuentas.json, luego los archivos anteriores y por ultimo
+la semilla. Las contrasenas en texto plano se cifran y cuentas.json se guarda de nuevo.
+*/
+func cargarCuentas(semilla []*Cuenta) error {
+	cuentas, err := leerCuentas("cuentas.json")
+	if err == nil {
+		listadocuenta.Cuentas = cuentas
+		if cifradas, err := cifrarContrasenas(cuentas); err != nil || cifradas == 0 {
+			return err
+		}
+		return guardarCuentas(cuentas)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	_, errAdmin := os.Stat("administradores.json")
+	_, errUser := os.Stat("usuarios.json")
+	if errAdmin == nil || errUser == nil {
+		reporte, err := migrarCuentas("administradores.json", "usuarios.json")
+		if err != nil {
+			return err
+		}
+		imprimirReporteMigracion(os.Stderr, reporte)
+		listadocuenta.Cuentas = reporte.Cuentas
+		_, err = cifrarContrasenas(reporte.Cuentas)
+		return err
+	}
+
+	listadocuenta.Cuentas = semilla
+	_, err = cifrarContrasenas(semilla)
+	return err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDerivarContrasena(t *testing.T) {
+	// Vectores de PBKDF2-HMAC-SHA256 del RFC 7914
+	casos := []struct {
+		iteraciones int
+		want        string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	}
+	for _, caso := range casos {
+		if got := hex.EncodeToString(derivarContrasena("password", []byte("salt"), caso.iteraciones)); got != caso.want {
+			t.Errorf("%d iteraciones = %s, se esperaba %s", caso.iteraciones, got, caso.want)
+		}
+	}
+}
+
+func TestComprobarContrasena(t *testing.T) {
+	a, err := nuevaCuenta(1, "Ana", "ana@correo.com", "secreta123", RolUsuario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := nuevaCuenta(2, "Luis", "luis@correo.com", "secreta123", RolUsuario)
+	if !strings.HasPrefix(a.Contrasena, prefijoContrasena) || strings.Contains(a.Contrasena, "secreta123") {
+		t.Errorf("contraseña guardada %q", a.Contrasena)
+	}
+	if a.Contrasena == b.Contrasena {
+		t.Error("la misma contraseña da el mismo resultado en dos cuentas")
+	}
+	if !a.ComprobarContrasena("secreta123") || a.ComprobarContrasena("secreta124") || a.ComprobarContrasena("") {
+		t.Error("la contraseña no se comprueba correctamente")
+	}
+
+	// Una contrasena en texto plano o una cuenta sin contrasena no aceptan ninguna
+	for _, guardada := range []string{"secreta123", "", prefijoContrasena + "x$y$z"} {
+		c := &Cuenta{Contrasena: guardada}
+		if c.ComprobarContrasena(guardada) {
+			t.Errorf("se aceptó la contraseña guardada como %q", guardada)
+		}
+	}
+}
+
+func TestCuentaJSONSinContrasena(t *testing.T) {
+	c, _ := nuevaCuenta(1, "Ana", "ana@correo.com", "secreta123", RolAdministrador)
+	datos, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(datos), "contrasena") || strings.Contains(string(datos), c.Contrasena) {
+		t.Errorf("el JSON de la cuenta incluye la contraseña: %s", datos)
+	}
+}
+
+func TestCargarCuentasCifraTextoPlano(t *testing.T) {
+	enDirectorioTemporal(t)
+	anterior := listadocuenta.Cuentas
+	t.Cleanup(func() { listadocuenta.Cuentas = anterior })
+	archivo := `[{"id": 1, "nombre": "Ana", "mail": "ana@correo.com", "roles": ["usuario"], "contrasena": "librosana1"}]`
+	if err := os.WriteFile("cuentas.json", []byte(archivo), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cargarCuentas(nil); err != nil {
+		t.Fatal(err)
+	}
+	datos, err := os.ReadFile("cuentas.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(datos), "librosana1") || !strings.Contains(string(datos), prefijoContrasena) {
+		t.Errorf("cuentas.json no se cifró: %s", datos)
+	}
+	cuentas, err := leerCuentas("cuentas.json")
+	if err != nil || len(cuentas) != 1 || !cuentas[0].ComprobarContrasena("librosana1") {
+		t.Errorf("la cuenta no ingresa con su contraseña tras la migración: %v", err)
+	}
+}
+
+func TestMigrarCuentas(t *testing.T) {
+	enDirectorioTemporal(t)
+	admins := `[{"id": 3, "nombre": "Kevin", "mail": "Kevin@correo.com", "contrasena": "clave-admin", "rol": "administrador"},
+		{"id": 1, "nombre": "Jazmin", "mail": "jazmin@correo.com", "contrasena": "clave-jazmin", "rol": "administrador"}]`
+	usuarios := `[{"id": 1, "nombre": "Juan", "mail": "juan@correo.com", "contrasena": "clave-juan", "rol": "usuario"},
+		{"id": 5, "nombre": "Kevin", "mail": "kevin@correo.com", "contrasena": "clave-usuario", "rol": "usuario"}]`
+	os.WriteFile("administradores.json", []byte(admins), 0644)
+	os.WriteFile("usuarios.json", []byte(usuarios), 0644)
+
+	reporte, err := migrarCuentas("administradores.json", "usuarios.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reporte.Cuentas) != 3 || len(reporte.Fusionadas) != 1 {
+		t.Fatalf("%d cuentas y %d fusionadas, se esperaban 3 y 1", len(reporte.Cuentas), len(reporte.Fusionadas))
+	}
+
+	// El administrador con el correo de un usuario conserva su rol y su contrasena en esa cuenta
+	kevin := reporte.Cuentas[1]
+	if kevin.CuentaID != 5 || !kevin.TieneRol(RolAdministrador) || !kevin.TieneRol(RolUsuario) || kevin.Contrasena != "clave-admin" {
+		t.Errorf("cuenta fusionada %+v", kevin)
+	}
+	// El administrador cuyo ID ocupa un usuario recibe el siguiente libre
+	if jazmin := reporte.Cuentas[2]; jazmin.Mail != "jazmin@correo.com" || jazmin.CuentaID != 6 || reporte.Reasignados[1] != 6 || reporte.Reasignados[3] != 5 {
+		t.Errorf("administrador reasignado %+v, reasignados %v", jazmin, reporte.Reasignados)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import "strings"
+
+// Distancia de edicion entre dos palabras (Damerau-Levenshtein con transposiciones adyacentes)
+func distanciaEdicion(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 {
+		return len(rb)
+	}
+	if len(rb) == 0 {
+		return len(ra)
+	}
+
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			costo := 1
+			if ra[i-1] == rb[j-1] {
+				costo = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+costo)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+costo)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// Errores permitidos segun el largo de la palabra: ninguno en palabras cortas
+func tolerancia(palabra string) int {
+	n := len([]rune(palabra))
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Indica si la palabra buscada coincide con la del catalogo dentro de la tolerancia
+func coincideAproximado(buscada, palabra string) bool {
+	t := tolerancia(buscada)
+	if t == 0 {
+		return false
+	}
+	diferencia := len([]rune(buscada)) - len([]rune(palabra))
+	if diferencia > t || -diferencia > t {
+		return false
+	}
+	return distanciaEdicion(buscada, palabra) <= t
+}
+
+// Palabra del vocabulario mas parecida; en empate gana la mas frecuente en el catalogo
+func (ix *IndiceCatalogo) palabraCercana(palabra string) (string, bool) {
+	mejor, mejorDist, mejorFrec := "", tolerancia(palabra)+1, 0
+	for v, frec := range ix.vocabulario {
+		if !coincideAproximado(palabra, v) {
+			continue
+		}
+		d := distanciaEdicion(palabra, v)
+		if d < mejorDist || (d == mejorDist && (frec > mejorFrec || (frec == mejorFrec && v < mejor))) {
+			mejor, mejorDist, mejorFrec = v, d, frec
+		}
+	}
+	return mejor, mejor != ""
+}
+
+/*
+Construye una consulta corregida con las palabras del propio catalogo. Solo se
+reemplazan las palabras que no existen en el vocabulario; si ninguna cambia no
+hay sugerencia y se devuelve un texto vacio.
+*/
+func (ix *IndiceCatalogo) Sugerir(consulta string) string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	palabras := tokenizar(consulta)
+	cambio := false
+	for i, p := range palabras {
+		if ix.vocabulario[p] > 0 || palabrasVacias[p] {
+			continue
+		}
+		if cercana, ok := ix.palabraCercana(p); ok {
+			palabras[i] = cercana
+			cambio = true
+		}
+	}
+	if !cambio {
+		return ""
+	}
+	return strings.Join(palabras, " ")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDistanciaEdicion(t *testing.T) {
+	casos := []struct {
+		a, b string
+		want int
+	}{
+		{"seneca", "seneca", 0},
+		{"seneka", "seneca", 1},    // sustitucion
+		{"epicteto", "epicteo", 1}, // eliminacion
+		{"epictetus", "epicteto", 2},
+		{"marco", "amrco", 1}, // transposicion de letras vecinas
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"ñandu", "nandu", 1}, // cuenta runas, no bytes
+	}
+	for _, caso := range casos {
+		if got := distanciaEdicion(caso.a, caso.b); got != caso.want {
+			t.Errorf("distanciaEdicion(%q, %q) = %d, se esperaba %d", caso.a, caso.b, got, caso.want)
+		}
+	}
+}
+
+func TestCoincideAproximado(t *testing.T) {
+	casos := []struct {
+		buscada, palabra string
+		want             bool
+	}{
+		{"seneka", "seneca", true},
+		{"epictetus", "epicteto", true}, // nueve letras admiten dos errores
+		{"marko", "marco", true},
+		{"sol", "son", false}, // las palabras cortas deben ser exactas
+		{"seneca", "senecas", true},
+		{"seneca", "cesena", false},
+		{"roma", "romanticismo", false}, // demasiada diferencia de largo
+	}
+	for _, caso := range casos {
+		if got := coincideAproximado(caso.buscada, caso.palabra); got != caso.want {
+			t.Errorf("coincideAproximado(%q, %q) = %v, se esperaba %v", caso.buscada, caso.palabra, got, caso.want)
+		}
+	}
+}
+
+func TestSugerir(t *testing.T) {
+	ix := nuevoIndice()
+	ix.Agregar(&Libro{LibroID: 1, Titulo: "Cartas de un Estoico", Autor: "Séneca"})
+	ix.Agregar(&Libro{LibroID: 2, Titulo: "Manual", Autor: "Epicteto"})
+	ix.Agregar(&Libro{LibroID: 3, Titulo: "Meditaciones", Autor: "Marco Aurelio"})
+
+	casos := []struct {
+		consulta string
+		want     string
+	}{
+		{"Seneka", "seneca"},
+		{"Epictetus", "epicteto"},
+		{"cartas de Seneka", "cartas de seneca"}, // solo cambian las palabras que no existen
+		{"meditasiones marko", "meditaciones marco"},
+		{"seneca", ""},     // sin errores no hay sugerencia
+		{"astronomia", ""}, // nada parecido en el catalogo
+	}
+	for _, caso := range casos {
+		if got := ix.Sugerir(caso.consulta); got != caso.want {
+			t.Errorf("Sugerir(%q) = %q, se esperaba %q", caso.consulta, got, caso.want)
+		}
+	}
+}
+
+func TestBuscarConErrores(t *testing.T) {
+	ix := nuevoIndice()
+	ix.Agregar(&Libro{LibroID: 1, Titulo: "Cartas de un Estoico", Autor: "Séneca"})
+	ix.Agregar(&Libro{LibroID: 2, Titulo: "Manual", Autor: "Epicteto"})
+
+	casos := []struct {
+		consulta string
+		want     []int
+	}{
+		{"Seneka", []int{1}},
+		{"Epictetus", []int{2}},
+		{"Estoicp", []int{1}},
+	}
+	for _, caso := range casos {
+		if got := idsResultados(ix.Buscar(caso.consulta, 0)); !reflect.DeepEqual(got, caso.want) {
+			t.Errorf("Buscar(%q) = %v, se esperaba %v", caso.consulta, got, caso.want)
+		}
+	}
+
+	// La coincidencia exacta puntua mas que la aproximada
+	exacta, aproximada := ix.Buscar("seneca", 0), ix.Buscar("seneka", 0)
+	if len(exacta) != 1 || len(aproximada) != 1 || exacta[0].Puntaje <= aproximada[0].Puntaje {
+		t.Errorf("puntajes exacta %v, aproximada %v", exacta, aproximada)
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tamano maximo de los XML del paquete y de la portada que se leen del EPUB
+const (
+	maxXMLEPUB     = 1 << 20
+	maxPortadaEPUB = 10 << 20
+)
+
+// META-INF/container.xml indica donde esta el paquete OPF
+type contenedorEPUB struct {
+	Rootfiles []struct {
+		Ruta string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// Autor o colaborador del OPF; el rol viene en opf:role (EPUB 2) o en un meta refines (EPUB 3)
+type creadorOPF struct {
+	ID     string `xml:"id,attr"`
+	Rol    string `xml:"role,attr"`
+	Nombre string `xml:",chardata"`
+}
+
+type metaOPF struct {
+	Nombre    string `xml:"name,attr"`
+	Contenido string `xml:"content,attr"`
+	Refina    string `xml:"refines,attr"`
+	Propiedad string `xml:"property,attr"`
+	Valor     string `xml:",chardata"`
+}
+
+type itemOPF struct {
+	ID          string `xml:"id,attr"`
+	Href        string `xml:"href,attr"`
+	Tipo        string `xml:"media-type,attr"`
+	Propiedades string `xml:"properties,attr"`
+}
+
+// Paquete OPF con los metadatos Dublin Core, el manifiesto de archivos y el orden de lectura
+type paqueteOPF struct {
+	Metadatos struct {
+		Titulos         []string     `xml:"title"`
+		Creadores       []creadorOPF `xml:"creator"`
+		Colaboradores   []creadorOPF `xml:"contributor"`
+		Idiomas         []string     `xml:"language"`
+		Editoriales     []string     `xml:"publisher"`
+		Identificadores []string     `xml:"identifier"`
+		Descripciones   []string     `xml:"description"`
+		Fechas          []string     `xml:"date"`
+		Materias        []string     `xml:"subject"`
+		Metas           []metaOPF    `xml:"meta"`
+	} `xml:"metadata"`
+	Manifiesto []itemOPF `xml:"manifest>item"`
+	Lectura    []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// Datos propuestos para un libro a partir de su EPUB, de un registro MARC o de una fila CSV
+type PropuestaLibro struct {
+	LibroID     int
+	Titulo      string
+	Subtitulo   string
+	Autor       string
+	Traductor   string
+	Editor      string
+	Fecha       string
+	Edicion     string
+	Idioma      string
+	Editorial   string
+	ISBN        string
+	Descripcion string
+	Url         string
+	ObraID      int // 0 agrupa el libro con las ediciones del mismo titulo y autor
+	Materias    []int
+	Archivo     ArchivoLibro
+	Portada     string // hash de la portada en el almacen de archivos
+	Importacion string // clave de la importacion pendiente que recibe el formulario
+	Avisos      []string
+}
+
+/*
+EPUB importado que espera la revision del formulario. El formulario solo lleva la
+clave de la importacion, por lo que el archivo y la portada que se adjuntan al libro
+son los que leyo el servidor y no hashes elegidos por el cliente.
+*/
+type importacionEPUB struct {
+	cuentaID int
+	archivo  ArchivoLibro
+	portada  string
+	expira   time.Time
+}
+
+// Tiempo para revisar los datos importados antes de crear el libro
+const plazoImportacionEPUB = time.Hour
+
+var (
+	importacionesEPUB   = map[string]*importacionEPUB{}
+	muImportacionesEPUB sync.Mutex
+)
+
+// Guarda la importacion pendiente de la cuenta y devuelve su clave
+func registrarImportacion(cuentaID int, p *PropuestaLibro) (string, error) {
+	clave, err := aleatorioBase64(32)
+	if err != nil {
+		return "", err
+	}
+	muImportacionesEPUB.Lock()
+	defer muImportacionesEPUB.Unlock()
+	for k, imp := range importacionesEPUB {
+		if time.Now().After(imp.expira) {
+			delete(importacionesEPUB, k)
+		}
+	}
+	importacionesEPUB[clave] = &importacionEPUB{cuentaID: cuentaID, archivo: p.Archivo, portada: p.Portada,
+		expira: time.Now().Add(plazoImportacionEPUB)}
+	return clave, nil
+}
+
+// Nombres de los idiomas mas comunes a partir del codigo de dc:language
+var nombresIdiomas = map[string]string{
+	"es": "español", "en": "inglés", "la": "latín", "el": "griego", "grc": "griego",
+	"fr": "francés", "de": "alemán", "it": "italiano", "pt": "portugués", "ca": "catalán",
+}
+
+func nombreIdioma(codigo string) string {
+	codigo = strings.ToLower(strings.TrimSpace(codigo))
+	base, _, _ := strings.Cut(codigo, "-")
+	if nombre, ok := nombresIdiomas[base]; ok {
+		return nombre
+	}
+	return codigo
+}
+
+// Lee un archivo del EPUB con un tamano maximo
+func leerEntradaEPUB(z *zip.Reader, nombre string, limite int64) ([]byte, error) {
+	f, err := z.Open(nombre)
+	if err != nil {
+		return nil, fmt.Errorf("el EPUB no contiene %s", nombre)
+	}
+	defer f.Close()
+	datos, err := io.ReadAll(io.LimitReader(f, limite+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(datos)) > limite {
+		return nil, fmt.Errorf("%s es demasiado grande", nombre)
+	}
+	return datos, nil
+}
+
+// Quita las etiquetas HTML que algunas editoriales ponen en dc:description
+func textoPlano(texto string) string {
+	var b strings.Builder
+	dentro := false
+	for _, r := range texto {
+		switch {
+		case r == '<':
+			dentro = true
+		case r == '>' && dentro:
+			dentro = false
+			b.WriteRune(' ')
+		case !dentro:
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(b.String())), " ")
+}
+
+// Rol de un creador: el atributo opf:role o el meta role que lo refina
+func rolCreador(c creadorOPF, metas []metaOPF) string {
+	rol := c.Rol
+	for _, m := range metas {
+		if c.ID != "" && m.Refina == "#"+c.ID && m.Propiedad == "role" {
+			rol = m.Valor
+		}
+	}
+	return strings.TrimSpace(rol)
+}
+
+// Lee el paquete OPF que indica META-INF/container.xml y devuelve tambien su ruta en el EPUB
+func leerPaqueteOPF(z *zip.Reader) (*paqueteOPF, string, error) {
+	datos, err := leerEntradaEPUB(z, "META-INF/container.xml", maxXMLEPUB)
+	if err != nil {
+		return nil, "", err
+	}
+	var contenedor contenedorEPUB
+	if err := xml.Unmarshal(datos, &contenedor); err != nil || len(contenedor.Rootfiles) == 0 {
+		return nil, "", errors.New("META-INF/container.xml no indica el paquete OPF")
+	}
+	rutaOPF := contenedor.Rootfiles[0].Ruta
+	if datos, err = leerEntradaEPUB(z, rutaOPF, maxXMLEPUB); err != nil {
+		return nil, "", err
+	}
+	var opf paqueteOPF
+	if err := xml.Unmarshal(datos, &opf); err != nil {
+		return nil, "", fmt.Errorf("error al leer %s: %w", rutaOPF, err)
+	}
+	return &opf, rutaOPF, nil
+}
+
+/*
+Lee los metadatos del paquete OPF de un EPUB y extrae la portada al almacen de
+archivos. Los campos que no se encuentran quedan vacios y se anotan en los avisos.
+*/
+func leerEPUB(z *zip.Reader) (*PropuestaLibro, error) {
+	opf, rutaOPF, err := leerPaqueteOPF(z)
+	if err != nil {
+		return nil, err
+	}
+
+	md := opf.Metadatos
+	p := &PropuestaLibro{}
+	if len(md.Titulos) > 0 {
+		p.Titulo = strings.TrimSpace(md.Titulos[0])
+	}
+	// Los roles usan los codigos MARC: aut (autor), trl (traductor) y edt (editor);
+	// dc:creator sin rol es autor
+	roles := map[string][]string{}
+	for _, c := range md.Creadores {
+		rol := cmp.Or(rolCreador(c, md.Metas), "aut")
+		roles[rol] = append(roles[rol], strings.TrimSpace(c.Nombre))
+	}
+	for _, c := range md.Colaboradores {
+		rol := rolCreador(c, md.Metas)
+		roles[rol] = append(roles[rol], strings.TrimSpace(c.Nombre))
+	}
+	p.Autor = strings.Join(roles["aut"], "; ")
+	p.Traductor = strings.Join(roles["trl"], "; ")
+	p.Editor = strings.Join(roles["edt"], "; ")
+	if len(md.Idiomas) > 0 {
+		p.Idioma = nombreIdioma(md.Idiomas[0])
+	}
+	if len(md.Editoriales) > 0 {
+		p.Editorial = strings.TrimSpace(md.Editoriales[0])
+	}
+	for _, id := range md.Identificadores {
+		valor := strings.TrimPrefix(strings.TrimSpace(id), "urn:isbn:")
+		if isbn, err := NormalizarISBN(valor); err == nil {
+			p.ISBN = isbn
+			break
+		}
+	}
+	if len(md.Descripciones) > 0 {
+		p.Descripcion = textoPlano(md.Descripciones[0])
+	}
+	for _, f := range md.Fechas {
+		// Las fechas del OPF pueden traer la hora: 2024-09-21T00:00:00Z
+		fecha, _, _ := strings.Cut(strings.TrimSpace(f), "T")
+		if parcial, err := ParseFechaParcial(fecha); err == nil {
+			p.Fecha = parcial.ISO()
+			break
+		}
+	}
+	var sinMateria []string
+	for _, s := range md.Materias {
+		m := listadomateria.resolver(s)
+		if m == nil {
+			sinMateria = append(sinMateria, strings.TrimSpace(s))
+		} else if !contieneEntero(p.Materias, m.MateriaID) {
+			p.Materias = append(p.Materias, m.MateriaID)
+		}
+	}
+
+	// La portada se guarda solo si es una imagen que luego se pueda reducir a miniaturas
+	portadaInvalida := false
+	if item := portadaOPF(opf); item != nil {
+		ruta, err := url.PathUnescape(item.Href)
+		if err == nil {
+			ruta = path.Join(path.Dir(rutaOPF), ruta)
+			if imagen, err := leerEntradaEPUB(z, ruta, maxPortadaEPUB); err == nil {
+				if _, err := decodificarPortada(imagen); err != nil {
+					portadaInvalida = true
+				} else if hash, _, err := guardarBlob(bytes.NewReader(imagen)); err == nil {
+					p.Portada = hash
+				}
+			}
+		}
+	}
+
+	for _, campo := range []struct{ nombre, valor string }{{"el título", p.Titulo}, {"el autor", p.Autor},
+		{"la fecha", p.Fecha}, {"el idioma", p.Idioma}, {"la editorial", p.Editorial}, {"el ISBN", p.ISBN},
+		{"la descripción", p.Descripcion}} {
+		if campo.valor == "" {
+			p.Avisos = append(p.Avisos, "No se encontró "+campo.nombre+" en el EPUB")
+		}
+	}
+	if portadaInvalida {
+		p.Avisos = append(p.Avisos, "La portada del EPUB no es una imagen válida y se descartó")
+	} else if p.Portada == "" {
+		p.Avisos = append(p.Avisos, "No se encontró la portada en el EPUB")
+	}
+	if len(sinMateria) > 0 {
+		p.Avisos = append(p.Avisos, "Temas del EPUB sin materia en el vocabulario: "+strings.Join(sinMateria, ", "))
+	}
+	if len(p.Materias) == 0 {
+		p.Avisos = append(p.Avisos, "Ninguna materia del EPUB corresponde al vocabulario; elija al menos una")
+	}
+	return p, nil
+}
+
+/*
+Imagen de portada del manifiesto: la marcada con properties="cover-image" (EPUB 3),
+la indicada por <meta name="cover"> (EPUB 2) o una imagen con "cover" en su id.
+*/
+func portadaOPF(opf *paqueteOPF) *itemOPF {
+	idPortada := ""
+	for _, m := range opf.Metadatos.Metas {
+		if m.Nombre == "cover" {
+			idPortada = m.Contenido
+		}
+	}
+	for _, criterio := range []func(it itemOPF) bool{
+		func(it itemOPF) bool { return strings.Contains(it.Propiedades, "cover-image") },
+		func(it itemOPF) bool { return idPortada != "" && it.ID == idPortada },
+		func(it itemOPF) bool { return strings.Contains(strings.ToLower(it.ID), "cover") },
+	} {
+		for i, it := range opf.Manifiesto {
+			if strings.HasPrefix(it.Tipo, "image/") && criterio(it) {
+				return &opf.Manifiesto[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Abre un EPUB del almacen de archivos para leerlo como ZIP
+func abrirEPUB(hash string) (*zip.Reader, *os.File, error) {
+	f, err := abrirBlob(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	z, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.New("el archivo no es un EPUB válido")
+	}
+	return z, f, nil
+}
+
+// Codigo HTML para importar un EPUB
+var importTemplate = template.Must(template.New("importar").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Importar EPUB</title>
+</head>
+<body>
+	<h1>Importar libro desde un EPUB</h1>
+	<p>Los datos del EPUB se muestran en el formulario de creación para revisarlos antes de guardar el libro.</p>
+	<form action="/importar-epub" method="post" enctype="multipart/form-data">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<label for="archivo">Archivo EPUB:</label>
+		<input type="file" id="archivo" name="archivo" accept=".epub,application/epub+zip" required><br>
+		<button type="submit">Leer metadatos</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para subir un EPUB y revisar los datos que propone para el nuevo libro
+func importarEPUB(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if err := importTemplate.Execute(w, formulario(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archivo, cabecera, err := r.FormFile("archivo")
+	if err != nil {
+		http.Error(w, "Adjunte un archivo EPUB de hasta 100 MB", http.StatusBadRequest)
+		return
+	}
+	defer archivo.Close()
+	inicio := make([]byte, 58)
+	n, _ := io.ReadFull(archivo, inicio)
+	if formato, err := detectarFormato(inicio[:n]); err != nil || formato != formatoEPUB {
+		http.Error(w, "El archivo debe ser un EPUB", http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := archivo.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Error al leer el archivo", http.StatusInternalServerError)
+		return
+	}
+	hash, tamano, err := guardarBlob(archivo)
+	if err != nil {
+		http.Error(w, "Error al guardar el archivo", http.StatusInternalServerError)
+		return
+	}
+
+	// El EPUB se recibe sin el candado; la propuesta se arma con el catalogo tomado para lectura
+	muCatalogo.RLock()
+	defer muCatalogo.RUnlock()
+	z, f, err := abrirEPUB(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	defer f.Close()
+	propuesta, err := leerEPUB(z)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	propuesta.Archivo = ArchivoLibro{Hash: hash, Formato: formatoEPUB, Nombre: filepath.Base(cabecera.Filename), Tamano: tamano}
+	for _, l := range libreria.Libros {
+		propuesta.LibroID = max(propuesta.LibroID, l.LibroID)
+	}
+	propuesta.LibroID++
+	propuesta.Importacion, err = registrarImportacion(cuentaActual(r).CuentaID, propuesta)
+	if err != nil {
+		http.Error(w, "Error al registrar la importación", http.StatusInternalServerError)
+		return
+	}
+
+	datos := struct {
+		CSRF      string
+		Materias  []OpcionMateria
+		Obras     []*Obra
+		Propuesta *PropuestaLibro
+	}{tokenCSRF(r), opcionesMaterias(propuesta.Materias), obrasOrdenadas(), propuesta}
+	if err := createBook.Execute(w, datos); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/*
+Adjunta al libro nuevo el EPUB y la portada de la importacion pendiente del
+formulario de revision. La importacion solo sirve para la cuenta que subio el EPUB
+y se descarta una vez adjuntada.
+*/
+func adjuntarImportados(r *http.Request, l *Libro) error {
+	clave := r.FormValue("importacion")
+	if clave == "" {
+		return nil
+	}
+	muImportacionesEPUB.Lock()
+	imp, ok := importacionesEPUB[clave]
+	muImportacionesEPUB.Unlock()
+	if !ok || time.Now().After(imp.expira) || imp.cuentaID != cuentaActual(r).CuentaID {
+		return errors.New("la importación venció o no existe; vuelva a subir el EPUB")
+	}
+
+	archivo := imp.archivo
+	archivo.Subido = time.Now()
+	l.Archivos = []ArchivoLibro{archivo}
+	if hash := imp.portada; hash != "" {
+		f, err := abrirBlob(hash)
+		if err != nil {
+			return errors.New("la portada importada no está en el almacén")
+		}
+		contenido, err := io.ReadAll(io.LimitReader(f, maxPortadaEPUB))
+		f.Close()
+		if err != nil {
+			return err
+		}
+		img, err := decodificarPortada(contenido)
+		if err != nil {
+			return err
+		}
+		if err := l.asignarPortada(img, contenido); err != nil {
+			return err
+		}
+	}
+
+	muImportacionesEPUB.Lock()
+	delete(importacionesEPUB, clave)
+	muImportacionesEPUB.Unlock()
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const contenedorPrueba = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+// OPF de EPUB 3 con autor, traductor refinado por meta, fecha con hora y portada por properties
+const opfPrueba = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<dc:title>Meditaciones</dc:title>
+		<dc:creator id="aut">Marco Aurelio</dc:creator>
+		<dc:contributor id="trad">Francisco Cano</dc:contributor>
+		<meta refines="#trad" property="role" scheme="marc:relators">trl</meta>
+		<dc:language>es-ES</dc:language>
+		<dc:publisher>Gredos</dc:publisher>
+		<dc:identifier>urn:isbn:978-84-249-3519-1</dc:identifier>
+		<dc:description>&lt;p&gt;Reflexiones &lt;b&gt;estoicas&lt;/b&gt; del emperador.&lt;/p&gt;</dc:description>
+		<dc:date>1977-05-02T00:00:00Z</dc:date>
+		<dc:subject>Philosophy</dc:subject>
+		<dc:subject>Roma</dc:subject>
+	</metadata>
+	<manifest>
+		<item id="portada" href="imagenes/portada%20grande.png" media-type="image/png" properties="cover-image"/>
+		<item id="cap1" href="cap1.xhtml" media-type="application/xhtml+xml"/>
+	</manifest>
+	<spine><itemref idref="cap1"/></spine>
+</package>`
+
+// EPUB en memoria con los archivos indicados
+func epubPrueba(t *testing.T, archivos map[string][]byte) *zip.Reader {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for nombre, contenido := range archivos {
+		f, err := zw.Create(nombre)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(contenido)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+func pngPrueba(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 4, 6))); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestLeerEPUB(t *testing.T) {
+	enDirectorioTemporal(t)
+	anteriores := listadomateria.Materias
+	t.Cleanup(func() { listadomateria.Materias = anteriores })
+	listadomateria.Materias = []*Materia{{MateriaID: 1, Nombre: "Filosofía", Variantes: []string{"Philosophy"}}}
+
+	p, err := leerEPUB(epubPrueba(t, map[string][]byte{
+		"META-INF/container.xml":            []byte(contenedorPrueba),
+		"OEBPS/content.opf":                 []byte(opfPrueba),
+		"OEBPS/imagenes/portada grande.png": pngPrueba(t),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PropuestaLibro{Titulo: "Meditaciones", Autor: "Marco Aurelio", Traductor: "Francisco Cano", Fecha: "1977-05-02",
+		Idioma: "español", Editorial: "Gredos", ISBN: "9788424935191", Descripcion: "Reflexiones estoicas del emperador."}
+	got := *p
+	got.Materias, got.Portada, got.Avisos = nil, "", nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("propuesta %+v", got)
+	}
+	if !slices.Equal(p.Materias, []int{1}) {
+		t.Errorf("materias %v", p.Materias)
+	}
+	if !esHash(p.Portada) {
+		t.Errorf("portada %q", p.Portada)
+	}
+	if !slices.Equal(p.Avisos, []string{"Temas del EPUB sin materia en el vocabulario: Roma"}) {
+		t.Errorf("avisos %q", p.Avisos)
+	}
+}
+
+func TestLeerEPUBPortadaInvalida(t *testing.T) {
+	enDirectorioTemporal(t)
+	anteriores := listadomateria.Materias
+	t.Cleanup(func() { listadomateria.Materias = anteriores })
+	listadomateria.Materias = nil
+
+	p, err := leerEPUB(epubPrueba(t, map[string][]byte{
+		"META-INF/container.xml":            []byte(contenedorPrueba),
+		"OEBPS/content.opf":                 []byte(opfPrueba),
+		"OEBPS/imagenes/portada grande.png": []byte("no es una imagen"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Portada != "" || !slices.Contains(p.Avisos, "La portada del EPUB no es una imagen válida y se descartó") {
+		t.Errorf("portada %q, avisos %q", p.Portada, p.Avisos)
+	}
+	if slices.ContainsFunc(p.Avisos, func(a string) bool { return strings.Contains(a, "No se encontró la portada") }) {
+		t.Errorf("aviso repetido de la portada: %q", p.Avisos)
+	}
+}
+
+func TestLeerEPUBSinPaquete(t *testing.T) {
+	casos := map[string]map[string][]byte{
+		"sin container.xml": {"OEBPS/content.opf": []byte(opfPrueba)},
+		"sin rootfile":      {"META-INF/container.xml": []byte("<container/>")},
+		"sin OPF":           {"META-INF/container.xml": []byte(contenedorPrueba)},
+		"OPF dañado":        {"META-INF/container.xml": []byte(contenedorPrueba), "OEBPS/content.opf": []byte("<package><metadata>")},
+	}
+	for nombre, archivos := range casos {
+		if _, err := leerEPUB(epubPrueba(t, archivos)); err == nil {
+			t.Errorf("%s: se esperaba un error", nombre)
+		}
+	}
+}
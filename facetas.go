@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// Un libro esta disponible si tiene al menos un ejemplar disponible en el inventario
+func libroDisponible(libroID int) bool {
+	for _, inv := range listadoinventario.Inventarios {
+		if inv.LibroID == libroID && inv.Disponible {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Faceta del catalogo y la forma de obtener sus valores para un libro (varios si tiene
+varios autores o materias). Un libro de Estoicismo cuenta tambien en Filosofía.
+*/
+type definicionFaceta struct {
+	nombre   string
+	etiqueta string
+	valores  func(l *Libro) []string
+}
+
+var facetasCatalogo = []definicionFaceta{
+	{"materia", "Materia", func(l *Libro) []string { return l.nombresMaterias() }},
+	{"autor", "Autor", func(l *Libro) []string { return l.NombresAutores() }},
+	{"anio", "Año de publicación", func(l *Libro) []string {
+		if !l.FechaPublicacion.EsCero() {
+			return []string{strconv.Itoa(l.FechaPublicacion.Anio)}
+		}
+		return nil
+	}},
+	{"disponible", "Disponibilidad", func(l *Libro) []string {
+		if libroDisponible(l.LibroID) {
+			return []string{"si"}
+		}
+		return []string{"no"}
+	}},
+}
+
+// Valor de una faceta con la cantidad de libros y el enlace para seleccionarlo o quitarlo
+type ValorFaceta struct {
+	Valor        string `json:"valor"`
+	Cantidad     int    `json:"cantidad"`
+	Seleccionado bool   `json:"seleccionado"`
+	URL          string `json:"url"`
+}
+
+type Faceta struct {
+	Nombre   string        `json:"nombre"`
+	Etiqueta string        `json:"etiqueta"`
+	Valores  []ValorFaceta `json:"valores"`
+}
+
+type ResultadoCatalogo struct {
+	Consulta   string   `json:"consulta"`
+	Orden      string   `json:"orden,omitempty"`
+	Sugerencia string   `json:"sugerencia,omitempty"`
+	Total      int      `json:"total"`
+	Resultados []*Libro `json:"resultados"`
+	Facetas    []Faceta `json:"facetas"`
+	Ordenes    []Orden  `json:"ordenes"`
+	Exportar   string   `json:"exportar"` // enlace para exportar los resultados en MARC21
+}
+
+// Criterio de orden del catalogo con el enlace para aplicarlo
+type Orden struct {
+	Valor        string `json:"valor"`
+	Etiqueta     string `json:"etiqueta"`
+	Seleccionado bool   `json:"seleccionado"`
+	URL          string `json:"url"`
+}
+
+// Comprueba los filtros de todas las facetas salvo la indicada en omitir
+func cumpleFiltros(l *Libro, filtros url.Values, omitir string) bool {
+	for _, f := range facetasCatalogo {
+		if f.nombre == omitir || len(filtros[f.nombre]) == 0 {
+			continue
+		}
+		cumple := false
+		for _, v := range f.valores(l) {
+			cumple = cumple || contiene(filtros[f.nombre], v)
+		}
+		if !cumple {
+			return false
+		}
+	}
+	return true
+}
+
+// Enlace al catalogo agregando o quitando un valor de una faceta
+func urlFaceta(params url.Values, faceta, valor string) string {
+	nuevos := url.Values{}
+	for k, v := range params {
+		nuevos[k] = append([]string(nil), v...)
+	}
+	actuales := nuevos[faceta]
+	if contiene(actuales, valor) {
+		var quedan []string
+		for _, v := range actuales {
+			if v != valor {
+				quedan = append(quedan, v)
+			}
+		}
+		nuevos[faceta] = quedan
+	} else {
+		nuevos[faceta] = append(actuales, valor)
+	}
+	nuevos.Del("formato")
+	return "/catalogo?" + nuevos.Encode()
+}
+
+/*
+Aplica la consulta y los filtros seleccionados y calcula los conteos de cada faceta.
+Los conteos de una faceta ignoran su propio filtro para poder combinar varios valores
+de la misma faceta (por ejemplo dos materias) y los filtros de las demas facetas.
+*/
+func navegarCatalogo(params url.Values) ResultadoCatalogo {
+	consulta := params.Get("q")
+	candidatos := libreria.Libros
+	if consulta != "" {
+		candidatos = nil
+		for _, res := range indice.Buscar(consulta, 0) {
+			candidatos = append(candidatos, res.Libro)
+		}
+	}
+
+	res := ResultadoCatalogo{Consulta: consulta, Resultados: []*Libro{}}
+	if consulta != "" {
+		res.Sugerencia = indice.Sugerir(consulta)
+	}
+	for _, l := range candidatos {
+		if cumpleFiltros(l, params, "") {
+			res.Resultados = append(res.Resultados, l)
+		}
+	}
+	res.Total = len(res.Resultados)
+	exportar := url.Values{}
+	for k, v := range params {
+		exportar[k] = v
+	}
+	exportar.Del("formato")
+	exportar.Del("orden")
+	res.Exportar = "/exportar-marc?" + exportar.Encode()
+	res.Orden = ordenarCatalogo(res.Resultados, params.Get("orden"))
+	for _, o := range []Orden{{Valor: "", Etiqueta: "relevancia"}, {Valor: "fecha", Etiqueta: "más antiguos"},
+		{Valor: "-fecha", Etiqueta: "más recientes"}, {Valor: "titulo", Etiqueta: "título"}} {
+		nuevos := url.Values{}
+		for k, v := range params {
+			nuevos[k] = v
+		}
+		nuevos.Del("formato")
+		nuevos.Del("orden")
+		if o.Valor != "" {
+			nuevos.Set("orden", o.Valor)
+		}
+		o.URL = "/catalogo?" + nuevos.Encode()
+		o.Seleccionado = o.Valor == res.Orden
+		res.Ordenes = append(res.Ordenes, o)
+	}
+
+	for _, f := range facetasCatalogo {
+		conteos := map[string]int{}
+		for _, l := range candidatos {
+			if !cumpleFiltros(l, params, f.nombre) {
+				continue
+			}
+			for _, v := range f.valores(l) {
+				if v != "" {
+					conteos[v]++
+				}
+			}
+		}
+		for _, v := range params[f.nombre] {
+			if _, ok := conteos[v]; !ok {
+				conteos[v] = 0
+			}
+		}
+
+		faceta := Faceta{Nombre: f.nombre, Etiqueta: f.etiqueta, Valores: []ValorFaceta{}}
+		for v, n := range conteos {
+			faceta.Valores = append(faceta.Valores, ValorFaceta{
+				Valor:        v,
+				Cantidad:     n,
+				Seleccionado: contiene(params[f.nombre], v),
+				URL:          urlFaceta(params, f.nombre, v),
+			})
+		}
+		sort.Slice(faceta.Valores, func(i, j int) bool {
+			if faceta.Valores[i].Cantidad != faceta.Valores[j].Cantidad {
+				return faceta.Valores[i].Cantidad > faceta.Valores[j].Cantidad
+			}
+			return faceta.Valores[i].Valor < faceta.Valores[j].Valor
+		})
+		res.Facetas = append(res.Facetas, faceta)
+	}
+	return res
+}
+
+/*
+Ordena los resultados del catalogo: "fecha" (mas antiguos primero), "-fecha" (mas
+recientes primero) o "titulo". Sin orden se conserva la relevancia de la busqueda.
+Los libros sin fecha van siempre al final. Devuelve el orden aplicado.
+*/
+func ordenarCatalogo(libros []*Libro, orden string) string {
+	switch orden {
+	case "fecha", "-fecha":
+		sort.SliceStable(libros, func(i, j int) bool {
+			a, b := libros[i].FechaPublicacion, libros[j].FechaPublicacion
+			if a.EsCero() || b.EsCero() {
+				return !a.EsCero() && b.EsCero()
+			}
+			if orden == "-fecha" {
+				return a.Comparar(b) > 0
+			}
+			return a.Comparar(b) < 0
+		})
+	case "titulo":
+		sort.SliceStable(libros, func(i, j int) bool {
+			return normalizarTexto(libros[i].Titulo) < normalizarTexto(libros[j].Titulo)
+		})
+	default:
+		return ""
+	}
+	return orden
+}
+
+// Codigo HTML para la navegacion del catalogo por facetas
+var catalogTemplate = template.Must(template.New("catalogo").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Catálogo</title>
+</head>
+<body>
+	<h1>Catálogo</h1>
+	<form action="/catalogo" method="get">
+		<input type="search" name="q" value="{{.Consulta}}" list="sugerencias" autocomplete="off" data-autocompletar>
+		<datalist id="sugerencias"></datalist>
+		<button type="submit">Buscar</button>
+		<a href="/catalogo?disponible=si">Solo disponibles ahora</a>
+	</form>
+	{{range .Facetas}}
+	<h3>{{.Etiqueta}}</h3>
+	<ul>
+		{{range .Valores}}
+		<li><a href="{{.URL}}">{{if .Seleccionado}}<strong>{{.Valor}}</strong> (quitar){{else}}{{.Valor}}{{end}}</a> ({{.Cantidad}})</li>
+		{{end}}
+	</ul>
+	{{end}}
+	{{if .Sugerencia}}<p>¿Quiso decir <a href="/catalogo?q={{.Sugerencia}}">{{.Sugerencia}}</a>?</p>{{end}}
+	<h2>{{.Total}} resultado(s)</h2>
+	<p>Ordenar por:
+		{{range .Ordenes}}<a href="{{.URL}}">{{if .Seleccionado}}<strong>{{.Etiqueta}}</strong>{{else}}{{.Etiqueta}}{{end}}</a> {{end}}
+	</p>
+	<p>Exportar resultados: <a href="{{.Exportar}}">MARC21</a> <a href="{{.Exportar}}&amp;formato=marcxml">MARCXML</a></p>
+	<ul>
+		{{range .Resultados}}
+		<li>{{with .URLPortada "pequena"}}<img src="{{.}}" alt="" width="80"> {{end}}{{.Titulo}} - {{range $i, $p := .Participantes}}{{if $i}}; {{end}}<a href="/autor?id={{$p.Autor.AutorID}}">{{$p.Autor.Nombre}}</a>{{if ne $p.Rol "autor"}} ({{$p.Rol}}){{end}}{{else}}{{.Autor}}{{end}} ({{.FechaPublicacion}}, {{range $i, $m := .MateriasLibro}}{{if $i}}, {{end}}<a href="/materias?id={{$m.MateriaID}}">{{$m.Nombre}}</a>{{else}}{{.Genero}}{{end}}){{with .ObraLibro}} <a href="/obra?id={{.ObraID}}">ediciones</a>{{end}}</li>
+		{{end}}
+	</ul>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+	<script src="/static/autocompletar.js"></script>
+</body>
+</html>
+`))
+
+// Funcion para navegar el catalogo por facetas (formato=json para la respuesta en JSON)
+func catalogo(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	resultado := navegarCatalogo(params)
+
+	if params.Get("formato") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resultado); err != nil {
+			http.Error(w, "Error al codificar la respuesta", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := catalogTemplate.Execute(w, resultado); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
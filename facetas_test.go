@@ -0,0 +1,115 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// Catalogo para las facetas: dos libros de Seneca, uno de Epicteto y uno sin fecha ni ejemplares
+func catalogoFacetasPrueba(t *testing.T) {
+	t.Helper()
+	anterior, anteriorIndice, anteriorInventario := libreria, indice, listadoinventario.Inventarios
+	t.Cleanup(func() { libreria, indice, listadoinventario.Inventarios = anterior, anteriorIndice, anteriorInventario })
+
+	libreria = &Libreria{Libros: []*Libro{
+		{LibroID: 1, Titulo: "Cartas a Lucilio", Autor: "Séneca", FechaPublicacion: FechaParcial{Anio: 65}},
+		{LibroID: 2, Titulo: "Sobre la brevedad de la vida", Autor: "Séneca", FechaPublicacion: FechaParcial{Anio: 1990}},
+		{LibroID: 3, Titulo: "Manual", Autor: "Epicteto", FechaPublicacion: FechaParcial{Anio: 1990}},
+		{LibroID: 4, Titulo: "Meditaciones", Autor: "Marco Aurelio"},
+	}}
+	indice = nuevoIndice()
+	for _, l := range libreria.Libros {
+		indice.Agregar(l)
+	}
+	listadoinventario.Inventarios = []*Inventario{
+		{InventarioId: 1, LibroID: 1, Disponible: true},
+		{InventarioId: 2, LibroID: 2, Disponible: false},
+		{InventarioId: 3, LibroID: 3, Disponible: true},
+	}
+}
+
+// Conteos de una faceta del resultado y los valores seleccionados
+func conteosFaceta(t *testing.T, res ResultadoCatalogo, nombre string) (map[string]int, []string) {
+	t.Helper()
+	for _, f := range res.Facetas {
+		if f.Nombre != nombre {
+			continue
+		}
+		conteos, seleccionados := map[string]int{}, []string{}
+		for _, v := range f.Valores {
+			conteos[v.Valor] = v.Cantidad
+			if v.Seleccionado {
+				seleccionados = append(seleccionados, v.Valor)
+			}
+		}
+		return conteos, seleccionados
+	}
+	t.Fatalf("no existe la faceta %s", nombre)
+	return nil, nil
+}
+
+func TestNavegarCatalogo(t *testing.T) {
+	catalogoFacetasPrueba(t)
+	casos := []struct {
+		params     url.Values
+		resultados []int
+		autor      map[string]int
+		anio       map[string]int
+		disponible map[string]int
+	}{
+		{url.Values{}, []int{1, 2, 3, 4},
+			map[string]int{"Séneca": 2, "Epicteto": 1, "Marco Aurelio": 1},
+			map[string]int{"65": 1, "1990": 2},
+			map[string]int{"si": 2, "no": 2}},
+		// Los conteos del autor no dependen del autor elegido, los demas si
+		{url.Values{"autor": {"Séneca"}}, []int{1, 2},
+			map[string]int{"Séneca": 2, "Epicteto": 1, "Marco Aurelio": 1},
+			map[string]int{"65": 1, "1990": 1},
+			map[string]int{"si": 1, "no": 1}},
+		// Dos valores de la misma faceta se suman
+		{url.Values{"autor": {"Séneca", "Epicteto"}}, []int{1, 2, 3},
+			map[string]int{"Séneca": 2, "Epicteto": 1, "Marco Aurelio": 1},
+			map[string]int{"65": 1, "1990": 2},
+			map[string]int{"si": 2, "no": 1}},
+		// Facetas distintas se combinan; el valor elegido aparece aunque no tenga libros
+		{url.Values{"anio": {"1990"}, "disponible": {"si"}, "autor": {"Marco Aurelio"}}, []int{},
+			map[string]int{"Epicteto": 1, "Marco Aurelio": 0},
+			map[string]int{"1990": 0},
+			map[string]int{"si": 0}},
+		{url.Values{"q": {"seneca"}, "disponible": {"no"}}, []int{2},
+			map[string]int{"Séneca": 1},
+			map[string]int{"1990": 1},
+			map[string]int{"si": 1, "no": 1}},
+	}
+	for _, caso := range casos {
+		res := navegarCatalogo(caso.params)
+		if got := idsLibros(res.Resultados); !slices.Equal(got, caso.resultados) || res.Total != len(caso.resultados) {
+			t.Errorf("%v: resultados %v (total %d), se esperaban %v", caso.params, got, res.Total, caso.resultados)
+		}
+		for nombre, want := range map[string]map[string]int{"autor": caso.autor, "anio": caso.anio, "disponible": caso.disponible} {
+			conteos, seleccionados := conteosFaceta(t, res, nombre)
+			if !maps.Equal(conteos, want) {
+				t.Errorf("%v: faceta %s = %v, se esperaba %v", caso.params, nombre, conteos, want)
+			}
+			slices.Sort(seleccionados)
+			if elegidos := slices.Sorted(slices.Values(caso.params[nombre])); !slices.Equal(seleccionados, elegidos) {
+				t.Errorf("%v: seleccionados en %s = %v", caso.params, nombre, seleccionados)
+			}
+		}
+	}
+}
+
+func TestURLFaceta(t *testing.T) {
+	params := url.Values{"q": {"seneca"}, "autor": {"Séneca"}, "formato": {"json"}}
+	if got := urlFaceta(params, "autor", "Epicteto"); got != "/catalogo?autor=S%C3%A9neca&autor=Epicteto&q=seneca" {
+		t.Errorf("agregar = %s", got)
+	}
+	if got := urlFaceta(params, "autor", "Séneca"); got != "/catalogo?q=seneca" {
+		t.Errorf("quitar = %s", got)
+	}
+	if len(params["autor"]) != 1 {
+		t.Error("urlFaceta modificó los parámetros de la petición")
+	}
+}
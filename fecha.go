@@ -0,0 +1,255 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+Fecha de publicacion parcial: el año es obligatorio y el mes y el dia son opcionales
+(0 cuando no se conocen). En JSON se guarda como "2024", "2024-09" o "2024-09-21".
+*/
+type FechaParcial struct {
+	Anio int
+	Mes  int
+	Dia  int
+}
+
+var nombresMeses = []string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio",
+	"agosto", "septiembre", "octubre", "noviembre", "diciembre"}
+
+// Nombres y abreviaturas de los meses en español e ingles
+var meses = map[string]int{"setiembre": 9, "sept": 9, "set": 9}
+
+func init() {
+	ingles := []string{"january", "february", "march", "april", "may", "june", "july",
+		"august", "september", "october", "november", "december"}
+	for i := range nombresMeses {
+		for _, nombre := range []string{nombresMeses[i], ingles[i]} {
+			meses[nombre] = i + 1
+			meses[nombre[:3]] = i + 1
+		}
+	}
+}
+
+// Palabras que se ignoran entre las partes de la fecha ("21 de septiembre de 2024")
+var conectoresFecha = map[string]bool{"de": true, "del": true, "of": true, "the": true}
+
+// Valida el año, el mes y el dia de la fecha
+func nuevaFechaParcial(anio, mes, dia int) (FechaParcial, error) {
+	switch {
+	case anio < 1 || anio > 9999:
+		return FechaParcial{}, fmt.Errorf("año %d fuera de rango", anio)
+	case mes < 0 || mes > 12:
+		return FechaParcial{}, fmt.Errorf("mes %d no válido", mes)
+	case dia != 0 && mes == 0:
+		return FechaParcial{}, errors.New("el día requiere el mes")
+	case dia < 0 || dia > 0 && dia > time.Date(anio, time.Month(mes)+1, 0, 0, 0, 0, 0, time.UTC).Day():
+		return FechaParcial{}, fmt.Errorf("el día %d no existe en %s de %d", dia, nombresMeses[mes-1], anio)
+	}
+	return FechaParcial{Anio: anio, Mes: mes, Dia: dia}, nil
+}
+
+/*
+Interpreta los formatos comunes de fecha: "2024", "2024-09", "2024-09-21",
+"21/09/2024", "09/2024", "2024 September", "septiembre de 2024",
+"21 de septiembre de 2024" o "September 21, 2024". Las fechas numericas con el
+año al final se leen como dia/mes/año.
+*/
+func ParseFechaParcial(texto string) (FechaParcial, error) {
+	partes := strings.FieldsFunc(normalizarTexto(texto), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(partes) == 0 {
+		return FechaParcial{}, errors.New("la fecha está vacía")
+	}
+
+	var numeros []string
+	mes := 0
+	for _, p := range partes {
+		if n := strings.TrimRight(p, "stndrh"); n != p && esNumero(n) && len(p)-len(n) == 2 {
+			p = n // ordinales en ingles: 21st, 2nd, 3rd, 4th
+		}
+		switch m, esMes := meses[p]; {
+		case esNumero(p):
+			numeros = append(numeros, p)
+		case conectoresFecha[p]:
+		case esMes:
+			if mes != 0 {
+				return FechaParcial{}, fmt.Errorf("la fecha %q tiene más de un mes", texto)
+			}
+			mes = m
+		default:
+			return FechaParcial{}, fmt.Errorf("la fecha %q tiene una palabra no reconocida: %q", texto, p)
+		}
+	}
+
+	// Un numero es el año si tiene cuatro cifras o no puede ser dia ni mes
+	esAnio := func(s string) bool {
+		n, _ := strconv.Atoi(s)
+		return len(s) == 4 || n > 31
+	}
+	valor := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	switch {
+	case mes != 0 && len(numeros) == 1 && esAnio(numeros[0]):
+		return nuevaFechaParcial(valor(numeros[0]), mes, 0)
+	case mes != 0 && len(numeros) == 2 && esAnio(numeros[0]) && !esAnio(numeros[1]):
+		return nuevaFechaParcial(valor(numeros[0]), mes, valor(numeros[1]))
+	case mes != 0 && len(numeros) == 2 && esAnio(numeros[1]) && !esAnio(numeros[0]):
+		return nuevaFechaParcial(valor(numeros[1]), mes, valor(numeros[0]))
+	case mes != 0:
+		return FechaParcial{}, fmt.Errorf("no se encontró el año en la fecha %q", texto)
+	case len(numeros) == 1 && esAnio(numeros[0]):
+		return nuevaFechaParcial(valor(numeros[0]), 0, 0)
+	case len(numeros) == 2 && esAnio(numeros[0]):
+		return nuevaFechaParcial(valor(numeros[0]), valor(numeros[1]), 0)
+	case len(numeros) == 2 && esAnio(numeros[1]):
+		return nuevaFechaParcial(valor(numeros[1]), valor(numeros[0]), 0)
+	case len(numeros) == 3 && esAnio(numeros[0]):
+		return nuevaFechaParcial(valor(numeros[0]), valor(numeros[1]), valor(numeros[2]))
+	case len(numeros) == 3 && esAnio(numeros[2]):
+		return nuevaFechaParcial(valor(numeros[2]), valor(numeros[1]), valor(numeros[0]))
+	}
+	return FechaParcial{}, fmt.Errorf("formato de fecha no reconocido: %q", texto)
+}
+
+func esNumero(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (f FechaParcial) EsCero() bool {
+	return f.Anio == 0
+}
+
+// Compara dos fechas; una fecha sin mes o sin dia va antes que las que lo tienen
+func (f FechaParcial) Comparar(o FechaParcial) int {
+	return cmp.Or(cmp.Compare(f.Anio, o.Anio), cmp.Compare(f.Mes, o.Mes), cmp.Compare(f.Dia, o.Dia))
+}
+
+// Fecha en formato AAAA, AAAA-MM o AAAA-MM-DD
+func (f FechaParcial) ISO() string {
+	switch {
+	case f.EsCero():
+		return ""
+	case f.Mes == 0:
+		return fmt.Sprintf("%04d", f.Anio)
+	case f.Dia == 0:
+		return fmt.Sprintf("%04d-%02d", f.Anio, f.Mes)
+	default:
+		return fmt.Sprintf("%04d-%02d-%02d", f.Anio, f.Mes, f.Dia)
+	}
+}
+
+// Fecha para mostrar, por ejemplo "21 de septiembre de 2024"
+func (f FechaParcial) String() string {
+	switch {
+	case f.EsCero():
+		return ""
+	case f.Mes == 0:
+		return strconv.Itoa(f.Anio)
+	case f.Dia == 0:
+		return fmt.Sprintf("%s de %d", nombresMeses[f.Mes-1], f.Anio)
+	default:
+		return fmt.Sprintf("%d de %s de %d", f.Dia, nombresMeses[f.Mes-1], f.Anio)
+	}
+}
+
+func (f FechaParcial) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.ISO())
+}
+
+// Acepta el formato AAAA-MM-DD y tambien los textos anteriores como "2024 September"
+func (f *FechaParcial) UnmarshalJSON(datos []byte) error {
+	var texto string
+	if err := json.Unmarshal(datos, &texto); err != nil {
+		return err
+	}
+	if strings.TrimSpace(texto) == "" {
+		*f = FechaParcial{}
+		return nil
+	}
+	fecha, err := ParseFechaParcial(texto)
+	if err != nil {
+		return err
+	}
+	*f = fecha
+	return nil
+}
+
+// Libro con la fecha de publicacion en el formato anterior de texto libre
+type libroAnterior struct {
+	Libro
+	FechaPublicacion string `json:"fecha_publicacion"`
+}
+
+// Resultado de la migracion de fechas de libros.json
+type ReporteFechas struct {
+	Libros      []*Libro
+	Convertidas map[int]string // ID del libro -> fecha anterior
+	SinFecha    map[int]string // ID del libro -> fecha que no se pudo interpretar
+}
+
+/*
+Convierte las fechas de texto libre de libros.json a fechas parciales. Las fechas
+que no se pueden interpretar quedan vacias y se informan para corregirlas a mano.
+*/
+func migrarFechasLibros(archivo string) (*ReporteFechas, error) {
+	var anteriores []libroAnterior
+	if err := loadFromJSON(archivo, &anteriores); err != nil {
+		return nil, fmt.Errorf("error al leer %s: %w", archivo, err)
+	}
+
+	reporte := &ReporteFechas{Convertidas: map[int]string{}, SinFecha: map[int]string{}}
+	for _, a := range anteriores {
+		libro := a.Libro
+		if strings.TrimSpace(a.FechaPublicacion) != "" {
+			fecha, err := ParseFechaParcial(a.FechaPublicacion)
+			if err != nil {
+				reporte.SinFecha[libro.LibroID] = a.FechaPublicacion
+			} else {
+				libro.FechaPublicacion = fecha
+				if fecha.ISO() != a.FechaPublicacion {
+					reporte.Convertidas[libro.LibroID] = a.FechaPublicacion
+				}
+			}
+		}
+		reporte.Libros = append(reporte.Libros, &libro)
+	}
+	return reporte, nil
+}
+
+// Migra las fechas de libros.json (opcion -migrar-libros)
+func ejecutarMigracionLibros() error {
+	reporte, err := migrarFechasLibros("libros.json")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Libros revisados: %d\n", len(reporte.Libros))
+	for _, l := range reporte.Libros {
+		if anterior, ok := reporte.Convertidas[l.LibroID]; ok {
+			fmt.Printf("Libro %d: %q -> %s\n", l.LibroID, anterior, l.FechaPublicacion.ISO())
+		}
+		if anterior, ok := reporte.SinFecha[l.LibroID]; ok {
+			fmt.Printf("Libro %d: fecha %q no reconocida, queda vacía\n", l.LibroID, anterior)
+		}
+	}
+	return saveToJSON(reporte.Libros, "libros.json")
+}
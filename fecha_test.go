@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseFechaParcial(t *testing.T) {
+	casos := []struct {
+		texto string
+		want  FechaParcial
+	}{
+		{"2024", FechaParcial{2024, 0, 0}},
+		{"2024-09", FechaParcial{2024, 9, 0}},
+		{"2024-09-21", FechaParcial{2024, 9, 21}},
+		{"21/09/2024", FechaParcial{2024, 9, 21}},
+		{"09/2024", FechaParcial{2024, 9, 0}},
+		{"2024 September", FechaParcial{2024, 9, 0}},
+		{"septiembre de 2024", FechaParcial{2024, 9, 0}},
+		{"Setiembre 2024", FechaParcial{2024, 9, 0}},
+		{"21 de septiembre de 2024", FechaParcial{2024, 9, 21}},
+		{"September 21st, 2024", FechaParcial{2024, 9, 21}},
+		{"1 ene. 1980", FechaParcial{1980, 1, 1}},
+		{"  Mayo 1980 ", FechaParcial{1980, 5, 0}},
+		{"29/02/2024", FechaParcial{2024, 2, 29}},
+		{"65", FechaParcial{65, 0, 0}}, // un año antiguo sin cuatro cifras
+	}
+	for _, caso := range casos {
+		got, err := ParseFechaParcial(caso.texto)
+		if err != nil || got != caso.want {
+			t.Errorf("ParseFechaParcial(%q) = %+v, %v; se esperaba %+v", caso.texto, got, err, caso.want)
+		}
+	}
+}
+
+func TestParseFechaParcialErrores(t *testing.T) {
+	casos := []struct {
+		texto   string
+		mensaje string
+	}{
+		{"", "vacía"},
+		{"pronto", "no reconocida"},
+		{"septiembre", "no se encontró el año"},
+		{"marzo abril 2020", "más de un mes"},
+		{"2024-13", "mes 13"},
+		{"31/02/2023", "no existe"},
+		{"29/02/2023", "no existe"},
+		{"marzo 12000", "fuera de rango"},
+		{"1/2/3/4", "no reconocido"},
+	}
+	for _, caso := range casos {
+		_, err := ParseFechaParcial(caso.texto)
+		if err == nil || !strings.Contains(err.Error(), caso.mensaje) {
+			t.Errorf("ParseFechaParcial(%q) = %v, se esperaba un error con %q", caso.texto, err, caso.mensaje)
+		}
+	}
+}
+
+func TestFechaParcialFormatos(t *testing.T) {
+	casos := []struct {
+		fecha       FechaParcial
+		iso, string string
+	}{
+		{FechaParcial{2024, 9, 21}, "2024-09-21", "21 de septiembre de 2024"},
+		{FechaParcial{2024, 9, 0}, "2024-09", "septiembre de 2024"},
+		{FechaParcial{65, 0, 0}, "0065", "65"},
+		{FechaParcial{}, "", ""},
+	}
+	for _, caso := range casos {
+		if got := caso.fecha.ISO(); got != caso.iso {
+			t.Errorf("%+v.ISO() = %q, se esperaba %q", caso.fecha, got, caso.iso)
+		}
+		if got := caso.fecha.String(); got != caso.string {
+			t.Errorf("%+v.String() = %q, se esperaba %q", caso.fecha, got, caso.string)
+		}
+
+		// El JSON guarda la fecha ISO y se lee de vuelta igual
+		datos, err := json.Marshal(caso.fecha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var leida FechaParcial
+		if err := json.Unmarshal(datos, &leida); err != nil || leida != caso.fecha {
+			t.Errorf("JSON %s se leyó como %+v, %v", datos, leida, err)
+		}
+	}
+}
+
+func TestCompararFechas(t *testing.T) {
+	fechas := []FechaParcial{{2024, 9, 21}, {1980, 0, 0}, {2024, 0, 0}, {2024, 9, 0}, {2023, 12, 31}, {1980, 5, 0}}
+	sort.Slice(fechas, func(i, j int) bool { return fechas[i].Comparar(fechas[j]) < 0 })
+	want := []FechaParcial{{1980, 0, 0}, {1980, 5, 0}, {2023, 12, 31}, {2024, 0, 0}, {2024, 9, 0}, {2024, 9, 21}}
+	for i := range want {
+		if fechas[i] != want[i] {
+			t.Fatalf("orden = %v, se esperaba %v", fechas, want)
+		}
+	}
+}
+
+func TestMigrarFechasLibros(t *testing.T) {
+	enDirectorioTemporal(t)
+	anterior := `[
+		{"id": 1, "titulo": "Cartas de un Estoico", "fecha_publicacion": "2024 September"},
+		{"id": 2, "titulo": "Manual de Epicteto", "fecha_publicacion": "1980-05"},
+		{"id": 3, "titulo": "Meditaciones", "fecha_publicacion": "algún día"},
+		{"id": 4, "titulo": "Historia de Roma", "fecha_publicacion": ""}
+	]`
+	if err := os.WriteFile("libros.json", []byte(anterior), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reporte, err := migrarFechasLibros("libros.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fechas := map[int]string{}
+	for _, l := range reporte.Libros {
+		fechas[l.LibroID] = l.FechaPublicacion.ISO()
+	}
+	want := map[int]string{1: "2024-09", 2: "1980-05", 3: "", 4: ""}
+	for id, iso := range want {
+		if fechas[id] != iso {
+			t.Errorf("libro %d: fecha %q, se esperaba %q", id, fechas[id], iso)
+		}
+	}
+	if len(reporte.Convertidas) != 1 || reporte.Convertidas[1] != "2024 September" {
+		t.Errorf("convertidas = %v", reporte.Convertidas)
+	}
+	if len(reporte.SinFecha) != 1 || reporte.SinFecha[3] != "algún día" {
+		t.Errorf("sin fecha = %v", reporte.SinFecha)
+	}
+}
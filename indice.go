@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Palabras vacias en español e ingles que no se indexan
+var palabrasVacias = map[string]bool{}
+
+func init() {
+	for _, p := range strings.Fields(`
+		a al algo como con de del desde el en entre era es esta este esto ha hay la las le les lo los mas me mi mis muy no nos o otra otro para pero por que se sin sobre su sus tambien te un una unas uno unos y ya
+		an and are as at be but by for from has have in into is it its of on or that the their this to was were will with`) {
+		palabrasVacias[p] = true
+	}
+}
+
+// Sufijos que se recortan para reducir cada palabra a su raiz (español e ingles)
+var sufijos = []string{
+	"aciones", "amientos", "imientos", "amiento", "imiento", "idades", "mente",
+	"ancias", "encias", "adoras", "adores", "acion", "ancia", "encia", "idad",
+	"istas", "ismos", "adora", "ador", "ista", "ismo", "ando", "iendo", "ados", "idos", "adas", "idas",
+	"ado", "ido", "ada", "ida", "ness", "ment", "ings", "ing", "ies", "ed", "ly",
+	"os", "as", "es", "s", "o", "a",
+}
+
+// Reduce una palabra normalizada a su raiz de forma aproximada
+func raiz(palabra string) string {
+	if len(palabra) <= 4 {
+		return palabra
+	}
+	for _, suf := range sufijos {
+		if strings.HasSuffix(palabra, suf) && len(palabra)-len(suf) >= 3 {
+			if suf == "ies" {
+				return palabra[:len(palabra)-3] + "y"
+			}
+			return palabra[:len(palabra)-len(suf)]
+		}
+	}
+	return palabra
+}
+
+// Palabras del texto listas para indexar: normalizadas, sin palabras vacias y reducidas a su raiz
+func analizar(texto string) []string {
+	var terminos []string
+	for _, p := range tokenizar(texto) {
+		if palabrasVacias[p] {
+			continue
+		}
+		terminos = append(terminos, raiz(p))
+	}
+	return terminos
+}
+
+// Campos indexados y el peso de cada uno en la relevancia
+var camposIndice = []struct {
+	nombre string
+	peso   float64
+	valor  func(l *Libro) string
+}{
+	{"titulo", 3, func(l *Libro) string { return l.Titulo + " " + l.Subtitulo }},
+	{"autor", 2, func(l *Libro) string { return l.textoAutores() }},
+	{"genero", 1.5, func(l *Libro) string { return l.textoMaterias() }},
+	{"descripcion", 1, func(l *Libro) string { return l.Descripcion }},
+}
+
+// Indice invertido del catalogo que se actualiza al crear, editar o eliminar libros
+type IndiceCatalogo struct {
+	mu            sync.RWMutex
+	postings      map[string]map[int]float64 // termino -> libro -> frecuencia ponderada
+	longitudes    map[int]float64
+	terminos      map[int][]string
+	libros        map[int]*Libro
+	totalLongitud float64
+	vocabulario   map[string]int // palabra normalizada -> apariciones, para sugerencias
+	palabras      map[int][]string
+}
+
+func nuevoIndice() *IndiceCatalogo {
+	return &IndiceCatalogo{
+		postings:    map[string]map[int]float64{},
+		longitudes:  map[int]float64{},
+		terminos:    map[int][]string{},
+		libros:      map[int]*Libro{},
+		vocabulario: map[string]int{},
+		palabras:    map[int][]string{},
+	}
+}
+
+// Agrega o reemplaza un libro en el indice
+func (ix *IndiceCatalogo) Agregar(l *Libro) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.eliminar(l.LibroID)
+
+	frecuencias := map[string]float64{}
+	longitud := 0.0
+	for _, campo := range camposIndice {
+		for _, p := range tokenizar(campo.valor(l)) {
+			if palabrasVacias[p] {
+				continue
+			}
+			frecuencias[raiz(p)] += campo.peso
+			longitud += campo.peso
+			ix.vocabulario[p]++
+			ix.palabras[l.LibroID] = append(ix.palabras[l.LibroID], p)
+		}
+	}
+
+	for t, f := range frecuencias {
+		if ix.postings[t] == nil {
+			ix.postings[t] = map[int]float64{}
+		}
+		ix.postings[t][l.LibroID] = f
+		ix.terminos[l.LibroID] = append(ix.terminos[l.LibroID], t)
+	}
+	ix.longitudes[l.LibroID] = longitud
+	ix.totalLongitud += longitud
+	ix.libros[l.LibroID] = l
+}
+
+// Quita un libro del indice
+func (ix *IndiceCatalogo) Eliminar(id int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.eliminar(id)
+}
+
+func (ix *IndiceCatalogo) eliminar(id int) {
+	if _, ok := ix.libros[id]; !ok {
+		return
+	}
+	for _, t := range ix.terminos[id] {
+		delete(ix.postings[t], id)
+		if len(ix.postings[t]) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+	for _, p := range ix.palabras[id] {
+		ix.vocabulario[p]--
+		if ix.vocabulario[p] <= 0 {
+			delete(ix.vocabulario, p)
+		}
+	}
+	delete(ix.palabras, id)
+	ix.totalLongitud -= ix.longitudes[id]
+	delete(ix.longitudes, id)
+	delete(ix.terminos, id)
+	delete(ix.libros, id)
+}
+
+// Resultado de la busqueda en el indice con fragmentos resaltados por campo
+type ResultadoIndice struct {
+	Libro      *Libro            `json:"libro"`
+	Puntaje    float64           `json:"puntaje"`
+	Fragmentos map[string]string `json:"fragmentos"`
+}
+
+/*
+Busca en el indice y ordena los libros con BM25. Si una palabra no existe en el
+catalogo se busca la palabra mas parecida del vocabulario (errores de escritura),
+con menor peso que una coincidencia exacta.
+*/
+func (ix *IndiceCatalogo) Buscar(consulta string, limite int) []ResultadoIndice {
+	const k1, b, pesoAproximado = 1.2, 0.75, 0.7
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if len(ix.libros) == 0 {
+		return nil
+	}
+
+	pesos := map[string]float64{}
+	for _, p := range tokenizar(consulta) {
+		if palabrasVacias[p] {
+			continue
+		}
+		t := raiz(p)
+		if len(ix.postings[t]) > 0 {
+			pesos[t] = 1
+		} else if cercana, ok := ix.palabraCercana(p); ok {
+			pesos[raiz(cercana)] = max(pesos[raiz(cercana)], pesoAproximado)
+		}
+	}
+	if len(pesos) == 0 {
+		return nil
+	}
+
+	n := float64(len(ix.libros))
+	promedio := ix.totalLongitud / n
+	puntajes := map[int]float64{}
+	for t, peso := range pesos {
+		docs := ix.postings[t]
+		idf := math.Log(1 + (n-float64(len(docs))+0.5)/(float64(len(docs))+0.5))
+		for id, f := range docs {
+			norma := f + k1*(1-b+b*ix.longitudes[id]/promedio)
+			puntajes[id] += peso * idf * f * (k1 + 1) / norma
+		}
+	}
+
+	resultados := make([]ResultadoIndice, 0, len(puntajes))
+	for id, p := range puntajes {
+		resultados = append(resultados, ResultadoIndice{Libro: ix.libros[id], Puntaje: p})
+	}
+	sort.Slice(resultados, func(i, j int) bool {
+		if resultados[i].Puntaje != resultados[j].Puntaje {
+			return resultados[i].Puntaje > resultados[j].Puntaje
+		}
+		return resultados[i].Libro.LibroID < resultados[j].Libro.LibroID
+	})
+	if limite > 0 && len(resultados) > limite {
+		resultados = resultados[:limite]
+	}
+
+	buscados := map[string]bool{}
+	for t := range pesos {
+		buscados[t] = true
+	}
+	for i := range resultados {
+		resultados[i].Fragmentos = fragmentos(resultados[i].Libro, buscados)
+	}
+	return resultados
+}
+
+// Fragmentos de cada campo con las palabras encontradas marcadas con <mark>
+func fragmentos(l *Libro, buscados map[string]bool) map[string]string {
+	res := map[string]string{}
+	for _, campo := range camposIndice {
+		if f, ok := resaltar(campo.valor(l), buscados, 20); ok {
+			res[campo.nombre] = f
+		}
+	}
+	return res
+}
+
+/*
+Divide el texto en palabras y separadores, marca las palabras cuya raiz fue buscada
+y si el texto es largo devuelve solo una ventana alrededor de la primera coincidencia.
+El texto se escapa para que el fragmento pueda mostrarse como HTML.
+*/
+func resaltar(texto string, buscados map[string]bool, ventana int) (string, bool) {
+	type trozo struct {
+		texto   string
+		palabra bool
+	}
+	var trozos []trozo
+	var actual strings.Builder
+	enPalabra := false
+	for _, r := range texto {
+		p := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if actual.Len() > 0 && p != enPalabra {
+			trozos = append(trozos, trozo{actual.String(), enPalabra})
+			actual.Reset()
+		}
+		enPalabra = p
+		actual.WriteRune(r)
+	}
+	if actual.Len() > 0 {
+		trozos = append(trozos, trozo{actual.String(), enPalabra})
+	}
+
+	primera, palabras := -1, 0
+	marcas := make([]bool, len(trozos))
+	for i, t := range trozos {
+		if !t.palabra {
+			continue
+		}
+		palabras++
+		if buscados[raiz(normalizarTexto(t.texto))] {
+			marcas[i] = true
+			if primera < 0 {
+				primera = i
+			}
+		}
+	}
+	if primera < 0 {
+		return "", false
+	}
+
+	desde, hasta := 0, len(trozos)
+	if palabras > ventana {
+		desde = max(0, primera-ventana/2)
+		hasta = min(len(trozos), desde+ventana*2)
+	}
+
+	var sb strings.Builder
+	if desde > 0 {
+		sb.WriteString("… ")
+	}
+	for i := desde; i < hasta; i++ {
+		if marcas[i] {
+			sb.WriteString("<mark>" + html.EscapeString(trozos[i].texto) + "</mark>")
+		} else {
+			sb.WriteString(html.EscapeString(trozos[i].texto))
+		}
+	}
+	if hasta < len(trozos) {
+		sb.WriteString(" …")
+	}
+	return strings.TrimSpace(sb.String()), true
+}
+
+var indice = nuevoIndice()
+
+/*
+Candado del catalogo: los libros, el indice y las listas que los describen. Los
+handlers que los modifican toman el de escritura y los que solo los consultan el
+de lectura, para no recorrer la lista de libros mientras otro la reemplaza. Se
+toma al recibir la peticion, antes que cualquier otro candado; los handlers que
+reciben o envian archivos lo toman solo mientras consultan o cambian el libro.
+*/
+var muCatalogo sync.RWMutex
+
+// Ejecuta el handler con el catalogo tomado para lectura
+func lecturaCatalogo(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		muCatalogo.RLock()
+		defer muCatalogo.RUnlock()
+		next(w, r)
+	}
+}
+
+// Ejecuta el handler con el catalogo tomado para escritura
+func escrituraCatalogo(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		muCatalogo.Lock()
+		defer muCatalogo.Unlock()
+		next(w, r)
+	}
+}
+
+// Codigo HTML para el buscador del catalogo
+var catalogSearchTemplate = template.Must(template.New("buscar").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Buscar en el Catálogo</title>
+</head>
+<body>
+	<h1>Buscar en el Catálogo</h1>
+	<form action="/buscar" method="get">
+		<label for="q">Título, autor, género o descripción:</label>
+		<input type="search" id="q" name="q" list="sugerencias" autocomplete="off" data-autocompletar required>
+		<datalist id="sugerencias"></datalist>
+		<button type="submit">Buscar</button>
+	</form>
+	<script src="/static/autocompletar.js"></script>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para buscar en todo el catalogo con un solo cuadro de busqueda
+func buscarCatalogo(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		if err := catalogSearchTemplate.Execute(w, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	limite, err := strconv.Atoi(r.URL.Query().Get("limite"))
+	if err != nil || limite <= 0 {
+		limite = 20
+	}
+
+	respuesta := struct {
+		Consulta   string            `json:"consulta"`
+		Sugerencia string            `json:"sugerencia,omitempty"`
+		Resultados []ResultadoIndice `json:"resultados"`
+	}{q, indice.Sugerir(q), indice.Buscar(q, limite)}
+	if respuesta.Resultados == nil {
+		respuesta.Resultados = []ResultadoIndice{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(respuesta); err != nil {
+		http.Error(w, "Error al codificar la respuesta", http.StatusInternalServerError)
+	}
+}
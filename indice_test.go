@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRaiz(t *testing.T) {
+	casos := []struct {
+		palabras []string // formas que deben reducirse a la misma raiz
+		raiz     string
+	}{
+		{[]string{"estoico", "estoicos", "estoicismo", "estoica"}, "estoic"},
+		{[]string{"carta", "cartas"}, "cart"},
+		{[]string{"library", "libraries"}, "library"},
+		{[]string{"meditaciones", "meditacion"}, "medit"},
+		{[]string{"vida"}, "vida"}, // las palabras cortas no se recortan
+	}
+	for _, caso := range casos {
+		for _, p := range caso.palabras {
+			if got := raiz(p); got != caso.raiz {
+				t.Errorf("raiz(%q) = %q, se esperaba %q", p, got, caso.raiz)
+			}
+		}
+	}
+}
+
+func TestAnalizar(t *testing.T) {
+	casos := []struct {
+		texto string
+		want  []string
+	}{
+		{"Cartas de un Estoico", []string{"cart", "estoic"}},
+		{"The Letters of a Stoic", []string{"letter", "stoic"}},
+		{"SOBRE la brevedad de la VIDA", []string{"brevedad", "vida"}},
+		{"de la y el", nil},
+	}
+	for _, caso := range casos {
+		if got := analizar(caso.texto); !reflect.DeepEqual(got, caso.want) {
+			t.Errorf("analizar(%q) = %q, se esperaba %q", caso.texto, got, caso.want)
+		}
+	}
+}
+
+// Indice con libros que solo tienen los textos de autor y genero, sin autores ni materias vinculados
+func indicePrueba() *IndiceCatalogo {
+	ix := nuevoIndice()
+	for _, l := range []*Libro{
+		{LibroID: 1, Titulo: "Cartas de un Estoico", Autor: "Séneca", Genero: "Filosofía", Descripcion: "Correspondencia con Lucilio sobre la amistad."},
+		{LibroID: 2, Titulo: "Manual de Epicteto", Autor: "Epicteto", Genero: "Filosofía", Descripcion: "Máximas del pensamiento estoico."},
+		{LibroID: 3, Titulo: "Meditaciones", Autor: "Marco Aurelio", Genero: "Filosofía", Descripcion: "Reflexiones de un emperador."},
+		{LibroID: 4, Titulo: "Historia de Roma", Autor: "Tito Livio", Genero: "Historia", Descripcion: "Desde la fundación de la ciudad."},
+	} {
+		ix.Agregar(l)
+	}
+	return ix
+}
+
+func idsResultados(resultados []ResultadoIndice) []int {
+	ids := []int{}
+	for _, r := range resultados {
+		ids = append(ids, r.Libro.LibroID)
+	}
+	return ids
+}
+
+func TestBuscarIndice(t *testing.T) {
+	ix := indicePrueba()
+	casos := []struct {
+		consulta string
+		want     []int
+	}{
+		{"estoicos", []int{1, 2}},             // el titulo pesa mas que la descripcion
+		{"ESTOICISMO", []int{1, 2}},           // misma raiz y sin distinguir mayusculas
+		{"seneca", []int{1}},                  // sin acentos
+		{"filosofia roma", []int{4, 3, 1, 2}}, // a igual coincidencia, el libro mas corto primero
+		{"de la", nil},                        // solo palabras vacias
+		{"astronomia", nil},
+	}
+	for _, caso := range casos {
+		got := ix.Buscar(caso.consulta, 0)
+		if ids := idsResultados(got); len(ids) != len(caso.want) || len(ids) > 0 && !reflect.DeepEqual(ids, caso.want) {
+			t.Errorf("Buscar(%q) = %v, se esperaba %v", caso.consulta, ids, caso.want)
+		}
+	}
+
+	if got := ix.Buscar("filosofia", 2); len(got) != 2 {
+		t.Errorf("Buscar con límite 2 devolvió %d resultados", len(got))
+	}
+}
+
+func TestIndiceIncremental(t *testing.T) {
+	ix := indicePrueba()
+
+	// Editar un libro reemplaza sus terminos anteriores
+	ix.Agregar(&Libro{LibroID: 3, Titulo: "Soliloquios", Autor: "Marco Aurelio", Genero: "Filosofía"})
+	if got := idsResultados(ix.Buscar("meditaciones", 0)); len(got) != 0 {
+		t.Errorf("tras editar el título se encontró %v", got)
+	}
+	if got := idsResultados(ix.Buscar("soliloquios", 0)); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("tras editar el título = %v", got)
+	}
+
+	// Eliminar un libro lo quita de los resultados y del vocabulario
+	ix.Eliminar(4)
+	if got := idsResultados(ix.Buscar("roma", 0)); len(got) != 0 {
+		t.Errorf("tras eliminar se encontró %v", got)
+	}
+	if ix.vocabulario["roma"] != 0 || ix.libros[4] != nil {
+		t.Error("el libro eliminado sigue en el índice")
+	}
+	ix.Eliminar(99) // un libro que no esta no cambia nada
+	if len(ix.libros) != 3 {
+		t.Errorf("el índice tiene %d libros", len(ix.libros))
+	}
+}
+
+func TestFragmentos(t *testing.T) {
+	ix := indicePrueba()
+	res := ix.Buscar("estoico", 1)
+	if len(res) != 1 {
+		t.Fatalf("Buscar = %v", idsResultados(res))
+	}
+	if got := res[0].Fragmentos["titulo"]; got != "Cartas de un <mark>Estoico</mark>" {
+		t.Errorf("fragmento del título = %q", got)
+	}
+
+	casos := []struct {
+		texto   string
+		ventana int
+		want    string
+		ok      bool
+	}{
+		{"Vida <breve> y feliz", 20, "<mark>Vida</mark> &lt;breve&gt; y feliz", true},
+		{"Sobre la brevedad de la vida", 20, "Sobre la brevedad de la <mark>vida</mark>", true},
+		{"sin coincidencias", 20, "", false},
+	}
+	for _, caso := range casos {
+		got, ok := resaltar(caso.texto, map[string]bool{"vida": true}, caso.ventana)
+		if got != caso.want || ok != caso.ok {
+			t.Errorf("resaltar(%q) = %q, %v; se esperaba %q, %v", caso.texto, got, ok, caso.want, caso.ok)
+		}
+	}
+
+	// Un texto largo se recorta alrededor de la primera coincidencia
+	largo := strings.Repeat("antes ", 30) + "vida" + strings.Repeat(" despues", 30)
+	got, _ := resaltar(largo, map[string]bool{"vida": true}, 10)
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "<mark>vida</mark>") ||
+		strings.Count(got, "antes") > 10 {
+		t.Errorf("fragmento de un texto largo = %q", got)
+	}
+	if strings.Contains(res[0].Fragmentos["descripcion"], "<mark>") {
+		t.Error("la descripción sin la palabra buscada no debe resaltarse")
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Quita el prefijo "ISBN", los guiones y los espacios; la X final se pasa a mayuscula
+func limpiarISBN(texto string) string {
+	texto = strings.ToUpper(strings.TrimSpace(texto))
+	texto = strings.TrimPrefix(texto, "ISBN-13")
+	texto = strings.TrimPrefix(texto, "ISBN-10")
+	texto = strings.TrimPrefix(texto, "ISBN")
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == ':' {
+			return -1
+		}
+		return r
+	}, texto)
+}
+
+// Digito de control de un ISBN-10 a partir de sus primeros nueve digitos
+func controlISBN10(nueve string) byte {
+	suma := 0
+	for i := 0; i < 9; i++ {
+		suma += int(nueve[i]-'0') * (10 - i)
+	}
+	control := (11 - suma%11) % 11
+	if control == 10 {
+		return 'X'
+	}
+	return byte('0' + control)
+}
+
+// Digito de control de un ISBN-13 a partir de sus primeros doce digitos
+func controlISBN13(doce string) byte {
+	suma := 0
+	for i := 0; i < 12; i++ {
+		peso := 1
+		if i%2 == 1 {
+			peso = 3
+		}
+		suma += int(doce[i]-'0') * peso
+	}
+	return byte('0' + (10-suma%10)%10)
+}
+
+func validarISBN10(isbn string) error {
+	if len(isbn) != 10 || !esNumero(isbn[:9]) || !(esNumero(isbn[9:]) || isbn[9] == 'X') {
+		return errors.New("un ISBN-10 tiene nueve dígitos y un dígito de control (0-9 o X)")
+	}
+	if esperado := controlISBN10(isbn[:9]); isbn[9] != esperado {
+		return fmt.Errorf("dígito de control incorrecto en el ISBN-10 %s: se esperaba %c", isbn, esperado)
+	}
+	return nil
+}
+
+func validarISBN13(isbn string) error {
+	if len(isbn) != 13 || !esNumero(isbn) {
+		return errors.New("un ISBN-13 tiene trece dígitos")
+	}
+	if !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") {
+		return fmt.Errorf("el ISBN-13 %s debe empezar con 978 o 979", isbn)
+	}
+	if esperado := controlISBN13(isbn[:12]); isbn[12] != esperado {
+		return fmt.Errorf("dígito de control incorrecto en el ISBN-13 %s: se esperaba %c", isbn, esperado)
+	}
+	return nil
+}
+
+// Convierte un ISBN-10 valido al ISBN-13 equivalente (prefijo 978)
+func ISBN10a13(isbn10 string) (string, error) {
+	isbn10 = limpiarISBN(isbn10)
+	if err := validarISBN10(isbn10); err != nil {
+		return "", err
+	}
+	doce := "978" + isbn10[:9]
+	return doce + string(controlISBN13(doce)), nil
+}
+
+// Convierte un ISBN-13 valido al ISBN-10; los ISBN con prefijo 979 no tienen equivalente
+func ISBN13a10(isbn13 string) (string, error) {
+	isbn13 = limpiarISBN(isbn13)
+	if err := validarISBN13(isbn13); err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(isbn13, "978") {
+		return "", fmt.Errorf("el ISBN-13 %s no tiene equivalente ISBN-10", isbn13)
+	}
+	return isbn13[3:12] + string(controlISBN10(isbn13[3:12])), nil
+}
+
+/*
+Valida un ISBN-10 o ISBN-13 escrito con o sin guiones y devuelve el ISBN-13, que es
+la forma en que se guarda en el libro para poder comparar ambos formatos.
+*/
+func NormalizarISBN(texto string) (string, error) {
+	isbn := limpiarISBN(texto)
+	switch len(isbn) {
+	case 10:
+		return ISBN10a13(isbn)
+	case 13:
+		if err := validarISBN13(isbn); err != nil {
+			return "", err
+		}
+		return isbn, nil
+	}
+	return "", fmt.Errorf("el ISBN %q debe tener 10 o 13 dígitos", texto)
+}
+
+// ISBN-10 del libro, vacio si no tiene ISBN o si no tiene equivalente
+func (l *Libro) GetISBN10() string {
+	isbn10, err := ISBN13a10(l.ISBN)
+	if err != nil {
+		return ""
+	}
+	return isbn10
+}
+
+// Busca un libro por ISBN-10 o ISBN-13
+func (lib *Libreria) BuscarISBN(texto string) (*Libro, error) {
+	isbn, err := NormalizarISBN(texto)
+	if err != nil {
+		return nil, err
+	}
+	for _, libro := range lib.Libros {
+		if libro.ISBN == isbn {
+			return libro, nil
+		}
+	}
+	return nil, errors.New("libro no encontrado con el ISBN digitado")
+}
+
+// Funcion para buscar un libro por ISBN (/buscar-isbn?isbn=)
+func buscarISBN(w http.ResponseWriter, r *http.Request) {
+	texto := r.URL.Query().Get("isbn")
+	if _, err := NormalizarISBN(texto); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	libro, err := libreria.BuscarISBN(texto)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	responderJSON(w, struct {
+		libroSerializado
+		ISBN10 string `json:"isbn10,omitempty"`
+	}{libro.serializado(), libro.GetISBN10()})
+}
+
+// Codigo HTML que se muestra al crear un libro con un ISBN que ya esta en el catalogo
+var duplicateBook = template.Must(template.New("duplicado").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Libro Existente</title>
+</head>
+<body>
+	<h1>El libro ya está en el catálogo</h1>
+	<p>El ISBN {{.Libro.ISBN}} corresponde a <strong>{{.Libro.Titulo}}</strong> de {{.Libro.Autor}} (ID {{.Libro.LibroID}}).</p>
+	<p>En lugar de crear un registro duplicado, agregue un ejemplar al inventario:</p>
+	<form action="/agregar-ejemplar" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<input type="hidden" name="libroID" value="{{.Libro.LibroID}}">
+		<label for="codigo_barras">Código de barras:</label>
+		<input type="text" id="codigo_barras" name="codigo_barras"><br>
+		<label for="ubicacion">Ubicación:</label>
+		<input type="text" id="ubicacion" name="ubicacion"><br>
+		<button type="submit">Agregar ejemplar</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para agregar un ejemplar de un libro existente al inventario
+func agregarEjemplar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	muCirculacion.Lock()
+	defer muCirculacion.Unlock()
+
+	libroID, err := strconv.Atoi(r.FormValue("libroID"))
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	if _, err := libreria.BuscarID(libroID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	codigo := strings.TrimSpace(r.FormValue("codigo_barras"))
+	if codigo != "" {
+		if _, err := listadoinventario.BuscarCodigo(codigo); err == nil {
+			http.Error(w, "Ya existe un ejemplar con ese código de barras", http.StatusConflict)
+			return
+		}
+	}
+
+	id := 0
+	for _, inv := range listadoinventario.Inventarios {
+		id = max(id, inv.InventarioId)
+	}
+	ejemplar, err := nuevoInventario(id+1, libroID, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ejemplar.CodigoBarras = codigo
+	ejemplar.Ubicacion = strings.TrimSpace(r.FormValue("ubicacion"))
+
+	listadoinventario.Inventarios = append(listadoinventario.Inventarios, ejemplar)
+	// El ejemplar nuevo se aparta para la reserva mas antigua que lo espera
+	listadoreserva.atender(ejemplar)
+	if err := guardarReservas(); err != nil {
+		http.Error(w, "Error al guardar el inventario", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ejemplar)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizarISBN(t *testing.T) {
+	casos := []struct {
+		texto string
+		want  string
+	}{
+		{"9780140442106", "9780140442106"},
+		{"978-0-14-044210-6", "9780140442106"},
+		{"ISBN 0-14-044210-3", "9780140442106"},  // ISBN-10 convertido a ISBN-13
+		{"ISBN-10: 080442957x", "9780804429573"}, // digito de control X en minuscula
+		{"979-10-323-0082-4", "9791032300824"},
+	}
+	for _, caso := range casos {
+		got, err := NormalizarISBN(caso.texto)
+		if err != nil || got != caso.want {
+			t.Errorf("NormalizarISBN(%q) = %q, %v; se esperaba %q", caso.texto, got, err, caso.want)
+		}
+	}
+}
+
+func TestNormalizarISBNErrores(t *testing.T) {
+	casos := []struct {
+		texto   string
+		mensaje string
+	}{
+		{"9780140442107", "se esperaba 6"},
+		{"0140442104", "se esperaba 3"},
+		{"9770140442100", "978 o 979"},
+		{"01404421X3", "nueve dígitos"},
+		{"97801404421", "10 o 13 dígitos"},
+		{"", "10 o 13 dígitos"},
+	}
+	for _, caso := range casos {
+		if _, err := NormalizarISBN(caso.texto); err == nil || !strings.Contains(err.Error(), caso.mensaje) {
+			t.Errorf("NormalizarISBN(%q) = %v, se esperaba un error con %q", caso.texto, err, caso.mensaje)
+		}
+	}
+}
+
+func TestConversionISBN(t *testing.T) {
+	casos := []struct {
+		isbn10, isbn13 string
+	}{
+		{"0140442103", "9780140442106"},
+		{"080442957X", "9780804429573"},
+		{"8420412147", "9788420412146"},
+	}
+	for _, caso := range casos {
+		if got, err := ISBN10a13(caso.isbn10); err != nil || got != caso.isbn13 {
+			t.Errorf("ISBN10a13(%q) = %q, %v; se esperaba %q", caso.isbn10, got, err, caso.isbn13)
+		}
+		if got, err := ISBN13a10(caso.isbn13); err != nil || got != caso.isbn10 {
+			t.Errorf("ISBN13a10(%q) = %q, %v; se esperaba %q", caso.isbn13, got, err, caso.isbn10)
+		}
+	}
+
+	// Los ISBN con prefijo 979 no tienen ISBN-10
+	if _, err := ISBN13a10("9791032300824"); err == nil {
+		t.Error("ISBN13a10 de un 979 no devolvió error")
+	}
+	if got := (&Libro{ISBN: "9791032300824"}).GetISBN10(); got != "" {
+		t.Errorf("GetISBN10 de un 979 = %q", got)
+	}
+}
+
+func TestBuscarISBNDuplicado(t *testing.T) {
+	anterior := libreria
+	t.Cleanup(func() { libreria = anterior })
+	libreria = &Libreria{Libros: []*Libro{
+		{LibroID: 1, Titulo: "Cartas de un Estoico"},
+		{LibroID: 6, Titulo: "Letters from a Stoic", ISBN: "9780140442106"},
+	}}
+
+	// El mismo libro se encuentra con cualquiera de sus formas
+	for _, texto := range []string{"9780140442106", "0-14-044210-3", "isbn 978 0 14 044210 6"} {
+		if l, err := libreria.BuscarISBN(texto); err != nil || l.LibroID != 6 {
+			t.Errorf("BuscarISBN(%q) = %v, %v", texto, l, err)
+		}
+	}
+	if _, err := libreria.BuscarISBN("9788420412146"); err == nil {
+		t.Error("BuscarISBN encontró un ISBN que no está en el catálogo")
+	}
+
+	casos := []struct {
+		isbn   string
+		codigo int
+	}{
+		{"0140442103", http.StatusOK},
+		{"9788420412146", http.StatusNotFound},
+		{"0140442104", http.StatusBadRequest},
+	}
+	for _, caso := range casos {
+		w := httptest.NewRecorder()
+		buscarISBN(w, httptest.NewRequest(http.MethodGet, "/buscar-isbn?isbn="+caso.isbn, nil))
+		if w.Code != caso.codigo {
+			t.Errorf("/buscar-isbn?isbn=%s: código %d, se esperaba %d", caso.isbn, w.Code, caso.codigo)
+			continue
+		}
+		if caso.codigo == http.StatusOK {
+			var respuesta struct {
+				ID     int    `json:"id"`
+				ISBN10 string `json:"isbn10"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &respuesta); err != nil || respuesta.ID != 6 || respuesta.ISBN10 != "0140442103" {
+				t.Errorf("respuesta %s, %v", w.Body, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Largo maximo de la posicion de lectura que envia el lector
+const maxPosicionLectura = 100
+
+/*
+Punto de lectura de un usuario en un libro, para seguir en otro dispositivo. La
+posicion la interpreta el lector: en un EPUB es "capitulo:fraccion" (indice en el
+orden de lectura y parte recorrida del capitulo) y en un PDF el numero de pagina.
+*/
+type ProgresoLectura struct {
+	UsuarioID   int       `json:"usuario_id"`
+	LibroID     int       `json:"libro_id"`
+	Formato     string    `json:"formato"`
+	Posicion    string    `json:"posicion"`
+	Porcentaje  float64   `json:"porcentaje,omitempty"`
+	Actualizado time.Time `json:"actualizado"`
+}
+
+/*
+Creamos la estructura progreso con slice para guardar los puntos de lectura. El
+candado cubre la lista y progresos.json; se toma antes que muCuentas y muCirculacion,
+que la limpieza consulta.
+*/
+type Listadoprogreso struct {
+	mu        sync.Mutex
+	Progresos []*ProgresoLectura
+}
+
+var listadoprogreso Listadoprogreso
+
+func (lp *Listadoprogreso) buscar(usuarioID, libroID int) *ProgresoLectura {
+	for _, p := range lp.Progresos {
+		if p.UsuarioID == usuarioID && p.LibroID == libroID {
+			return p
+		}
+	}
+	return nil
+}
+
+/*
+Borra el progreso de los prestamos que ya terminaron, salvo que la cuenta haya
+elegido conservarlo, armando una lista nueva. Se llama con lp.mu tomado y devuelve
+true si borro alguno.
+*/
+func (lp *Listadoprogreso) limpiar(ahora time.Time) bool {
+	quedan := make([]*ProgresoLectura, 0, len(lp.Progresos))
+	for _, p := range lp.Progresos {
+		muCuentas.Lock()
+		cuenta := buscarCuentaID(p.UsuarioID)
+		conservar := cuenta != nil && cuenta.ConservarProgreso
+		muCuentas.Unlock()
+		if conservar || (cuenta != nil && prestamoActivo(p.UsuarioID, p.LibroID, ahora) != nil) {
+			quedan = append(quedan, p)
+		}
+	}
+	borrados := len(quedan) != len(lp.Progresos)
+	lp.Progresos = quedan
+	return borrados
+}
+
+// Borra los progresos vencidos y guarda progresos.json si cambio
+func limpiarProgresos(ahora time.Time) error {
+	listadoprogreso.mu.Lock()
+	defer listadoprogreso.mu.Unlock()
+	if !listadoprogreso.limpiar(ahora) {
+		return nil
+	}
+	return saveToJSON(listadoprogreso.Progresos, "progresos.json")
+}
+
+// Cada cuanto se borran los progresos de prestamos terminados aunque nadie use el lector
+const intervaloLimpiezaProgresos = time.Hour
+
+/*
+Ademas de limpiarse al iniciar y en cada uso del lector, los progresos se limpian
+cada intervaloLimpiezaProgresos, para no guardar el de un prestamo terminado hasta
+que alguien vuelva a leer.
+*/
+func limpiarProgresosPeriodicamente() {
+	for ahora := range time.Tick(intervaloLimpiezaProgresos) {
+		if err := limpiarProgresos(ahora); err != nil {
+			log.Println("Error al limpiar el progreso de lectura:", err)
+		}
+	}
+}
+
+// Carga los progresos al iniciar; si aun no existe progresos.json la lista empieza vacia
+func cargarProgresos() error {
+	err := loadFromJSON("progresos.json", &listadoprogreso.Progresos)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Capitulo del orden de lectura de un EPUB con la direccion para abrirlo en el lector
+type CapituloLector struct {
+	Nombre string
+	URL    string
+}
+
+// Ruta completa en el EPUB de cada archivo del manifiesto con su tipo de contenido
+func recursosEPUB(opf *paqueteOPF, rutaOPF string) map[string]string {
+	recursos := map[string]string{}
+	for _, item := range opf.Manifiesto {
+		if ruta, err := url.PathUnescape(item.Href); err == nil {
+			recursos[path.Join(path.Dir(rutaOPF), ruta)] = item.Tipo
+		}
+	}
+	return recursos
+}
+
+// Direccion de un archivo del EPUB dentro del lector, escapando cada parte de la ruta
+func urlRecursoLector(libroID int, ruta string) string {
+	partes := strings.Split(ruta, "/")
+	for i, p := range partes {
+		partes[i] = url.PathEscape(p)
+	}
+	return "/lector/" + strconv.Itoa(libroID) + "/epub/" + strings.Join(partes, "/")
+}
+
+// Capitulos del EPUB en el orden de lectura (spine) del paquete OPF
+func capitulosEPUB(libro *Libro, hash string) ([]CapituloLector, error) {
+	z, f, err := abrirEPUB(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	opf, rutaOPF, err := leerPaqueteOPF(z)
+	if err != nil {
+		return nil, err
+	}
+	var capitulos []CapituloLector
+	for _, ref := range opf.Lectura {
+		for _, item := range opf.Manifiesto {
+			if item.ID != ref.IDRef {
+				continue
+			}
+			ruta, err := url.PathUnescape(item.Href)
+			if err != nil {
+				continue
+			}
+			ruta = path.Join(path.Dir(rutaOPF), ruta)
+			capitulos = append(capitulos, CapituloLector{
+				Nombre: strconv.Itoa(len(capitulos)+1) + ". " + path.Base(ruta),
+				URL:    urlRecursoLector(libro.LibroID, ruta),
+			})
+		}
+	}
+	if len(capitulos) == 0 {
+		return nil, errors.New("el EPUB no tiene un orden de lectura")
+	}
+	return capitulos, nil
+}
+
+// Codigo HTML del lector de libros electronicos; la logica esta en /static/lector.js
+var readerTemplate = template.Must(template.New("lector").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>{{.Libro.Titulo}}</title>
+</head>
+<body>
+	<h1>{{.Libro.Titulo}}</h1>
+	<div id="lector" data-libro="{{.Libro.LibroID}}" data-formato="{{.Formato}}" data-csrf="{{.CSRF}}" data-documento="{{.Documento}}">
+		{{if .Capitulos}}
+		<button type="button" id="anterior">Anterior</button>
+		<select id="capitulo">
+			{{range .Capitulos}}<option value="{{.URL}}">{{.Nombre}}</option>{{end}}
+		</select>
+		<button type="button" id="siguiente">Siguiente</button>
+		{{else}}
+		<label for="numeroPagina">Página:</label>
+		<input type="number" id="numeroPagina" min="1" value="1">
+		<button type="button" id="irPagina">Ir y guardar</button>
+		{{end}}
+		<span id="porcentaje"></span>
+	</div>
+	<iframe id="pagina" title="{{.Libro.Titulo}}" width="100%" height="700"></iframe>
+	<p><a href="/privacidad">Privacidad del progreso de lectura</a></p>
+	<script src="/static/lector.js"></script>
+</body>
+</html>
+`))
+
+/*
+Funcion para leer un libro en el navegador (/lector?libroID=&formato=epub|pdf).
+Necesita un prestamo vigente del libro; sin formato se prefiere el EPUB.
+*/
+func lector(w http.ResponseWriter, r *http.Request) {
+	cuenta := cuentaActual(r)
+	ahora := time.Now()
+	if err := limpiarProgresos(ahora); err != nil {
+		http.Error(w, "Error al guardar el progreso de lectura", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("libroID"))
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	libro, err := libreria.BuscarID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if prestamoActivo(cuenta.CuentaID, id, ahora) == nil {
+		http.Error(w, "Necesita un préstamo vigente de este libro para leerlo", http.StatusForbidden)
+		return
+	}
+	formato := strings.ToLower(r.URL.Query().Get("formato"))
+	if formato == "" {
+		formato = formatoPDF
+		if _, err := libro.BuscarArchivo(formatoEPUB); err == nil {
+			formato = formatoEPUB
+		}
+	}
+	archivo, err := libro.BuscarArchivo(formato)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	datos := struct {
+		CSRF      string
+		Libro     *Libro
+		Formato   string
+		Documento string
+		Capitulos []CapituloLector
+	}{CSRF: tokenCSRF(r), Libro: libro, Formato: formato}
+	if formato == formatoEPUB {
+		if datos.Capitulos, err = capitulosEPUB(libro, archivo.Hash); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	} else {
+		datos.Documento = "/lector/" + strconv.Itoa(libro.LibroID) + "/pdf"
+	}
+	if err := readerTemplate.Execute(w, datos); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/*
+Funcion que entrega al lector el contenido del libro: /lector/{libroID}/pdf o un
+archivo del manifiesto del EPUB en /lector/{libroID}/epub/{ruta}. Las respuestas
+pueden mostrarse en el marco del lector y los capitulos no pueden ejecutar scripts.
+*/
+func recursoLector(w http.ResponseWriter, r *http.Request) {
+	cuenta := cuentaActual(r)
+	partes := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/lector/"), "/", 3)
+	if len(partes) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(partes[0])
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	if prestamoActivo(cuenta.CuentaID, id, time.Now()) == nil {
+		http.Error(w, "Necesita un préstamo vigente de este libro para leerlo", http.StatusForbidden)
+		return
+	}
+
+	h := w.Header()
+	h.Set("X-Frame-Options", "SAMEORIGIN")
+	h.Set("Cache-Control", "private, no-store")
+	if partes[1] == formatoPDF && len(partes) == 2 {
+		// El visor de PDF del navegador no funciona en un marco con sandbox
+		h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'self'")
+		rw := &respuestaConEstado{ResponseWriter: w, estado: http.StatusOK}
+		registro := &RegistroDescarga{Fecha: time.Now(), UsuarioID: cuenta.CuentaID, LibroID: id, Rango: r.Header.Get("Range"), IP: r.RemoteAddr}
+		defer func() {
+			registro.Estado = rw.estado
+			listadodescarga.registrar(registro)
+		}()
+		archivo, err := archivoCatalogo(id, formatoPDF)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		servirArchivo(rw, r, archivo, "inline", registro)
+		return
+	}
+	if partes[1] != formatoEPUB || len(partes) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	archivo, err := archivoCatalogo(id, formatoEPUB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	z, f, err := abrirEPUB(archivo.Hash)
+	if err != nil {
+		http.Error(w, "El archivo no está disponible", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	opf, rutaOPF, err := leerPaqueteOPF(z)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	// Solo se entregan los archivos declarados en el manifiesto, con su tipo declarado
+	tipo, ok := recursosEPUB(opf, rutaOPF)[partes[2]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	contenido, err := z.Open(partes[2])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer contenido.Close()
+
+	h.Set("Content-Security-Policy", "default-src 'self'; script-src 'none'; object-src 'none'; frame-ancestors 'self'; sandbox allow-same-origin")
+	h.Set("Content-Type", tipo)
+	io.Copy(w, contenido)
+}
+
+// Funcion para consultar (GET) o guardar (POST) el progreso de lectura de la cuenta actual
+func progresoLectura(w http.ResponseWriter, r *http.Request) {
+	cuenta := cuentaActual(r)
+	ahora := time.Now()
+	if err := limpiarProgresos(ahora); err != nil {
+		http.Error(w, "Error al guardar el progreso de lectura", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if texto := r.URL.Query().Get("libroID"); texto != "" {
+			id, err := strconv.Atoi(texto)
+			if err != nil {
+				http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+				return
+			}
+			listadoprogreso.mu.Lock()
+			progreso := listadoprogreso.buscar(cuenta.CuentaID, id)
+			var copia ProgresoLectura
+			if progreso != nil {
+				copia = *progreso
+			}
+			listadoprogreso.mu.Unlock()
+			if progreso == nil {
+				http.Error(w, "No hay progreso de lectura de este libro", http.StatusNotFound)
+				return
+			}
+			responderJSON(w, copia)
+			return
+		}
+		// Se responde con copias para no leer un progreso mientras otro pedido lo cambia
+		progresos := []ProgresoLectura{}
+		listadoprogreso.mu.Lock()
+		for _, p := range listadoprogreso.Progresos {
+			if p.UsuarioID == cuenta.CuentaID {
+				progresos = append(progresos, *p)
+			}
+		}
+		listadoprogreso.mu.Unlock()
+		responderJSON(w, progresos)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("libroID"))
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	libro, err := libreria.BuscarID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if prestamoActivo(cuenta.CuentaID, id, ahora) == nil {
+		http.Error(w, "Necesita un préstamo vigente de este libro", http.StatusForbidden)
+		return
+	}
+	formato := r.FormValue("formato")
+	if _, err := libro.BuscarArchivo(formato); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posicion := strings.TrimSpace(r.FormValue("posicion"))
+	if posicion == "" || len(posicion) > maxPosicionLectura {
+		http.Error(w, "La posición de lectura no es válida", http.StatusBadRequest)
+		return
+	}
+	porcentaje := 0.0
+	if texto := r.FormValue("porcentaje"); texto != "" {
+		if porcentaje, err = strconv.ParseFloat(texto, 64); err != nil || porcentaje < 0 || porcentaje > 100 {
+			http.Error(w, "El porcentaje debe estar entre 0 y 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	listadoprogreso.mu.Lock()
+	defer listadoprogreso.mu.Unlock()
+	progreso := listadoprogreso.buscar(cuenta.CuentaID, id)
+	if progreso == nil {
+		progreso = &ProgresoLectura{UsuarioID: cuenta.CuentaID, LibroID: id}
+		listadoprogreso.Progresos = append(listadoprogreso.Progresos, progreso)
+	}
+	progreso.Formato = formato
+	progreso.Posicion = posicion
+	progreso.Porcentaje = porcentaje
+	progreso.Actualizado = ahora
+	if err := saveToJSON(listadoprogreso.Progresos, "progresos.json"); err != nil {
+		http.Error(w, "Error al guardar el progreso de lectura", http.StatusInternalServerError)
+		return
+	}
+	responderJSON(w, progreso)
+}
+
+// Codigo HTML para la configuracion de privacidad del progreso de lectura
+var privacyTemplate = template.Must(template.New("privacidad").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Privacidad</title>
+</head>
+<body>
+	<h1>Privacidad del progreso de lectura</h1>
+	<p>El lector guarda en qué parte de cada libro va para que pueda seguir en otro dispositivo.</p>
+	<form action="/privacidad" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<input type="radio" id="borrar" name="conservar" value="no"{{if not .Conservar}} checked{{end}}>
+		<label for="borrar">Borrar el progreso cuando termina el préstamo</label><br>
+		<input type="radio" id="conservar" name="conservar" value="si"{{if .Conservar}} checked{{end}}>
+		<label for="conservar">Conservar el progreso para un próximo préstamo</label><br>
+		<button type="submit">Guardar</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+/*
+Funcion para elegir si el progreso de lectura se conserva al terminar un prestamo.
+Al elegir borrarlo se eliminan de inmediato los progresos de prestamos ya terminados.
+*/
+func privacidad(w http.ResponseWriter, r *http.Request) {
+	cuenta := cuentaActual(r)
+	if r.Method == http.MethodGet {
+		datos := struct {
+			CSRF      string
+			Conservar bool
+		}{tokenCSRF(r), cuenta.ConservarProgreso}
+		if err := privacyTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conservar := r.FormValue("conservar")
+	if conservar != "si" && conservar != "no" {
+		http.Error(w, "Elija si desea conservar el progreso de lectura", http.StatusBadRequest)
+		return
+	}
+	muCuentas.Lock()
+	cuenta.ConservarProgreso = conservar == "si"
+	err := guardarCuentas(listadocuenta.Cuentas)
+	muCuentas.Unlock()
+	if err != nil {
+		http.Error(w, "Error al guardar la cuenta", http.StatusInternalServerError)
+		return
+	}
+	if err := limpiarProgresos(time.Now()); err != nil {
+		http.Error(w, "Error al guardar el progreso de lectura", http.StatusInternalServerError)
+		return
+	}
+	responderJSON(w, Respuesta{"Configuración de privacidad guardada"})
+}
+
+/*
+Script del lector. En un EPUB abre cada capitulo en el marco, vuelve a la parte
+recorrida y guarda la posicion un segundo despues de dejar de desplazarse; en un
+PDF el visor del navegador no informa la pagina, por lo que se guarda la que se elige.
+*/
+const scriptLector = `(function () {
+	var lector = document.getElementById("lector");
+	if (!lector) { return; }
+	var libro = lector.dataset.libro, formato = lector.dataset.formato;
+	var marco = document.getElementById("pagina");
+	var estado = document.getElementById("porcentaje");
+	var espera;
+
+	function guardar(posicion, porcentaje) {
+		var datos = new URLSearchParams();
+		datos.set("libroID", libro);
+		datos.set("formato", formato);
+		datos.set("posicion", posicion);
+		if (porcentaje !== null) {
+			datos.set("porcentaje", porcentaje.toFixed(1));
+			estado.textContent = porcentaje.toFixed(0) + " %";
+		}
+		fetch("/progreso", { method: "POST", headers: { "X-CSRF-Token": lector.dataset.csrf }, body: datos });
+	}
+
+	function iniciarEPUB(posicion) {
+		var capitulos = document.getElementById("capitulo");
+		var fraccionInicial = 0;
+		var partes = posicion.split(":");
+		var indice = parseInt(partes[0], 10);
+
+		function abrir(i, fraccion) {
+			if (i < 0 || i >= capitulos.options.length) { return; }
+			capitulos.selectedIndex = i;
+			fraccionInicial = fraccion;
+			marco.src = capitulos.value;
+		}
+		function registrar() {
+			var ventana = marco.contentWindow, doc = marco.contentDocument.documentElement;
+			var recorrido = doc.scrollHeight - ventana.innerHeight;
+			var fraccion = recorrido > 0 ? Math.min(ventana.scrollY / recorrido, 1) : 1;
+			var i = capitulos.selectedIndex;
+			guardar(i + ":" + fraccion.toFixed(4), (i + fraccion) / capitulos.options.length * 100);
+		}
+
+		marco.addEventListener("load", function () {
+			var ventana = marco.contentWindow, doc = marco.contentDocument.documentElement;
+			// Los enlaces del libro pueden abrir otro capitulo dentro del marco
+			for (var i = 0; i < capitulos.options.length; i++) {
+				if (capitulos.options[i].value === ventana.location.pathname) { capitulos.selectedIndex = i; }
+			}
+			ventana.scrollTo(0, fraccionInicial * (doc.scrollHeight - ventana.innerHeight));
+			fraccionInicial = 0;
+			ventana.addEventListener("scroll", function () {
+				clearTimeout(espera);
+				espera = setTimeout(registrar, 1000);
+			});
+			registrar();
+		});
+		capitulos.addEventListener("change", function () { abrir(capitulos.selectedIndex, 0); });
+		document.getElementById("anterior").addEventListener("click", function () { abrir(capitulos.selectedIndex - 1, 0); });
+		document.getElementById("siguiente").addEventListener("click", function () { abrir(capitulos.selectedIndex + 1, 0); });
+		abrir(isNaN(indice) ? 0 : indice, parseFloat(partes[1]) || 0);
+	}
+
+	function iniciarPDF(posicion) {
+		var numero = document.getElementById("numeroPagina");
+		numero.value = parseInt(posicion, 10) || 1;
+		marco.src = lector.dataset.documento + "#page=" + numero.value;
+		document.getElementById("irPagina").addEventListener("click", function () {
+			marco.src = lector.dataset.documento + "#page=" + numero.value;
+			guardar(String(numero.value), null);
+		});
+	}
+
+	fetch("/progreso?libroID=" + libro)
+		.then(function (r) { return r.ok ? r.json() : null; })
+		.then(function (p) {
+			var posicion = p && p.formato === formato ? p.posicion : "";
+			if (formato === "epub") { iniciarEPUB(posicion); } else { iniciarPDF(posicion); }
+		});
+})();
+`
+
+func scriptLectorJS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(scriptLector))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Tres cuentas que leen el libro 1: Ana con el prestamo vigente, Luis que conserva su
+progreso y Sara sin prestamo ni progreso conservado. Hay tambien un progreso de una
+cuenta eliminada.
+*/
+func progresosPrueba(t *testing.T) (ana, luis, sara *Cuenta) {
+	t.Helper()
+	enDirectorioTemporal(t)
+	anterior, anterioresCuentas := libreria, listadocuenta.Cuentas
+	anterioresPrestamos, anterioresProgresos := listadoprestamo.Prestamos, listadoprogreso.Progresos
+	t.Cleanup(func() {
+		libreria, listadocuenta.Cuentas = anterior, anterioresCuentas
+		listadoprestamo.Prestamos, listadoprogreso.Progresos = anterioresPrestamos, anterioresProgresos
+	})
+
+	ahora := time.Now()
+	ana = &Cuenta{CuentaID: 1, Nombre: "Ana", Roles: []Rol{RolUsuario}}
+	luis = &Cuenta{CuentaID: 2, Nombre: "Luis", Roles: []Rol{RolUsuario}, ConservarProgreso: true}
+	sara = &Cuenta{CuentaID: 3, Nombre: "Sara", Roles: []Rol{RolUsuario}}
+	listadocuenta.Cuentas = []*Cuenta{ana, luis, sara}
+	libreria = &Libreria{Libros: []*Libro{{LibroID: 1, Titulo: "Meditaciones", Archivos: []ArchivoLibro{{Hash: "abc", Formato: formatoEPUB}}}}}
+	listadoprestamo.Prestamos = []*Prestamo{
+		{PrestamoID: 1, LibroID: 1, UsuarioID: 1, Digital: true, FechaReserva: ahora.Add(-time.Hour), FechaDevolucion: ahora.Add(time.Hour)},
+		{PrestamoID: 2, LibroID: 1, UsuarioID: 2, Digital: true, FechaReserva: ahora.Add(-48 * time.Hour), FechaDevolucion: ahora.Add(-24 * time.Hour)},
+		{PrestamoID: 3, LibroID: 1, UsuarioID: 3, Digital: true, FechaReserva: ahora.Add(-48 * time.Hour), FechaDevolucion: ahora.Add(-24 * time.Hour)},
+	}
+	listadoprogreso.Progresos = []*ProgresoLectura{
+		{UsuarioID: 1, LibroID: 1, Formato: formatoEPUB, Posicion: "3:0.5"},
+		{UsuarioID: 2, LibroID: 1, Formato: formatoEPUB, Posicion: "7:0.1"},
+		{UsuarioID: 3, LibroID: 1, Formato: formatoEPUB, Posicion: "2:0.9"},
+		{UsuarioID: 9, LibroID: 1, Formato: formatoEPUB, Posicion: "1:0"},
+	}
+	return ana, luis, sara
+}
+
+func usuariosProgreso(progresos []*ProgresoLectura) []int {
+	ids := []int{}
+	for _, p := range progresos {
+		ids = append(ids, p.UsuarioID)
+	}
+	return ids
+}
+
+func TestLimpiarProgresos(t *testing.T) {
+	progresosPrueba(t)
+	anteriores := listadoprogreso.Progresos
+
+	if err := limpiarProgresos(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := usuariosProgreso(listadoprogreso.Progresos); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("quedan los progresos de %v", got)
+	}
+	// La lista anterior queda intacta para quien la estuviera recorriendo
+	if len(anteriores) != 4 || anteriores[2].UsuarioID != 3 {
+		t.Errorf("se modificó la lista anterior: %v", usuariosProgreso(anteriores))
+	}
+	var guardados []*ProgresoLectura
+	if err := loadFromJSON("progresos.json", &guardados); err != nil || len(guardados) != 2 {
+		t.Errorf("progresos.json tiene %d progresos: %v", len(guardados), err)
+	}
+
+	// Al vencer el prestamo de Ana se borra tambien el suyo
+	if err := limpiarProgresos(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := usuariosProgreso(listadoprogreso.Progresos); len(got) != 1 || got[0] != 2 {
+		t.Errorf("tras vencer el préstamo quedan %v", got)
+	}
+}
+
+func TestProgresoLecturaPrivacidad(t *testing.T) {
+	ana, luis, _ := progresosPrueba(t)
+	pedir := func(c *Cuenta, manejador http.HandlerFunc, metodo string, datos url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(metodo, "/?"+datos.Encode(), nil)
+		if metodo == http.MethodPost {
+			r = httptest.NewRequest(metodo, "/", strings.NewReader(datos.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		sesion := httptest.NewRecorder()
+		if err := iniciarSesion(sesion, r, c); err != nil {
+			t.Fatal(err)
+		}
+		r.AddCookie(sesion.Result().Cookies()[0])
+		w := httptest.NewRecorder()
+		manejador(w, r)
+		return w
+	}
+
+	guardar := url.Values{"libroID": {"1"}, "formato": {"epub"}, "posicion": {"4:0.25"}, "porcentaje": {"30"}}
+	if w := pedir(ana, progresoLectura, http.MethodPost, guardar); w.Code != http.StatusOK {
+		t.Fatalf("guardar = %d: %s", w.Code, w.Body)
+	}
+	// Sin prestamo vigente no se guarda progreso
+	if w := pedir(luis, progresoLectura, http.MethodPost, guardar); w.Code != http.StatusForbidden {
+		t.Errorf("guardar sin préstamo = %d", w.Code)
+	}
+
+	// Cada cuenta solo ve sus progresos
+	var progresos []ProgresoLectura
+	w := pedir(ana, progresoLectura, http.MethodGet, url.Values{})
+	if err := json.Unmarshal(w.Body.Bytes(), &progresos); err != nil || len(progresos) != 1 || progresos[0].Posicion != "4:0.25" || progresos[0].Porcentaje != 30 {
+		t.Errorf("progresos de Ana %s", w.Body)
+	}
+	if w := pedir(luis, progresoLectura, http.MethodGet, url.Values{"libroID": {"1"}}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"7:0.1"`) {
+		t.Errorf("progreso conservado de Luis = %d %s", w.Code, w.Body)
+	}
+
+	// Al dejar de conservarlo se borra de inmediato el del prestamo terminado
+	if w := pedir(luis, privacidad, http.MethodPost, url.Values{"conservar": {"no"}}); w.Code != http.StatusOK {
+		t.Fatalf("privacidad = %d", w.Code)
+	}
+	if w := pedir(luis, progresoLectura, http.MethodGet, url.Values{"libroID": {"1"}}); w.Code != http.StatusNotFound {
+		t.Errorf("progreso de Luis tras la privacidad = %d", w.Code)
+	}
+	if luis.ConservarProgreso {
+		t.Error("la cuenta sigue conservando el progreso")
+	}
+	if got := usuariosProgreso(listadoprogreso.Progresos); len(got) != 1 || got[0] != 1 {
+		t.Errorf("quedan los progresos de %v", got)
+	}
+}
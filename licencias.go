@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duracion de un prestamo digital
+const plazoPrestamoDigital = 14 * 24 * time.Hour
+
+/*
+Clave para firmar los enlaces de lectura. Se toma de CLAVE_ENLACES; si no esta
+definida se genera una al iniciar, por lo que los enlaces dejan de servir al
+reiniciar el servidor (basta con pedirlos de nuevo en /prestamo-digital).
+*/
+var claveEnlaces = cargarClaveEnlaces()
+
+func cargarClaveEnlaces() []byte {
+	if clave := os.Getenv("CLAVE_ENLACES"); clave != "" {
+		return []byte(clave)
+	}
+	clave := make([]byte, 32)
+	if _, err := rand.Read(clave); err != nil {
+		panic("no se pudo generar la clave de los enlaces: " + err.Error())
+	}
+	return clave
+}
+
+// Un libro es electronico si tiene licencias digitales y al menos un archivo
+func (l *Libro) EsElectronico() bool {
+	return l.Licencias > 0 && len(l.Archivos) > 0
+}
+
+/*
+Licencias del libro que no estan en uso. Un prestamo digital ocupa una licencia
+solo hasta su FechaDevolucion, por lo que al vencer la licencia vuelve sola al
+total disponible sin registrar ninguna devolucion.
+*/
+func (l *Libro) LicenciasDisponibles(ahora time.Time) int {
+	muCirculacion.RLock()
+	defer muCirculacion.RUnlock()
+	return l.licenciasLibres(ahora)
+}
+
+// Igual que LicenciasDisponibles, para llamarla con muCirculacion ya tomado
+func (l *Libro) licenciasLibres(ahora time.Time) int {
+	enUso := 0
+	for _, p := range listadoprestamo.Prestamos {
+		if p.Digital && p.LibroID == l.LibroID && p.Vigente(ahora) {
+			enUso++
+		}
+	}
+	return max(l.Licencias-enUso, 0)
+}
+
+// Indica si el prestamo esta en curso
+func (p *Prestamo) Vigente(ahora time.Time) bool {
+	return !ahora.Before(p.FechaReserva) && ahora.Before(p.FechaDevolucion)
+}
+
+func firmarEnlace(prestamoID int, formato string, expira int64) string {
+	mac := hmac.New(sha256.New, claveEnlaces)
+	fmt.Fprintf(mac, "%d|%s|%d", prestamoID, formato, expira)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Enlace firmado para leer el archivo del prestamo; deja de servir en la FechaDevolucion
+func enlaceLectura(p *Prestamo, formato string) string {
+	expira := p.FechaDevolucion.Unix()
+	v := url.Values{}
+	v.Set("prestamo", strconv.Itoa(p.PrestamoID))
+	v.Set("formato", formato)
+	v.Set("expira", strconv.FormatInt(expira, 10))
+	v.Set("firma", firmarEnlace(p.PrestamoID, formato, expira))
+	return urlBase() + "/leer?" + v.Encode()
+}
+
+// Comprueba la firma y la vigencia de un enlace de lectura y devuelve su prestamo
+func validarEnlace(v url.Values, ahora time.Time) (*Prestamo, error) {
+	id, err := strconv.Atoi(v.Get("prestamo"))
+	if err != nil {
+		return nil, errors.New("enlace inválido")
+	}
+	expira, err := strconv.ParseInt(v.Get("expira"), 10, 64)
+	if err != nil {
+		return nil, errors.New("enlace inválido")
+	}
+	esperada := firmarEnlace(id, v.Get("formato"), expira)
+	if !hmac.Equal([]byte(esperada), []byte(v.Get("firma"))) {
+		return nil, errors.New("la firma del enlace no es válida")
+	}
+	if !ahora.Before(time.Unix(expira, 0)) {
+		return nil, errors.New("el enlace venció")
+	}
+	muCirculacion.RLock()
+	defer muCirculacion.RUnlock()
+	for _, p := range listadoprestamo.Prestamos {
+		if p.PrestamoID == id && p.Digital {
+			if !p.Vigente(ahora) {
+				return nil, errors.New("el préstamo digital terminó")
+			}
+			return p, nil
+		}
+	}
+	return nil, errors.New("préstamo no encontrado")
+}
+
+// Prestamo digital con sus enlaces de lectura, para las respuestas
+type PrestamoDigital struct {
+	*Prestamo
+	Titulo  string            `json:"titulo"`
+	Enlaces map[string]string `json:"enlaces"`
+	Lector  string            `json:"lector"` // pagina para leerlo en el navegador
+}
+
+func prestamoDigital(p *Prestamo) PrestamoDigital {
+	pd := PrestamoDigital{Prestamo: p, Enlaces: map[string]string{}, Lector: urlBase() + "/lector?libroID=" + strconv.Itoa(p.LibroID)}
+	if libro, err := libreria.BuscarID(p.LibroID); err == nil {
+		pd.Titulo = libro.Titulo
+		for _, a := range libro.Archivos {
+			pd.Enlaces[a.Formato] = enlaceLectura(p, a.Formato)
+		}
+	}
+	return pd
+}
+
+// Numero de licencias del formulario del libro; vacio es 0 (sin prestamo digital)
+func licenciasFormulario(r *http.Request) (int, error) {
+	texto := strings.TrimSpace(r.FormValue("licencias"))
+	if texto == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(texto)
+	if err != nil || n < 0 {
+		return 0, errors.New("las licencias digitales deben ser un número entero positivo")
+	}
+	return n, nil
+}
+
+/*
+Presta el libro a la cuenta con una de sus licencias libres y guarda los prestamos.
+Si no se puede devuelve el error con el codigo HTTP que corresponde. La comprobacion
+y el nuevo prestamo se hacen con muCirculacion tomado para no prestar de mas.
+*/
+func tomarLicencia(libro *Libro, cuenta *Cuenta, ahora time.Time) (*Prestamo, int, error) {
+	muCirculacion.Lock()
+	defer muCirculacion.Unlock()
+	if !libro.EsElectronico() {
+		return nil, http.StatusConflict, errors.New("el libro no tiene préstamo digital")
+	}
+	for _, p := range listadoprestamo.Prestamos {
+		if p.Digital && p.LibroID == libro.LibroID && p.UsuarioID == cuenta.CuentaID && p.Vigente(ahora) {
+			return nil, http.StatusConflict, errors.New("ya tiene un préstamo digital vigente de este libro")
+		}
+	}
+	if libro.licenciasLibres(ahora) == 0 {
+		return nil, http.StatusConflict, errors.New("no hay licencias digitales disponibles; intente cuando venza un préstamo")
+	}
+
+	prestamo, err := nuevoPrestamo(listadoprestamo.siguienteID(), libro.LibroID, cuenta.CuentaID, ahora, ahora.Add(plazoPrestamoDigital))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	prestamo.Digital = true
+	listadoprestamo.Prestamos = append(listadoprestamo.Prestamos, prestamo)
+	prefijos.Invalidar()
+	if err := saveToJSON(listadoprestamo.Prestamos, "prestamos.json"); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("error al guardar los préstamos")
+	}
+	return prestamo, 0, nil
+}
+
+/*
+Funcion para los prestamos digitales de la cuenta actual. GET lista los prestamos
+vigentes con sus enlaces; POST con libroID toma una licencia libre del libro.
+*/
+func prestarDigital(w http.ResponseWriter, r *http.Request) {
+	cuenta := cuentaActual(r)
+	ahora := time.Now()
+
+	if r.Method == http.MethodGet {
+		prestamos := []PrestamoDigital{}
+		muCirculacion.RLock()
+		for _, p := range listadoprestamo.Prestamos {
+			if p.Digital && p.UsuarioID == cuenta.CuentaID && p.Vigente(ahora) {
+				prestamos = append(prestamos, prestamoDigital(p))
+			}
+		}
+		muCirculacion.RUnlock()
+		responderJSON(w, prestamos)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	libroID, err := strconv.Atoi(r.FormValue("libroID"))
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	libro, err := libreria.BuscarID(libroID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	prestamo, estado, err := tomarLicencia(libro, cuenta, ahora)
+	if err != nil {
+		http.Error(w, err.Error(), estado)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(prestamoDigital(prestamo))
+}
+
+/*
+Funcion para leer el archivo de un prestamo digital con un enlace firmado
+(/leer?prestamo=&formato=&expira=&firma=). El enlace no necesita sesion, para
+abrirlo desde un lector de libros, y deja de servir cuando vence el prestamo.
+*/
+func leerPrestamo(w http.ResponseWriter, r *http.Request) {
+	registro := &RegistroDescarga{Fecha: time.Now(), Rango: r.Header.Get("Range"), IP: r.RemoteAddr}
+	rw := &respuestaConEstado{ResponseWriter: w, estado: http.StatusOK}
+	defer func() {
+		registro.Estado = rw.estado
+		listadodescarga.registrar(registro)
+	}()
+
+	prestamo, err := validarEnlace(r.URL.Query(), registro.Fecha)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	}
+	registro.UsuarioID = prestamo.UsuarioID
+	registro.LibroID = prestamo.LibroID
+	archivo, err := archivoCatalogo(prestamo.LibroID, r.URL.Query().Get("formato"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	servirArchivo(rw, r, archivo, "attachment", registro)
+}
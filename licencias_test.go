@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTomarLicenciaConcurrente(t *testing.T) {
+	enDirectorioTemporal(t)
+	anterior := listadoprestamo.Prestamos
+	t.Cleanup(func() { listadoprestamo.Prestamos = anterior })
+	listadoprestamo.Prestamos = nil
+
+	libro := &Libro{LibroID: 4, Titulo: "Meditaciones", Licencias: 2, Archivos: []ArchivoLibro{{Hash: "abc", Formato: "epub"}}}
+	ahora := time.Now()
+
+	// Muchas cuentas piden a la vez las dos licencias del libro
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	prestados := 0
+	for id := 1; id <= 20; id++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := tomarLicencia(libro, &Cuenta{CuentaID: id}, ahora); err == nil {
+				mu.Lock()
+				prestados++
+				mu.Unlock()
+			}
+		}()
+	}
+	// Mientras tanto se consultan las licencias libres y los prestamos vigentes
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			libro.LicenciasDisponibles(ahora)
+			prestamoActivo(1, libro.LibroID, ahora)
+		}()
+	}
+	wg.Wait()
+
+	if prestados != 2 || len(listadoprestamo.Prestamos) != 2 {
+		t.Errorf("se prestaron %d licencias (%d préstamos), se esperaban 2", prestados, len(listadoprestamo.Prestamos))
+	}
+	if libro.LicenciasDisponibles(ahora) != 0 {
+		t.Errorf("quedan %d licencias disponibles", libro.LicenciasDisponibles(ahora))
+	}
+}
+
+func TestValidarEnlace(t *testing.T) {
+	anterior := listadoprestamo.Prestamos
+	t.Cleanup(func() { listadoprestamo.Prestamos = anterior })
+	ahora := time.Now()
+	vigente := &Prestamo{PrestamoID: 1, LibroID: 4, Digital: true, FechaReserva: ahora.Add(-time.Hour), FechaDevolucion: ahora.Add(time.Hour)}
+	fisico := &Prestamo{PrestamoID: 2, LibroID: 4, FechaReserva: ahora.Add(-time.Hour), FechaDevolucion: ahora.Add(time.Hour)}
+	listadoprestamo.Prestamos = []*Prestamo{vigente, fisico}
+
+	enlace := func(p *Prestamo) url.Values {
+		u, err := url.Parse(enlaceLectura(p, "epub"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Query()
+	}
+	if p, err := validarEnlace(enlace(vigente), ahora); err != nil || p != vigente {
+		t.Fatalf("enlace válido: %v", err)
+	}
+
+	otroFormato := enlace(vigente)
+	otroFormato.Set("formato", "pdf")
+	casos := map[string]struct {
+		v     url.Values
+		ahora time.Time
+		error string
+	}{
+		"otro formato":     {otroFormato, ahora, "firma"},
+		"vencido":          {enlace(vigente), ahora.Add(2 * time.Hour), "venció"},
+		"préstamo físico":  {enlace(fisico), ahora, "no encontrado"},
+		"sin ID":           {url.Values{"expira": {"1"}}, ahora, "inválido"},
+		"préstamo borrado": {enlace(&Prestamo{PrestamoID: 3, FechaDevolucion: ahora.Add(time.Hour)}), ahora, "no encontrado"},
+	}
+	for nombre, caso := range casos {
+		if _, err := validarEnlace(caso.v, caso.ahora); err == nil || !strings.Contains(err.Error(), caso.error) {
+			t.Errorf("%s: %v, se esperaba %q", nombre, err, caso.error)
+		}
+	}
+
+	// Un prestamo devuelto antes de tiempo deja sin valor sus enlaces
+	vigente.FechaDevolucion = ahora.Add(-time.Minute)
+	if _, err := validarEnlace(enlace(&Prestamo{PrestamoID: 1, FechaDevolucion: ahora.Add(time.Hour)}), ahora); err == nil {
+		t.Error("el enlace de un préstamo terminado sigue sirviendo")
+	}
+}
@@ -0,0 +1,1103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Separadores del formato MARC21 de intercambio (ISO 2709) y tamano maximo del archivo a importar
+const (
+	delimitadorMARC = 0x1F
+	finCampoMARC    = 0x1E
+	finRegistroMARC = 0x1D
+	maxTamanoMARC   = 20 << 20
+	espacioMARCXML  = "http://www.loc.gov/MARC21/slim"
+)
+
+/*
+Cabecera de los registros exportados: registro nuevo (n) de material textual (a)
+monografico (m), en UTF-8 (a) y sin puntuacion ISBD (c). Las longitudes se
+completan al escribir el registro.
+*/
+const cabeceraMARC = "00000nam a2200000 c 4500"
+
+var errFormatoMARC = errors.New("el archivo debe estar en MARC21 (ISO 2709) o MARCXML")
+
+type subcampoMARC struct {
+	Codigo string `xml:"code,attr"`
+	Valor  string `xml:",chardata"`
+}
+
+// Campo de un registro MARC; los campos de control (001 a 009) solo tienen valor, sin indicadores
+type campoMARC struct {
+	Etiqueta    string
+	Indicadores string // dos caracteres, un espacio si el indicador no se usa
+	Valor       string
+	Subcampos   []subcampoMARC
+}
+
+func (c campoMARC) control() bool {
+	return c.Etiqueta < "010"
+}
+
+// Primer valor del subcampo indicado
+func (c campoMARC) subcampo(codigo string) string {
+	for _, s := range c.Subcampos {
+		if s.Codigo == codigo {
+			return s.Valor
+		}
+	}
+	return ""
+}
+
+// Valores del subcampo indicado, en el orden del campo
+func (c campoMARC) subcampos(codigo string) []string {
+	var valores []string
+	for _, s := range c.Subcampos {
+		if s.Codigo == codigo {
+			valores = append(valores, s.Valor)
+		}
+	}
+	return valores
+}
+
+type registroMARC struct {
+	Cabecera string
+	Campos   []campoMARC
+}
+
+func (r *registroMARC) agregarControl(etiqueta, valor string) {
+	r.Campos = append(r.Campos, campoMARC{Etiqueta: etiqueta, Valor: valor})
+}
+
+// Agrega un campo con los pares codigo, valor de sus subcampos; los valores vacios se omiten
+func (r *registroMARC) agregar(etiqueta, indicadores string, pares ...string) {
+	campo := campoMARC{Etiqueta: etiqueta, Indicadores: indicadores}
+	for i := 0; i+1 < len(pares); i += 2 {
+		if valor := strings.TrimSpace(pares[i+1]); valor != "" {
+			campo.Subcampos = append(campo.Subcampos, subcampoMARC{pares[i], valor})
+		}
+	}
+	if len(campo.Subcampos) > 0 {
+		r.Campos = append(r.Campos, campo)
+	}
+}
+
+// Lee los registros de un archivo MARC21 en formato de intercambio ISO 2709
+func leerMARC21(datos []byte) ([]registroMARC, error) {
+	var registros []registroMARC
+	for _, crudo := range bytes.Split(datos, []byte{finRegistroMARC}) {
+		// Algunos sistemas separan los registros con saltos de linea
+		crudo = bytes.TrimLeft(crudo, "\r\n ")
+		if len(crudo) == 0 {
+			continue
+		}
+		reg, err := leerRegistroMARC21(crudo)
+		if err != nil {
+			return nil, fmt.Errorf("registro %d: %w", len(registros)+1, err)
+		}
+		registros = append(registros, reg)
+	}
+	return registros, nil
+}
+
+/*
+Lee un registro ISO 2709: la cabecera de 24 caracteres, el directorio con una
+entrada de 12 caracteres por campo (etiqueta, longitud y posicion) y los datos.
+La posicion 9 de la cabecera indica la codificacion; solo se aceptan registros
+MARC-8 si no tienen caracteres especiales, porque son iguales en ASCII.
+*/
+func leerRegistroMARC21(crudo []byte) (registroMARC, error) {
+	if len(crudo) < 25 {
+		return registroMARC{}, errors.New("el registro es demasiado corto")
+	}
+	reg := registroMARC{Cabecera: string(crudo[:24])}
+	if reg.Cabecera[9] == 'a' {
+		if !utf8.Valid(crudo) {
+			return registroMARC{}, errors.New("el registro indica UTF-8 pero tiene caracteres no válidos")
+		}
+	} else if bytes.ContainsFunc(crudo, func(r rune) bool { return r >= utf8.RuneSelf }) {
+		return registroMARC{}, errors.New("el registro usa la codificación MARC-8; conviértalo a MARC21 en UTF-8")
+	}
+	base, ok := numeroMARC(reg.Cabecera[12:17])
+	if !ok || base < 25 || base > len(crudo) || (base-25)%12 != 0 || crudo[base-1] != finCampoMARC {
+		return registroMARC{}, errors.New("la dirección base de los datos no es válida")
+	}
+
+	directorio := crudo[24 : base-1]
+	for i := 0; i < len(directorio); i += 12 {
+		entrada := string(directorio[i : i+12])
+		etiqueta := entrada[:3]
+		largo, okLargo := numeroMARC(entrada[3:7])
+		inicio, okInicio := numeroMARC(entrada[7:12])
+		if !okLargo || !okInicio || base+inicio+largo > len(crudo) {
+			return registroMARC{}, fmt.Errorf("la entrada del directorio del campo %s no es válida", etiqueta)
+		}
+		dato := bytes.TrimSuffix(crudo[base+inicio:base+inicio+largo], []byte{finCampoMARC})
+		campo := campoMARC{Etiqueta: etiqueta}
+		if campo.control() {
+			campo.Valor = string(dato)
+		} else {
+			if len(dato) < 2 {
+				return registroMARC{}, fmt.Errorf("el campo %s no tiene indicadores", etiqueta)
+			}
+			campo.Indicadores = string(dato[:2])
+			for _, parte := range bytes.Split(dato[2:], []byte{delimitadorMARC})[1:] {
+				if len(parte) > 0 {
+					campo.Subcampos = append(campo.Subcampos, subcampoMARC{string(parte[:1]), string(parte[1:])})
+				}
+			}
+		}
+		reg.Campos = append(reg.Campos, campo)
+	}
+	return reg, nil
+}
+
+// Numero de ancho fijo de la cabecera o del directorio; a diferencia de strconv.Atoi no admite signos
+func numeroMARC(texto string) (int, bool) {
+	n := 0
+	for _, c := range []byte(texto) {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, texto != ""
+}
+
+// Escribe los registros en formato ISO 2709, completando las longitudes de la cabecera
+func escribirMARC21(w io.Writer, registros []registroMARC) error {
+	for _, reg := range registros {
+		var directorio, datos bytes.Buffer
+		for _, c := range reg.Campos {
+			inicio := datos.Len()
+			if c.control() {
+				datos.WriteString(c.Valor)
+			} else {
+				datos.WriteString(c.Indicadores)
+				for _, s := range c.Subcampos {
+					datos.WriteByte(delimitadorMARC)
+					datos.WriteString(s.Codigo)
+					datos.WriteString(s.Valor)
+				}
+			}
+			datos.WriteByte(finCampoMARC)
+			if datos.Len()-inicio > 9999 {
+				return fmt.Errorf("el campo %s supera los 9999 bytes que admite MARC21", c.Etiqueta)
+			}
+			fmt.Fprintf(&directorio, "%s%04d%05d", c.Etiqueta, datos.Len()-inicio, inicio)
+		}
+		directorio.WriteByte(finCampoMARC)
+
+		base := 24 + directorio.Len()
+		largo := base + datos.Len() + 1
+		if largo > 99999 {
+			return errors.New("el registro supera los 99999 bytes que admite MARC21")
+		}
+		cabecera := []byte(cabeceraMARC)
+		if len(reg.Cabecera) == 24 {
+			cabecera = []byte(reg.Cabecera)
+		}
+		copy(cabecera[0:5], fmt.Sprintf("%05d", largo))
+		copy(cabecera[12:17], fmt.Sprintf("%05d", base))
+		cabecera[9] = 'a'
+		copy(cabecera[10:12], "22")
+		copy(cabecera[20:24], "4500")
+
+		for _, parte := range [][]byte{cabecera, directorio.Bytes(), datos.Bytes(), {finRegistroMARC}} {
+			if _, err := w.Write(parte); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Registros en MARCXML (esquema MARC21 slim)
+type controlMARCXML struct {
+	Etiqueta string `xml:"tag,attr"`
+	Valor    string `xml:",chardata"`
+}
+
+type datoMARCXML struct {
+	Etiqueta  string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subcampos []subcampoMARC `xml:"subfield"`
+}
+
+type registroMARCXML struct {
+	Cabecera string           `xml:"leader"`
+	Control  []controlMARCXML `xml:"controlfield"`
+	Datos    []datoMARCXML    `xml:"datafield"`
+}
+
+type coleccionMARCXML struct {
+	XMLName   xml.Name          `xml:"collection"`
+	Espacio   string            `xml:"xmlns,attr"`
+	Registros []registroMARCXML `xml:"record"`
+}
+
+// Indicador de un solo caracter; vacio se toma como no definido
+func indicadorMARCXML(ind string) string {
+	if ind == "" {
+		return " "
+	}
+	return ind[:1]
+}
+
+/*
+Lee los registros de un MARCXML, tanto una coleccion como un registro suelto. Los
+elementos se buscan por nombre local para aceptar tambien archivos sin el espacio
+de nombres de MARC21 slim o con prefijo (marc:record).
+*/
+func leerMARCXML(r io.Reader) ([]registroMARC, error) {
+	var registros []registroMARC
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("el MARCXML no es válido: %w", err)
+		}
+		inicio, ok := tok.(xml.StartElement)
+		if !ok || inicio.Name.Local != "record" {
+			continue
+		}
+		var x registroMARCXML
+		if err := d.DecodeElement(&x, &inicio); err != nil {
+			return nil, fmt.Errorf("registro %d: %w", len(registros)+1, err)
+		}
+		reg := registroMARC{Cabecera: x.Cabecera}
+		for _, c := range x.Control {
+			reg.agregarControl(c.Etiqueta, c.Valor)
+		}
+		for _, c := range x.Datos {
+			reg.Campos = append(reg.Campos, campoMARC{Etiqueta: c.Etiqueta,
+				Indicadores: indicadorMARCXML(c.Ind1) + indicadorMARCXML(c.Ind2), Subcampos: c.Subcampos})
+		}
+		registros = append(registros, reg)
+	}
+	return registros, nil
+}
+
+func escribirMARCXML(w io.Writer, registros []registroMARC) error {
+	coleccion := coleccionMARCXML{Espacio: espacioMARCXML, Registros: []registroMARCXML{}}
+	for _, reg := range registros {
+		x := registroMARCXML{Cabecera: reg.Cabecera}
+		for _, c := range reg.Campos {
+			if c.control() {
+				x.Control = append(x.Control, controlMARCXML{c.Etiqueta, c.Valor})
+			} else {
+				x.Datos = append(x.Datos, datoMARCXML{c.Etiqueta, c.Indicadores[:1], c.Indicadores[1:], c.Subcampos})
+			}
+		}
+		coleccion.Registros = append(coleccion.Registros, x)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(coleccion); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Reconoce el formato por el contenido: MARCXML empieza con "<" y MARC21 con la longitud del registro
+func leerArchivoMARC(datos []byte) ([]registroMARC, string, error) {
+	inicio := bytes.TrimLeft(bytes.TrimPrefix(datos, []byte("\xef\xbb\xbf")), " \t\r\n")
+	var registros []registroMARC
+	var formato string
+	var err error
+	switch {
+	case bytes.HasPrefix(inicio, []byte("<")):
+		formato = "MARCXML"
+		registros, err = leerMARCXML(bytes.NewReader(inicio))
+	case len(inicio) >= 24 && esNumero(string(inicio[:5])):
+		formato = "MARC21"
+		registros, err = leerMARC21(inicio)
+	default:
+		return nil, "", errFormatoMARC
+	}
+	if err != nil {
+		return nil, formato, err
+	}
+	if len(registros) == 0 {
+		return nil, formato, errors.New("el archivo no contiene registros MARC")
+	}
+	return registros, formato, nil
+}
+
+// Codigos de idioma de MARC21 (ISO 639-2/B) de los idiomas mas comunes; al exportar se usa el primero de cada nombre
+var idiomasMARC = []struct{ codigo, nombre string }{
+	{"spa", "español"}, {"eng", "inglés"}, {"lat", "latín"}, {"grc", "griego"}, {"gre", "griego"},
+	{"fre", "francés"}, {"ger", "alemán"}, {"ita", "italiano"}, {"por", "portugués"}, {"cat", "catalán"},
+}
+
+func idiomaMARC(codigo string) string {
+	codigo = strings.ToLower(strings.TrimSpace(codigo))
+	for _, i := range idiomasMARC {
+		if i.codigo == codigo {
+			return i.nombre
+		}
+	}
+	return codigo
+}
+
+// Codigo MARC del idioma; los idiomas sin codigo conocido se exportan solo si ya son un codigo de tres letras
+func codigoIdiomaMARC(nombre string) string {
+	for _, i := range idiomasMARC {
+		if normalizarTexto(i.nombre) == normalizarTexto(strings.TrimSpace(nombre)) {
+			return i.codigo
+		}
+	}
+	if len(nombre) == 3 && strings.Trim(nombre, "abcdefghijklmnopqrstuvwxyz") == "" {
+		return nombre
+	}
+	return ""
+}
+
+// Roles de los campos 100/700 segun el codigo de relacion ($4) o el termino ($e)
+var rolesMARC = map[string]string{
+	"aut": "aut", "autor": "aut", "author": "aut",
+	"trl": "trl", "traductor": "trl", "traduccion": "trl", "translator": "trl", "trad": "trl", "tr": "trl",
+	"edt": "edt", "editor": "edt", "ed": "edt", "edicion": "edt",
+}
+
+var nombresRolMARC = map[string]RolAutor{"aut": RolAutorPrincipal, "trl": RolTraductor, "edt": RolEditor}
+
+// Rol de un autor del registro; sin $4 ni $e es autor. Devuelve false con el termino si no corresponde a ningun rol
+func rolMARC(c campoMARC) (string, bool) {
+	terminos := append(c.subcampos("4"), c.subcampos("e")...)
+	if len(terminos) == 0 {
+		return "aut", true
+	}
+	for _, t := range terminos {
+		// $4 puede traer la URI del vocabulario: http://id.loc.gov/vocabulary/relators/trl
+		t = t[strings.LastIndex(t, "/")+1:]
+		if rol, ok := rolesMARC[strings.Trim(normalizarTexto(t), " .,")]; ok {
+			return rol, true
+		}
+	}
+	return strings.Join(terminos, ", "), false
+}
+
+/*
+Quita la puntuacion ISBD que cierra los subcampos ("Meditaciones :", "Madrid,").
+El punto final se conserva en las iniciales ("Lewis, C. S.").
+*/
+func limpiarMARC(texto string) string {
+	texto = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(texto), " /:;,="))
+	if palabras := strings.Fields(texto); strings.HasSuffix(texto, ".") && utf8.RuneCountInString(palabras[len(palabras)-1]) > 2 {
+		texto = strings.TrimSuffix(texto, ".")
+	}
+	return texto
+}
+
+// Nombre de un campo 100/700 en orden directo: "Aurelio, Marco" con el primer indicador 1 (apellido) es "Marco Aurelio"
+func nombreMARC(c campoMARC) string {
+	nombre := limpiarMARC(c.subcampo("a"))
+	if strings.HasSuffix(c.Etiqueta, "00") && strings.HasPrefix(c.Indicadores, "1") {
+		if apellido, resto, ok := strings.Cut(nombre, ", "); ok {
+			nombre = resto + " " + apellido
+		}
+	}
+	return nombre
+}
+
+// Fecha de publicacion de 260/264 $c, que suele traer marcas como "c2019.", "[2019]" o "ca. 1990"
+func fechaMARC(texto string) (string, bool) {
+	texto = strings.TrimRight(strings.TrimLeft(limpiarMARC(texto), "[©cp"), "]?")
+	if fecha, err := ParseFechaParcial(texto); err == nil {
+		return fecha.ISO(), true
+	}
+	for i := 0; i+4 <= len(texto); i++ {
+		anterior, siguiente := i == 0 || texto[i-1] < '0' || texto[i-1] > '9', i+4 == len(texto) || texto[i+4] < '0' || texto[i+4] > '9'
+		if esNumero(texto[i:i+4]) && anterior && siguiente {
+			return texto[i : i+4], true
+		}
+	}
+	return "", false
+}
+
+// Campo del registro y el dato del libro que llena; sin destino el campo no se importa
+type CampoImportado struct {
+	Etiqueta string `json:"etiqueta"`
+	Destino  string `json:"destino,omitempty"`
+}
+
+/*
+Convierte un registro bibliografico MARC21 en los datos de un libro y anota a que
+dato va cada campo. Se usan 008 (fecha e idioma si faltan), 020 (ISBN), 041
+(idioma), 082 (materias por Dewey si no hay encabezamientos que correspondan),
+100/110/700/710 (autores, traductores y editores), 245 (titulo y subtitulo), 250
+(edicion), 260/264 (editorial y fecha), 520 (descripcion), 650/651/653/655
+(materias) y 856 (url). Devuelve error si el registro no tiene titulo o autor.
+*/
+func propuestaMARC(reg registroMARC) (*PropuestaLibro, []CampoImportado, error) {
+	p := &PropuestaLibro{}
+	var campos []CampoImportado
+	if len(reg.Cabecera) == 24 && !strings.ContainsRune("acdmt", rune(reg.Cabecera[6])) {
+		return p, nil, errors.New("el registro no es bibliográfico de material textual")
+	}
+
+	roles := map[string][]string{}
+	var deweys, sinMateria []string
+	var fijos string
+	indiceDewey := -1
+	for _, c := range reg.Campos {
+		destino := ""
+		switch c.Etiqueta {
+		case "008":
+			fijos = c.Valor
+			destino = "fecha e idioma, si faltan"
+		case "020":
+			if p.ISBN != "" {
+				break
+			}
+			for _, a := range c.subcampos("a") {
+				// El ISBN puede venir con una aclaracion: 9780140449334 (rústica)
+				if palabras := strings.Fields(a); len(palabras) > 0 {
+					if isbn, err := NormalizarISBN(palabras[0]); err == nil {
+						p.ISBN, destino = isbn, "ISBN"
+					} else {
+						p.Avisos = append(p.Avisos, fmt.Sprintf("ISBN no válido en el 020: %s", a))
+					}
+				}
+			}
+		case "041":
+			if a := c.subcampo("a"); a != "" && p.Idioma == "" {
+				p.Idioma, destino = idiomaMARC(a), "idioma"
+			}
+		case "082":
+			deweys = append(deweys, c.subcampos("a")...)
+			indiceDewey = len(campos)
+		case "100", "110", "700", "710":
+			nombre := nombreMARC(c)
+			rol, ok := rolMARC(c)
+			if !ok {
+				p.Avisos = append(p.Avisos, fmt.Sprintf("Rol sin correspondencia en el %s: %s (%s)", c.Etiqueta, nombre, rol))
+				break
+			}
+			if nombre != "" {
+				roles[rol] = append(roles[rol], nombre)
+				destino = string(nombresRolMARC[rol])
+			}
+		case "245":
+			// El numero ($n) y el nombre ($p) de la parte se agregan al titulo
+			partes := []string{limpiarMARC(c.subcampo("a"))}
+			for _, s := range c.Subcampos {
+				if s.Codigo == "n" || s.Codigo == "p" {
+					partes = append(partes, limpiarMARC(s.Valor))
+				}
+			}
+			p.Titulo = strings.Join(partes, ". ")
+			p.Subtitulo = limpiarMARC(c.subcampo("b"))
+			destino = "título y subtítulo"
+		case "250":
+			p.Edicion, destino = strings.TrimSpace(c.subcampo("a")), "edición"
+		case "260", "264":
+			// En el 264 solo el segundo indicador 1 es la publicacion; los demas son produccion, distribucion o copyright
+			if c.Etiqueta == "264" && !strings.HasSuffix(c.Indicadores, "1") {
+				break
+			}
+			if b := limpiarMARC(c.subcampo("b")); b != "" && p.Editorial == "" {
+				p.Editorial = b
+			}
+			if texto := c.subcampo("c"); texto != "" && p.Fecha == "" {
+				if fecha, ok := fechaMARC(texto); ok {
+					p.Fecha = fecha
+				} else {
+					p.Avisos = append(p.Avisos, fmt.Sprintf("Fecha no reconocida en el %s: %s", c.Etiqueta, texto))
+				}
+			}
+			destino = "editorial y fecha"
+		case "520":
+			p.Descripcion = strings.TrimSpace(p.Descripcion + " " + strings.TrimSpace(c.subcampo("a")))
+			destino = "descripción"
+		case "650", "651", "653", "655":
+			// Primero el encabezamiento completo y luego el termino principal
+			encabezamiento := strings.Join(append(c.subcampos("a"), c.subcampos("x")...), " -- ")
+			m := listadomateria.resolver(encabezamiento)
+			if m == nil {
+				m = listadomateria.resolver(c.subcampo("a"))
+			}
+			if m == nil {
+				sinMateria = append(sinMateria, limpiarMARC(encabezamiento))
+				break
+			}
+			if !contieneEntero(p.Materias, m.MateriaID) {
+				p.Materias = append(p.Materias, m.MateriaID)
+			}
+			destino = "materias"
+		case "856":
+			if u := strings.TrimSpace(c.subcampo("u")); u != "" && p.Url == "" {
+				p.Url, destino = u, "url"
+			}
+		}
+		campos = append(campos, CampoImportado{c.Etiqueta, destino})
+	}
+
+	if len(p.Materias) == 0 {
+		for _, d := range deweys {
+			d = strings.ReplaceAll(strings.TrimSpace(d), "/", "")
+			for _, m := range listadomateria.Materias {
+				if m.Dewey != "" && m.Dewey == d && !contieneEntero(p.Materias, m.MateriaID) {
+					p.Materias = append(p.Materias, m.MateriaID)
+					campos[indiceDewey].Destino = "materias"
+				}
+			}
+		}
+	}
+	// 008: posiciones 7 a 10 el primer año de publicacion y 35 a 37 el idioma
+	if len(fijos) >= 38 {
+		if anio := fijos[7:11]; p.Fecha == "" && esNumero(anio) {
+			p.Fecha = anio
+		}
+		if codigo := strings.TrimSpace(fijos[35:38]); p.Idioma == "" && codigo != "" && !strings.ContainsAny(codigo, "|#") {
+			p.Idioma = idiomaMARC(codigo)
+		}
+	}
+
+	p.Autor = strings.Join(roles["aut"], "; ")
+	p.Traductor = strings.Join(roles["trl"], "; ")
+	p.Editor = strings.Join(roles["edt"], "; ")
+	if len(sinMateria) > 0 {
+		p.Avisos = append(p.Avisos, "Materias sin correspondencia en el vocabulario: "+strings.Join(sinMateria, ", "))
+	}
+	switch {
+	case p.Titulo == "":
+		return p, campos, errors.New("el registro no tiene título (campo 245)")
+	case p.Autor == "":
+		return p, campos, errors.New("el registro no tiene autor (campos 100, 110 o 700)")
+	}
+	return p, campos, nil
+}
+
+// Crea el libro con los datos importados, le asigna su obra y vincula sus autores
+func libroPropuesta(p *PropuestaLibro, id int) (*Libro, error) {
+	genero := ""
+	if len(p.Materias) > 0 {
+		if m, err := listadomateria.BuscarID(p.Materias[0]); err == nil {
+			genero = m.Nombre
+		}
+	}
+	l, err := nuevoLibro(id, p.Titulo, p.Autor, p.Fecha, genero, p.Url)
+	if err != nil {
+		return nil, err
+	}
+	l.SetSubtitulo(p.Subtitulo)
+	l.SetDescripcion(p.Descripcion)
+	l.SetISBN(p.ISBN)
+	l.SetMaterias(p.Materias)
+	l.Edicion, l.Idioma, l.Editorial = p.Edicion, p.Idioma, p.Editorial
+	l.ObraID = p.ObraID
+	if l.ObraID == 0 {
+		o, _ := listadoobra.obraPara(l)
+		l.ObraID = o.ObraID
+	}
+	vincularAutores(l, map[RolAutor]string{RolAutorPrincipal: p.Autor, RolTraductor: p.Traductor, RolEditor: p.Editor})
+	return l, nil
+}
+
+// Copia de los autores que se puede modificar sin cambiar los originales
+func copiarAutores(autores []*Autor) []*Autor {
+	copia := make([]*Autor, len(autores))
+	for i, a := range autores {
+		c := *a
+		c.Variantes = slices.Clone(a.Variantes)
+		copia[i] = &c
+	}
+	return copia
+}
+
+/*
+Agrega al catalogo los libros que crea la funcion y guarda los autores, las obras y
+los libros. Los libros se crean sobre copias de los autores y las obras, porque
+vincularlos puede agregar autores, variantes de nombres y obras; las copias y el
+catalogo nuevo reemplazan a los anteriores solo si se guardaron todos los archivos.
+Se llama con el catalogo tomado para escritura.
+*/
+func agregarLibros(crear func() ([]*Libro, error)) error {
+	autores, obras := listadoautor.Autores, listadoobra.Obras
+	listadoautor.Autores, listadoobra.Obras = copiarAutores(autores), slices.Clone(obras)
+	agregados, err := crear()
+	if err == nil && len(agregados) == 0 {
+		listadoautor.Autores, listadoobra.Obras = autores, obras
+		return nil
+	}
+	libros := append(slices.Clip(libreria.Libros), agregados...)
+	if err == nil {
+		err = guardarListados(archivoJSON{listadoautor.Autores, "autores.json"},
+			archivoJSON{listadoobra.Obras, "obras.json"}, archivoJSON{libros, "libros.json"})
+	}
+	if err != nil {
+		listadoautor.Autores, listadoobra.Obras = autores, obras
+		return err
+	}
+	libreria.Libros = libros
+	for _, l := range agregados {
+		indice.Agregar(l)
+	}
+	prefijos.Invalidar()
+	return nil
+}
+
+/*
+Registro MARC21 de un libro. Los nombres se exportan en orden directo con el primer
+indicador 0, porque el catalogo no distingue el apellido del nombre de pila. Cada
+autor lleva el rol como termino ($e) y como codigo de relacion ($4).
+*/
+func registroLibro(l *Libro, ahora time.Time) registroMARC {
+	reg := registroMARC{Cabecera: cabeceraMARC}
+	reg.agregarControl("001", strconv.Itoa(l.LibroID))
+
+	// 008: fecha de creacion del registro, tipo de fecha, año, lugar sin determinar e idioma
+	tipoFecha, anio := "n", "uuuu"
+	if f := l.FechaPublicacion; f.Anio > 0 && f.Anio <= 9999 {
+		tipoFecha, anio = "s", fmt.Sprintf("%04d", f.Anio)
+	}
+	idioma := codigoIdiomaMARC(l.Idioma)
+	reg.agregarControl("008", ahora.Format("060102")+tipoFecha+anio+"    xx "+strings.Repeat("|", 17)+
+		idioma008(idioma)+" d")
+
+	reg.agregar("020", "  ", "a", l.ISBN)
+	reg.agregar("041", "0 ", "a", idioma)
+	var deweys []string
+	for _, m := range l.MateriasLibro() {
+		if m.Dewey != "" && !contiene(deweys, m.Dewey) {
+			deweys = append(deweys, m.Dewey)
+			reg.agregar("082", "04", "a", m.Dewey)
+		}
+	}
+
+	participantes := l.Participantes()
+	if len(participantes) == 0 {
+		for _, nombre := range dividirAutores(l.Autor) {
+			participantes = append(participantes, ParticipanteLibro{&Autor{Nombre: nombre}, RolAutorPrincipal})
+		}
+	}
+	principal := false
+	for _, p := range participantes {
+		etiqueta := "700"
+		if p.Rol == RolAutorPrincipal && !principal {
+			etiqueta, principal = "100", true
+		}
+		codigo := ""
+		for c, rol := range nombresRolMARC {
+			if rol == p.Rol {
+				codigo = c
+			}
+		}
+		reg.agregar(etiqueta, "0 ", "a", p.Autor.Nombre, "e", string(p.Rol), "4", codigo)
+	}
+
+	// Primer indicador del 245: 1 si hay un asiento principal de autor (100)
+	indicadores := "00"
+	if principal {
+		indicadores = "10"
+	}
+	reg.agregar("245", indicadores, "a", l.Titulo, "b", l.Subtitulo)
+	reg.agregar("250", "  ", "a", l.Edicion)
+	reg.agregar("264", " 1", "b", l.Editorial, "c", l.FechaPublicacion.ISO())
+	reg.agregar("520", "  ", "a", l.Descripcion)
+	for _, m := range l.MateriasLibro() {
+		reg.agregar("650", " 4", "a", m.Nombre)
+	}
+	reg.agregar("856", "40", "u", l.Url)
+
+	// Los campos van en orden de etiqueta; los 700 quedan despues de las materias
+	sort.SliceStable(reg.Campos, func(i, j int) bool { return reg.Campos[i].Etiqueta < reg.Campos[j].Etiqueta })
+	return reg
+}
+
+// Codigo de idioma para el 008, que es de largo fijo
+func idioma008(codigo string) string {
+	if len(codigo) != 3 {
+		return "   "
+	}
+	return codigo
+}
+
+// Resultado de importar un registro MARC
+type RegistroImportado struct {
+	Numero  int              `json:"numero"`
+	Titulo  string           `json:"titulo"`
+	Estado  string           `json:"estado"` // importado, omitido o error
+	LibroID int              `json:"libro_id,omitempty"`
+	Error   string           `json:"error,omitempty"`
+	Campos  []CampoImportado `json:"campos"`
+	Avisos  []string         `json:"avisos,omitempty"`
+}
+
+type ConteoCampo struct {
+	Etiqueta string `json:"etiqueta"`
+	Cantidad int    `json:"cantidad"`
+}
+
+// Informe de una importacion MARC con el destino de cada campo de cada registro
+type InformeMARC struct {
+	Formato     string              `json:"formato"`
+	Importados  int                 `json:"importados"`
+	Omitidos    int                 `json:"omitidos"`
+	Errores     int                 `json:"errores"`
+	Registros   []RegistroImportado `json:"registros"`
+	SinImportar []ConteoCampo       `json:"sin_importar"` // campos sin correspondencia y en cuantos registros aparecen
+}
+
+/*
+Crea un libro por cada registro y los guarda con agregarLibros. Los registros con el
+ISBN de un libro del catalogo (o de uno anterior del mismo archivo) se omiten para no
+duplicar libros, y los que no tienen titulo o autor quedan como error; ninguno de los
+dos detiene la importacion. Si no se pueden guardar los archivos no se agrega ningun
+libro. Se llama con el catalogo tomado para escritura.
+*/
+func importarRegistrosMARC(registros []registroMARC, formato string) (*InformeMARC, error) {
+	informe := &InformeMARC{Formato: formato, Registros: []RegistroImportado{}, SinImportar: []ConteoCampo{}}
+	siguiente := 0
+	for _, l := range libreria.Libros {
+		siguiente = max(siguiente, l.LibroID)
+	}
+	sinImportar := map[string]int{}
+	err := agregarLibros(func() ([]*Libro, error) {
+		var agregados []*Libro
+		isbns := map[string]int{}
+		for i, reg := range registros {
+			p, campos, err := propuestaMARC(reg)
+			ri := RegistroImportado{Numero: i + 1, Titulo: p.Titulo, Campos: campos, Avisos: p.Avisos}
+			vistos := map[string]bool{}
+			for _, c := range campos {
+				if c.Destino == "" && !vistos[c.Etiqueta] {
+					sinImportar[c.Etiqueta]++
+					vistos[c.Etiqueta] = true
+				}
+			}
+
+			if err == nil && p.ISBN != "" {
+				existente := isbns[p.ISBN]
+				if libro, errISBN := libreria.BuscarISBN(p.ISBN); errISBN == nil {
+					existente = libro.LibroID
+				}
+				if existente != 0 {
+					ri.Estado, ri.LibroID = "omitido", existente
+					ri.Error = fmt.Sprintf("ya existe el libro %d con el ISBN %s", existente, p.ISBN)
+					informe.Omitidos++
+					informe.Registros = append(informe.Registros, ri)
+					continue
+				}
+			}
+			var libro *Libro
+			if err == nil {
+				libro, err = libroPropuesta(p, siguiente+1)
+			}
+			if err != nil {
+				ri.Estado, ri.Error = "error", err.Error()
+				informe.Errores++
+			} else {
+				siguiente++
+				agregados = append(agregados, libro)
+				if p.ISBN != "" {
+					isbns[p.ISBN] = libro.LibroID
+				}
+				ri.Estado, ri.LibroID = "importado", libro.LibroID
+				informe.Importados++
+			}
+			informe.Registros = append(informe.Registros, ri)
+		}
+		return agregados, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for etiqueta, n := range sinImportar {
+		informe.SinImportar = append(informe.SinImportar, ConteoCampo{etiqueta, n})
+	}
+	sort.Slice(informe.SinImportar, func(i, j int) bool { return informe.SinImportar[i].Etiqueta < informe.SinImportar[j].Etiqueta })
+	return informe, nil
+}
+
+// Codigo HTML para importar registros MARC y ver el informe de la importacion
+var marcTemplate = template.Must(template.New("marc").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Importar MARC</title>
+</head>
+<body>
+	<h1>Importar registros MARC</h1>
+	{{with .Informe}}
+	<p>Archivo {{.Formato}}: {{.Importados}} importado(s), {{.Omitidos}} omitido(s) y {{.Errores}} con error.</p>
+	{{if .SinImportar}}
+	<p>Campos sin correspondencia, que no se importaron:
+		{{range $i, $c := .SinImportar}}{{if $i}}, {{end}}{{$c.Etiqueta}} ({{$c.Cantidad}}){{end}}
+	</p>
+	{{end}}
+	<table>
+		<tr><th>Registro</th><th>Título</th><th>Resultado</th><th>Campos</th><th>Avisos</th></tr>
+		{{range .Registros}}
+		<tr>
+			<td>{{.Numero}}</td>
+			<td>{{.Titulo}}</td>
+			<td>{{.Estado}}{{if .LibroID}} (<a href="/visualizar-libro?id={{.LibroID}}">libro {{.LibroID}}</a>){{end}}{{with .Error}}: {{.}}{{end}}</td>
+			<td>{{range .Campos}}{{.Etiqueta}} → {{or .Destino "sin importar"}}<br>{{end}}</td>
+			<td>{{range .Avisos}}{{.}}<br>{{end}}</td>
+		</tr>
+		{{end}}
+	</table>
+	{{end}}
+	<form action="/importar-marc" method="post" enctype="multipart/form-data">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<label for="archivo">Archivo MARC21 (.mrc) o MARCXML (hasta 20 MB):</label>
+		<input type="file" id="archivo" name="archivo" accept=".mrc,.marc,.xml,application/marc,application/marcxml+xml" required><br>
+		<button type="submit">Importar</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+/*
+Funcion para importar libros desde un archivo MARC21 o MARCXML. Responde con el
+informe de la importacion (formato=json para recibirlo en JSON).
+*/
+func importarMARC(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		datos := struct {
+			CSRF    string
+			Informe *InformeMARC
+		}{tokenCSRF(r), nil}
+		if err := marcTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archivo, _, err := r.FormFile("archivo")
+	if err != nil {
+		http.Error(w, "Adjunte un archivo MARC21 o MARCXML de hasta 20 MB", http.StatusBadRequest)
+		return
+	}
+	defer archivo.Close()
+	contenido, err := io.ReadAll(io.LimitReader(archivo, maxTamanoMARC+1))
+	if err != nil {
+		http.Error(w, "Error al leer el archivo", http.StatusInternalServerError)
+		return
+	}
+	if len(contenido) > maxTamanoMARC {
+		http.Error(w, "El archivo supera los 20 MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+	registros, formato, err := leerArchivoMARC(contenido)
+	if errors.Is(err, errFormatoMARC) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// El archivo se recibe y se lee sin tomar el catalogo, que solo se toma para agregar los libros
+	muCatalogo.Lock()
+	informe, err := importarRegistrosMARC(registros, formato)
+	muCatalogo.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("formato") == "json" {
+		responderJSON(w, informe)
+		return
+	}
+	datos := struct {
+		CSRF    string
+		Informe *InformeMARC
+	}{tokenCSRF(r), informe}
+	if err := marcTemplate.Execute(w, datos); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/*
+Funcion para exportar libros del catalogo en MARC21 (formato=mrc, por defecto) o
+MARCXML (formato=marcxml). Se exportan los libros indicados con id (que se puede
+repetir o separar con comas) o, sin id, los que cumplen los mismos filtros que el
+catalogo (q, materia, autor, disponible...).
+*/
+func exportarMARC(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	var libros []*Libro
+	if len(params["id"]) > 0 {
+		for _, texto := range params["id"] {
+			for _, parte := range strings.Split(texto, ",") {
+				id, err := strconv.Atoi(strings.TrimSpace(parte))
+				if err != nil {
+					http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+					return
+				}
+				libro, err := libreria.BuscarID(id)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				libros = append(libros, libro)
+			}
+		}
+	} else {
+		libros = navegarCatalogo(params).Resultados
+	}
+
+	ahora := time.Now()
+	registros := make([]registroMARC, 0, len(libros))
+	for _, l := range libros {
+		registros = append(registros, registroLibro(l, ahora))
+	}
+	var buf bytes.Buffer
+	nombre, tipo := "catalogo.mrc", "application/marc"
+	escribir := escribirMARC21
+	if params.Get("formato") == "marcxml" {
+		nombre, tipo = "catalogo.xml", "application/marcxml+xml; charset=utf-8"
+		escribir = escribirMARCXML
+	}
+	if err := escribir(&buf, registros); err != nil {
+		http.Error(w, "Error al exportar los registros: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", tipo)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+nombre+`"`)
+	w.Write(buf.Bytes())
+}
+
+// Datos de dos importaciones del mismo registro que no coinciden
+func diferenciasPropuesta(a, b *PropuestaLibro) []string {
+	var diferencias []string
+	for _, campo := range []struct{ nombre, antes, despues string }{
+		{"título", a.Titulo, b.Titulo}, {"subtítulo", a.Subtitulo, b.Subtitulo}, {"autor", a.Autor, b.Autor},
+		{"traductor", a.Traductor, b.Traductor}, {"editor", a.Editor, b.Editor}, {"fecha", a.Fecha, b.Fecha},
+		{"edición", a.Edicion, b.Edicion}, {"idioma", a.Idioma, b.Idioma}, {"editorial", a.Editorial, b.Editorial},
+		{"ISBN", a.ISBN, b.ISBN}, {"descripción", a.Descripcion, b.Descripcion}, {"url", a.Url, b.Url},
+		{"materias", fmt.Sprint(a.Materias), fmt.Sprint(b.Materias)},
+	} {
+		if campo.antes != campo.despues {
+			diferencias = append(diferencias, fmt.Sprintf("%s: %q -> %q", campo.nombre, campo.antes, campo.despues))
+		}
+	}
+	return diferencias
+}
+
+/*
+Importa el registro como el libro id, lo exporta en MARC21 y en MARCXML y lo vuelve
+a importar. Devuelve los datos de la primera importacion y lo que cambio en cada
+formato; el error indica que el registro no se pudo importar.
+*/
+func comprobarRegistroMARC(reg registroMARC, id int) (*PropuestaLibro, []string, error) {
+	original, _, err := propuestaMARC(reg)
+	if err != nil {
+		return original, nil, err
+	}
+	// El libro se vincula con copias de los autores y las obras, que despues se descartan
+	autores, obras := listadoautor.Autores, listadoobra.Obras
+	listadoautor.Autores, listadoobra.Obras = copiarAutores(autores), slices.Clone(obras)
+	defer func() { listadoautor.Autores, listadoobra.Obras = autores, obras }()
+	libro, err := libroPropuesta(original, id)
+	if err != nil {
+		return original, nil, err
+	}
+	// Los autores se comparan con el nombre preferido con el que quedaron vinculados
+	esperado := *original
+	nombres := map[RolAutor][]string{}
+	for _, p := range libro.Participantes() {
+		nombres[p.Rol] = append(nombres[p.Rol], p.Autor.Nombre)
+	}
+	esperado.Autor = strings.Join(nombres[RolAutorPrincipal], "; ")
+	esperado.Traductor = strings.Join(nombres[RolTraductor], "; ")
+	esperado.Editor = strings.Join(nombres[RolEditor], "; ")
+
+	var diferencias []string
+	for _, f := range []struct {
+		nombre   string
+		escribir func(io.Writer, []registroMARC) error
+	}{{"MARC21", escribirMARC21}, {"MARCXML", escribirMARCXML}} {
+		var buf bytes.Buffer
+		if err := f.escribir(&buf, []registroMARC{registroLibro(libro, time.Now())}); err != nil {
+			diferencias = append(diferencias, f.nombre+": "+err.Error())
+			continue
+		}
+		leidos, _, err := leerArchivoMARC(buf.Bytes())
+		if err != nil {
+			diferencias = append(diferencias, f.nombre+": "+err.Error())
+			continue
+		}
+		vuelta, _, _ := propuestaMARC(leidos[0])
+		for _, d := range diferenciasPropuesta(&esperado, vuelta) {
+			diferencias = append(diferencias, f.nombre+": "+d)
+		}
+	}
+	return original, diferencias, nil
+}
+
+/*
+Comprueba que los registros de un archivo MARC se conservan al importarlos,
+exportarlos en MARC21 y en MARCXML e importarlos de nuevo (opcion -comprobar-marc).
+No guarda ningun cambio.
+*/
+func ejecutarComprobacionMARC(ruta string) error {
+	contenido, err := os.ReadFile(ruta)
+	if err != nil {
+		return err
+	}
+	registros, formato, err := leerArchivoMARC(contenido)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Registros %s leídos: %d\n", formato, len(registros))
+
+	fallidos := 0
+	for i, reg := range registros {
+		original, diferencias, err := comprobarRegistroMARC(reg, i+1)
+		if err != nil {
+			fmt.Printf("Registro %d: %v\n", i+1, err)
+			fallidos++
+			continue
+		}
+		if len(diferencias) == 0 {
+			fmt.Printf("Registro %d: %s, sin cambios\n", i+1, original.Titulo)
+			continue
+		}
+		fallidos++
+		fmt.Printf("Registro %d: %s\n", i+1, original.Titulo)
+		for _, d := range diferencias {
+			fmt.Println("  " + d)
+		}
+	}
+	if fallidos > 0 {
+		return fmt.Errorf("%d de %d registros no se conservan al exportar e importar de nuevo", fallidos, len(registros))
+	}
+	return nil
+}
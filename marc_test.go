@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// Registro MARC21 en UTF-8 con traductor, ISBN con aclaracion y materia por encabezamiento
+const registroMARC21Prueba = "00520nam a2200181   4500" +
+	"001000800000008004100008020002900049041000800078082000800086100002600094245004500120" +
+	"250001100165264003000176520004200206650001500248700004200263856003300305\x1e" +
+	"epict-1\x1e" +
+	"240101s1980    sp            000 0 spa d\x1e" +
+	"  \x1fa9788420412146 (rústica)\x1e" +
+	"0 \x1faspa\x1e" +
+	"04\x1fa188\x1e" +
+	"1 \x1faEpicteto,\x1feautor\x1f4aut\x1e" +
+	"10\x1faManual de Epicteto /\x1fbmáximas estoicas.\x1e" +
+	"  \x1fa2a ed.\x1e" +
+	" 1\x1faMadrid :\x1fbAlianza,\x1fc1980.\x1e" +
+	"  \x1faBreve compendio de máximas estoicas.\x1e" +
+	" 4\x1faEstoicismo\x1e" +
+	"1 \x1faGarcía Gual, Carlos,\x1fetraductor\x1f4trl\x1e" +
+	"40\x1fuhttp://www.libros.com/manual\x1e\x1d"
+
+// Registro MARCXML sin encabezamientos de materia, que se asignan por la clasificacion Dewey
+const registroMARCXMLPrueba = `<?xml version="1.0" encoding="UTF-8"?>
+<collection xmlns="http://www.loc.gov/MARC21/slim">
+  <record>
+    <leader>00000nam a2200000 i 4500</leader>
+    <controlfield tag="001">med-1</controlfield>
+    <controlfield tag="008">230101s2023    sp            000 0 spa d</controlfield>
+    <datafield tag="082" ind1="0" ind2="4"><subfield code="a">188</subfield></datafield>
+    <datafield tag="100" ind1="0" ind2=" "><subfield code="a">Marco Aurelio</subfield><subfield code="4">aut</subfield></datafield>
+    <datafield tag="245" ind1="1" ind2="0"><subfield code="a">Meditaciones.</subfield></datafield>
+    <datafield tag="264" ind1=" " ind2="1"><subfield code="b">Gredos</subfield><subfield code="c">octubre de 2023</subfield></datafield>
+    <datafield tag="520" ind1=" " ind2=" "><subfield code="a">Reflexiones del emperador.</subfield></datafield>
+  </record>
+</collection>`
+
+// Vocabulario de materias y listados vacios de autores y obras, restaurados al terminar
+func catalogoMARCPrueba(t *testing.T) {
+	materias, autores, obras := listadomateria.Materias, listadoautor.Autores, listadoobra.Obras
+	t.Cleanup(func() { listadomateria.Materias, listadoautor.Autores, listadoobra.Obras = materias, autores, obras })
+	listadomateria.Materias = []*Materia{
+		{MateriaID: 1, Nombre: "Filosofía", Dewey: "100"},
+		{MateriaID: 2, Nombre: "Estoicismo", PadreID: 1, Variantes: []string{"Stoicism"}, Dewey: "188"},
+	}
+	listadoautor.Autores = nil
+	listadoobra.Obras = nil
+}
+
+func TestComprobarMARC(t *testing.T) {
+	catalogoMARCPrueba(t)
+	casos := []struct {
+		nombre, datos, formato string
+		want                   PropuestaLibro
+	}{
+		{"MARC21", registroMARC21Prueba, "MARC21", PropuestaLibro{
+			Titulo: "Manual de Epicteto", Subtitulo: "máximas estoicas", Autor: "Epicteto", Traductor: "Carlos García Gual",
+			Fecha: "1980", Edicion: "2a ed.", Idioma: "español", Editorial: "Alianza", ISBN: "9788420412146",
+			Descripcion: "Breve compendio de máximas estoicas.", Url: "http://www.libros.com/manual", Materias: []int{2},
+		}},
+		{"MARCXML", registroMARCXMLPrueba, "MARCXML", PropuestaLibro{
+			Titulo: "Meditaciones", Autor: "Marco Aurelio", Fecha: "2023-10", Idioma: "español",
+			Editorial: "Gredos", Descripcion: "Reflexiones del emperador.", Materias: []int{2},
+		}},
+	}
+	for i, caso := range casos {
+		registros, formato, err := leerArchivoMARC([]byte(caso.datos))
+		if err != nil || formato != caso.formato || len(registros) != 1 {
+			t.Fatalf("%s: leerArchivoMARC = %d registros, %q, %v", caso.nombre, len(registros), formato, err)
+		}
+
+		original, diferencias, err := comprobarRegistroMARC(registros[0], i+1)
+		if err != nil {
+			t.Fatalf("%s: %v", caso.nombre, err)
+		}
+		for _, d := range diferenciasPropuesta(&caso.want, original) {
+			t.Errorf("%s: importado %s", caso.nombre, d)
+		}
+		// Exportar en MARC21 y en MARCXML e importar de nuevo da los mismos datos
+		for _, d := range diferencias {
+			t.Errorf("%s: al exportar e importar de nuevo cambia %s", caso.nombre, d)
+		}
+	}
+	// La comprobacion no guarda los autores ni las obras que crea
+	if len(listadoautor.Autores) != 0 || len(listadoobra.Obras) != 0 {
+		t.Errorf("quedaron %d autores y %d obras", len(listadoautor.Autores), len(listadoobra.Obras))
+	}
+}
+
+func TestImportarRegistrosMARC(t *testing.T) {
+	catalogoMARCPrueba(t)
+	enDirectorioTemporal(t)
+	anterior, anteriorIndice := libreria, indice
+	t.Cleanup(func() { libreria, indice = anterior, anteriorIndice })
+	existente := &Libro{LibroID: 5, Titulo: "Historia de Roma", Autor: "Tito Livio"}
+	libreria = &Libreria{Libros: []*Libro{existente}}
+	indice = nuevoIndice()
+	indice.Agregar(existente)
+
+	// El segundo registro MARC21 repite el ISBN del primero
+	registros, _, err := leerArchivoMARC([]byte(registroMARC21Prueba + registroMARC21Prueba))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xml, _, err := leerArchivoMARC([]byte(registroMARCXMLPrueba))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registros = append(registros, xml...)
+
+	// Si no se pueden guardar los libros no queda nada de la importacion
+	if err := os.Mkdir("libros.json", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importarRegistrosMARC(registros, "MARC21"); err == nil {
+		t.Fatal("se importó sin poder guardar libros.json")
+	}
+	if len(libreria.Libros) != 1 || len(listadoautor.Autores) != 0 || len(listadoobra.Obras) != 0 || len(indice.Buscar("meditaciones", 10)) != 0 {
+		t.Errorf("tras el error quedan %d libros, %d autores y %d obras", len(libreria.Libros), len(listadoautor.Autores), len(listadoobra.Obras))
+	}
+	os.Remove("libros.json")
+
+	informe, err := importarRegistrosMARC(registros, "MARC21")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if informe.Importados != 2 || informe.Omitidos != 1 || informe.Errores != 0 {
+		t.Fatalf("informe %+v", informe)
+	}
+	if r := informe.Registros[1]; r.Estado != "omitido" || r.LibroID != 6 {
+		t.Errorf("registro repetido %+v", r)
+	}
+	if len(libreria.Libros) != 3 || libreria.Libros[1].LibroID != 6 || libreria.Libros[2].LibroID != 7 {
+		t.Errorf("%d libros", len(libreria.Libros))
+	}
+	if len(listadoautor.Autores) != 3 || len(listadoobra.Obras) != 2 || len(indice.Buscar("meditaciones", 10)) != 1 {
+		t.Errorf("%d autores, %d obras", len(listadoautor.Autores), len(listadoobra.Obras))
+	}
+	var guardados []*Libro
+	if err := loadFromJSON("libros.json", &guardados); err != nil || len(guardados) != 3 {
+		t.Errorf("libros.json tiene %d libros: %v", len(guardados), err)
+	}
+}
+
+func TestMARC21DirectorioInvalido(t *testing.T) {
+	valido := []byte(registroMARC21Prueba)
+	if _, err := leerRegistroMARC21(valido[:len(valido)-1]); err != nil {
+		t.Fatalf("el registro de prueba no se lee: %v", err)
+	}
+
+	// Reemplaza desde la posicion indicada del registro valido
+	cambiar := func(posicion int, texto string) string {
+		crudo := []byte(registroMARC21Prueba)
+		copy(crudo[posicion:], texto)
+		return string(crudo)
+	}
+	casos := []struct {
+		nombre, crudo, mensaje string
+	}{
+		{"largo negativo", "00050nam a2200037 c 4500245-00100000\x1e10\x1faX\x1e\x1d", "directorio del campo 245"},
+		{"posición con signo", cambiar(24+12*6+7, "+0120"), "directorio del campo 245"},
+		{"largo con letras", cambiar(24+3, "00x8"), "directorio del campo 001"},
+		{"campo fuera del registro", cambiar(24+12*12+3, "0099"), "directorio del campo 856"},
+		{"dirección base con signo", cambiar(12, "-0181"), "dirección base"},
+		{"dirección base fuera del directorio", cambiar(12, "00180"), "dirección base"},
+	}
+	for _, caso := range casos {
+		_, err := leerMARC21([]byte(caso.crudo))
+		if err == nil || !strings.Contains(err.Error(), caso.mensaje) {
+			t.Errorf("%s: leerMARC21 = %v, se esperaba un error con %q", caso.nombre, err, caso.mensaje)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Materia del vocabulario controlado. Las materias forman una jerarquia
+(Filosofía > Estoicismo) y pueden tener numero de clasificacion Dewey o CDU.
+Las variantes son otras formas del nombre que se aceptan al buscar o migrar
+("Filosofia", "Philosophy").
+*/
+type Materia struct {
+	MateriaID int      `json:"id"`
+	Nombre    string   `json:"nombre"`
+	PadreID   int      `json:"padre_id,omitempty"`
+	Variantes []string `json:"variantes,omitempty"`
+	Dewey     string   `json:"dewey,omitempty"`
+	CDU       string   `json:"cdu,omitempty"`
+}
+
+// Creamos la estructura materia con slice para guardar la taxonomia
+type Listadomateria struct {
+	Materias []*Materia
+}
+
+var listadomateria Listadomateria
+
+var _ Busqueda[*Materia] = (*Listadomateria)(nil)
+
+// Busqueda de materias
+func (lm *Listadomateria) BuscarID(id int) (*Materia, error) {
+	for _, m := range lm.Materias {
+		if m.MateriaID == id {
+			return m, nil
+		}
+	}
+	return nil, errors.New("materia no encontrada con el ID digitado")
+}
+
+func (lm *Listadomateria) BuscarNombre(nombre string) ([]*Materia, error) {
+	var resultados []*Materia
+	for _, m := range lm.Materias {
+		if contienePalabras(nombre, append([]string{m.Nombre}, m.Variantes...)...) {
+			resultados = append(resultados, m)
+		}
+	}
+	if len(resultados) == 0 {
+		return nil, errors.New("no existen materias con ese nombre")
+	}
+	return resultados, nil
+}
+
+// Materia cuyo nombre o variante coincide con el texto, sin distinguir mayusculas ni acentos
+func (lm *Listadomateria) resolver(texto string) *Materia {
+	buscado := strings.Join(tokenizar(texto), " ")
+	if buscado == "" {
+		return nil
+	}
+	for _, m := range lm.Materias {
+		for _, n := range append([]string{m.Nombre}, m.Variantes...) {
+			if strings.Join(tokenizar(n), " ") == buscado {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// Materias desde la raiz hasta la indicada, por ejemplo Filosofía, Estoicismo
+func (lm *Listadomateria) ruta(m *Materia) []*Materia {
+	ruta := []*Materia{m}
+	for actual := m; actual.PadreID != 0 && len(ruta) <= len(lm.Materias); {
+		padre, err := lm.BuscarID(actual.PadreID)
+		if err != nil {
+			break
+		}
+		ruta = append([]*Materia{padre}, ruta...)
+		actual = padre
+	}
+	return ruta
+}
+
+// Nombre con la jerarquia completa, por ejemplo "Filosofía > Estoicismo"
+func (lm *Listadomateria) etiqueta(m *Materia) string {
+	var nombres []string
+	for _, r := range lm.ruta(m) {
+		nombres = append(nombres, r.Nombre)
+	}
+	return strings.Join(nombres, " > ")
+}
+
+// Submaterias directas ordenadas por nombre (padreID 0 para las materias principales)
+func (lm *Listadomateria) hijas(padreID int) []*Materia {
+	var hijas []*Materia
+	for _, m := range lm.Materias {
+		if m.PadreID == padreID {
+			hijas = append(hijas, m)
+		}
+	}
+	sort.Slice(hijas, func(i, j int) bool {
+		return normalizarTexto(hijas[i].Nombre) < normalizarTexto(hijas[j].Nombre)
+	})
+	return hijas
+}
+
+// Indica si la materia es id o una de sus submaterias
+func (lm *Listadomateria) pertenece(m *Materia, id int) bool {
+	for _, r := range lm.ruta(m) {
+		if r.MateriaID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (lm *Listadomateria) siguienteID() int {
+	id := 0
+	for _, m := range lm.Materias {
+		id = max(id, m.MateriaID)
+	}
+	return id + 1
+}
+
+// Materias asignadas al libro
+func (l *Libro) MateriasLibro() []*Materia {
+	var materias []*Materia
+	for _, id := range l.Materias {
+		if m, err := listadomateria.BuscarID(id); err == nil {
+			materias = append(materias, m)
+		}
+	}
+	return materias
+}
+
+// Nombres de las materias del libro y de sus materias superiores, sin repetir
+func (l *Libro) nombresMaterias() []string {
+	var nombres []string
+	for _, m := range l.MateriasLibro() {
+		for _, r := range listadomateria.ruta(m) {
+			if !contiene(nombres, r.Nombre) {
+				nombres = append(nombres, r.Nombre)
+			}
+		}
+	}
+	if len(nombres) == 0 && l.Genero != "" {
+		nombres = append(nombres, l.Genero)
+	}
+	return nombres
+}
+
+// Texto con el genero, las materias, sus superiores y variantes, para buscar
+func (l *Libro) textoMaterias() string {
+	textos := []string{l.Genero}
+	for _, m := range l.MateriasLibro() {
+		for _, r := range listadomateria.ruta(m) {
+			textos = append(textos, r.Nombre)
+			textos = append(textos, r.Variantes...)
+		}
+	}
+	return strings.Join(textos, " ")
+}
+
+// Asigna como materia el genero de texto libre de los libros que aun no tienen materias
+func migrarMaterias(libros []*Libro) []string {
+	var cambios []string
+	for _, l := range libros {
+		if len(l.Materias) > 0 || strings.TrimSpace(l.Genero) == "" {
+			continue
+		}
+		m := listadomateria.resolver(l.Genero)
+		if m == nil {
+			m = &Materia{MateriaID: listadomateria.siguienteID(), Nombre: strings.TrimSpace(l.Genero)}
+			listadomateria.Materias = append(listadomateria.Materias, m)
+			cambios = append(cambios, fmt.Sprintf("materia %d creada a partir del género %q", m.MateriaID, l.Genero))
+		}
+		l.Materias = []int{m.MateriaID}
+	}
+	return cambios
+}
+
+// Carga las materias al iniciar (materias.json o la semilla) y asigna materias a los libros que no tienen
+func cargarMaterias(semilla []*Materia, libros []*Libro) error {
+	err := loadFromJSON("materias.json", &listadomateria.Materias)
+	if os.IsNotExist(err) {
+		listadomateria.Materias = semilla
+	} else if err != nil {
+		return err
+	}
+	for _, cambio := range migrarMaterias(libros) {
+		fmt.Fprintln(os.Stderr, cambio)
+	}
+	return nil
+}
+
+// Materia para elegir en los formularios, con su jerarquia completa
+type OpcionMateria struct {
+	ID           int
+	Etiqueta     string
+	Seleccionada bool
+}
+
+// Materias ordenadas por jerarquia, marcando las seleccionadas
+func opcionesMaterias(seleccionadas []int) []OpcionMateria {
+	var opciones []OpcionMateria
+	var agregar func(padreID int)
+	agregar = func(padreID int) {
+		for _, m := range listadomateria.hijas(padreID) {
+			opciones = append(opciones, OpcionMateria{m.MateriaID, listadomateria.etiqueta(m), contieneEntero(seleccionadas, m.MateriaID)})
+			agregar(m.MateriaID)
+		}
+	}
+	agregar(0)
+	return opciones
+}
+
+// Materias elegidas en el formulario del libro; se requiere al menos una
+func materiasFormulario(r *http.Request) ([]int, error) {
+	var ids []int
+	for _, texto := range r.Form["materias"] {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			return nil, errors.New("el ID de la materia debe ser un número entero")
+		}
+		if _, err := listadomateria.BuscarID(id); err != nil {
+			return nil, err
+		}
+		if !contieneEntero(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("elija al menos una materia")
+	}
+	return ids, nil
+}
+
+// Materia con sus submaterias y la cantidad de libros, para la pagina de materias
+type NodoMateria struct {
+	Materia  *Materia      `json:"materia"`
+	Cantidad int           `json:"cantidad"`
+	Hijas    []NodoMateria `json:"hijas,omitempty"`
+}
+
+// Libros de la materia o de alguna de sus submaterias
+func librosMateria(id int) []*Libro {
+	var libros []*Libro
+	for _, l := range libreria.Libros {
+		for _, m := range l.MateriasLibro() {
+			if listadomateria.pertenece(m, id) {
+				libros = append(libros, l)
+				break
+			}
+		}
+	}
+	return libros
+}
+
+func arbolMaterias(padreID int) []NodoMateria {
+	nodos := []NodoMateria{}
+	for _, m := range listadomateria.hijas(padreID) {
+		nodos = append(nodos, NodoMateria{m, len(librosMateria(m.MateriaID)), arbolMaterias(m.MateriaID)})
+	}
+	return nodos
+}
+
+// Codigo HTML para explorar las materias
+var subjectTemplate = template.Must(template.New("materias").Parse(`
+{{define "arbol"}}<ul>{{range .}}
+	<li><a href="/materias?id={{.Materia.MateriaID}}">{{.Materia.Nombre}}</a> ({{.Cantidad}}){{if .Hijas}}{{template "arbol" .Hijas}}{{end}}</li>
+{{end}}</ul>{{end}}
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>{{if .Materia}}{{.Materia.Nombre}}{{else}}Materias{{end}}</title>
+</head>
+<body>
+	{{if .Materia}}
+	<p><a href="/materias">Materias</a>{{range .Ruta}} &gt; <a href="/materias?id={{.MateriaID}}">{{.Nombre}}</a>{{end}}</p>
+	<h1>{{.Materia.Nombre}}</h1>
+	{{with .Materia.Dewey}}<p>Dewey: {{.}}</p>{{end}}
+	{{with .Materia.CDU}}<p>CDU: {{.}}</p>{{end}}
+	{{if .Arbol}}<h2>Submaterias</h2>{{template "arbol" .Arbol}}{{end}}
+	<h2>{{len .Libros}} libro(s)</h2>
+	<ul>
+		{{range .Libros}}<li>{{.Titulo}} - {{.Autor}}{{with .FechaPublicacion.ISO}} ({{.}}){{end}}</li>{{end}}
+	</ul>
+	{{else}}
+	<h1>Materias</h1>
+	{{template "arbol" .Arbol}}
+	{{end}}
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para explorar las materias (?id= para ver una materia y sus libros, formato=json para JSON)
+func explorarMaterias(w http.ResponseWriter, r *http.Request) {
+	datos := struct {
+		Materia *Materia      `json:"materia,omitempty"`
+		Ruta    []*Materia    `json:"ruta,omitempty"`
+		Arbol   []NodoMateria `json:"submaterias"`
+		Libros  []*Libro      `json:"libros,omitempty"`
+	}{}
+
+	padreID := 0
+	if texto := r.URL.Query().Get("id"); texto != "" {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		if datos.Materia, err = listadomateria.BuscarID(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		padreID = id
+		datos.Ruta = listadomateria.ruta(datos.Materia)
+		datos.Ruta = datos.Ruta[:len(datos.Ruta)-1]
+		datos.Libros = librosMateria(id)
+	}
+	datos.Arbol = arbolMaterias(padreID)
+
+	if r.URL.Query().Get("formato") == "json" {
+		responderJSON(w, datos)
+		return
+	}
+	if err := subjectTemplate.Execute(w, datos); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Codigo HTML para crear o editar una materia
+var editSubjectTemplate = template.Must(template.New("editarMateria").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>{{if .Materia.MateriaID}}Editar Materia{{else}}Crear Materia{{end}}</title>
+</head>
+<body>
+	<h1>{{if .Materia.MateriaID}}Editar Materia {{.Materia.MateriaID}}{{else}}Crear Materia{{end}}</h1>
+	<form action="/editar-materia" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		{{if .Materia.MateriaID}}<input type="hidden" name="id" value="{{.Materia.MateriaID}}">{{end}}
+		<label for="nombre">Nombre:</label>
+		<input type="text" id="nombre" name="nombre" value="{{.Materia.Nombre}}" required><br>
+		<label for="padre">Materia superior:</label>
+		<select id="padre" name="padre">
+			<option value="0">(ninguna)</option>
+			{{range .Opciones}}<option value="{{.ID}}"{{if .Seleccionada}} selected{{end}}>{{.Etiqueta}}</option>{{end}}
+		</select><br>
+		<label for="variantes">Variantes (una por línea):</label><br>
+		<textarea id="variantes" name="variantes" rows="3" cols="50">{{range .Materia.Variantes}}{{.}}
+{{end}}</textarea><br>
+		<label for="dewey">Dewey:</label>
+		<input type="text" id="dewey" name="dewey" value="{{.Materia.Dewey}}"><br>
+		<label for="cdu">CDU:</label>
+		<input type="text" id="cdu" name="cdu" value="{{.Materia.CDU}}"><br>
+		<button type="submit">Guardar</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para crear una materia o editar una existente (?id=)
+func editarMateria(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	materia := &Materia{}
+	if texto := r.FormValue("id"); texto != "" {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		if materia, err = listadomateria.BuscarID(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	if r.Method == http.MethodGet {
+		datos := struct {
+			CSRF     string
+			Materia  *Materia
+			Opciones []OpcionMateria
+		}{tokenCSRF(r), materia, opcionesMaterias([]int{materia.PadreID})}
+		if err := editSubjectTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	nombre := strings.TrimSpace(r.FormValue("nombre"))
+	if nombre == "" {
+		http.Error(w, "El nombre es obligatorio", http.StatusBadRequest)
+		return
+	}
+	var variantes []string
+	for _, v := range strings.Split(r.FormValue("variantes"), "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			variantes = append(variantes, v)
+		}
+	}
+	// Un nombre o variante no puede pertenecer a otra materia
+	for _, n := range append([]string{nombre}, variantes...) {
+		if otra := listadomateria.resolver(n); otra != nil && otra != materia {
+			http.Error(w, fmt.Sprintf("%q ya corresponde a la materia %s", n, otra.Nombre), http.StatusConflict)
+			return
+		}
+	}
+
+	padreID, err := strconv.Atoi(r.FormValue("padre"))
+	if err != nil {
+		padreID = 0
+	}
+	if padreID != 0 {
+		padre, err := listadomateria.BuscarID(padreID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if materia.MateriaID != 0 && listadomateria.pertenece(padre, materia.MateriaID) {
+			http.Error(w, "Una materia no puede estar dentro de sí misma ni de sus submaterias", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if materia.MateriaID == 0 {
+		materia.MateriaID = listadomateria.siguienteID()
+		listadomateria.Materias = append(listadomateria.Materias, materia)
+	}
+	materia.Nombre = nombre
+	materia.PadreID = padreID
+	materia.Variantes = variantes
+	materia.Dewey = strings.TrimSpace(r.FormValue("dewey"))
+	materia.CDU = strings.TrimSpace(r.FormValue("cdu"))
+
+	// El genero de los libros es el nombre de su primera materia y cambia con ella
+	renombrados := false
+	for _, l := range libreria.Libros {
+		if len(l.Materias) > 0 && l.Materias[0] == materia.MateriaID && l.Genero != nombre {
+			l.SetGenero(nombre)
+			renombrados = true
+		}
+	}
+	// Los nombres de las materias se indexan con sus libros
+	for _, l := range librosMateria(materia.MateriaID) {
+		indice.Agregar(l)
+	}
+	if err := saveToJSON(listadomateria.Materias, "materias.json"); err != nil {
+		http.Error(w, "Error al guardar las materias", http.StatusInternalServerError)
+		return
+	}
+	if renombrados {
+		if err := saveToJSON(libreria.Libros, "libros.json"); err != nil {
+			http.Error(w, "Error al guardar los libros", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, "/materias?id="+strconv.Itoa(materia.MateriaID), http.StatusSeeOther)
+}
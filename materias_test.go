@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// Filosofia > Estoicismo e Historia, con un libro de estoicismo y otro de historia
+func materiasPrueba(t *testing.T) []*Libro {
+	t.Helper()
+	enDirectorioTemporal(t)
+	anterior, anteriorIndice, anteriores := libreria, indice, listadomateria.Materias
+	t.Cleanup(func() { libreria, indice, listadomateria.Materias = anterior, anteriorIndice, anteriores })
+	listadomateria.Materias = []*Materia{
+		{MateriaID: 1, Nombre: "Filosofía", Variantes: []string{"Philosophy"}},
+		{MateriaID: 2, Nombre: "Estoicismo", PadreID: 1},
+		{MateriaID: 3, Nombre: "Historia"},
+	}
+	libros := []*Libro{
+		{LibroID: 1, Titulo: "Manual", Genero: "Estoicismo", Materias: []int{2}},
+		{LibroID: 2, Titulo: "Historia de Roma", Genero: "Historia", Materias: []int{3, 2}},
+	}
+	libreria = &Libreria{Libros: libros}
+	indice = nuevoIndice()
+	for _, l := range libros {
+		indice.Agregar(l)
+	}
+	return libros
+}
+
+func TestNombresMaterias(t *testing.T) {
+	libros := materiasPrueba(t)
+	if got := libros[0].nombresMaterias(); !slices.Equal(got, []string{"Filosofía", "Estoicismo"}) {
+		t.Errorf("materias del manual = %q", got)
+	}
+	if got := libros[1].nombresMaterias(); !slices.Equal(got, []string{"Historia", "Filosofía", "Estoicismo"}) {
+		t.Errorf("materias de la historia = %q", got)
+	}
+	if got := len(librosMateria(1)); got != 2 {
+		t.Errorf("libros de filosofía = %d", got)
+	}
+	if m := listadomateria.resolver("PHILOSOPHY"); m == nil || m.MateriaID != 1 {
+		t.Errorf("resolver por la variante = %+v", m)
+	}
+}
+
+func TestMigrarMaterias(t *testing.T) {
+	materiasPrueba(t)
+	libros := []*Libro{
+		{LibroID: 3, Genero: "filosofia"},
+		{LibroID: 4, Genero: "Poesía"},
+		{LibroID: 5, Genero: "Historia", Materias: []int{2}},
+		{LibroID: 6},
+	}
+	cambios := migrarMaterias(libros)
+	if len(cambios) != 1 || len(listadomateria.Materias) != 4 {
+		t.Fatalf("%d materias; cambios %q", len(listadomateria.Materias), cambios)
+	}
+	if !slices.Equal(libros[0].Materias, []int{1}) || !slices.Equal(libros[1].Materias, []int{4}) ||
+		!slices.Equal(libros[2].Materias, []int{2}) || libros[3].Materias != nil {
+		t.Errorf("materias migradas %v %v %v %v", libros[0].Materias, libros[1].Materias, libros[2].Materias, libros[3].Materias)
+	}
+}
+
+func TestEditarMateria(t *testing.T) {
+	libros := materiasPrueba(t)
+	editar := func(datos url.Values) int {
+		return enviarFormulario(editarMateria, datos).Code
+	}
+	if codigo := editar(url.Values{"id": {"3"}, "nombre": {"Historia"}, "variantes": {"philosophy"}}); codigo != http.StatusConflict {
+		t.Errorf("variante de otra materia = %d", codigo)
+	}
+	if codigo := editar(url.Values{"nombre": {"estoicismo"}}); codigo != http.StatusConflict {
+		t.Errorf("nombre repetido = %d", codigo)
+	}
+	if codigo := editar(url.Values{"id": {"1"}, "nombre": {"Filosofía"}, "padre": {"2"}}); codigo != http.StatusBadRequest {
+		t.Errorf("materia dentro de su submateria = %d", codigo)
+	}
+	if codigo := editar(url.Values{"id": {"1"}, "nombre": {"Filosofía"}, "padre": {"1"}}); codigo != http.StatusBadRequest {
+		t.Errorf("materia dentro de sí misma = %d", codigo)
+	}
+
+	// Al renombrar cambia el genero de los libros cuya primera materia es esta
+	if codigo := editar(url.Values{"id": {"2"}, "nombre": {"Estoicismo antiguo"}, "padre": {"1"}, "variantes": {"Stoicism"}}); codigo != http.StatusSeeOther {
+		t.Fatalf("renombrar = %d", codigo)
+	}
+	if libros[0].Genero != "Estoicismo antiguo" || libros[1].Genero != "Historia" {
+		t.Errorf("géneros %q y %q", libros[0].Genero, libros[1].Genero)
+	}
+	if res := indice.Buscar("stoicism", 10); len(res) != 2 {
+		t.Errorf("búsqueda por la variante nueva: %d resultados", len(res))
+	}
+	var guardados []*Libro
+	if err := loadFromJSON("libros.json", &guardados); err != nil || len(guardados) != 2 || guardados[0].Genero != "Estoicismo antiguo" {
+		t.Errorf("libros.json: %v", err)
+	}
+	var materias []*Materia
+	if err := loadFromJSON("materias.json", &materias); err != nil || len(materias) != 3 || materias[1].Nombre != "Estoicismo antiguo" {
+		t.Errorf("materias.json: %v", err)
+	}
+
+	if codigo := editar(url.Values{"nombre": {"Epicureísmo"}, "padre": {"1"}}); codigo != http.StatusSeeOther {
+		t.Fatalf("crear = %d", codigo)
+	}
+	if m, err := listadomateria.BuscarID(4); err != nil || m.PadreID != 1 {
+		t.Errorf("materia nueva %+v: %v", m, err)
+	}
+}
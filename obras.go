@@ -0,0 +1,384 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Obra: el texto creado por el autor, independiente de sus ediciones (modelo inspirado
+en FRBR). Cada Libro del catalogo es una edicion o traduccion de una obra, con su
+propia edicion, idioma, editorial y traductores. Las obras pueden formar parte de
+una serie con un numero de orden.
+*/
+type Obra struct {
+	ObraID         int    `json:"id"`
+	Titulo         string `json:"titulo"`
+	TituloOriginal string `json:"titulo_original,omitempty"`
+	Autor          string `json:"autor"`
+	IdiomaOriginal string `json:"idioma_original,omitempty"`
+	Serie          string `json:"serie,omitempty"`
+	NumeroSerie    int    `json:"numero_serie,omitempty"`
+}
+
+// Creamos la estructura obra con slice para guardar las obras
+type Listadoobra struct {
+	Obras []*Obra
+}
+
+var listadoobra Listadoobra
+
+var _ Busqueda[*Obra] = (*Listadoobra)(nil)
+
+// Busqueda de obras
+func (lo *Listadoobra) BuscarID(id int) (*Obra, error) {
+	for _, o := range lo.Obras {
+		if o.ObraID == id {
+			return o, nil
+		}
+	}
+	return nil, errors.New("obra no encontrada con el ID digitado")
+}
+
+func (lo *Listadoobra) BuscarNombre(nombre string) ([]*Obra, error) {
+	var resultados []*Obra
+	for _, o := range lo.Obras {
+		if contienePalabras(nombre, o.Titulo, o.TituloOriginal, o.Serie) {
+			resultados = append(resultados, o)
+		}
+	}
+	if len(resultados) == 0 {
+		return nil, errors.New("no existen obras con ese nombre")
+	}
+	return resultados, nil
+}
+
+func (lo *Listadoobra) siguienteID() int {
+	id := 0
+	for _, o := range lo.Obras {
+		id = max(id, o.ObraID)
+	}
+	return id + 1
+}
+
+// Obras de la serie ordenadas por su numero
+func (lo *Listadoobra) serie(nombre string) []*Obra {
+	var obras []*Obra
+	for _, o := range lo.Obras {
+		if nombre != "" && normalizarTexto(o.Serie) == normalizarTexto(nombre) {
+			obras = append(obras, o)
+		}
+	}
+	sort.SliceStable(obras, func(i, j int) bool { return obras[i].NumeroSerie < obras[j].NumeroSerie })
+	return obras
+}
+
+// Ediciones de la obra en el catalogo, de la mas antigua a la mas reciente
+func (o *Obra) Ediciones() []*Libro {
+	var ediciones []*Libro
+	for _, l := range libreria.Libros {
+		if l.ObraID == o.ObraID {
+			ediciones = append(ediciones, l)
+		}
+	}
+	sort.SliceStable(ediciones, func(i, j int) bool {
+		return ediciones[i].FechaPublicacion.Comparar(ediciones[j].FechaPublicacion) < 0
+	})
+	return ediciones
+}
+
+// Una obra esta disponible si alguna de sus ediciones tiene un ejemplar disponible
+func (o *Obra) Disponible() bool {
+	for _, l := range o.Ediciones() {
+		if libroDisponible(l.LibroID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Obra de la que el libro es una edicion
+func (l *Libro) ObraLibro() *Obra {
+	o, err := listadoobra.BuscarID(l.ObraID)
+	if err != nil {
+		return nil
+	}
+	return o
+}
+
+// Obra que corresponde al libro: la de otra edicion con el mismo titulo y autor, o una nueva
+func (lo *Listadoobra) obraPara(l *Libro) (*Obra, string) {
+	titulo := strings.Join(tokenizar(l.Titulo), " ")
+	for _, o := range lo.Obras {
+		mismoTitulo := strings.Join(tokenizar(o.Titulo), " ") == titulo ||
+			(o.TituloOriginal != "" && strings.Join(tokenizar(o.TituloOriginal), " ") == titulo)
+		if mismoTitulo && (normalizarTexto(o.Autor) == normalizarTexto(l.Autor) || mismoAutor(o.Autor, l.Autor)) {
+			return o, ""
+		}
+	}
+	o := &Obra{ObraID: lo.siguienteID(), Titulo: l.Titulo, Autor: l.Autor}
+	lo.Obras = append(lo.Obras, o)
+	return o, fmt.Sprintf("obra %d creada para el libro %d: %s", o.ObraID, l.LibroID, o.Titulo)
+}
+
+// Asigna una obra a los libros que aun no tienen, agrupando las ediciones del mismo titulo y autor
+func migrarObras(libros []*Libro) []string {
+	var cambios []string
+	for _, l := range libros {
+		if l.ObraID != 0 {
+			continue
+		}
+		o, cambio := listadoobra.obraPara(l)
+		if cambio != "" {
+			cambios = append(cambios, cambio)
+		}
+		l.ObraID = o.ObraID
+	}
+	return cambios
+}
+
+// Carga las obras al iniciar (obras.json o la semilla) y asigna una obra a los libros que no tienen
+func cargarObras(semilla []*Obra, libros []*Libro) error {
+	err := loadFromJSON("obras.json", &listadoobra.Obras)
+	if os.IsNotExist(err) {
+		listadoobra.Obras = semilla
+	} else if err != nil {
+		return err
+	}
+	for _, cambio := range migrarObras(libros) {
+		fmt.Fprintln(os.Stderr, cambio)
+	}
+	return nil
+}
+
+// Obra elegida en el formulario del libro; 0 crea o busca la obra a partir del titulo y el autor
+func obraFormulario(r *http.Request, l *Libro) error {
+	id, err := strconv.Atoi(cmp.Or(r.FormValue("obra"), "0"))
+	if err != nil {
+		return errors.New("el ID de la obra debe ser un número entero")
+	}
+	if id == 0 {
+		o, _ := listadoobra.obraPara(l)
+		id = o.ObraID
+	} else if _, err := listadoobra.BuscarID(id); err != nil {
+		return err
+	}
+	l.ObraID = id
+	l.Edicion = strings.TrimSpace(r.FormValue("edicion"))
+	l.Idioma = strings.TrimSpace(r.FormValue("idioma"))
+	l.Editorial = strings.TrimSpace(r.FormValue("editorial"))
+	return nil
+}
+
+// Obras ordenadas por titulo, para elegirlas en los formularios
+func obrasOrdenadas() []*Obra {
+	obras := append([]*Obra(nil), listadoobra.Obras...)
+	sort.Slice(obras, func(i, j int) bool {
+		return normalizarTexto(obras[i].Titulo) < normalizarTexto(obras[j].Titulo)
+	})
+	return obras
+}
+
+// Codigo HTML para la pagina de una obra con sus ediciones
+var workTemplate = template.Must(template.New("obra").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>{{if .Obra}}{{.Obra.Titulo}}{{else}}Obras{{end}}</title>
+</head>
+<body>
+	{{if .Obra}}
+	<h1>{{.Obra.Titulo}}</h1>
+	<p>{{.Obra.Autor}}{{with .Obra.TituloOriginal}} - título original: <em>{{.}}</em>{{end}}{{with .Obra.IdiomaOriginal}} ({{.}}){{end}}</p>
+	{{if .Serie}}
+	<p>Serie {{.Obra.Serie}}:
+		{{range .Serie}}<a href="/obra?id={{.ObraID}}">{{.NumeroSerie}}. {{.Titulo}}</a> {{end}}
+	</p>
+	{{end}}
+	<h2>{{len .Ediciones}} edición(es)</h2>
+	<table>
+		<tr><th></th><th>Título</th><th>Edición</th><th>Idioma</th><th>Traductor</th><th>Editorial</th><th>Fecha</th><th>Disponible</th><th></th></tr>
+		{{range .Ediciones}}
+		<tr>
+			<td>{{with .Libro.URLPortada "pequena"}}<img src="{{.}}" alt="" width="80">{{end}}</td>
+			<td>{{.Libro.Titulo}}</td>
+			<td>{{.Libro.Edicion}}</td>
+			<td>{{.Libro.Idioma}}</td>
+			<td>{{.Libro.NombresRol "traductor"}}</td>
+			<td>{{.Libro.Editorial}}</td>
+			<td>{{.Libro.FechaPublicacion}}</td>
+			<td>{{if .Disponible}}sí{{else}}no{{end}}</td>
+			<td>
+				<form action="/reservar" method="post">
+					<input type="hidden" name="csrf_token" value="{{$.CSRF}}">
+					<input type="hidden" name="obraID" value="{{$.Obra.ObraID}}">
+					<input type="hidden" name="libroID" value="{{.Libro.LibroID}}">
+					<button type="submit">Reservar esta edición</button>
+				</form>
+			</td>
+		</tr>
+		{{end}}
+	</table>
+	<form action="/reservar" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<input type="hidden" name="obraID" value="{{.Obra.ObraID}}">
+		<button type="submit">Reservar cualquier edición</button>
+	</form>
+	<p><a href="/obra">Todas las obras</a></p>
+	{{else}}
+	<h1>Obras</h1>
+	<ul>
+		{{range .Obras}}<li><a href="/obra?id={{.ObraID}}">{{.Titulo}}</a> - {{.Autor}}</li>{{end}}
+	</ul>
+	{{end}}
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Edicion de una obra y si tiene un ejemplar disponible
+type EdicionObra struct {
+	Libro      *Libro `json:"libro"`
+	Disponible bool   `json:"disponible"`
+}
+
+// Funcion para ver una obra con sus ediciones (?id=) o la lista de obras (formato=json para JSON)
+func verObra(w http.ResponseWriter, r *http.Request) {
+	datos := struct {
+		CSRF      string        `json:"-"`
+		Obra      *Obra         `json:"obra,omitempty"`
+		Serie     []*Obra       `json:"serie,omitempty"`
+		Ediciones []EdicionObra `json:"ediciones,omitempty"`
+		Obras     []*Obra       `json:"obras,omitempty"`
+	}{CSRF: tokenCSRF(r)}
+
+	if texto := r.URL.Query().Get("id"); texto != "" {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		if datos.Obra, err = listadoobra.BuscarID(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		datos.Serie = listadoobra.serie(datos.Obra.Serie)
+		for _, l := range datos.Obra.Ediciones() {
+			datos.Ediciones = append(datos.Ediciones, EdicionObra{l, libroDisponible(l.LibroID)})
+		}
+	} else {
+		datos.Obras = obrasOrdenadas()
+	}
+
+	if r.URL.Query().Get("formato") == "json" {
+		responderJSON(w, datos)
+		return
+	}
+	if err := workTemplate.Execute(w, datos); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Codigo HTML para crear o editar una obra
+var editWorkTemplate = template.Must(template.New("editarObra").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>{{if .Obra.ObraID}}Editar Obra{{else}}Crear Obra{{end}}</title>
+</head>
+<body>
+	<h1>{{if .Obra.ObraID}}Editar Obra {{.Obra.ObraID}}{{else}}Crear Obra{{end}}</h1>
+	<form action="/editar-obra" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		{{if .Obra.ObraID}}<input type="hidden" name="id" value="{{.Obra.ObraID}}">{{end}}
+		<label for="titulo">Título:</label>
+		<input type="text" id="titulo" name="titulo" value="{{.Obra.Titulo}}" required><br>
+		<label for="titulo_original">Título original:</label>
+		<input type="text" id="titulo_original" name="titulo_original" value="{{.Obra.TituloOriginal}}"><br>
+		<label for="autor">Autor:</label>
+		<input type="text" id="autor" name="autor" value="{{.Obra.Autor}}" required><br>
+		<label for="idioma_original">Idioma original:</label>
+		<input type="text" id="idioma_original" name="idioma_original" value="{{.Obra.IdiomaOriginal}}"><br>
+		<label for="serie">Serie:</label>
+		<input type="text" id="serie" name="serie" value="{{.Obra.Serie}}"><br>
+		<label for="numero_serie">Número en la serie:</label>
+		<input type="number" id="numero_serie" name="numero_serie" min="0" value="{{.Obra.NumeroSerie}}"><br>
+		<button type="submit">Guardar</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para crear una obra o editar una existente (?id=)
+func editarObra(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	obra := &Obra{}
+	if texto := r.FormValue("id"); texto != "" {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		if obra, err = listadoobra.BuscarID(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	if r.Method == http.MethodGet {
+		datos := struct {
+			CSRF string
+			Obra *Obra
+		}{tokenCSRF(r), obra}
+		if err := editWorkTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	titulo := strings.TrimSpace(r.FormValue("titulo"))
+	autor := strings.TrimSpace(r.FormValue("autor"))
+	if titulo == "" || autor == "" {
+		http.Error(w, "El título y el autor son obligatorios", http.StatusBadRequest)
+		return
+	}
+	numero, err := strconv.Atoi(cmp.Or(r.FormValue("numero_serie"), "0"))
+	if err != nil || numero < 0 {
+		http.Error(w, "El número en la serie debe ser un entero positivo", http.StatusBadRequest)
+		return
+	}
+
+	if obra.ObraID == 0 {
+		obra.ObraID = listadoobra.siguienteID()
+		listadoobra.Obras = append(listadoobra.Obras, obra)
+	}
+	obra.Titulo = titulo
+	obra.Autor = autor
+	obra.TituloOriginal = strings.TrimSpace(r.FormValue("titulo_original"))
+	obra.IdiomaOriginal = strings.TrimSpace(r.FormValue("idioma_original"))
+	obra.Serie = strings.TrimSpace(r.FormValue("serie"))
+	obra.NumeroSerie = numero
+
+	if err := saveToJSON(listadoobra.Obras, "obras.json"); err != nil {
+		http.Error(w, "Error al guardar las obras", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/obra?id="+strconv.Itoa(obra.ObraID), http.StatusSeeOther)
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configuracion del proveedor de identidad OpenID Connect
+type ConfigOIDC struct {
+	Emisor         string
+	ClienteID      string
+	ClienteSecreto string
+	URLRedireccion string
+	ClaimRoles     string // claim con los grupos del usuario, por ejemplo "groups"
+	GrupoAdmin     string // grupo que recibe el rol de administrador
+	Cliente        *http.Client
+}
+
+// Configuracion tomada de las variables de entorno
+func configOIDCEntorno() ConfigOIDC {
+	cfg := ConfigOIDC{
+		Emisor:         strings.TrimSuffix(os.Getenv("OIDC_EMISOR"), "/"),
+		ClienteID:      os.Getenv("OIDC_CLIENTE_ID"),
+		ClienteSecreto: os.Getenv("OIDC_CLIENTE_SECRETO"),
+		URLRedireccion: os.Getenv("OIDC_REDIRECCION"),
+		ClaimRoles:     os.Getenv("OIDC_CLAIM_ROLES"),
+		GrupoAdmin:     os.Getenv("OIDC_GRUPO_ADMIN"),
+	}
+	if cfg.URLRedireccion == "" {
+		cfg.URLRedireccion = urlBase() + "/oidc/callback"
+	}
+	if cfg.ClaimRoles == "" {
+		cfg.ClaimRoles = "groups"
+	}
+	return cfg
+}
+
+// Proveedor OIDC con los endpoints descubiertos y las claves publicas para validar tokens
+type ProveedorOIDC struct {
+	config        ConfigOIDC
+	autorizacion  string
+	tokenEndpoint string
+	jwksURI       string
+
+	mu     sync.Mutex
+	claves map[string]*rsa.PublicKey
+}
+
+// Claims del ID token que usa el sistema
+type ClaimsOIDC struct {
+	Emisor         string
+	Sujeto         string
+	Audiencia      []string
+	Expira         time.Time
+	Nonce          string
+	Mail           string
+	MailVerificado bool
+	Nombre         string
+	Grupos         []string
+}
+
+// Estado de un inicio de sesion en curso (PKCE y nonce)
+type estadoOIDC struct {
+	verificador string
+	nonce       string
+	expira      time.Time
+}
+
+var (
+	proveedorOIDC *ProveedorOIDC
+	estadosOIDC   = map[string]*estadoOIDC{}
+	muEstadosOIDC sync.Mutex
+)
+
+const cookieEstadoOIDC = "oidc_estado"
+
+func (p *ProveedorOIDC) cliente() *http.Client {
+	if p.config.Cliente != nil {
+		return p.config.Cliente
+	}
+	return http.DefaultClient
+}
+
+// Descubre los endpoints del proveedor a partir de su documento de configuracion
+func descubrirOIDC(cfg ConfigOIDC) (*ProveedorOIDC, error) {
+	if cfg.Emisor == "" || cfg.ClienteID == "" {
+		return nil, errors.New("falta el emisor o el cliente OIDC")
+	}
+	p := &ProveedorOIDC{config: cfg}
+
+	resp, err := p.cliente().Get(cfg.Emisor + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error al descubrir el proveedor OIDC: %s", resp.Status)
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JwksURI               string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != cfg.Emisor {
+		return nil, fmt.Errorf("el emisor %q no coincide con %q", doc.Issuer, cfg.Emisor)
+	}
+	p.autorizacion = doc.AuthorizationEndpoint
+	p.tokenEndpoint = doc.TokenEndpoint
+	p.jwksURI = doc.JwksURI
+	return p, nil
+}
+
+func aleatorioBase64(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Desafio PKCE con el metodo S256
+func desafioPKCE(verificador string) string {
+	suma := sha256.Sum256([]byte(verificador))
+	return base64.RawURLEncoding.EncodeToString(suma[:])
+}
+
+// URL del proveedor donde el usuario se autentica
+func (p *ProveedorOIDC) urlAutorizacion(estado, nonce, verificador string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.config.ClienteID)
+	v.Set("redirect_uri", p.config.URLRedireccion)
+	v.Set("scope", "openid email profile")
+	v.Set("state", estado)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", desafioPKCE(verificador))
+	v.Set("code_challenge_method", "S256")
+
+	separador := "?"
+	if strings.Contains(p.autorizacion, "?") {
+		separador = "&"
+	}
+	return p.autorizacion + separador + v.Encode()
+}
+
+// Intercambia el codigo de autorizacion por el ID token
+func (p *ProveedorOIDC) canjearCodigo(codigo, verificador string) (string, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", codigo)
+	v.Set("redirect_uri", p.config.URLRedireccion)
+	v.Set("code_verifier", verificador)
+	v.Set("client_id", p.config.ClienteID)
+
+	req, err := http.NewRequest(http.MethodPost, p.tokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClienteSecreto != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClienteID), url.QueryEscape(p.config.ClienteSecreto))
+	}
+
+	resp, err := p.cliente().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var cuerpo struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cuerpo); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || cuerpo.Error != "" {
+		return "", fmt.Errorf("error al canjear el código: %s %s", cuerpo.Error, cuerpo.ErrorDescription)
+	}
+	if cuerpo.IDToken == "" {
+		return "", errors.New("el proveedor no devolvió un ID token")
+	}
+	return cuerpo.IDToken, nil
+}
+
+// Descarga las claves publicas RSA del proveedor
+func (p *ProveedorOIDC) cargarClaves() error {
+	resp, err := p.cliente().Get(p.jwksURI)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return err
+	}
+
+	claves := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		claves[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	p.claves = claves
+	p.mu.Unlock()
+	return nil
+}
+
+// Busca la clave del token y vuelve a descargar las claves si el proveedor las rotó
+func (p *ProveedorOIDC) clave(kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	k, ok := p.claves[kid]
+	p.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	if err := p.cargarClaves(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.claves[kid]; ok {
+		return k, nil
+	}
+	return nil, errors.New("clave de firma desconocida")
+}
+
+// Valida la firma RS256 y los claims del ID token
+func (p *ProveedorOIDC) verificarIDToken(token, nonce string) (*ClaimsOIDC, error) {
+	partes := strings.Split(token, ".")
+	if len(partes) != 3 {
+		return nil, errors.New("ID token mal formado")
+	}
+
+	var cabecera struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodificarSegmento(partes[0], &cabecera); err != nil {
+		return nil, err
+	}
+	if cabecera.Alg != "RS256" {
+		return nil, errors.New("algoritmo de firma no soportado: " + cabecera.Alg)
+	}
+	clave, err := p.clave(cabecera.Kid)
+	if err != nil {
+		return nil, err
+	}
+	firma, err := base64.RawURLEncoding.DecodeString(partes[2])
+	if err != nil {
+		return nil, errors.New("firma mal formada")
+	}
+	suma := sha256.Sum256([]byte(partes[0] + "." + partes[1]))
+	if err := rsa.VerifyPKCS1v15(clave, crypto.SHA256, suma[:], firma); err != nil {
+		return nil, errors.New("firma del ID token inválida")
+	}
+
+	var datos map[string]json.RawMessage
+	if err := decodificarSegmento(partes[1], &datos); err != nil {
+		return nil, err
+	}
+	claims := &ClaimsOIDC{}
+	var exp int64
+	json.Unmarshal(datos["iss"], &claims.Emisor)
+	json.Unmarshal(datos["sub"], &claims.Sujeto)
+	json.Unmarshal(datos["exp"], &exp)
+	json.Unmarshal(datos["nonce"], &claims.Nonce)
+	json.Unmarshal(datos["email"], &claims.Mail)
+	json.Unmarshal(datos["email_verified"], &claims.MailVerificado)
+	json.Unmarshal(datos["name"], &claims.Nombre)
+	claims.Audiencia = listaClaim(datos["aud"])
+	claims.Grupos = listaClaim(datos[p.config.ClaimRoles])
+	claims.Expira = time.Unix(exp, 0)
+
+	if strings.TrimSuffix(claims.Emisor, "/") != p.config.Emisor {
+		return nil, errors.New("emisor del ID token inválido")
+	}
+	if !contiene(claims.Audiencia, p.config.ClienteID) {
+		return nil, errors.New("el ID token no está dirigido a este cliente")
+	}
+	if time.Now().After(claims.Expira.Add(time.Minute)) {
+		return nil, errors.New("el ID token ha expirado")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce del ID token inválido")
+	}
+	if claims.Sujeto == "" {
+		return nil, errors.New("el ID token no tiene sujeto")
+	}
+	return claims, nil
+}
+
+func decodificarSegmento(segmento string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segmento)
+	if err != nil {
+		return errors.New("ID token mal formado")
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Los claims como aud o groups pueden ser un texto o una lista
+func listaClaim(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var lista []string
+	if err := json.Unmarshal(raw, &lista); err == nil {
+		return lista
+	}
+	var uno string
+	if err := json.Unmarshal(raw, &uno); err == nil && uno != "" {
+		return []string{uno}
+	}
+	return nil
+}
+
+func contiene(lista []string, valor string) bool {
+	for _, v := range lista {
+		if v == valor {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Relaciona los claims con una cuenta local. Primero por el sujeto del proveedor,
+luego por correo verificado y si no existe se crea la cuenta (aprovisionamiento
+justo a tiempo). Un correo sin verificar que ya tiene cuenta se rechaza. Todos son usuarios y quienes pertenecen al grupo de administradores
+reciben tambien ese rol, que pierden al salir del grupo solo si lo otorgo el proveedor.
+*/
+func cuentaOIDC(cfg ConfigOIDC, claims *ClaimsOIDC) (*Cuenta, error) {
+	muCuentas.Lock()
+	defer muCuentas.Unlock()
+	sujeto := cfg.Emisor + "#" + claims.Sujeto
+
+	var c *Cuenta
+	for _, cuenta := range listadocuenta.Cuentas {
+		if cuenta.SujetoOIDC == sujeto {
+			c = cuenta
+			break
+		}
+	}
+	if c == nil && claims.Mail != "" {
+		c = buscarCuentaMail(claims.Mail)
+		// Sin verificar no se vincula, y tampoco se crea otra cuenta con el mismo correo
+		if c != nil && !claims.MailVerificado {
+			return nil, errors.New("el correo ya pertenece a una cuenta y el proveedor no lo verificó")
+		}
+	}
+	if c == nil {
+		if claims.Mail == "" {
+			return nil, errors.New("el proveedor no entregó el correo del usuario")
+		}
+		nombre := claims.Nombre
+		if nombre == "" {
+			nombre = claims.Mail
+		}
+		c = &Cuenta{
+			CuentaID:       siguienteCuentaID(),
+			Nombre:         nombre,
+			Mail:           claims.Mail,
+			FechaCreacion:  time.Now(),
+			Estado:         EstadoActiva,
+			MailVerificado: claims.MailVerificado,
+		}
+		listadocuenta.Cuentas = append(listadocuenta.Cuentas, c)
+	}
+	if !c.EstaActiva() {
+		return nil, errors.New("la cuenta no está activa")
+	}
+
+	c.SujetoOIDC = sujeto
+	c.AgregarRol(RolUsuario)
+	// Solo se quita el rol de administrador que otorgo el proveedor, no el asignado localmente
+	otorgado := slices.Contains(c.RolesOIDC, RolAdministrador)
+	switch {
+	case cfg.GrupoAdmin != "" && contiene(claims.Grupos, cfg.GrupoAdmin):
+		if !c.TieneRol(RolAdministrador) {
+			c.AgregarRol(RolAdministrador)
+			c.RolesOIDC = append(c.RolesOIDC, RolAdministrador)
+		}
+	case otorgado:
+		c.QuitarRol(RolAdministrador)
+		c.RolesOIDC = nil
+	}
+	return c, nil
+}
+
+// Funcion que inicia el inicio de sesion con el proveedor de identidad
+func loginOIDC(w http.ResponseWriter, r *http.Request) {
+	if proveedorOIDC == nil {
+		http.Error(w, "El inicio de sesión institucional no está configurado", http.StatusNotFound)
+		return
+	}
+
+	estado, err1 := aleatorioBase64(32)
+	nonce, err2 := aleatorioBase64(32)
+	verificador, err3 := aleatorioBase64(48)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		http.Error(w, "Error al iniciar sesión", http.StatusInternalServerError)
+		return
+	}
+
+	muEstadosOIDC.Lock()
+	for k, e := range estadosOIDC {
+		if time.Now().After(e.expira) {
+			delete(estadosOIDC, k)
+		}
+	}
+	estadosOIDC[estado] = &estadoOIDC{verificador: verificador, nonce: nonce, expira: time.Now().Add(10 * time.Minute)}
+	muEstadosOIDC.Unlock()
+
+	// La cookie asocia el estado con este navegador
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieEstadoOIDC,
+		Value:    estado,
+		Path:     "/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, proveedorOIDC.urlAutorizacion(estado, nonce, verificador), http.StatusFound)
+}
+
+// Funcion que recibe la respuesta del proveedor de identidad
+func callbackOIDC(w http.ResponseWriter, r *http.Request) {
+	if proveedorOIDC == nil {
+		http.Error(w, "El inicio de sesión institucional no está configurado", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "El proveedor rechazó el inicio de sesión: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	estado := q.Get("state")
+	cookie, err := r.Cookie(cookieEstadoOIDC)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(estado)) != 1 {
+		http.Error(w, "Estado de inicio de sesión inválido", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: cookieEstadoOIDC, Value: "", Path: "/oidc", MaxAge: -1})
+
+	muEstadosOIDC.Lock()
+	e, ok := estadosOIDC[estado]
+	delete(estadosOIDC, estado)
+	muEstadosOIDC.Unlock()
+	if !ok || time.Now().After(e.expira) {
+		http.Error(w, "El inicio de sesión expiró, intente nuevamente", http.StatusBadRequest)
+		return
+	}
+
+	idToken, err := proveedorOIDC.canjearCodigo(q.Get("code"), e.verificador)
+	if err != nil {
+		log.Println("Error OIDC:", err)
+		http.Error(w, "No se pudo completar el inicio de sesión", http.StatusBadGateway)
+		return
+	}
+	claims, err := proveedorOIDC.verificarIDToken(idToken, e.nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	c, err := cuentaOIDC(proveedorOIDC.config, claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err := iniciarSesion(w, r, c); err != nil {
+		http.Error(w, "Error al iniciar sesión", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Proveedor OIDC local con endpoints de autorizacion, token y claves
+type proveedorPrueba struct {
+	srv      *httptest.Server
+	clave    *rsa.PrivateKey
+	codigos  map[string]url.Values // codigo entregado -> parametros de la autorizacion
+	usuario  map[string]any        // claims del usuario que inicia sesion
+	canjeado bool
+}
+
+func nuevoProveedorPrueba(t *testing.T) *proveedorPrueba {
+	t.Helper()
+	clave, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proveedorPrueba{clave: clave, codigos: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.srv.URL,
+			"authorization_endpoint": p.srv.URL + "/authorize",
+			"token_endpoint":         p.srv.URL + "/token",
+			"jwks_uri":               p.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "clave-1",
+			"n":   base64.RawURLEncoding.EncodeToString(clave.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(clave.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "falta PKCE", http.StatusBadRequest)
+			return
+		}
+		codigo := "codigo-" + strconv.Itoa(len(p.codigos)+1)
+		p.codigos[codigo] = q
+		destino := q.Get("redirect_uri") + "?" + url.Values{"code": {codigo}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, destino, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		autorizacion, ok := p.codigos[r.FormValue("code")]
+		delete(p.codigos, r.FormValue("code"))
+		if !ok || desafioPKCE(r.FormValue("code_verifier")) != autorizacion.Get("code_challenge") ||
+			r.FormValue("redirect_uri") != autorizacion.Get("redirect_uri") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]any{
+			"iss":   p.srv.URL,
+			"aud":   autorizacion.Get("client_id"),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": autorizacion.Get("nonce"),
+		}
+		for k, v := range p.usuario {
+			claims[k] = v
+		}
+		p.canjeado = true
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.firmar(t, claims)})
+	})
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *proveedorPrueba) firmar(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	segmento := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	datos := segmento(map[string]string{"alg": "RS256", "kid": "clave-1"}) + "." + segmento(claims)
+	suma := sha256.Sum256([]byte(datos))
+	firma, err := rsa.SignPKCS1v15(rand.Reader, p.clave, crypto.SHA256, suma[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return datos + "." + base64.RawURLEncoding.EncodeToString(firma)
+}
+
+func (p *proveedorPrueba) config() ConfigOIDC {
+	return ConfigOIDC{
+		Emisor:         p.srv.URL,
+		ClienteID:      "biblioteca",
+		URLRedireccion: "http://biblioteca.local/oidc/callback",
+		ClaimRoles:     "groups",
+		GrupoAdmin:     "bibliotecarios",
+		Cliente:        p.srv.Client(),
+	}
+}
+
+// Recorre /oidc/login, el proveedor y /oidc/callback, y devuelve la respuesta del callback
+func iniciarSesionPrueba(t *testing.T, p *proveedorPrueba) *httptest.ResponseRecorder {
+	t.Helper()
+	login := httptest.NewRecorder()
+	loginOIDC(login, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+	if login.Code != http.StatusFound {
+		t.Fatalf("login: código %d", login.Code)
+	}
+
+	// El navegador sigue la redireccion al proveedor, que vuelve al callback con el codigo
+	cliente := p.srv.Client()
+	cliente.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := cliente.Get(login.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("autorización: %s", resp.Status)
+	}
+
+	callback := httptest.NewRequest(http.MethodGet, resp.Header.Get("Location"), nil)
+	for _, c := range login.Result().Cookies() {
+		callback.AddCookie(c)
+	}
+	respuesta := httptest.NewRecorder()
+	callbackOIDC(respuesta, callback)
+	return respuesta
+}
+
+func TestInicioSesionOIDC(t *testing.T) {
+	enDirectorioTemporal(t)
+	p := nuevoProveedorPrueba(t)
+	proveedor, err := descubrirOIDC(p.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	anteriorProveedor, anterioresCuentas := proveedorOIDC, listadocuenta.Cuentas
+	t.Cleanup(func() { proveedorOIDC, listadocuenta.Cuentas = anteriorProveedor, anterioresCuentas })
+	proveedorOIDC = proveedor
+	listadocuenta.Cuentas = nil
+
+	p.usuario = map[string]any{"sub": "u-1", "email": "ana@uni.edu", "email_verified": true, "name": "Ana", "groups": []string{"bibliotecarios"}}
+	respuesta := iniciarSesionPrueba(t, p)
+	if respuesta.Code != http.StatusSeeOther || !p.canjeado {
+		t.Fatalf("callback: código %d, %s", respuesta.Code, respuesta.Body)
+	}
+	c := buscarCuentaMail("ana@uni.edu")
+	if c == nil || !c.TieneRol(RolAdministrador) || c.SujetoOIDC != p.srv.URL+"#u-1" {
+		t.Fatalf("cuenta aprovisionada: %+v", c)
+	}
+	var sesion bool
+	for _, ck := range respuesta.Result().Cookies() {
+		sesion = sesion || ck.Name == cookieSesion && ck.Value != ""
+	}
+	if !sesion {
+		t.Fatal("el callback no inició la sesión")
+	}
+
+	// Al salir del grupo pierde el rol que le dio el proveedor
+	p.usuario["groups"] = []string{"alumnos"}
+	if respuesta := iniciarSesionPrueba(t, p); respuesta.Code != http.StatusSeeOther {
+		t.Fatalf("segundo inicio: código %d", respuesta.Code)
+	}
+	if c.TieneRol(RolAdministrador) {
+		t.Fatal("la cuenta conserva el rol de administrador otorgado por el proveedor")
+	}
+
+	// Sin la cookie del estado el callback se rechaza
+	callback := httptest.NewRecorder()
+	callbackOIDC(callback, httptest.NewRequest(http.MethodGet, "/oidc/callback?code=codigo-1&state=x", nil))
+	if callback.Code != http.StatusBadRequest {
+		t.Fatalf("callback sin estado: código %d", callback.Code)
+	}
+}
+
+func TestCuentaOIDCRoles(t *testing.T) {
+	cfg := ConfigOIDC{Emisor: "https://idp", GrupoAdmin: "bibliotecarios"}
+	casos := []struct {
+		nombre     string
+		local      *Cuenta // cuenta existente con el mismo correo
+		grupoAdmin string
+		grupos     [][]string // grupos en cada inicio de sesion
+		admin      bool
+	}{
+		{"administrador local sin grupo configurado", &Cuenta{CuentaID: 1, Mail: "ana@uni.edu", Roles: []Rol{RolAdministrador}}, "", [][]string{nil}, true},
+		{"administrador local fuera del grupo", &Cuenta{CuentaID: 1, Mail: "ana@uni.edu", Roles: []Rol{RolAdministrador}}, "bibliotecarios", [][]string{{"alumnos"}}, true},
+		{"administrador local que entra y sale del grupo", &Cuenta{CuentaID: 1, Mail: "ana@uni.edu", Roles: []Rol{RolAdministrador}}, "bibliotecarios", [][]string{{"bibliotecarios"}, nil}, true},
+		{"usuario local que entra al grupo", &Cuenta{CuentaID: 1, Mail: "ana@uni.edu", Roles: []Rol{RolUsuario}}, "bibliotecarios", [][]string{{"bibliotecarios"}}, true},
+		{"usuario local que entra y sale del grupo", &Cuenta{CuentaID: 1, Mail: "ana@uni.edu", Roles: []Rol{RolUsuario}}, "bibliotecarios", [][]string{{"bibliotecarios"}, {"alumnos"}}, false},
+		{"cuenta nueva sin grupo", nil, "bibliotecarios", [][]string{nil}, false},
+	}
+	anteriores := listadocuenta.Cuentas
+	t.Cleanup(func() { listadocuenta.Cuentas = anteriores })
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			listadocuenta.Cuentas = nil
+			if caso.local != nil {
+				listadocuenta.Cuentas = []*Cuenta{caso.local}
+			}
+			cfg.GrupoAdmin = caso.grupoAdmin
+			var c *Cuenta
+			for _, grupos := range caso.grupos {
+				var err error
+				c, err = cuentaOIDC(cfg, &ClaimsOIDC{Sujeto: "u-1", Mail: "ana@uni.edu", MailVerificado: true, Grupos: grupos})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.TieneRol(RolAdministrador) != caso.admin || !c.TieneRol(RolUsuario) {
+				t.Errorf("roles = %v", c.Roles)
+			}
+			if caso.local != nil && c != caso.local {
+				t.Error("no se vinculó la cuenta local por correo")
+			}
+		})
+	}
+}
+
+func TestCuentaOIDCCorreoSinVerificar(t *testing.T) {
+	anteriores := listadocuenta.Cuentas
+	t.Cleanup(func() { listadocuenta.Cuentas = anteriores })
+	local := &Cuenta{CuentaID: 1, Mail: "ana@uni.edu", Roles: []Rol{RolAdministrador}}
+	listadocuenta.Cuentas = []*Cuenta{local}
+
+	// No se vincula con la cuenta local ni se crea otra con el mismo correo
+	c, err := cuentaOIDC(ConfigOIDC{Emisor: "https://idp"}, &ClaimsOIDC{Sujeto: "u-9", Mail: "ANA@uni.edu"})
+	if err == nil || c != nil {
+		t.Fatalf("se aceptó un correo sin verificar que ya tiene cuenta: %+v", c)
+	}
+	if len(listadocuenta.Cuentas) != 1 || local.SujetoOIDC != "" {
+		t.Errorf("quedaron %d cuentas y la local tiene el sujeto %q", len(listadocuenta.Cuentas), local.SujetoOIDC)
+	}
+
+	// Un correo sin verificar que no tiene cuenta si crea una
+	c, err = cuentaOIDC(ConfigOIDC{Emisor: "https://idp"}, &ClaimsOIDC{Sujeto: "u-10", Mail: "luis@uni.edu"})
+	if err != nil || c.CuentaID != 2 || c.MailVerificado {
+		t.Errorf("cuenta nueva %+v: %v", c, err)
+	}
+}
@@ -0,0 +1,890 @@
+package main
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tipos de contenido y relaciones de OPDS 1.2 (Atom) y OPDS 2.0 (JSON)
+const (
+	tipoOPDSNavegacion  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	tipoOPDSAdquisicion = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	tipoOPDS2           = "application/opds+json"
+	tipoOpenSearch      = "application/opensearchdescription+xml"
+	relAdquirir         = "http://opds-spec.org/acquisition"
+	relPrestar          = "http://opds-spec.org/acquisition/borrow"
+	relImagen           = "http://opds-spec.org/image"
+	relMiniatura        = "http://opds-spec.org/image/thumbnail"
+	librosPorPaginaOPDS = 25
+)
+
+// Tiempo que sirve el enlace de prestamo de un feed; las aplicaciones piden el feed de nuevo al navegar
+const plazoEnlacePrestamoOPDS = 24 * time.Hour
+
+// Fallos de credenciales HTTP Basic que se aceptan de una direccion en la ventana antes de bloquearla
+const (
+	maxFallosBasica     = 10
+	ventanaFallosBasica = 15 * time.Minute
+)
+
+/*
+Catalogo OPDS independiente del formato: el mismo feed se escribe en Atom para
+OPDS 1.2 (/opds/...) y en JSON para OPDS 2.0 (/opds/v2/...). Un feed de
+navegacion tiene Navegacion y uno de adquisicion tiene Libros.
+*/
+type feedOPDS struct {
+	Titulo      string
+	Ruta        string // ruta del feed sin el prefijo de la version, para el id y el enlace self
+	Adquisicion bool   // feed con libros en lugar de navegacion
+	Enlaces     []enlaceOPDS
+	Navegacion  []entradaNavegacionOPDS
+	Libros      []*Libro
+	Total       int
+	Pagina      int
+	Cuenta      *Cuenta // cuenta que pide el feed, para los enlaces de adquisicion; puede ser nil
+}
+
+type enlaceOPDS struct {
+	Rel       string
+	Href      string
+	Tipo      string
+	Titulo    string
+	Plantilla bool   // href con variables (OPDS 2.0)
+	Estado    string // available o unavailable, en los enlaces de prestamo
+	Total     int    // licencias del libro, en los enlaces de prestamo
+	Libres    int
+}
+
+type entradaNavegacionOPDS struct {
+	Titulo   string
+	Ruta     string
+	Cantidad int
+}
+
+/*
+Cuenta que pide el catalogo: la de la sesion del navegador o la de las credenciales
+HTTP Basic (correo y contraseña) que envian las aplicaciones de lectura.
+*/
+func cuentaOPDS(r *http.Request) *Cuenta {
+	if c := cuentaActual(r); c != nil {
+		return c
+	}
+	return cuentaBasica(r)
+}
+
+// Fallos de credenciales de una direccion desde el primero de la ventana actual
+type fallosBasica struct {
+	cantidad int
+	desde    time.Time
+}
+
+var (
+	fallosCredenciales   = map[string]*fallosBasica{}
+	muFallosCredenciales sync.Mutex
+)
+
+// Direccion IP de la peticion, sin el puerto
+func direccionCliente(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Indica si la direccion agoto sus intentos de credenciales en la ventana actual
+func credencialesBloqueadas(ip string, ahora time.Time) bool {
+	muFallosCredenciales.Lock()
+	defer muFallosCredenciales.Unlock()
+	f, ok := fallosCredenciales[ip]
+	return ok && ahora.Sub(f.desde) < ventanaFallosBasica && f.cantidad >= maxFallosBasica
+}
+
+// Anota un fallo de la direccion y olvida las ventanas que ya pasaron
+func anotarFalloCredenciales(ip string, ahora time.Time) {
+	muFallosCredenciales.Lock()
+	defer muFallosCredenciales.Unlock()
+	for otra, f := range fallosCredenciales {
+		if ahora.Sub(f.desde) >= ventanaFallosBasica {
+			delete(fallosCredenciales, otra)
+		}
+	}
+	f, ok := fallosCredenciales[ip]
+	if !ok {
+		f = &fallosBasica{desde: ahora}
+		fallosCredenciales[ip] = f
+	}
+	f.cantidad++
+}
+
+/*
+Cuenta de las credenciales HTTP Basic de la peticion, o nil si no son validas. Una
+direccion con maxFallosBasica fallos en ventanaFallosBasica no puede seguir probando
+contraseñas: sus credenciales se rechazan sin comprobarlas hasta que pase la ventana.
+*/
+func cuentaBasica(r *http.Request) *Cuenta {
+	mail, contrasena, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+	ip, ahora := direccionCliente(r), time.Now()
+	if credencialesBloqueadas(ip, ahora) {
+		return nil
+	}
+	c := buscarCuentaMail(mail)
+	if c == nil || !c.EstaActiva() || !c.ComprobarContrasena(contrasena) {
+		anotarFalloCredenciales(ip, ahora)
+		return nil
+	}
+	return c
+}
+
+// Pide las credenciales a la aplicacion de lectura
+func pedirCredenciales(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Biblioteca", charset="UTF-8"`)
+	http.Error(w, "Ingrese su correo y contraseña de la biblioteca", http.StatusUnauthorized)
+}
+
+// Libros con archivo electronico, los unicos que se pueden adquirir desde el catalogo OPDS
+func librosElectronicos(libros []*Libro) []*Libro {
+	var electronicos []*Libro
+	for _, l := range libros {
+		if len(l.Archivos) > 0 {
+			electronicos = append(electronicos, l)
+		}
+	}
+	return electronicos
+}
+
+// Firma del enlace de prestamo de un feed; el prefijo la distingue de la de los enlaces de lectura
+func firmarPrestamoOPDS(cuentaID, libroID int, formato string, expira int64) string {
+	mac := hmac.New(sha256.New, claveEnlaces)
+	fmt.Fprintf(mac, "prestar|%d|%d|%s|%d", cuentaID, libroID, formato, expira)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Enlace de prestamo del archivo del libro, firmado para la cuenta y con plazoEnlacePrestamoOPDS
+func enlacePrestamoOPDS(cuentaID, libroID int, formato string, ahora time.Time) string {
+	expira := ahora.Add(plazoEnlacePrestamoOPDS).Unix()
+	v := url.Values{}
+	v.Set("libroID", strconv.Itoa(libroID))
+	v.Set("formato", formato)
+	v.Set("expira", strconv.FormatInt(expira, 10))
+	v.Set("firma", firmarPrestamoOPDS(cuentaID, libroID, formato, expira))
+	return urlBase() + "/opds/prestar?" + v.Encode()
+}
+
+// Indica si el enlace de prestamo de la peticion esta firmado para la cuenta y sigue vigente
+func prestamoOPDSFirmado(v url.Values, cuentaID, libroID int, ahora time.Time) bool {
+	expira, err := strconv.ParseInt(v.Get("expira"), 10, 64)
+	if err != nil || !ahora.Before(time.Unix(expira, 0)) {
+		return false
+	}
+	esperada := firmarPrestamoOPDS(cuentaID, libroID, v.Get("formato"), expira)
+	return hmac.Equal([]byte(esperada), []byte(v.Get("firma")))
+}
+
+/*
+Enlaces de adquisicion de cada archivo del libro segun la cuenta: con un prestamo
+vigente se descarga directamente; si no y el libro tiene licencias, el enlace es
+de prestamo e indica cuantas licencias quedan libres. Sin licencias solo lo puede
+descargar quien tiene un prestamo del ejemplar fisico. El enlace de prestamo va
+firmado para la cuenta del feed; sin cuenta lleva solo el libro y el formato.
+*/
+func enlacesAdquisicion(l *Libro, cuenta *Cuenta, ahora time.Time) []enlaceOPDS {
+	prestado := cuenta != nil && prestamoActivo(cuenta.CuentaID, l.LibroID, ahora) != nil
+	var enlaces []enlaceOPDS
+	for _, a := range l.Archivos {
+		v := url.Values{}
+		v.Set("libroID", strconv.Itoa(l.LibroID))
+		v.Set("formato", a.Formato)
+		enlace := enlaceOPDS{Rel: relAdquirir, Href: urlBase() + "/opds/descargar?" + v.Encode(), Tipo: tipoContenido(a.Formato)}
+		if !prestado && l.EsElectronico() {
+			enlace.Rel = relPrestar
+			enlace.Href = urlBase() + "/opds/prestar?" + v.Encode()
+			if cuenta != nil {
+				enlace.Href = enlacePrestamoOPDS(cuenta.CuentaID, l.LibroID, a.Formato, ahora)
+			}
+			enlace.Total = l.Licencias
+			enlace.Libres = l.LicenciasDisponibles(ahora)
+			enlace.Estado = "available"
+			if enlace.Libres == 0 {
+				enlace.Estado = "unavailable"
+			}
+		}
+		enlaces = append(enlaces, enlace)
+	}
+	return enlaces
+}
+
+// Enlaces a la portada y a la miniatura del libro
+func enlacesImagen(l *Libro) []enlaceOPDS {
+	if l.Portada == "" {
+		return nil
+	}
+	return []enlaceOPDS{
+		{Rel: relImagen, Href: urlBase() + l.URLPortada("grande"), Tipo: "image/jpeg"},
+		{Rel: relMiniatura, Href: urlBase() + l.URLPortada("pequena"), Tipo: "image/jpeg"},
+	}
+}
+
+// Identificador permanente del libro en el catalogo: el ISBN si lo tiene
+func idLibroOPDS(l *Libro) string {
+	if l.ISBN != "" {
+		return "urn:isbn:" + l.ISBN
+	}
+	return urlBase() + "/opds/libros?id=" + strconv.Itoa(l.LibroID)
+}
+
+// Fecha de actualizacion de la entrada: la del archivo subido mas reciente
+func actualizadoLibro(l *Libro) time.Time {
+	var fecha time.Time
+	for _, a := range l.Archivos {
+		if a.Subido.After(fecha) {
+			fecha = a.Subido
+		}
+	}
+	return fecha
+}
+
+// Feed de inicio con la navegacion por materia y por autor y la busqueda
+func feedRaizOPDS(cuenta *Cuenta) *feedOPDS {
+	f := &feedOPDS{Titulo: "Biblioteca", Cuenta: cuenta}
+	f.Navegacion = []entradaNavegacionOPDS{
+		{"Todos los libros", "/libros", len(librosElectronicos(libreria.Libros))},
+		{"Por materia", "/materias", 0},
+		{"Por autor", "/autores", 0},
+	}
+	if cuenta != nil {
+		f.Navegacion = append(f.Navegacion, entradaNavegacionOPDS{"Mis préstamos", "/libros?prestados=si", 0})
+	}
+	return f
+}
+
+// Feed con las materias que tienen libros electronicos, incluidas sus submaterias
+func feedMateriasOPDS() *feedOPDS {
+	f := &feedOPDS{Titulo: "Materias", Ruta: "/materias"}
+	for _, m := range listadomateria.Materias {
+		if n := len(librosElectronicos(librosMateria(m.MateriaID))); n > 0 {
+			f.Navegacion = append(f.Navegacion, entradaNavegacionOPDS{listadomateria.etiqueta(m), "/libros?materia=" + strconv.Itoa(m.MateriaID), n})
+		}
+	}
+	sort.Slice(f.Navegacion, func(i, j int) bool {
+		return normalizarTexto(f.Navegacion[i].Titulo) < normalizarTexto(f.Navegacion[j].Titulo)
+	})
+	return f
+}
+
+// Libros en los que participa el autor con cualquier rol
+func librosAutor(autorID int) []*Libro {
+	var libros []*Libro
+	for _, l := range libreria.Libros {
+		for _, p := range l.Autores {
+			if p.AutorID == autorID {
+				libros = append(libros, l)
+				break
+			}
+		}
+	}
+	return libros
+}
+
+// Feed con los autores que tienen libros electronicos
+func feedAutoresOPDS() *feedOPDS {
+	f := &feedOPDS{Titulo: "Autores", Ruta: "/autores"}
+	for _, a := range listadoautor.Autores {
+		if n := len(librosElectronicos(librosAutor(a.AutorID))); n > 0 {
+			f.Navegacion = append(f.Navegacion, entradaNavegacionOPDS{a.Nombre, "/libros?autor=" + strconv.Itoa(a.AutorID), n})
+		}
+	}
+	sort.Slice(f.Navegacion, func(i, j int) bool {
+		return normalizarTexto(f.Navegacion[i].Titulo) < normalizarTexto(f.Navegacion[j].Titulo)
+	})
+	return f
+}
+
+/*
+Feed de adquisicion con los libros electronicos que cumplen los filtros: id,
+materia, autor, q (busqueda en el indice; OPDS 2.0 la envia como query) y
+prestados=si para los libros con prestamo vigente de la cuenta. Se pagina con pagina.
+*/
+func feedLibrosOPDS(params url.Values, cuenta *Cuenta, ahora time.Time) (*feedOPDS, int, string) {
+	f := &feedOPDS{Titulo: "Libros", Adquisicion: true, Cuenta: cuenta, Pagina: 1}
+	libros := append([]*Libro(nil), libreria.Libros...)
+	ordenar := true
+	filtros := url.Values{}
+
+	if texto := params.Get("id"); texto != "" {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			return nil, http.StatusBadRequest, "El ID del libro debe ser un número entero"
+		}
+		libro, err := libreria.BuscarID(id)
+		if err != nil {
+			return nil, http.StatusNotFound, err.Error()
+		}
+		libros = []*Libro{libro}
+		f.Titulo = libro.Titulo
+		filtros.Set("id", texto)
+	}
+	if texto := params.Get("materia"); texto != "" {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			return nil, http.StatusBadRequest, "El ID de la materia debe ser un número entero"
+		}
+		m, err := listadomateria.BuscarID(id)
+		if err != nil {
+			return nil, http.StatusNotFound, err.Error()
+		}
+		libros = librosMateria(id)
+		f.Titulo = listadomateria.etiqueta(m)
+		filtros.Set("materia", texto)
+	}
+	if texto := params.Get("autor"); texto != "" {
+		id, err := strconv.Atoi(texto)
+		if err != nil {
+			return nil, http.StatusBadRequest, "El ID del autor debe ser un número entero"
+		}
+		a, err := listadoautor.BuscarID(id)
+		if err != nil {
+			return nil, http.StatusNotFound, err.Error()
+		}
+		libros = librosAutor(id)
+		f.Titulo = a.Nombre
+		filtros.Set("autor", texto)
+	}
+	if q := strings.TrimSpace(cmp.Or(params.Get("q"), params.Get("query"))); q != "" {
+		libros = nil
+		for _, res := range indice.Buscar(q, len(libreria.Libros)) {
+			libros = append(libros, res.Libro)
+		}
+		ordenar = false // se conserva el orden por relevancia
+		f.Titulo = "Resultados de " + q
+		filtros.Set("q", q)
+	}
+	if params.Get("prestados") == "si" {
+		if cuenta == nil {
+			return nil, http.StatusUnauthorized, ""
+		}
+		var prestados []*Libro
+		for _, l := range libros {
+			if prestamoActivo(cuenta.CuentaID, l.LibroID, ahora) != nil {
+				prestados = append(prestados, l)
+			}
+		}
+		libros = prestados
+		f.Titulo = "Mis préstamos"
+		filtros.Set("prestados", "si")
+	}
+
+	libros = librosElectronicos(libros)
+	if ordenar {
+		sort.SliceStable(libros, func(i, j int) bool {
+			return normalizarTexto(libros[i].Titulo) < normalizarTexto(libros[j].Titulo)
+		})
+	}
+	if texto := params.Get("pagina"); texto != "" {
+		pagina, err := strconv.Atoi(texto)
+		if err != nil || pagina < 1 {
+			return nil, http.StatusBadRequest, "La página debe ser un número entero positivo"
+		}
+		f.Pagina = pagina
+	}
+	f.Total = len(libros)
+	desde := min((f.Pagina-1)*librosPorPaginaOPDS, len(libros))
+	f.Libros = libros[desde:min(desde+librosPorPaginaOPDS, len(libros))]
+
+	pagina := func(n int) string {
+		v := url.Values{}
+		for k, valores := range filtros {
+			v[k] = valores
+		}
+		if n > 1 {
+			v.Set("pagina", strconv.Itoa(n))
+		}
+		if len(v) == 0 {
+			return "/libros"
+		}
+		return "/libros?" + v.Encode()
+	}
+	f.Ruta = pagina(f.Pagina)
+	f.Enlaces = append(f.Enlaces, enlaceOPDS{Rel: "first", Href: pagina(1)})
+	if f.Pagina > 1 {
+		f.Enlaces = append(f.Enlaces, enlaceOPDS{Rel: "previous", Href: pagina(f.Pagina - 1)})
+	}
+	if desde+librosPorPaginaOPDS < len(libros) {
+		f.Enlaces = append(f.Enlaces, enlaceOPDS{Rel: "next", Href: pagina(f.Pagina + 1)})
+	}
+	return f, http.StatusOK, ""
+}
+
+// Enlace de Atom con los elementos de disponibilidad de OPDS 1.2
+type atomEnlace struct {
+	Rel            string `xml:"rel,attr,omitempty"`
+	Href           string `xml:"href,attr"`
+	Tipo           string `xml:"type,attr,omitempty"`
+	Titulo         string `xml:"title,attr,omitempty"`
+	Cantidad       int    `xml:"thr:count,attr,omitempty"`
+	Disponibilidad *struct {
+		Estado string `xml:"status,attr"`
+	} `xml:"opds:availability"`
+	Copias *struct {
+		Total  int `xml:"total,attr"`
+		Libres int `xml:"available,attr"`
+	} `xml:"opds:copies"`
+}
+
+type atomPersona struct {
+	Nombre string `xml:"name"`
+	URI    string `xml:"uri,omitempty"`
+}
+
+type atomCategoria struct {
+	Termino  string `xml:"term,attr"`
+	Etiqueta string `xml:"label,attr"`
+	Esquema  string `xml:"scheme,attr"`
+}
+
+type atomEntrada struct {
+	Titulo        string          `xml:"title"`
+	ID            string          `xml:"id"`
+	Actualizado   string          `xml:"updated"`
+	Autores       []atomPersona   `xml:"author"`
+	Colaboradores []atomPersona   `xml:"contributor"`
+	Idioma        string          `xml:"dc:language,omitempty"`
+	Editorial     string          `xml:"dc:publisher,omitempty"`
+	Publicado     string          `xml:"dc:issued,omitempty"`
+	Identificador string          `xml:"dc:identifier,omitempty"`
+	Resumen       string          `xml:"summary,omitempty"`
+	Contenido     string          `xml:"content,omitempty"`
+	Categorias    []atomCategoria `xml:"category"`
+	Enlaces       []atomEnlace    `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName     xml.Name      `xml:"http://www.w3.org/2005/Atom feed"`
+	NsDC        string        `xml:"xmlns:dc,attr"`
+	NsOPDS      string        `xml:"xmlns:opds,attr"`
+	NsThr       string        `xml:"xmlns:thr,attr"`
+	NsBusqueda  string        `xml:"xmlns:opensearch,attr"`
+	ID          string        `xml:"id"`
+	Titulo      string        `xml:"title"`
+	Actualizado string        `xml:"updated"`
+	Total       int           `xml:"opensearch:totalResults,omitempty"`
+	PorPagina   int           `xml:"opensearch:itemsPerPage,omitempty"`
+	Enlaces     []atomEnlace  `xml:"link"`
+	Entradas    []atomEntrada `xml:"entry"`
+}
+
+func (e enlaceOPDS) atom() atomEnlace {
+	a := atomEnlace{Rel: e.Rel, Href: e.Href, Tipo: e.Tipo, Titulo: e.Titulo}
+	if e.Estado != "" {
+		a.Disponibilidad = &struct {
+			Estado string `xml:"status,attr"`
+		}{e.Estado}
+		a.Copias = &struct {
+			Total  int `xml:"total,attr"`
+			Libres int `xml:"available,attr"`
+		}{e.Total, e.Libres}
+	}
+	return a
+}
+
+// Escribe el feed en Atom para OPDS 1.2
+func escribirAtomOPDS(w http.ResponseWriter, f *feedOPDS) {
+	ahora := time.Now()
+	base := urlBase() + "/opds"
+	tipo := tipoOPDSNavegacion
+	if f.Adquisicion {
+		tipo = tipoOPDSAdquisicion
+	}
+	feed := atomFeed{
+		NsDC: "http://purl.org/dc/terms/", NsOPDS: "http://opds-spec.org/2010/catalog",
+		NsThr: "http://purl.org/syndication/thread/1.0", NsBusqueda: "http://a9.com/-/spec/opensearch/1.1/",
+		ID: base + f.Ruta, Titulo: f.Titulo, Actualizado: ahora.Format(time.RFC3339),
+		Enlaces: []atomEnlace{
+			{Rel: "self", Href: base + f.Ruta, Tipo: tipo},
+			{Rel: "start", Href: base, Tipo: tipoOPDSNavegacion},
+			{Rel: "search", Href: base + "/opensearch.xml", Tipo: tipoOpenSearch},
+		},
+	}
+	if tipo == tipoOPDSAdquisicion {
+		feed.Total, feed.PorPagina = f.Total, librosPorPaginaOPDS
+	}
+	for _, e := range f.Enlaces {
+		e.Href = base + e.Href
+		e.Tipo = tipo
+		feed.Enlaces = append(feed.Enlaces, e.atom())
+	}
+
+	for _, n := range f.Navegacion {
+		entrada := atomEntrada{Titulo: n.Titulo, ID: base + n.Ruta, Actualizado: feed.Actualizado}
+		tipoEnlace := tipoOPDSAdquisicion
+		if !strings.HasPrefix(n.Ruta, "/libros") {
+			tipoEnlace = tipoOPDSNavegacion
+		}
+		if n.Cantidad > 0 {
+			entrada.Contenido = strconv.Itoa(n.Cantidad) + " libro(s)"
+		}
+		entrada.Enlaces = []atomEnlace{{Rel: "subsection", Href: base + n.Ruta, Tipo: tipoEnlace, Cantidad: n.Cantidad}}
+		feed.Entradas = append(feed.Entradas, entrada)
+	}
+	for _, l := range f.Libros {
+		entrada := atomEntrada{
+			Titulo: l.Titulo, ID: idLibroOPDS(l), Actualizado: actualizadoLibro(l).Format(time.RFC3339),
+			Idioma: l.Idioma, Editorial: l.Editorial, Publicado: l.FechaPublicacion.ISO(), Resumen: l.Descripcion,
+		}
+		if l.ISBN != "" {
+			entrada.Identificador = "urn:isbn:" + l.ISBN
+		}
+		for _, p := range l.Participantes() {
+			persona := atomPersona{p.Autor.Nombre, urlBase() + "/autor?id=" + strconv.Itoa(p.Autor.AutorID)}
+			if p.Rol == RolAutorPrincipal {
+				entrada.Autores = append(entrada.Autores, persona)
+			} else {
+				entrada.Colaboradores = append(entrada.Colaboradores, persona)
+			}
+		}
+		if len(entrada.Autores) == 0 && l.Autor != "" {
+			entrada.Autores = []atomPersona{{Nombre: l.Autor}}
+		}
+		for _, m := range l.MateriasLibro() {
+			entrada.Categorias = append(entrada.Categorias, atomCategoria{strconv.Itoa(m.MateriaID), listadomateria.etiqueta(m), urlBase() + "/materias"})
+		}
+		for _, e := range append(enlacesImagen(l), enlacesAdquisicion(l, f.Cuenta, ahora)...) {
+			entrada.Enlaces = append(entrada.Enlaces, e.atom())
+		}
+		feed.Entradas = append(feed.Entradas, entrada)
+	}
+
+	w.Header().Set("Content-Type", tipo+";charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	if err := enc.Encode(feed); err != nil {
+		http.Error(w, "Error al codificar el catálogo", http.StatusInternalServerError)
+	}
+}
+
+// Enlace de OPDS 2.0; la disponibilidad va en properties
+type enlaceOPDS2 struct {
+	Rel         string         `json:"rel,omitempty"`
+	Href        string         `json:"href"`
+	Tipo        string         `json:"type,omitempty"`
+	Titulo      string         `json:"title,omitempty"`
+	Plantilla   bool           `json:"templated,omitempty"`
+	Propiedades map[string]any `json:"properties,omitempty"`
+}
+
+func (e enlaceOPDS) opds2() enlaceOPDS2 {
+	j := enlaceOPDS2{Rel: e.Rel, Href: e.Href, Tipo: e.Tipo, Titulo: e.Titulo, Plantilla: e.Plantilla}
+	if e.Estado != "" {
+		j.Propiedades = map[string]any{
+			"availability": map[string]string{"state": e.Estado},
+			"copies":       map[string]int{"total": e.Total, "available": e.Libres},
+		}
+	}
+	return j
+}
+
+type contribuyenteOPDS2 struct {
+	Nombre string `json:"name"`
+}
+
+type materiaOPDS2 struct {
+	Nombre  string `json:"name"`
+	Codigo  string `json:"code"`
+	Esquema string `json:"scheme"`
+}
+
+type publicacionOPDS2 struct {
+	Metadatos struct {
+		Tipo          string               `json:"@type"`
+		Titulo        string               `json:"title"`
+		Subtitulo     string               `json:"subtitle,omitempty"`
+		Identificador string               `json:"identifier"`
+		Autores       []contribuyenteOPDS2 `json:"author,omitempty"`
+		Traductores   []contribuyenteOPDS2 `json:"translator,omitempty"`
+		Editores      []contribuyenteOPDS2 `json:"editor,omitempty"`
+		Idioma        string               `json:"language,omitempty"`
+		Editorial     string               `json:"publisher,omitempty"`
+		Publicado     string               `json:"published,omitempty"`
+		Modificado    string               `json:"modified,omitempty"`
+		Descripcion   string               `json:"description,omitempty"`
+		Materias      []materiaOPDS2       `json:"subject,omitempty"`
+	} `json:"metadata"`
+	Enlaces  []enlaceOPDS2 `json:"links"`
+	Imagenes []enlaceOPDS2 `json:"images,omitempty"`
+}
+
+type feedOPDS2 struct {
+	Metadatos struct {
+		Titulo    string `json:"title"`
+		Total     int    `json:"numberOfItems,omitempty"`
+		PorPagina int    `json:"itemsPerPage,omitempty"`
+		Pagina    int    `json:"currentPage,omitempty"`
+	} `json:"metadata"`
+	Enlaces       []enlaceOPDS2      `json:"links"`
+	Navegacion    []enlaceOPDS2      `json:"navigation,omitempty"`
+	Publicaciones []publicacionOPDS2 `json:"publications,omitempty"`
+}
+
+// Escribe el feed en JSON para OPDS 2.0
+func escribirOPDS2(w http.ResponseWriter, f *feedOPDS) {
+	ahora := time.Now()
+	base := urlBase() + "/opds/v2"
+	var feed feedOPDS2
+	feed.Metadatos.Titulo = f.Titulo
+	feed.Enlaces = []enlaceOPDS2{
+		{Rel: "self", Href: base + f.Ruta, Tipo: tipoOPDS2},
+		{Rel: "start", Href: base, Tipo: tipoOPDS2},
+		{Rel: "search", Href: base + "/libros{?query}", Tipo: tipoOPDS2, Plantilla: true},
+	}
+	if f.Adquisicion {
+		feed.Metadatos.Total, feed.Metadatos.PorPagina, feed.Metadatos.Pagina = f.Total, librosPorPaginaOPDS, f.Pagina
+		feed.Publicaciones = []publicacionOPDS2{}
+	}
+	for _, e := range f.Enlaces {
+		e.Href = base + e.Href
+		e.Tipo = tipoOPDS2
+		feed.Enlaces = append(feed.Enlaces, e.opds2())
+	}
+	for _, n := range f.Navegacion {
+		enlace := enlaceOPDS2{Href: base + n.Ruta, Titulo: n.Titulo, Tipo: tipoOPDS2}
+		if n.Cantidad > 0 {
+			enlace.Propiedades = map[string]any{"numberOfItems": n.Cantidad}
+		}
+		feed.Navegacion = append(feed.Navegacion, enlace)
+	}
+
+	for _, l := range f.Libros {
+		var p publicacionOPDS2
+		md := &p.Metadatos
+		md.Tipo = "http://schema.org/Book"
+		md.Titulo, md.Subtitulo, md.Identificador = l.Titulo, l.Subtitulo, idLibroOPDS(l)
+		md.Idioma, md.Editorial, md.Publicado, md.Descripcion = l.Idioma, l.Editorial, l.FechaPublicacion.ISO(), l.Descripcion
+		if fecha := actualizadoLibro(l); !fecha.IsZero() {
+			md.Modificado = fecha.Format(time.RFC3339)
+		}
+		for _, part := range l.Participantes() {
+			c := contribuyenteOPDS2{part.Autor.Nombre}
+			switch part.Rol {
+			case RolAutorPrincipal:
+				md.Autores = append(md.Autores, c)
+			case RolTraductor:
+				md.Traductores = append(md.Traductores, c)
+			case RolEditor:
+				md.Editores = append(md.Editores, c)
+			}
+		}
+		if len(md.Autores) == 0 && l.Autor != "" {
+			md.Autores = []contribuyenteOPDS2{{l.Autor}}
+		}
+		for _, m := range l.MateriasLibro() {
+			md.Materias = append(md.Materias, materiaOPDS2{listadomateria.etiqueta(m), strconv.Itoa(m.MateriaID), urlBase() + "/materias"})
+		}
+		p.Enlaces = []enlaceOPDS2{{Rel: "self", Href: base + "/libros?id=" + strconv.Itoa(l.LibroID), Tipo: tipoOPDS2}}
+		for _, e := range enlacesAdquisicion(l, f.Cuenta, ahora) {
+			p.Enlaces = append(p.Enlaces, e.opds2())
+		}
+		for _, e := range enlacesImagen(l) {
+			p.Imagenes = append(p.Imagenes, e.opds2())
+		}
+		feed.Publicaciones = append(feed.Publicaciones, p)
+	}
+
+	w.Header().Set("Content-Type", tipoOPDS2)
+	if err := json.NewEncoder(w).Encode(feed); err != nil {
+		http.Error(w, "Error al codificar el catálogo", http.StatusInternalServerError)
+	}
+}
+
+// Descripcion OpenSearch de la busqueda del catalogo OPDS 1.2
+type descripcionOpenSearch struct {
+	XMLName      xml.Name `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	Nombre       string   `xml:"ShortName"`
+	Descripcion  string   `xml:"Description"`
+	Codificacion string   `xml:"InputEncoding"`
+	URL          struct {
+		Tipo      string `xml:"type,attr"`
+		Plantilla string `xml:"template,attr"`
+	} `xml:"Url"`
+}
+
+/*
+Funcion para el catalogo OPDS: /opds para OPDS 1.2 (Atom) y /opds/v2 para OPDS 2.0
+(JSON), con las rutas /materias, /autores y /libros. La navegacion es publica; con
+una sesion o credenciales HTTP Basic los enlaces muestran los prestamos de la cuenta.
+*/
+func catalogoOPDS(w http.ResponseWriter, r *http.Request) {
+	ruta := strings.TrimPrefix(r.URL.Path, "/opds")
+	v2 := ruta == "/v2" || strings.HasPrefix(ruta, "/v2/")
+	if v2 {
+		ruta = strings.TrimPrefix(ruta, "/v2")
+	}
+	ruta = strings.TrimSuffix(ruta, "/")
+	// Las credenciales se comprueban antes de tomar el catalogo, porque la contraseña tarda en verificarse
+	cuenta := cuentaOPDS(r)
+	muCatalogo.RLock()
+	defer muCatalogo.RUnlock()
+
+	var f *feedOPDS
+	switch ruta {
+	case "":
+		f = feedRaizOPDS(cuenta)
+	case "/materias":
+		f = feedMateriasOPDS()
+	case "/autores":
+		f = feedAutoresOPDS()
+	case "/libros":
+		var estado int
+		var mensaje string
+		if f, estado, mensaje = feedLibrosOPDS(r.URL.Query(), cuenta, time.Now()); f == nil {
+			if estado == http.StatusUnauthorized {
+				pedirCredenciales(w)
+				return
+			}
+			http.Error(w, mensaje, estado)
+			return
+		}
+	case "/opensearch.xml":
+		if v2 {
+			http.NotFound(w, r)
+			return
+		}
+		var d descripcionOpenSearch
+		d.Nombre, d.Descripcion, d.Codificacion = "Biblioteca", "Buscar libros electrónicos por título, autor, materia o descripción", "UTF-8"
+		d.URL.Tipo = tipoOPDSAdquisicion
+		d.URL.Plantilla = urlBase() + "/opds/libros?q={searchTerms}"
+		w.Header().Set("Content-Type", tipoOpenSearch+";charset=utf-8")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(d)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	// El feed cambia segun la cuenta, por lo que no se comparte en caches
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Authorization, Cookie")
+	if v2 {
+		escribirOPDS2(w, f)
+	} else {
+		escribirAtomOPDS(w, f)
+	}
+}
+
+// Codigo HTML para confirmar un prestamo pedido sin un enlace firmado del catalogo
+var confirmOPDSLoan = template.Must(template.New("prestarOPDS").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Confirmar préstamo</title>
+</head>
+<body>
+	<h1>Confirmar préstamo</h1>
+	<p>¿Desea tomar prestado «{{.Libro.Titulo}}» por {{.Dias}} días?</p>
+	<p><a href="{{.URL}}">Tomar prestado</a></p>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+/*
+Funcion para prestar un libro desde una aplicacion de lectura
+(/opds/prestar?libroID=&formato=&expira=&firma=). Con el enlace firmado del feed
+toma una licencia libre y entrega el archivo; si la cuenta ya tiene un prestamo
+vigente del libro lo entrega sin tomar otra. Solo acepta credenciales HTTP Basic,
+que el navegador tambien envia cuando otro sitio enlaza esta direccion, por lo que
+sin una firma valida para la cuenta no presta: responde una pagina para confirmar
+el prestamo con un enlace firmado nuevo.
+*/
+func prestarOPDS(w http.ResponseWriter, r *http.Request) {
+	cuenta := cuentaBasica(r)
+	if cuenta == nil {
+		pedirCredenciales(w)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("libroID"))
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	ahora := time.Now()
+	if !prestamoOPDSFirmado(r.URL.Query(), cuenta.CuentaID, id, ahora) {
+		confirmarPrestamoOPDS(w, r, cuenta, id, ahora)
+		return
+	}
+	if estado, err := prestarLibroOPDS(cuenta, id, ahora); err != nil {
+		http.Error(w, err.Error(), estado)
+		return
+	}
+	entregarArchivo(w, r, cuenta)
+}
+
+// Pagina para confirmar el prestamo con un enlace firmado para la cuenta
+func confirmarPrestamoOPDS(w http.ResponseWriter, r *http.Request, cuenta *Cuenta, id int, ahora time.Time) {
+	muCatalogo.RLock()
+	defer muCatalogo.RUnlock()
+	libro, err := libreria.BuscarID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	formato := r.URL.Query().Get("formato")
+	if _, err := libro.BuscarArchivo(formato); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	datos := struct {
+		Libro *Libro
+		Dias  int
+		URL   string
+	}{libro, int(plazoPrestamoDigital.Hours() / 24), enlacePrestamoOPDS(cuenta.CuentaID, id, formato, ahora)}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if err := confirmOPDSLoan.Execute(w, datos); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Toma una licencia del libro si la cuenta aun no lo tiene prestado; el archivo se entrega despues sin el catalogo
+func prestarLibroOPDS(cuenta *Cuenta, id int, ahora time.Time) (int, error) {
+	muCatalogo.RLock()
+	defer muCatalogo.RUnlock()
+	libro, err := libreria.BuscarID(id)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	if prestamoActivo(cuenta.CuentaID, id, ahora) != nil {
+		return 0, nil
+	}
+	_, estado, err := tomarLicencia(libro, cuenta, ahora)
+	return estado, err
+}
+
+// Funcion para descargar desde una aplicacion de lectura el archivo de un libro prestado
+func descargarOPDS(w http.ResponseWriter, r *http.Request) {
+	cuenta := cuentaOPDS(r)
+	if cuenta == nil {
+		pedirCredenciales(w)
+		return
+	}
+	entregarArchivo(w, r, cuenta)
+}
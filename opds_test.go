@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Catalogo con un libro electronico con una licencia y uno solo en papel, y dos
+cuentas activas para las credenciales HTTP Basic de las aplicaciones de lectura.
+*/
+func opdsPrueba(t *testing.T) (ana, luis *Cuenta) {
+	t.Helper()
+	enDirectorioTemporal(t)
+	anterior, anterioresCuentas, anterioresPrestamos := libreria, listadocuenta.Cuentas, listadoprestamo.Prestamos
+	anteriorIteraciones := iteracionesContrasena
+	t.Cleanup(func() {
+		libreria, listadocuenta.Cuentas, listadoprestamo.Prestamos = anterior, anterioresCuentas, anterioresPrestamos
+		iteracionesContrasena = anteriorIteraciones
+		muFallosCredenciales.Lock()
+		fallosCredenciales = map[string]*fallosBasica{}
+		muFallosCredenciales.Unlock()
+	})
+	iteracionesContrasena = 1000
+
+	hash, _, err := guardarBlob(strings.NewReader("contenido del epub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	libreria = &Libreria{Libros: []*Libro{
+		{LibroID: 1, Titulo: "Meditaciones", Licencias: 1, Archivos: []ArchivoLibro{{Hash: hash, Formato: formatoEPUB}}},
+		{LibroID: 2, Titulo: "Historia de Roma"},
+	}}
+	listadoprestamo.Prestamos = nil
+	if ana, err = nuevaCuenta(1, "Ana", "ana@example.com", "clave-de-ana", RolUsuario); err != nil {
+		t.Fatal(err)
+	}
+	if luis, err = nuevaCuenta(2, "Luis", "luis@example.com", "clave-de-luis", RolUsuario); err != nil {
+		t.Fatal(err)
+	}
+	listadocuenta.Cuentas = []*Cuenta{ana, luis}
+	return ana, luis
+}
+
+// Peticion de una aplicacion de lectura desde la direccion indicada, con credenciales si se dan
+func peticionOPDS(destino, ip, mail, contrasena string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, destino, nil)
+	r.RemoteAddr = ip + ":4321"
+	if mail != "" {
+		r.SetBasicAuth(mail, contrasena)
+	}
+	return r
+}
+
+// Enlaces de adquisicion de las publicaciones del feed OPDS 2.0
+func enlacesFeedOPDS2(t *testing.T, r *http.Request) map[string][]string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	catalogoOPDS(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("feed = %d: %s", w.Code, w.Body)
+	}
+	var feed feedOPDS2
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	enlaces := map[string][]string{}
+	for _, p := range feed.Publicaciones {
+		for _, e := range p.Enlaces {
+			if e.Rel != "self" {
+				enlaces[p.Metadatos.Titulo] = append(enlaces[p.Metadatos.Titulo], e.Href)
+			}
+		}
+	}
+	return enlaces
+}
+
+func TestFeedOPDS(t *testing.T) {
+	opdsPrueba(t)
+
+	// Sin cuenta solo aparecen los libros electronicos, con el enlace de prestamo sin firmar
+	enlaces := enlacesFeedOPDS2(t, peticionOPDS("/opds/v2/libros", "192.0.2.1", "", ""))
+	if len(enlaces) != 1 || len(enlaces["Meditaciones"]) != 1 {
+		t.Fatalf("enlaces %v", enlaces)
+	}
+	anonimo, err := url.Parse(enlaces["Meditaciones"][0])
+	if err != nil || anonimo.Path != "/opds/prestar" || anonimo.Query().Has("firma") {
+		t.Errorf("enlace anónimo %s", anonimo)
+	}
+
+	// Con credenciales el enlace va firmado para la cuenta
+	enlaces = enlacesFeedOPDS2(t, peticionOPDS("/opds/v2/libros", "192.0.2.1", "ana@example.com", "clave-de-ana"))
+	firmado, err := url.Parse(enlaces["Meditaciones"][0])
+	if err != nil || !prestamoOPDSFirmado(firmado.Query(), 1, 1, time.Now()) || prestamoOPDSFirmado(firmado.Query(), 2, 1, time.Now()) {
+		t.Errorf("enlace firmado %s", firmado)
+	}
+	if prestamoOPDSFirmado(firmado.Query(), 1, 1, time.Now().Add(plazoEnlacePrestamoOPDS)) {
+		t.Error("el enlace sigue vigente después del plazo")
+	}
+
+	// El feed en Atom tambien lleva solo los libros electronicos
+	w := httptest.NewRecorder()
+	catalogoOPDS(w, peticionOPDS("/opds/libros", "192.0.2.1", "", ""))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Meditaciones") || strings.Contains(w.Body.String(), "Historia de Roma") {
+		t.Errorf("feed Atom = %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Cache-Control"); !strings.Contains(got, "private") {
+		t.Errorf("Cache-Control = %q", got)
+	}
+}
+
+func TestCuentaBasicaBloqueo(t *testing.T) {
+	opdsPrueba(t)
+	if c := cuentaBasica(peticionOPDS("/opds", "192.0.2.1", "ana@example.com", "clave-de-ana")); c == nil || c.CuentaID != 1 {
+		t.Fatalf("credenciales válidas = %+v", c)
+	}
+	for range maxFallosBasica {
+		if c := cuentaBasica(peticionOPDS("/opds", "192.0.2.1", "ana@example.com", "otra")); c != nil {
+			t.Fatal("se aceptó una contraseña incorrecta")
+		}
+	}
+
+	// Agotados los intentos se rechaza incluso la contraseña correcta desde esa direccion
+	if c := cuentaBasica(peticionOPDS("/opds", "192.0.2.1", "ana@example.com", "clave-de-ana")); c != nil {
+		t.Error("la dirección bloqueada pudo iniciar sesión")
+	}
+	if c := cuentaBasica(peticionOPDS("/opds", "192.0.2.2", "ana@example.com", "clave-de-ana")); c == nil {
+		t.Error("se bloqueó otra dirección")
+	}
+	w := httptest.NewRecorder()
+	prestarOPDS(w, peticionOPDS("/opds/prestar?libroID=1&formato=epub", "192.0.2.1", "ana@example.com", "clave-de-ana"))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("préstamo desde la dirección bloqueada = %d", w.Code)
+	}
+
+	// Pasada la ventana se puede volver a probar
+	if credencialesBloqueadas("192.0.2.1", time.Now().Add(ventanaFallosBasica)) {
+		t.Error("la dirección sigue bloqueada después de la ventana")
+	}
+}
+
+func TestPrestarOPDS(t *testing.T) {
+	opdsPrueba(t)
+	prestar := func(destino, mail, contrasena string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		prestarOPDS(w, peticionOPDS(destino, "192.0.2.1", mail, contrasena))
+		return w
+	}
+
+	// Sin firma, como en un enlace de otro sitio, solo se pide confirmar
+	w := prestar("/opds/prestar?libroID=1&formato=epub", "ana@example.com", "clave-de-ana")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Confirmar préstamo") || len(listadoprestamo.Prestamos) != 0 {
+		t.Fatalf("sin firma = %d, %d préstamos", w.Code, len(listadoprestamo.Prestamos))
+	}
+	if got := w.Header().Get("Cache-Control"); got != "private, no-store" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	if w := prestar("/opds/prestar?libroID=2&formato=epub", "ana@example.com", "clave-de-ana"); w.Code != http.StatusNotFound {
+		t.Errorf("libro sin archivo = %d", w.Code)
+	}
+
+	// Un enlace firmado para otra cuenta tampoco presta
+	enlace := strings.TrimPrefix(enlacePrestamoOPDS(1, 1, formatoEPUB, time.Now()), urlBase())
+	w = prestar(enlace, "luis@example.com", "clave-de-luis")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Confirmar préstamo") || len(listadoprestamo.Prestamos) != 0 {
+		t.Fatalf("firma de otra cuenta = %d, %d préstamos", w.Code, len(listadoprestamo.Prestamos))
+	}
+
+	// Con su enlace firmado toma la licencia y recibe el archivo
+	w = prestar(enlace, "ana@example.com", "clave-de-ana")
+	if w.Code != http.StatusOK || w.Body.String() != "contenido del epub" {
+		t.Fatalf("préstamo firmado = %d: %s", w.Code, w.Body)
+	}
+	if len(listadoprestamo.Prestamos) != 1 || listadoprestamo.Prestamos[0].UsuarioID != 1 || !listadoprestamo.Prestamos[0].Digital {
+		t.Errorf("préstamos %+v", listadoprestamo.Prestamos)
+	}
+	// Repetir el enlace vuelve a entregar el archivo sin tomar otra licencia
+	if w := prestar(enlace, "ana@example.com", "clave-de-ana"); w.Code != http.StatusOK || len(listadoprestamo.Prestamos) != 1 {
+		t.Errorf("repetir el préstamo = %d, %d préstamos", w.Code, len(listadoprestamo.Prestamos))
+	}
+	// Sin licencias libres no hay prestamo para otra cuenta
+	otro := strings.TrimPrefix(enlacePrestamoOPDS(2, 1, formatoEPUB, time.Now()), urlBase())
+	if w := prestar(otro, "luis@example.com", "clave-de-luis"); w.Code != http.StatusConflict {
+		t.Errorf("sin licencias = %d", w.Code)
+	}
+}
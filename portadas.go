@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Tamano maximo de la imagen de portada y de su area en pixeles, para no decodificar imagenes enormes
+const (
+	maxTamanoPortada  = 10 << 20
+	maxPixelesPortada = 40_000_000
+	calidadMiniatura  = 85
+)
+
+// Miniaturas que se generan de cada portada, por ancho maximo en pixeles
+var tamanosMiniatura = []struct {
+	Nombre string
+	Ancho  int
+}{{"pequena", 80}, {"mediana", 200}, {"grande", 400}}
+
+// Decodifica una imagen JPEG, PNG o GIF comprobando antes sus dimensiones
+func decodificarPortada(contenido []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(contenido))
+	if err != nil {
+		return nil, errors.New("la portada debe ser una imagen JPEG, PNG o GIF")
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixelesPortada {
+		return nil, errors.New("las dimensiones de la portada no son válidas")
+	}
+	img, _, err := image.Decode(bytes.NewReader(contenido))
+	if err != nil {
+		return nil, errors.New("la imagen de portada está dañada")
+	}
+	return img, nil
+}
+
+/*
+Copia la imagen en RGBA compuesta sobre fondo blanco, porque las miniaturas se
+guardan en JPEG sin transparencia. Se hace una sola vez por portada y todas las
+miniaturas se reducen desde esta copia.
+*/
+func aplanar(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	origen := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(origen, origen.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(origen, origen.Bounds(), img, b.Min, draw.Over)
+	return origen
+}
+
+/*
+Reduce la imagen aplanada al ancho indicado conservando la proporcion. Cada pixel
+de la miniatura es el promedio de los pixeles de origen que cubre (filtro de caja),
+lo que evita el aspecto granulado del vecino mas cercano al reducir mucho. Las
+imagenes mas angostas que el ancho no se amplian.
+*/
+func redimensionar(origen *image.RGBA, ancho int) *image.RGBA {
+	b := origen.Bounds()
+	ancho = min(ancho, b.Dx())
+	alto := max(b.Dy()*ancho/b.Dx(), 1)
+	destino := image.NewRGBA(image.Rect(0, 0, ancho, alto))
+	for y := 0; y < alto; y++ {
+		y0, y1 := y*b.Dy()/alto, max((y+1)*b.Dy()/alto, y*b.Dy()/alto+1)
+		for x := 0; x < ancho; x++ {
+			x0, x1 := x*b.Dx()/ancho, max((x+1)*b.Dx()/ancho, x*b.Dx()/ancho+1)
+			var suma [4]int
+			for sy := y0; sy < y1; sy++ {
+				fila := origen.Pix[sy*origen.Stride+x0*4 : sy*origen.Stride+x1*4]
+				for i := 0; i < len(fila); i += 4 {
+					suma[0] += int(fila[i])
+					suma[1] += int(fila[i+1])
+					suma[2] += int(fila[i+2])
+					suma[3] += int(fila[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := destino.PixOffset(x, y)
+			for c := range suma {
+				destino.Pix[i+c] = uint8(suma[c] / n)
+			}
+		}
+	}
+	return destino
+}
+
+/*
+Guarda la imagen de portada del libro, ya decodificada, en el almacen de archivos y
+genera sus miniaturas en JPEG. La portada anterior se reemplaza; los archivos viejos
+quedan en el almacen porque otro libro puede usar la misma imagen.
+*/
+func (l *Libro) asignarPortada(img image.Image, contenido []byte) error {
+	hash, _, err := guardarBlob(bytes.NewReader(contenido))
+	if err != nil {
+		return err
+	}
+	origen := aplanar(img)
+	miniaturas := map[string]string{}
+	for _, t := range tamanosMiniatura {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, redimensionar(origen, t.Ancho), &jpeg.Options{Quality: calidadMiniatura}); err != nil {
+			return err
+		}
+		if miniaturas[t.Nombre], _, err = guardarBlob(&buf); err != nil {
+			return err
+		}
+	}
+	l.Portada = hash
+	l.Miniaturas = miniaturas
+	return nil
+}
+
+// Hash de la portada en el tamano pedido; vacio o desconocido es la imagen original
+func (l *Libro) hashPortada(tam string) string {
+	if hash, ok := l.Miniaturas[tam]; ok {
+		return hash
+	}
+	return l.Portada
+}
+
+/*
+Direccion de la portada para las vistas. Lleva el inicio del hash para que el
+navegador guarde la imagen sin volver a preguntar y pida otra al cambiar la portada.
+Devuelve "" si el libro no tiene portada.
+*/
+func (l *Libro) URLPortada(tam string) string {
+	hash := l.hashPortada(tam)
+	if hash == "" {
+		return ""
+	}
+	v := url.Values{}
+	v.Set("libroID", strconv.Itoa(l.LibroID))
+	if tam != "" {
+		v.Set("tam", tam)
+	}
+	v.Set("v", hash[:12])
+	return "/portada?" + v.Encode()
+}
+
+// Campos guardados del libro, sin su MarshalJSON
+type libroJSON Libro
+
+/*
+Libro con las direcciones de su portada y de sus miniaturas, para que quien usa la
+API no tenga que armarlas. Son datos derivados: tambien quedan en libros.json, pero
+al cargarlo se ignoran. Las respuestas que agregan campos al libro lo incluyen a el
+en lugar del *Libro, cuyo MarshalJSON ocultaria los campos agregados.
+*/
+type libroSerializado struct {
+	libroJSON
+	PortadaURL    string            `json:"portada_url,omitempty"`
+	MiniaturasURL map[string]string `json:"miniaturas_url,omitempty"`
+}
+
+func (l Libro) serializado() libroSerializado {
+	var miniaturas map[string]string
+	for tam := range l.Miniaturas {
+		if miniaturas == nil {
+			miniaturas = map[string]string{}
+		}
+		miniaturas[tam] = l.URLPortada(tam)
+	}
+	return libroSerializado{libroJSON(l), l.URLPortada(""), miniaturas}
+}
+
+func (l Libro) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.serializado())
+}
+
+/*
+Funcion para servir la portada de un libro (/portada?libroID=&tam=pequena|mediana|grande).
+Sin tam se entrega la imagen original. Con v igual a la version actual la respuesta
+se guarda en cache un ano; si no, una hora y luego se revalida con el ETag.
+*/
+func servirPortada(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("libroID"))
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	// El catalogo se consulta con el candado y la imagen se envia sin el
+	muCatalogo.RLock()
+	libro, err := libreria.BuscarID(id)
+	hash := ""
+	if err == nil {
+		hash = libro.hashPortada(r.URL.Query().Get("tam"))
+	}
+	muCatalogo.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if hash == "" {
+		http.Error(w, "El libro no tiene portada", http.StatusNotFound)
+		return
+	}
+	contenido, err := abrirBlob(hash)
+	if err != nil {
+		http.Error(w, "La portada no está disponible", http.StatusNotFound)
+		return
+	}
+	defer contenido.Close()
+
+	inicio := make([]byte, 512)
+	n, _ := io.ReadFull(contenido, inicio)
+	if _, err := contenido.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Error al leer la portada", http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("v") == hash[:12] {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(inicio[:n]))
+	w.Header().Set("ETag", `"`+hash+`"`)
+	http.ServeContent(w, r, "", time.Time{}, contenido)
+}
+
+// Codigo HTML para subir la portada de un libro
+var coverTemplate = template.Must(template.New("portada").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Portada</title>
+</head>
+<body>
+	<h1>Portada de {{.Libro.Titulo}}</h1>
+	{{with .Libro.URLPortada "grande"}}<p><img src="{{.}}" alt="Portada actual"></p>{{end}}
+	<form action="/subir-portada" method="post" enctype="multipart/form-data">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<input type="hidden" name="libroID" value="{{.Libro.LibroID}}">
+		<label for="portada">Imagen JPEG, PNG o GIF (hasta 10 MB):</label>
+		<input type="file" id="portada" name="portada" accept="image/jpeg,image/png,image/gif" required><br>
+		<button type="submit">Subir</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para subir la portada de un libro; genera las miniaturas y reemplaza la anterior
+func subirPortada(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		muCatalogo.RLock()
+		defer muCatalogo.RUnlock()
+		id, err := strconv.Atoi(r.URL.Query().Get("libroID"))
+		if err != nil {
+			http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		libro, err := libreria.BuscarID(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		datos := struct {
+			CSRF  string
+			Libro *Libro
+		}{tokenCSRF(r), libro}
+		if err := coverTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archivo, cabecera, err := r.FormFile("portada")
+	if err != nil {
+		http.Error(w, "Adjunte una imagen de hasta 10 MB", http.StatusBadRequest)
+		return
+	}
+	defer archivo.Close()
+
+	id, err := strconv.Atoi(r.FormValue("libroID"))
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	if cabecera.Size > maxTamanoPortada {
+		http.Error(w, "La imagen supera los 10 MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+	contenido, err := io.ReadAll(archivo)
+	if err != nil {
+		http.Error(w, "Error al leer la imagen", http.StatusInternalServerError)
+		return
+	}
+	img, err := decodificarPortada(contenido)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// La imagen se recibe y se decodifica sin el candado; solo el cambio del libro lo toma
+	muCatalogo.Lock()
+	defer muCatalogo.Unlock()
+	libro, err := libreria.BuscarID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := libro.asignarPortada(img, contenido); err != nil {
+		http.Error(w, "Error al guardar la portada", http.StatusInternalServerError)
+		return
+	}
+	if err := saveToJSON(libreria.Libros, "libros.json"); err != nil {
+		http.Error(w, "Error al guardar los libros", http.StatusInternalServerError)
+		return
+	}
+
+	enlaces := map[string]string{"original": libro.URLPortada("")}
+	for _, t := range tamanosMiniatura {
+		enlaces[t.Nombre] = libro.URLPortada(t.Nombre)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(enlaces)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedimensionar(t *testing.T) {
+	casos := []struct {
+		ancho, alto, max int
+		want             image.Point
+	}{
+		{1000, 1500, 80, image.Pt(80, 120)},
+		{1000, 1500, 400, image.Pt(400, 600)},
+		{300, 100, 200, image.Pt(200, 66)},
+		{50, 100, 200, image.Pt(50, 100)}, // las imagenes angostas no se amplian
+		{2000, 1, 80, image.Pt(80, 1)},    // el alto nunca queda en cero
+	}
+	for _, caso := range casos {
+		origen := aplanar(image.NewGray(image.Rect(0, 0, caso.ancho, caso.alto)))
+		if got := redimensionar(origen, caso.max).Bounds().Size(); got != caso.want {
+			t.Errorf("%dx%d a %d = %v, se esperaba %v", caso.ancho, caso.alto, caso.max, got, caso.want)
+		}
+	}
+
+	// Cada pixel es el promedio de los que cubre y la transparencia queda sobre blanco
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := range 2 {
+		img.Set(0, y, color.Black)
+		img.Set(1, y, color.White)
+		img.Set(2, y, color.Transparent)
+		img.Set(3, y, color.Transparent)
+	}
+	reducida := redimensionar(aplanar(img), 2)
+	if got := reducida.RGBAAt(0, 0); got.R != 127 || got.A != 255 {
+		t.Errorf("promedio de negro y blanco = %v", got)
+	}
+	if got := reducida.RGBAAt(1, 0); got.R != 255 {
+		t.Errorf("transparente = %v", got)
+	}
+}
+
+func TestAsignarPortada(t *testing.T) {
+	enDirectorioTemporal(t)
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, 600, 900)), nil); err != nil {
+		t.Fatal(err)
+	}
+	img, err := decodificarPortada(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	libro := &Libro{LibroID: 7, Titulo: "Meditaciones"}
+	if err := libro.asignarPortada(img, b.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tam := range tamanosMiniatura {
+		f, err := abrirBlob(libro.Miniaturas[tam.Nombre])
+		if err != nil {
+			t.Fatalf("miniatura %s: %v", tam.Nombre, err)
+		}
+		config, formato, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || formato != "jpeg" || config.Width != tam.Ancho || config.Height != tam.Ancho*3/2 {
+			t.Errorf("miniatura %s: %s %dx%d, %v", tam.Nombre, formato, config.Width, config.Height, err)
+		}
+	}
+
+	// Las respuestas de la API llevan las direcciones de la portada
+	datos, err := json.Marshal([]*Libro{libro})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var respuesta []struct {
+		ID            int               `json:"id"`
+		PortadaURL    string            `json:"portada_url"`
+		MiniaturasURL map[string]string `json:"miniaturas_url"`
+	}
+	if err := json.Unmarshal(datos, &respuesta); err != nil {
+		t.Fatal(err)
+	}
+	if r := respuesta[0]; r.ID != 7 || r.PortadaURL != libro.URLPortada("") || len(r.MiniaturasURL) != 3 ||
+		r.MiniaturasURL["mediana"] != libro.URLPortada("mediana") || !strings.Contains(r.MiniaturasURL["mediana"], "tam=mediana") {
+		t.Errorf("respuesta %s", datos)
+	}
+	if datos, _ := json.Marshal(&Libro{LibroID: 8}); strings.Contains(string(datos), "portada") {
+		t.Errorf("libro sin portada %s", datos)
+	}
+
+	// Con la version actual la portada se guarda en cache sin revalidar
+	anterior := libreria
+	t.Cleanup(func() { libreria = anterior })
+	libreria = &Libreria{Libros: []*Libro{libro}}
+	for url, cache := range map[string]string{
+		libro.URLPortada("pequena"):              "immutable",
+		"/portada?libroID=7&tam=pequena&v=viejo": "max-age=3600",
+	} {
+		w := httptest.NewRecorder()
+		servirPortada(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" || !strings.Contains(w.Header().Get("Cache-Control"), cache) {
+			t.Errorf("%s: %d %v", url, w.Code, w.Header())
+		}
+	}
+}
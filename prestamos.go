@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Duracion de un prestamo de un ejemplar fisico
+const plazoPrestamoFisico = 14 * 24 * time.Hour
+
+/*
+Candado de la circulacion. Los prestamos, las reservas y la disponibilidad del
+inventario cambian juntos, por lo que cada operacion que los modifica lo toma
+completo para no entregar dos veces la misma licencia o el mismo ejemplar. La
+importacion de CSV tambien lo toma para que nada cambie entre revisar y guardar.
+Las consultas de prestamos vigentes y licencias libres lo toman para lectura.
+*/
+var muCirculacion sync.RWMutex
+
+// Prestamo fisico sin devolver del ejemplar, o nil si el ejemplar esta en la biblioteca
+func prestamoEjemplar(inventarioID int) *Prestamo {
+	for _, p := range listadoprestamo.Prestamos {
+		if !p.Digital && !p.Devuelto && p.InventarioID == inventarioID {
+			return p
+		}
+	}
+	return nil
+}
+
+func (lp *Listadoprestamo) siguienteID() int {
+	id := 0
+	for _, p := range lp.Prestamos {
+		id = max(id, p.PrestamoID)
+	}
+	return id + 1
+}
+
+// Ejemplar del formulario, por su ID de inventario o por su codigo de barras
+func ejemplarFormulario(r *http.Request) (*Inventario, int, error) {
+	if codigo := strings.TrimSpace(r.FormValue("codigo_barras")); codigo != "" {
+		inv, err := listadoinventario.BuscarCodigo(codigo)
+		if err != nil {
+			return nil, http.StatusNotFound, err
+		}
+		return inv, 0, nil
+	}
+	id, err := strconv.Atoi(r.FormValue("inventarioID"))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("indique el inventarioID o el código de barras del ejemplar")
+	}
+	inv, err := listadoinventario.BuscarID(id)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	return inv, 0, nil
+}
+
+// Guarda los prestamos junto con las reservas y el inventario que cambian con ellos
+func guardarPrestamos() error {
+	if err := saveToJSON(listadoprestamo.Prestamos, "prestamos.json"); err != nil {
+		return err
+	}
+	return guardarReservas()
+}
+
+/*
+Presta un ejemplar fisico a un usuario. Un ejemplar apartado solo se presta al
+usuario de la reserva, que queda cumplida; si el usuario tenia una reserva
+pendiente de la misma obra tambien se da por cumplida.
+*/
+func prestarEjemplar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	muCirculacion.Lock()
+	defer muCirculacion.Unlock()
+	vencerReservas()
+
+	inv, codigo, err := ejemplarFormulario(r)
+	if err != nil {
+		http.Error(w, err.Error(), codigo)
+		return
+	}
+	usuarioID, err := strconv.Atoi(r.FormValue("usuarioID"))
+	if err != nil {
+		http.Error(w, "El ID del usuario debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	cuenta := buscarCuentaID(usuarioID)
+	if cuenta == nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if !cuenta.EstaActiva() {
+		http.Error(w, "La cuenta del usuario no está activa", http.StatusConflict)
+		return
+	}
+	if prestamoEjemplar(inv.InventarioId) != nil {
+		http.Error(w, "El ejemplar ya está prestado", http.StatusConflict)
+		return
+	}
+
+	var cumplida *Reserva
+	for _, res := range listadoreserva.Reservas {
+		if res.Estado == ReservaLista && res.InventarioID == inv.InventarioId {
+			if res.UsuarioID != usuarioID {
+				http.Error(w, "El ejemplar está apartado para otra reserva", http.StatusConflict)
+				return
+			}
+			cumplida = res
+		}
+	}
+	if cumplida == nil {
+		if !inv.IsDisponible() {
+			http.Error(w, "El ejemplar no está disponible", http.StatusConflict)
+			return
+		}
+		for _, res := range listadoreserva.Reservas {
+			if res.UsuarioID == usuarioID && res.Estado == ReservaPendiente && res.acepta(inv.LibroID) {
+				cumplida = res
+				break
+			}
+		}
+	}
+
+	ahora := time.Now()
+	prestamo, err := nuevoPrestamo(listadoprestamo.siguienteID(), inv.LibroID, usuarioID, ahora, ahora.Add(plazoPrestamoFisico))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prestamo.InventarioID = inv.InventarioId
+	inv.SetDisponible(false)
+	if cumplida != nil {
+		cumplida.Estado = ReservaCumplida
+		cumplida.InventarioID = inv.InventarioId
+		cumplida.Vence = nil
+	}
+	listadoprestamo.Prestamos = append(listadoprestamo.Prestamos, prestamo)
+	prefijos.Invalidar()
+	if err := guardarPrestamos(); err != nil {
+		http.Error(w, "Error al guardar el préstamo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(prestamo)
+}
+
+// Registra la devolucion de un ejemplar fisico y lo aparta para la siguiente reserva de la cola
+func devolverEjemplar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	muCirculacion.Lock()
+	defer muCirculacion.Unlock()
+
+	inv, codigo, err := ejemplarFormulario(r)
+	if err != nil {
+		http.Error(w, err.Error(), codigo)
+		return
+	}
+	prestamo := prestamoEjemplar(inv.InventarioId)
+	if prestamo == nil {
+		http.Error(w, "El ejemplar no está prestado", http.StatusConflict)
+		return
+	}
+
+	prestamo.Devuelto = true
+	prestamo.FechaDevolucion = time.Now()
+	inv.SetDisponible(true)
+	mensaje := "Ejemplar devuelto correctamente"
+	if res := listadoreserva.atender(inv); res != nil {
+		mensaje += "; quedó apartado para la reserva " + strconv.Itoa(res.ReservaID)
+	}
+	prefijos.Invalidar()
+	if err := guardarPrestamos(); err != nil {
+		http.Error(w, "Error al guardar la devolución", http.StatusInternalServerError)
+		return
+	}
+
+	responderJSON(w, Respuesta{Mensaje: mensaje})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Estados de una cuenta
+const (
+	EstadoActiva    = "activa"
+	EstadoPendiente = "pendiente"
+	EstadoRechazada = "rechazada"
+)
+
+// Las cuentas anteriores al registro publico no tienen estado y se consideran activas
+func (c *Cuenta) EstaActiva() bool {
+	return c.Estado == "" || c.Estado == EstadoActiva
+}
+
+/*
+Candado de las cuentas. Quien agrega una cuenta, cambia sus datos o guarda
+cuentas.json lo toma, para que dos registros a la vez no reciban el mismo ID ni
+el mismo correo y para no guardar la lista mientras otro la modifica.
+*/
+var muCuentas sync.Mutex
+
+// Siguiente ID libre para las cuentas creadas por el sistema; se llama con muCuentas tomado
+func siguienteCuentaID() int {
+	maxID := 0
+	for _, c := range listadocuenta.Cuentas {
+		if c.CuentaID > maxID {
+			maxID = c.CuentaID
+		}
+	}
+	return maxID + 1
+}
+
+// Codigo HTML para el registro publico de usuarios
+var registroTemplate = template.Must(template.New("registro").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Registro</title>
+</head>
+<body>
+	<h1>Solicitar una cuenta de usuario</h1>
+	<p>Su solicitud será revisada por un administrador antes de poder ingresar.</p>
+	<form action="/registro" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<label for="nombre">Nombre:</label>
+		<input type="text" id="nombre" name="nombre" required><br>
+		<label for="mail">Correo:</label>
+		<input type="email" id="mail" name="mail" required><br>
+		<label for="contrasena">Contraseña:</label>
+		<input type="password" id="contrasena" name="contrasena" required><br>
+		<button type="submit">Registrarse</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Codigo HTML para la cola de solicitudes pendientes
+var solicitudesTemplate = template.Must(template.New("solicitudes").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Solicitudes de registro</title>
+</head>
+<body>
+	<h1>Solicitudes de registro pendientes</h1>
+	{{if .Pendientes}}
+	<table>
+		<tr><th>Nombre</th><th>Correo</th><th>Correo verificado</th><th>Fecha</th><th>Aprobar</th><th>Rechazar</th></tr>
+		{{range .Pendientes}}
+		<tr>
+			<td>{{.Nombre}}</td>
+			<td>{{.Mail}}</td>
+			<td>{{if .MailVerificado}}Sí{{else}}No{{end}}</td>
+			<td>{{.FechaCreacion.Format "2006-01-02 15:04"}}</td>
+			<td>
+				<form action="/solicitudes" method="post">
+					<input type="hidden" name="csrf_token" value="{{$.CSRF}}">
+					<input type="hidden" name="id" value="{{.CuentaID}}">
+					<button type="submit" name="accion" value="aprobar">Aprobar</button>
+				</form>
+			</td>
+			<td>
+				<form action="/solicitudes" method="post">
+					<input type="hidden" name="csrf_token" value="{{$.CSRF}}">
+					<input type="hidden" name="id" value="{{.CuentaID}}">
+					<input type="text" name="motivo" placeholder="Motivo" required>
+					<button type="submit" name="accion" value="rechazar">Rechazar</button>
+				</form>
+			</td>
+		</tr>
+		{{end}}
+	</table>
+	{{else}}
+	<p>No hay solicitudes pendientes</p>
+	{{end}}
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+/*
+Funcion para el registro publico: la cuenta queda pendiente y siempre con rol de usuario.
+La respuesta es la misma aunque el correo ya tenga una cuenta, para no revelar cuales
+existen; en ese caso se avisa por correo a su dueno. Quien fue rechazado puede volver
+a solicitar la cuenta con el mismo correo.
+*/
+func registrarUsuario(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if err := registroTemplate.Execute(w, formulario(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Error al procesar el formulario", http.StatusBadRequest)
+			return
+		}
+
+		nombre := strings.TrimSpace(r.FormValue("nombre"))
+		mail := strings.TrimSpace(r.FormValue("mail"))
+		contrasena := r.FormValue("contrasena")
+
+		// El rol no se toma del formulario: el registro publico solo crea usuarios. La contrasena
+		// se cifra antes de buscar el correo para que el tiempo de respuesta no revele si existe,
+		// y el ID definitivo se asigna al agregar la cuenta
+		user, err := nuevaCuenta(1, nombre, mail, contrasena, RolUsuario)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		user.Estado = EstadoPendiente
+
+		muCuentas.Lock()
+		existente := buscarCuentaMail(mail)
+		avisar := existente != nil && existente.Estado != EstadoRechazada
+		switch {
+		case avisar:
+		case existente != nil:
+			// Quien fue rechazado vuelve a la cola con los datos de la nueva solicitud
+			existente.Nombre = user.Nombre
+			existente.Contrasena = user.Contrasena
+			existente.Estado = EstadoPendiente
+			existente.MotivoRechazo = ""
+			existente.MailVerificado = false
+			existente.FechaCreacion = user.FechaCreacion
+			user = existente
+		default:
+			user.CuentaID = siguienteCuentaID()
+			listadocuenta.Cuentas = append(listadocuenta.Cuentas, user)
+		}
+		if !avisar {
+			err = guardarCuentas(listadocuenta.Cuentas)
+		}
+		muCuentas.Unlock()
+		if err != nil {
+			http.Error(w, "Error al guardar las cuentas", http.StatusInternalServerError)
+			return
+		}
+
+		if avisar {
+			if err := avisarRegistroExistente(existente); err != nil {
+				log.Println("Error al avisar del registro con un correo existente:", err)
+			}
+		} else if err := enviarVerificacion(user); err != nil {
+			log.Println("Error al enviar el correo de verificación:", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(Respuesta{"Solicitud registrada, recibirá un correo cuando sea revisada"})
+	}
+}
+
+// Avisa al dueno de una cuenta que alguien intento registrarse con su correo
+func avisarRegistroExistente(c *Cuenta) error {
+	cuerpo := "Hola " + c.Nombre + ",\n\nAlguien solicitó una cuenta con este correo, que ya está registrado."
+	if c.Estado == EstadoPendiente {
+		cuerpo += " Su solicitud anterior sigue en revisión; recibirá un correo cuando un administrador la revise."
+	} else {
+		cuerpo += " Si fue usted, puede ingresar en " + urlBase() + "/login o restablecer la contraseña en " +
+			urlBase() + "/olvide-contrasena. Si no fue usted, puede ignorar este mensaje."
+	}
+	return correo.Enviar(c.Mail, "Solicitud de registro con su correo", cuerpo)
+}
+
+// Funcion para que los administradores aprueben o rechacen las solicitudes pendientes
+func revisarSolicitudes(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		pendientes := []*Cuenta{}
+		for _, c := range listadocuenta.Cuentas {
+			if c.Estado == EstadoPendiente {
+				pendientes = append(pendientes, c)
+			}
+		}
+		datos := struct {
+			CSRF       string
+			Pendientes []*Cuenta
+		}{tokenCSRF(r), pendientes}
+		if err := solicitudesTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Error al procesar el formulario", http.StatusBadRequest)
+			return
+		}
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+			return
+		}
+		accion, motivo := r.FormValue("accion"), strings.TrimSpace(r.FormValue("motivo"))
+		switch {
+		case accion != "aprobar" && accion != "rechazar":
+			http.Error(w, "Acción inválida", http.StatusBadRequest)
+			return
+		case accion == "rechazar" && motivo == "":
+			http.Error(w, "Debe indicar el motivo del rechazo", http.StatusBadRequest)
+			return
+		}
+
+		muCuentas.Lock()
+		c := buscarCuentaID(id)
+		if c == nil || c.Estado != EstadoPendiente {
+			muCuentas.Unlock()
+			http.Error(w, "No existe una solicitud pendiente con ese ID", http.StatusNotFound)
+			return
+		}
+		var asunto, cuerpo string
+		if accion == "aprobar" {
+			c.Estado = EstadoActiva
+			asunto = "Solicitud de cuenta aprobada"
+			cuerpo = "Hola " + c.Nombre + ",\n\nSu cuenta fue aprobada. Ya puede ingresar en " + urlBase() + "/login"
+		} else {
+			c.Estado = EstadoRechazada
+			c.MotivoRechazo = motivo
+			asunto = "Solicitud de cuenta rechazada"
+			cuerpo = "Hola " + c.Nombre + ",\n\nSu solicitud de cuenta fue rechazada por el siguiente motivo:\n" + motivo +
+				"\n\nPuede volver a solicitarla en " + urlBase() + "/registro"
+		}
+		err = guardarCuentas(listadocuenta.Cuentas)
+		muCuentas.Unlock()
+		if err != nil {
+			http.Error(w, "Error al guardar las cuentas", http.StatusInternalServerError)
+			return
+		}
+		if err := correo.Enviar(c.Mail, asunto, cuerpo); err != nil {
+			log.Println("Error al notificar al solicitante:", err)
+		}
+
+		http.Redirect(w, r, "/solicitudes", http.StatusSeeOther)
+	}
+}
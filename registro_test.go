@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Cuentas de prueba: una administradora y un usuario activo
+func cuentasRegistroPrueba(t *testing.T) (admin, usuario *Cuenta) {
+	t.Helper()
+	enDirectorioTemporal(t)
+	anteriores, anteriorTokens, anteriorIteraciones := listadocuenta.Cuentas, listadotoken.Tokens, iteracionesContrasena
+	t.Cleanup(func() {
+		listadocuenta.Cuentas, listadotoken.Tokens, iteracionesContrasena = anteriores, anteriorTokens, anteriorIteraciones
+	})
+	iteracionesContrasena = 1000
+
+	admin, _ = nuevaCuenta(1, "Jazmin", "jazmin@correo.com", "clave-admin", RolAdministrador)
+	usuario, _ = nuevaCuenta(2, "Juan", "juan@correo.com", "clave-juan", RolUsuario)
+	listadocuenta.Cuentas = []*Cuenta{admin, usuario}
+	listadotoken.Tokens = nil
+	return admin, usuario
+}
+
+func registrar(nombre, mail, contrasena string) *httptest.ResponseRecorder {
+	return enviarFormulario(registrarUsuario, url.Values{"nombre": {nombre}, "mail": {mail}, "contrasena": {contrasena}, "rol": {"administrador"}})
+}
+
+func TestRegistrarUsuario(t *testing.T) {
+	cuentasRegistroPrueba(t)
+	correos := capturarCorreos(t)
+
+	nuevo := registrar("Ana", "ana@correo.com", "clave-ana")
+	if nuevo.Code != http.StatusAccepted {
+		t.Fatalf("registro = %d: %s", nuevo.Code, nuevo.Body)
+	}
+	ana := buscarCuentaMail("ana@correo.com")
+	if ana == nil || ana.CuentaID != 3 || ana.Estado != EstadoPendiente || ana.TieneRol(RolAdministrador) || !ana.ComprobarContrasena("clave-ana") {
+		t.Fatalf("cuenta registrada %+v", ana)
+	}
+	if len(correos.enviados) != 1 || correos.enviados[0].Para != "ana@correo.com" || !strings.Contains(correos.enviados[0].Cuerpo, "/verificar-correo?token=") {
+		t.Errorf("correos enviados %+v", correos.enviados)
+	}
+
+	// Con un correo que ya tiene cuenta la respuesta es igual, no se crea otra y se avisa al dueno
+	existente := registrar("Otro Juan", "JUAN@correo.com", "otra-clave")
+	if existente.Code != nuevo.Code || existente.Body.String() != nuevo.Body.String() {
+		t.Errorf("registro con correo existente = %d %q, con correo nuevo = %d %q", existente.Code, existente.Body, nuevo.Code, nuevo.Body)
+	}
+	juan := buscarCuentaMail("juan@correo.com")
+	if len(listadocuenta.Cuentas) != 3 || juan.Nombre != "Juan" || !juan.ComprobarContrasena("clave-juan") {
+		t.Errorf("el registro cambió las cuentas: %d cuentas, %+v", len(listadocuenta.Cuentas), juan)
+	}
+	if aviso := correos.enviados[len(correos.enviados)-1]; aviso.Para != "juan@correo.com" || !strings.Contains(aviso.Cuerpo, "ya está registrado") {
+		t.Errorf("aviso al dueño %+v", aviso)
+	}
+	if len(listadotoken.Tokens) != 1 {
+		t.Errorf("se generaron %d tokens de verificación", len(listadotoken.Tokens))
+	}
+}
+
+func TestRevisarSolicitudes(t *testing.T) {
+	cuentasRegistroPrueba(t)
+	correos := capturarCorreos(t)
+	registrar("Ana", "ana@correo.com", "clave-ana")
+	registrar("Luis", "luis@correo.com", "clave-luis")
+	ana, luis := buscarCuentaMail("ana@correo.com"), buscarCuentaMail("luis@correo.com")
+
+	revisar := func(id int, accion, motivo string) *httptest.ResponseRecorder {
+		return enviarFormulario(revisarSolicitudes, url.Values{"id": {strconv.Itoa(id)}, "accion": {accion}, "motivo": {motivo}})
+	}
+	if w := revisar(luis.CuentaID, "rechazar", " "); w.Code != http.StatusBadRequest {
+		t.Errorf("rechazo sin motivo = %d", w.Code)
+	}
+	if w := revisar(2, "aprobar", ""); w.Code != http.StatusNotFound {
+		t.Errorf("aprobar una cuenta activa = %d", w.Code)
+	}
+	if w := revisar(ana.CuentaID, "aprobar", ""); w.Code != http.StatusSeeOther || !ana.EstaActiva() {
+		t.Errorf("aprobación = %d, estado %q", w.Code, ana.Estado)
+	}
+	if w := revisar(luis.CuentaID, "rechazar", "No es socio"); w.Code != http.StatusSeeOther || luis.Estado != EstadoRechazada || luis.MotivoRechazo != "No es socio" {
+		t.Errorf("rechazo = %d, cuenta %+v", w.Code, luis)
+	}
+	if aviso := correos.enviados[len(correos.enviados)-1]; aviso.Para != "luis@correo.com" || !strings.Contains(aviso.Cuerpo, "No es socio") || !strings.Contains(aviso.Cuerpo, "/registro") {
+		t.Errorf("aviso de rechazo %+v", aviso)
+	}
+
+	// Quien fue rechazado puede volver a solicitar la cuenta con el mismo correo
+	if w := registrar("Luis Pérez", "luis@correo.com", "clave-nueva"); w.Code != http.StatusAccepted {
+		t.Fatalf("nueva solicitud = %d", w.Code)
+	}
+	if luis.Estado != EstadoPendiente || luis.MotivoRechazo != "" || luis.Nombre != "Luis Pérez" || !luis.ComprobarContrasena("clave-nueva") || len(listadocuenta.Cuentas) != 4 {
+		t.Errorf("nueva solicitud %+v con %d cuentas", luis, len(listadocuenta.Cuentas))
+	}
+
+	var cuentas []*Cuenta
+	if err := loadFromJSON("cuentas.json", &cuentas); err != nil || len(cuentas) != 4 || cuentas[2].Estado != EstadoActiva {
+		t.Errorf("cuentas.json: %v", err)
+	}
+}
+
+func TestRegistrosSimultaneos(t *testing.T) {
+	cuentasRegistroPrueba(t)
+	capturarCorreos(t)
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			registrar("Lector", "lector"+strconv.Itoa(i)+"@correo.com", "clave-lector")
+		}()
+	}
+	wg.Wait()
+
+	ids := map[int]bool{}
+	for _, c := range listadocuenta.Cuentas {
+		ids[c.CuentaID] = true
+	}
+	if len(listadocuenta.Cuentas) != 12 || len(ids) != 12 {
+		t.Errorf("%d cuentas con %d IDs distintos", len(listadocuenta.Cuentas), len(ids))
+	}
+}
+
+func TestRequiereRol(t *testing.T) {
+	admin, usuario := cuentasRegistroPrueba(t)
+	pedir := func(c *Cuenta) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/visualizar-cuentas", nil)
+		if c != nil {
+			sesion := httptest.NewRecorder()
+			if err := iniciarSesion(sesion, r, c); err != nil {
+				t.Fatal(err)
+			}
+			r.AddCookie(sesion.Result().Cookies()[0])
+		}
+		w := httptest.NewRecorder()
+		requiereRol(RolAdministrador, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })(w, r)
+		return w
+	}
+
+	if w := pedir(nil); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Errorf("sin sesión = %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := pedir(usuario); w.Code != http.StatusForbidden {
+		t.Errorf("usuario = %d", w.Code)
+	}
+	if w := pedir(admin); w.Code != http.StatusNoContent {
+		t.Errorf("administradora = %d", w.Code)
+	}
+
+	// Una cuenta pendiente no conserva la sesion
+	admin.Estado = EstadoPendiente
+	if w := pedir(admin); w.Code != http.StatusSeeOther {
+		t.Errorf("cuenta pendiente = %d", w.Code)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Estado de una reserva
+type EstadoReserva string
+
+const (
+	ReservaPendiente EstadoReserva = "pendiente" // en la cola esperando un ejemplar
+	ReservaLista     EstadoReserva = "lista"     // hay un ejemplar apartado para el usuario
+	ReservaCumplida  EstadoReserva = "cumplida"  // el usuario se llevo el ejemplar en prestamo
+	ReservaVencida   EstadoReserva = "vencida"   // el usuario no retiro el ejemplar a tiempo
+	ReservaCancelada EstadoReserva = "cancelada"
+)
+
+// Tiempo que un ejemplar queda apartado para una reserva lista antes de pasar al siguiente de la cola
+const plazoRetiroReserva = 3 * 24 * time.Hour
+
+/*
+Reserva de una obra. Con LibroID en 0 el usuario acepta cualquier edicion o
+traduccion de la obra; si no, solo la edicion indicada. Las reservas pendientes se
+atienden en orden de solicitud cuando un ejemplar que les sirve queda disponible.
+*/
+type Reserva struct {
+	ReservaID      int           `json:"id"`
+	UsuarioID      int           `json:"usuario_id"`
+	ObraID         int           `json:"obra_id"`
+	LibroID        int           `json:"libro_id,omitempty"`
+	InventarioID   int           `json:"inventario_id,omitempty"` // ejemplar apartado cuando esta lista
+	Estado         EstadoReserva `json:"estado"`
+	FechaSolicitud time.Time     `json:"fecha_solicitud"`
+	Vence          *time.Time    `json:"vence,omitempty"` // fecha limite para retirar el ejemplar apartado
+}
+
+// Creamos la estructura reserva con slice para guardar la cola de reservas
+type Listadoreserva struct {
+	Reservas []*Reserva
+}
+
+var listadoreserva Listadoreserva
+
+func (lr *Listadoreserva) BuscarID(id int) (*Reserva, error) {
+	for _, res := range lr.Reservas {
+		if res.ReservaID == id {
+			return res, nil
+		}
+	}
+	return nil, errors.New("reserva no encontrada con el ID digitado")
+}
+
+func (lr *Listadoreserva) siguienteID() int {
+	id := 0
+	for _, res := range lr.Reservas {
+		id = max(id, res.ReservaID)
+	}
+	return id + 1
+}
+
+// Una reserva pendiente o lista sigue en curso; las demas ya terminaron
+func (res *Reserva) Activa() bool {
+	return res.Estado == ReservaPendiente || res.Estado == ReservaLista
+}
+
+// Indica si un ejemplar del libro sirve para la reserva
+func (res *Reserva) acepta(libroID int) bool {
+	if res.LibroID != 0 {
+		return res.LibroID == libroID
+	}
+	libro, err := libreria.BuscarID(libroID)
+	return err == nil && libro.ObraID == res.ObraID
+}
+
+// Posicion en la cola de la obra (1 es la siguiente); 0 si la reserva no esta pendiente
+func (lr *Listadoreserva) posicion(res *Reserva) int {
+	if res.Estado != ReservaPendiente {
+		return 0
+	}
+	pos := 1
+	for _, otra := range lr.Reservas {
+		if otra == res {
+			break
+		}
+		if otra.Estado == ReservaPendiente && otra.ObraID == res.ObraID {
+			pos++
+		}
+	}
+	return pos
+}
+
+// Aparta el ejemplar para la reserva, que tiene plazoRetiroReserva para retirarlo
+func apartarEjemplar(res *Reserva, inv *Inventario) {
+	inv.SetDisponible(false)
+	vence := time.Now().Add(plazoRetiroReserva)
+	res.InventarioID = inv.InventarioId
+	res.Estado = ReservaLista
+	res.Vence = &vence
+}
+
+// Devuelve al inventario el ejemplar apartado para la reserva y lo ofrece a la siguiente de la cola
+func liberarEjemplar(res *Reserva) {
+	apartado := res.InventarioID
+	res.InventarioID = 0
+	if apartado == 0 {
+		return
+	}
+	for _, inv := range listadoinventario.Inventarios {
+		if inv.InventarioId == apartado {
+			inv.SetDisponible(true)
+			listadoreserva.atender(inv)
+		}
+	}
+}
+
+/*
+Las reservas listas cuyo plazo de retiro ya paso quedan vencidas y su ejemplar pasa
+a la siguiente reserva de la cola. Devuelve si alguna reserva cambio.
+*/
+func (lr *Listadoreserva) vencer(ahora time.Time) bool {
+	// Primero se eligen las vencidas para no vencer en la misma pasada a las que reciben su ejemplar
+	var vencidas []*Reserva
+	for _, res := range lr.Reservas {
+		if res.Estado == ReservaLista && res.Vence != nil && !ahora.Before(*res.Vence) {
+			vencidas = append(vencidas, res)
+		}
+	}
+	for _, res := range vencidas {
+		res.Estado = ReservaVencida
+		liberarEjemplar(res)
+	}
+	return len(vencidas) > 0
+}
+
+// Vence las reservas no retiradas y guarda los cambios; se llama con muCirculacion tomado antes de consultar las reservas
+func vencerReservas() {
+	if listadoreserva.vencer(time.Now()) {
+		if err := guardarReservas(); err != nil {
+			log.Println("Error al guardar las reservas:", err)
+		}
+	}
+}
+
+/*
+Ofrece un ejemplar que acaba de quedar disponible a la reserva pendiente mas antigua
+que lo acepta. Devuelve la reserva atendida o nil si nadie lo esperaba.
+*/
+func (lr *Listadoreserva) atender(inv *Inventario) *Reserva {
+	if !inv.IsDisponible() {
+		return nil
+	}
+	for _, res := range lr.Reservas {
+		if res.Estado == ReservaPendiente && res.acepta(inv.LibroID) {
+			apartarEjemplar(res, inv)
+			return res
+		}
+	}
+	return nil
+}
+
+/*
+Carga las reservas al iniciar; si aun no existe reservas.json la cola empieza vacia.
+Los ejemplares de las reservas listas se vuelven a marcar como apartados y las que
+vencieron mientras el servidor estaba detenido pasan a la siguiente reserva.
+*/
+func cargarReservas() error {
+	err := loadFromJSON("reservas.json", &listadoreserva.Reservas)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	ahora := time.Now()
+	for _, res := range listadoreserva.Reservas {
+		if res.Estado != ReservaLista {
+			continue
+		}
+		// Las reservas listas anteriores al plazo de retiro reciben el plazo completo
+		if res.Vence == nil {
+			vence := ahora.Add(plazoRetiroReserva)
+			res.Vence = &vence
+		}
+		for _, inv := range listadoinventario.Inventarios {
+			if inv.InventarioId == res.InventarioID {
+				inv.SetDisponible(false)
+			}
+		}
+	}
+	if listadoreserva.vencer(ahora) {
+		return guardarReservas()
+	}
+	return nil
+}
+
+// Guarda las reservas y el inventario, que cambian juntos al apartar un ejemplar
+func guardarReservas() error {
+	if err := saveToJSON(listadoreserva.Reservas, "reservas.json"); err != nil {
+		return err
+	}
+	return saveToJSON(listadoinventario.Inventarios, "inventario.json")
+}
+
+// Reserva con su posicion en la cola y el titulo de la obra, para las respuestas
+type EstadoReservaUsuario struct {
+	*Reserva
+	Obra     string `json:"obra"`
+	Posicion int    `json:"posicion,omitempty"`
+}
+
+func estadoReserva(res *Reserva) EstadoReservaUsuario {
+	estado := EstadoReservaUsuario{Reserva: res, Posicion: listadoreserva.posicion(res)}
+	if o, err := listadoobra.BuscarID(res.ObraID); err == nil {
+		estado.Obra = o.Titulo
+	}
+	return estado
+}
+
+// Funcion para reservar una obra (cualquier edicion) o una edicion concreta (libroID)
+func reservar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	cuenta := cuentaActual(r)
+	muCirculacion.Lock()
+	defer muCirculacion.Unlock()
+	vencerReservas()
+
+	obraID, err := strconv.Atoi(r.FormValue("obraID"))
+	if err != nil {
+		http.Error(w, "El ID de la obra debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	obra, err := listadoobra.BuscarID(obraID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	libroID, err := strconv.Atoi(cmp.Or(r.FormValue("libroID"), "0"))
+	if err != nil {
+		http.Error(w, "El ID del libro debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	if libroID != 0 {
+		libro, err := libreria.BuscarID(libroID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if libro.ObraID != obra.ObraID {
+			http.Error(w, "El libro no es una edición de la obra", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(obra.Ediciones()) == 0 {
+		http.Error(w, "La obra no tiene ediciones en el catálogo", http.StatusConflict)
+		return
+	}
+	for _, res := range listadoreserva.Reservas {
+		if res.UsuarioID == cuenta.CuentaID && res.ObraID == obraID && res.Activa() {
+			http.Error(w, "Ya tiene una reserva activa de esta obra", http.StatusConflict)
+			return
+		}
+	}
+
+	res := &Reserva{
+		ReservaID:      listadoreserva.siguienteID(),
+		UsuarioID:      cuenta.CuentaID,
+		ObraID:         obraID,
+		LibroID:        libroID,
+		Estado:         ReservaPendiente,
+		FechaSolicitud: time.Now(),
+	}
+	listadoreserva.Reservas = append(listadoreserva.Reservas, res)
+	// Si ya hay un ejemplar disponible que le sirve se aparta de inmediato
+	for _, inv := range listadoinventario.Inventarios {
+		if inv.IsDisponible() && res.acepta(inv.LibroID) {
+			apartarEjemplar(res, inv)
+			break
+		}
+	}
+	if err := guardarReservas(); err != nil {
+		http.Error(w, "Error al guardar las reservas", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(estadoReserva(res))
+}
+
+// Funcion para ver las reservas de la cuenta actual
+func misReservas(w http.ResponseWriter, r *http.Request) {
+	cuenta := cuentaActual(r)
+	muCirculacion.Lock()
+	defer muCirculacion.Unlock()
+	vencerReservas()
+	reservas := []EstadoReservaUsuario{}
+	for _, res := range listadoreserva.Reservas {
+		if res.UsuarioID == cuenta.CuentaID && res.Estado != ReservaCancelada {
+			reservas = append(reservas, estadoReserva(res))
+		}
+	}
+	responderJSON(w, reservas)
+}
+
+// Funcion para cancelar una reserva; el ejemplar apartado pasa a la siguiente reserva de la cola
+func cancelarReserva(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	cuenta := cuentaActual(r)
+	muCirculacion.Lock()
+	defer muCirculacion.Unlock()
+	vencerReservas()
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "El ID debe ser un número entero", http.StatusBadRequest)
+		return
+	}
+	res, err := listadoreserva.BuscarID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if res.UsuarioID != cuenta.CuentaID && !cuenta.TieneRol(RolAdministrador) {
+		http.Error(w, "No tiene permisos para esta acción", http.StatusForbidden)
+		return
+	}
+	if !res.Activa() {
+		http.Error(w, "La reserva ya está "+string(res.Estado), http.StatusConflict)
+		return
+	}
+
+	res.Estado = ReservaCancelada
+	liberarEjemplar(res)
+	if err := guardarReservas(); err != nil {
+		http.Error(w, "Error al guardar las reservas", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Respuesta{"Reserva cancelada correctamente"})
+}
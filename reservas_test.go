@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Un ejemplar del libro 1 y dos usuarios; guarda los archivos en un directorio temporal
+func reservasPrueba(t *testing.T) *Inventario {
+	enDirectorioTemporal(t)
+	inventario, reservas, prestamos, cuentas := listadoinventario.Inventarios, listadoreserva.Reservas, listadoprestamo.Prestamos, listadocuenta.Cuentas
+	t.Cleanup(func() {
+		listadoinventario.Inventarios, listadoreserva.Reservas, listadoprestamo.Prestamos, listadocuenta.Cuentas = inventario, reservas, prestamos, cuentas
+	})
+	ejemplar := &Inventario{InventarioId: 1, LibroID: 1, Disponible: true, CodigoBarras: "BIB-0001"}
+	listadoinventario.Inventarios = []*Inventario{ejemplar}
+	listadoreserva.Reservas = nil
+	listadoprestamo.Prestamos = nil
+	listadocuenta.Cuentas = []*Cuenta{{CuentaID: 1, Nombre: "Ana"}, {CuentaID: 2, Nombre: "Luis"}}
+	return ejemplar
+}
+
+func TestVencerReservas(t *testing.T) {
+	ejemplar := reservasPrueba(t)
+	primera := &Reserva{ReservaID: 1, UsuarioID: 1, ObraID: 1, LibroID: 1, Estado: ReservaPendiente}
+	segunda := &Reserva{ReservaID: 2, UsuarioID: 2, ObraID: 1, LibroID: 1, Estado: ReservaPendiente}
+	listadoreserva.Reservas = []*Reserva{primera, segunda}
+
+	if res := listadoreserva.atender(ejemplar); res != primera || ejemplar.IsDisponible() || primera.Vence == nil {
+		t.Fatalf("atender = %v, ejemplar disponible %v", res, ejemplar.IsDisponible())
+	}
+
+	// Antes del plazo nada cambia
+	if listadoreserva.vencer(time.Now()) {
+		t.Error("se venció una reserva dentro del plazo")
+	}
+
+	// Pasado el plazo el ejemplar pasa a la siguiente reserva de la cola
+	if !listadoreserva.vencer(primera.Vence.Add(time.Minute)) {
+		t.Fatal("la reserva no retirada no venció")
+	}
+	if primera.Estado != ReservaVencida || primera.InventarioID != 0 {
+		t.Errorf("primera reserva %+v", primera)
+	}
+	if segunda.Estado != ReservaLista || segunda.InventarioID != ejemplar.InventarioId || ejemplar.IsDisponible() {
+		t.Errorf("segunda reserva %+v, ejemplar disponible %v", segunda, ejemplar.IsDisponible())
+	}
+	if primera.Activa() || !segunda.Activa() {
+		t.Error("solo las reservas pendientes o listas están activas")
+	}
+}
+
+func TestPrestarYDevolverEjemplar(t *testing.T) {
+	ejemplar := reservasPrueba(t)
+	reserva := &Reserva{ReservaID: 1, UsuarioID: 1, ObraID: 1, LibroID: 1, Estado: ReservaPendiente}
+	listadoreserva.Reservas = []*Reserva{reserva}
+	listadoreserva.atender(ejemplar)
+
+	// El ejemplar apartado no se presta a otro usuario
+	if w := enviarFormulario(prestarEjemplar, url.Values{"codigo_barras": {"BIB-0001"}, "usuarioID": {"2"}}); w.Code != http.StatusConflict {
+		t.Errorf("préstamo a otro usuario: código %d", w.Code)
+	}
+
+	w := enviarFormulario(prestarEjemplar, url.Values{"inventarioID": {"1"}, "usuarioID": {"1"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("préstamo: código %d %s", w.Code, w.Body)
+	}
+	if reserva.Estado != ReservaCumplida || reserva.Activa() || ejemplar.IsDisponible() {
+		t.Errorf("tras el préstamo la reserva está %s y el ejemplar disponible %v", reserva.Estado, ejemplar.IsDisponible())
+	}
+	if p := prestamoEjemplar(1); p == nil || p.UsuarioID != 1 || !p.Vigente(time.Now()) {
+		t.Errorf("préstamo del ejemplar %+v", p)
+	}
+	if w := enviarFormulario(prestarEjemplar, url.Values{"inventarioID": {"1"}, "usuarioID": {"2"}}); w.Code != http.StatusConflict {
+		t.Errorf("préstamo de un ejemplar prestado: código %d", w.Code)
+	}
+
+	// Al devolverlo pasa a la reserva pendiente
+	otra := &Reserva{ReservaID: 2, UsuarioID: 2, ObraID: 1, LibroID: 1, Estado: ReservaPendiente}
+	listadoreserva.Reservas = append(listadoreserva.Reservas, otra)
+	if w := enviarFormulario(devolverEjemplar, url.Values{"codigo_barras": {"BIB-0001"}}); w.Code != http.StatusOK {
+		t.Fatalf("devolución: código %d %s", w.Code, w.Body)
+	}
+	if prestamoEjemplar(1) != nil {
+		t.Error("el préstamo sigue abierto tras la devolución")
+	}
+	if otra.Estado != ReservaLista || ejemplar.IsDisponible() {
+		t.Errorf("tras la devolución la reserva está %s y el ejemplar disponible %v", otra.Estado, ejemplar.IsDisponible())
+	}
+	if w := enviarFormulario(devolverEjemplar, url.Values{"inventarioID": {"1"}}); w.Code != http.StatusConflict {
+		t.Errorf("devolución de un ejemplar no prestado: código %d", w.Code)
+	}
+}
+
+func TestEliminarLibroReservado(t *testing.T) {
+	reservasPrueba(t)
+	anterior, anteriorIndice, anterioresObras := libreria, indice, listadoobra.Obras
+	t.Cleanup(func() { libreria, indice, listadoobra.Obras = anterior, anteriorIndice, anterioresObras })
+	// Dos ediciones de las Meditaciones, sin ejemplares, y el Manual de Epicteto
+	libreria = &Libreria{Libros: []*Libro{
+		{LibroID: 2, Titulo: "Meditaciones", ObraID: 1},
+		{LibroID: 3, Titulo: "Meditaciones", ObraID: 1},
+		{LibroID: 4, Titulo: "Manual", ObraID: 2},
+	}}
+	indice = nuevoIndice()
+	listadoinventario.Inventarios = nil
+	listadoobra.Obras = []*Obra{{ObraID: 1, Titulo: "Meditaciones"}, {ObraID: 2, Titulo: "Manual"}}
+	listadoreserva.Reservas = []*Reserva{
+		{ReservaID: 1, UsuarioID: 1, ObraID: 1, Estado: ReservaPendiente},
+		{ReservaID: 2, UsuarioID: 2, ObraID: 2, LibroID: 4, Estado: ReservaCancelada},
+	}
+	eliminar := func(id string) int {
+		return enviarFormulario(eliminarLibro, url.Values{"id": {id}}).Code
+	}
+
+	// La reserva de cualquier edicion solo protege la ultima
+	if codigo := eliminar("2"); codigo != http.StatusOK {
+		t.Fatalf("eliminar una de dos ediciones = %d", codigo)
+	}
+	if codigo := eliminar("3"); codigo != http.StatusConflict {
+		t.Errorf("eliminar la última edición reservada = %d", codigo)
+	}
+	if len(listadoobra.Obras) != 2 {
+		t.Errorf("obras %d", len(listadoobra.Obras))
+	}
+
+	// Sin reservas en curso la obra se elimina con su ultima edicion
+	if codigo := eliminar("4"); codigo != http.StatusOK {
+		t.Fatalf("eliminar con una reserva cancelada = %d", codigo)
+	}
+	if _, err := listadoobra.BuscarID(2); err == nil {
+		t.Error("la obra sin ediciones sigue en el catálogo")
+	}
+	var obras []*Obra
+	if err := loadFromJSON("obras.json", &obras); err != nil || len(obras) != 1 || obras[0].ObraID != 1 {
+		t.Errorf("obras.json: %v", err)
+	}
+
+	listadoreserva.Reservas[0].Estado = ReservaCancelada
+	listadoreserva.Reservas = append(listadoreserva.Reservas, &Reserva{ReservaID: 3, UsuarioID: 1, ObraID: 1, LibroID: 3, Estado: ReservaLista})
+	if codigo := eliminar("3"); codigo != http.StatusConflict {
+		t.Errorf("eliminar una edición con reserva lista = %d", codigo)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+// Nombre de la cookie y del campo oculto con el token CSRF
+const (
+	cookieCSRF = "csrf_token"
+	campoCSRF  = "csrf_token"
+	headerCSRF = "X-CSRF-Token"
+)
+
+// Tamano maximo del cuerpo de una peticion: el de un archivo subido mas el resto del formulario
+const maxCuerpoPeticion = maxTamanoArchivo + 1<<20
+
+type claveContexto string
+
+const claveCSRF claveContexto = "csrf"
+
+// Middleware compartido que agrega las cabeceras de seguridad y valida el token CSRF
+// de las peticiones que modifican datos (patron de doble envio: cookie + formulario)
+func seguridad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'; form-action 'self'; base-uri 'none'")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+
+		token := ""
+		if c, err := r.Cookie(cookieCSRF); err == nil && len(c.Value) == 64 {
+			token = c.Value
+		}
+		if token == "" {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				http.Error(w, "Error al generar el token CSRF", http.StatusInternalServerError)
+				return
+			}
+			token = hex.EncodeToString(b)
+			http.SetCookie(w, &http.Cookie{
+				Name:     cookieCSRF,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			// El cuerpo se limita antes de leer el formulario para no aceptar subidas sin fin
+			r.Body = http.MaxBytesReader(w, r.Body, maxCuerpoPeticion)
+			enviado := r.Header.Get(headerCSRF)
+			if enviado == "" {
+				var demasiado *http.MaxBytesError
+				if err := r.ParseMultipartForm(32 << 20); errors.As(err, &demasiado) {
+					http.Error(w, "La petición es demasiado grande", http.StatusRequestEntityTooLarge)
+					return
+				}
+				enviado = r.FormValue(campoCSRF)
+			}
+			if subtle.ConstantTimeCompare([]byte(enviado), []byte(token)) != 1 {
+				http.Error(w, "Token CSRF inválido", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claveCSRF, token)))
+	})
+}
+
+// Token CSRF de la peticion actual para incluirlo en los formularios
+func tokenCSRF(r *http.Request) string {
+	token, _ := r.Context().Value(claveCSRF).(string)
+	return token
+}
+
+// Datos basicos que reciben las plantillas con formularios
+func formulario(r *http.Request) map[string]string {
+	return map[string]string{"CSRF": tokenCSRF(r)}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Handler protegido que responde con el token CSRF que recibio
+var eco = seguridad(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(tokenCSRF(r)))
+}))
+
+// Primera visita: devuelve la cookie CSRF que entrega el middleware
+func cookieCSRFPrueba(t *testing.T) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	eco.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, c := range w.Result().Cookies() {
+		if c.Name == cookieCSRF {
+			if !c.HttpOnly || c.SameSite != http.SameSiteStrictMode || w.Body.String() != c.Value {
+				t.Errorf("cookie %+v, token en la plantilla %q", c, w.Body)
+			}
+			return c
+		}
+	}
+	t.Fatal("no se entregó la cookie CSRF")
+	return nil
+}
+
+func TestSeguridadCabeceras(t *testing.T) {
+	w := httptest.NewRecorder()
+	eco.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	for cabecera, valor := range map[string]string{"X-Frame-Options": "DENY", "X-Content-Type-Options": "nosniff", "Referrer-Policy": "same-origin"} {
+		if got := w.Header().Get(cabecera); got != valor {
+			t.Errorf("%s = %q, se esperaba %q", cabecera, got, valor)
+		}
+	}
+	if !strings.Contains(w.Header().Get("Content-Security-Policy"), "frame-ancestors 'none'") {
+		t.Errorf("Content-Security-Policy = %q", w.Header().Get("Content-Security-Policy"))
+	}
+}
+
+func TestSeguridadCSRF(t *testing.T) {
+	cookie := cookieCSRFPrueba(t)
+	otra := strings.Repeat("0", 64)
+	enviar := func(conCookie bool, campo, cabecera string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(url.Values{"csrf_token": {campo}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if conCookie {
+			r.AddCookie(cookie)
+		}
+		if cabecera != "" {
+			r.Header.Set(headerCSRF, cabecera)
+		}
+		w := httptest.NewRecorder()
+		eco.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	casos := []struct {
+		nombre          string
+		conCookie       bool
+		campo, cabecera string
+		want            int
+	}{
+		{"formulario", true, cookie.Value, "", http.StatusOK},
+		{"cabecera", true, "", cookie.Value, http.StatusOK},
+		{"sin token", true, "", "", http.StatusForbidden},
+		{"token distinto", true, otra, "", http.StatusForbidden},
+		{"cabecera distinta", true, cookie.Value, otra, http.StatusForbidden},
+		{"sin cookie", false, cookie.Value, "", http.StatusForbidden},
+	}
+	for _, caso := range casos {
+		if got := enviar(caso.conCookie, caso.campo, caso.cabecera); got != caso.want {
+			t.Errorf("%s = %d, se esperaba %d", caso.nombre, got, caso.want)
+		}
+	}
+}
+
+func TestSeguridadCuerpoGrande(t *testing.T) {
+	cookie := cookieCSRFPrueba(t)
+	var cuerpo bytes.Buffer
+	m := multipart.NewWriter(&cuerpo)
+	m.WriteField(campoCSRF, cookie.Value)
+	archivo, _ := m.CreateFormFile("archivo", "libro.epub")
+	archivo.Write(make([]byte, maxCuerpoPeticion))
+	m.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", &cuerpo)
+	r.Header.Set("Content-Type", m.FormDataContentType())
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	eco.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("cuerpo demasiado grande = %d", w.Code)
+	}
+}
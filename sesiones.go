@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Nombre de la cookie de sesion y duracion de las sesiones
+const (
+	cookieSesion   = "sesion"
+	duracionSesion = 12 * time.Hour
+)
+
+// Sesion iniciada por una cuenta
+type Sesion struct {
+	CuentaID int
+	Expira   time.Time
+}
+
+var (
+	sesiones   = map[string]*Sesion{}
+	muSesiones sync.Mutex
+)
+
+// Crea una sesion para la cuenta y entrega la cookie al navegador
+func iniciarSesion(w http.ResponseWriter, r *http.Request, c *Cuenta) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b)
+	expira := time.Now().Add(duracionSesion)
+
+	muSesiones.Lock()
+	sesiones[token] = &Sesion{CuentaID: c.CuentaID, Expira: expira}
+	muSesiones.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieSesion,
+		Value:    token,
+		Path:     "/",
+		Expires:  expira,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	muCuentas.Lock()
+	defer muCuentas.Unlock()
+	c.SetUltimoAcceso(time.Now())
+	return guardarCuentas(listadocuenta.Cuentas)
+}
+
+// Cuenta de la sesion actual, o nil si no hay una sesion valida
+func cuentaActual(r *http.Request) *Cuenta {
+	cookie, err := r.Cookie(cookieSesion)
+	if err != nil {
+		return nil
+	}
+
+	muSesiones.Lock()
+	s, ok := sesiones[cookie.Value]
+	if ok && time.Now().After(s.Expira) {
+		delete(sesiones, cookie.Value)
+		ok = false
+	}
+	muSesiones.Unlock()
+	if !ok {
+		return nil
+	}
+
+	c := buscarCuentaID(s.CuentaID)
+	if c == nil || !c.EstaActiva() {
+		return nil
+	}
+	return c
+}
+
+// Restringe un handler a las cuentas con el rol indicado
+func requiereRol(rol Rol, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := cuentaActual(r)
+		if c == nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if !c.TieneRol(rol) {
+			http.Error(w, "No tiene permisos para esta acción", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Codigo HTML para la pagina de inicio de sesion
+var loginTemplate = template.Must(template.New("login").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Iniciar sesión</title>
+</head>
+<body>
+	<h1>Iniciar sesión</h1>
+	<form action="/login" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<label for="mail">Correo:</label>
+		<input type="email" id="mail" name="mail" required><br>
+		<label for="contrasena">Contraseña:</label>
+		<input type="password" id="contrasena" name="contrasena" required><br>
+		<button type="submit">Ingresar</button>
+	</form>
+	{{if .OIDC}}<p><a href="/oidc/login">Ingresar con la cuenta institucional</a></p>{{end}}
+	<p><a href="/olvide-contrasena">Olvidé mi contraseña</a></p>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para iniciar sesion con correo y contraseña
+func login(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		datos := formulario(r)
+		if proveedorOIDC != nil {
+			datos["OIDC"] = "si"
+		}
+		if err := loginTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Error al procesar el formulario", http.StatusBadRequest)
+			return
+		}
+
+		c := buscarCuentaMail(r.FormValue("mail"))
+		if c == nil || !c.ComprobarContrasena(r.FormValue("contrasena")) {
+			http.Error(w, "Correo o contraseña incorrectos", http.StatusUnauthorized)
+			return
+		}
+		if !c.EstaActiva() {
+			http.Error(w, "La cuenta aún no ha sido aprobada", http.StatusForbidden)
+			return
+		}
+
+		if err := iniciarSesion(w, r, c); err != nil {
+			http.Error(w, "Error al iniciar sesión", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// Funcion para cerrar la sesion actual
+func logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(cookieSesion); err == nil {
+		muSesiones.Lock()
+		delete(sesiones, cookie.Value)
+		muSesiones.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: cookieSesion, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/away", http.StatusSeeOther)
+}
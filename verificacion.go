@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Tipos de token enviados por correo
+const (
+	TokenVerificacion   = "verificacion"
+	TokenRestablecer    = "restablecer"
+	duracionVerificar   = 24 * time.Hour
+	duracionRestablecer = time.Hour
+)
+
+// Token de un solo uso enviado por correo. Solo se guarda el hash del token.
+type TokenCorreo struct {
+	Hash     string    `json:"hash"`
+	CuentaID int       `json:"cuenta_id"`
+	Tipo     string    `json:"tipo"`
+	Expira   time.Time `json:"expira"`
+	Usado    bool      `json:"usado"`
+}
+
+type Listadotoken struct {
+	Tokens []*TokenCorreo
+}
+
+var (
+	listadotoken Listadotoken
+	muTokens     sync.Mutex
+)
+
+func hashToken(token string) string {
+	suma := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(suma[:])
+}
+
+// Genera un token aleatorio y guarda su hash con la fecha de expiracion
+func nuevoToken(cuentaID int, tipo string, duracion time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	muTokens.Lock()
+	defer muTokens.Unlock()
+	listadotoken.Tokens = append(listadotoken.Tokens, &TokenCorreo{
+		Hash:     hashToken(token),
+		CuentaID: cuentaID,
+		Tipo:     tipo,
+		Expira:   time.Now().Add(duracion),
+	})
+	return token, saveToJSON(listadotoken.Tokens, "tokens.json")
+}
+
+// Valida el token sin consumirlo
+func buscarToken(token, tipo string) (*TokenCorreo, error) {
+	muTokens.Lock()
+	defer muTokens.Unlock()
+	return buscarTokenVigente(token, tipo)
+}
+
+// Busca un token vigente; se llama con muTokens tomado
+func buscarTokenVigente(token, tipo string) (*TokenCorreo, error) {
+	hash := hashToken(token)
+	for _, t := range listadotoken.Tokens {
+		if t.Hash != hash || t.Tipo != tipo {
+			continue
+		}
+		if t.Usado {
+			return nil, errors.New("el enlace ya fue utilizado")
+		}
+		if time.Now().After(t.Expira) {
+			return nil, errors.New("el enlace ha expirado")
+		}
+		return t, nil
+	}
+	return nil, errors.New("enlace inválido")
+}
+
+var errGuardarToken = errors.New("error al guardar el token")
+
+/*
+Valida el token y marca como usados todos los de ese tipo de la misma cuenta.
+Se hace con el candado tomado para que dos peticiones con el mismo enlace no lo
+usen a la vez; si no se puede guardar tokens.json el token sigue vigente.
+*/
+func canjearToken(token, tipo string) (*TokenCorreo, error) {
+	muTokens.Lock()
+	defer muTokens.Unlock()
+	t, err := buscarTokenVigente(token, tipo)
+	if err != nil {
+		return nil, err
+	}
+	var usados []*TokenCorreo
+	for _, otro := range listadotoken.Tokens {
+		if otro.CuentaID == t.CuentaID && otro.Tipo == tipo && !otro.Usado {
+			otro.Usado = true
+			usados = append(usados, otro)
+		}
+	}
+	if err := saveToJSON(listadotoken.Tokens, "tokens.json"); err != nil {
+		for _, otro := range usados {
+			otro.Usado = false
+		}
+		return nil, errGuardarToken
+	}
+	return t, nil
+}
+
+// Envia al usuario el enlace para verificar su correo
+func enviarVerificacion(u *Cuenta) error {
+	token, err := nuevoToken(u.CuentaID, TokenVerificacion, duracionVerificar)
+	if err != nil {
+		return err
+	}
+	enlace := urlBase() + "/verificar-correo?token=" + url.QueryEscape(token)
+	cuerpo := "Hola " + u.Nombre + ",\n\nPara verificar tu correo visita el siguiente enlace:\n" +
+		enlace + "\n\nEl enlace expira en 24 horas."
+	return correo.Enviar(u.Mail, "Verifica tu correo", cuerpo)
+}
+
+// Envia al usuario el enlace para restablecer su contraseña
+func enviarRestablecer(u *Cuenta) error {
+	token, err := nuevoToken(u.CuentaID, TokenRestablecer, duracionRestablecer)
+	if err != nil {
+		return err
+	}
+	enlace := urlBase() + "/restablecer-contrasena?token=" + url.QueryEscape(token)
+	cuerpo := "Hola " + u.Nombre + ",\n\nPara restablecer tu contraseña visita el siguiente enlace:\n" +
+		enlace + "\n\nEl enlace expira en 1 hora. Si no lo solicitaste, ignora este correo."
+	return correo.Enviar(u.Mail, "Restablecer contraseña", cuerpo)
+}
+
+// Codigo HTML para solicitar el restablecimiento de contraseña
+var forgotTemplate = template.Must(template.New("olvide").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Olvidé mi contraseña</title>
+</head>
+<body>
+	<h1>Restablecer contraseña</h1>
+	<form action="/olvide-contrasena" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<label for="mail">Correo:</label>
+		<input type="email" id="mail" name="mail" required><br>
+		<button type="submit">Enviar enlace</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Codigo HTML para ingresar la nueva contraseña
+var resetTemplate = template.Must(template.New("restablecer").Parse(`
+<!DOCTYPE html>
+<html lang="es">
+<head>
+	<meta charset="UTF-8">
+	<title>Nueva contraseña</title>
+</head>
+<body>
+	<h1>Ingrese su nueva contraseña</h1>
+	<form action="/restablecer-contrasena" method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<label for="contrasena">Contraseña:</label>
+		<input type="password" id="contrasena" name="contrasena" required><br>
+		<button type="submit">Guardar</button>
+	</form>
+	<footer>
+		<p>Vuelve pronto</p>
+	</footer>
+</body>
+</html>
+`))
+
+// Funcion para verificar el correo a partir del enlace enviado
+func verificarCorreo(w http.ResponseWriter, r *http.Request) {
+	t, err := canjearToken(r.URL.Query().Get("token"), TokenVerificacion)
+	if errors.Is(err, errGuardarToken) {
+		http.Error(w, "Error al guardar el token", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	muCuentas.Lock()
+	defer muCuentas.Unlock()
+	user := buscarCuentaID(t.CuentaID)
+	if user == nil {
+		http.Error(w, "Cuenta no encontrada", http.StatusNotFound)
+		return
+	}
+
+	user.MailVerificado = true
+	if err := guardarCuentas(listadocuenta.Cuentas); err != nil {
+		http.Error(w, "Error al guardar las cuentas", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Respuesta{"Correo verificado correctamente"})
+}
+
+// Funcion para solicitar el enlace de restablecimiento de contraseña
+func olvideContrasena(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if err := forgotTemplate.Execute(w, formulario(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Error al procesar el formulario", http.StatusBadRequest)
+			return
+		}
+
+		// La respuesta es la misma exista o no el correo para no revelar cuentas
+		if user := buscarCuentaMail(r.FormValue("mail")); user != nil {
+			if err := enviarRestablecer(user); err != nil {
+				log.Println("Error al enviar el correo de restablecimiento:", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Respuesta{"Si el correo está registrado recibirá un enlace para restablecer la contraseña"})
+	}
+}
+
+// Funcion para establecer una nueva contraseña con el enlace recibido
+func restablecerContrasena(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		token := r.URL.Query().Get("token")
+		if _, err := buscarToken(token, TokenRestablecer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		datos := formulario(r)
+		datos["Token"] = token
+		if err := resetTemplate.Execute(w, datos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Error al procesar el formulario", http.StatusBadRequest)
+			return
+		}
+
+		contrasena := r.FormValue("contrasena")
+		if contrasena == "" {
+			http.Error(w, "La contraseña no puede estar vacía", http.StatusBadRequest)
+			return
+		}
+		t, err := canjearToken(r.FormValue("token"), TokenRestablecer)
+		if errors.Is(err, errGuardarToken) {
+			http.Error(w, "Error al guardar el token", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		muCuentas.Lock()
+		defer muCuentas.Unlock()
+		user := buscarCuentaID(t.CuentaID)
+		if user == nil {
+			http.Error(w, "Cuenta no encontrada", http.StatusNotFound)
+			return
+		}
+
+		if err := user.SetContrasena(contrasena); err != nil {
+			http.Error(w, "Error al guardar la contraseña", http.StatusInternalServerError)
+			return
+		}
+		if err := guardarCuentas(listadocuenta.Cuentas); err != nil {
+			http.Error(w, "Error al guardar las cuentas", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Respuesta{"Contraseña actualizada correctamente"})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Cuenta de prueba sin tokens, con un cifrado de contrasena rapido
+func cuentaVerificacionPrueba(t *testing.T) *Cuenta {
+	t.Helper()
+	enDirectorioTemporal(t)
+	anteriores, anteriorTokens, anteriorIteraciones := listadocuenta.Cuentas, listadotoken.Tokens, iteracionesContrasena
+	t.Cleanup(func() {
+		listadocuenta.Cuentas, listadotoken.Tokens, iteracionesContrasena = anteriores, anteriorTokens, anteriorIteraciones
+	})
+	iteracionesContrasena = 1000
+
+	c, _ := nuevaCuenta(1, "Ana", "ana@correo.com", "clave-ana", RolUsuario)
+	listadocuenta.Cuentas = []*Cuenta{c}
+	listadotoken.Tokens = nil
+	return c
+}
+
+// Token del enlace incluido en el ultimo correo enviado
+func tokenDelCorreo(t *testing.T, correos *correoPrueba) string {
+	t.Helper()
+	if len(correos.enviados) == 0 {
+		t.Fatal("no se envió ningún correo")
+	}
+	cuerpo := correos.enviados[len(correos.enviados)-1].Cuerpo
+	_, token, ok := strings.Cut(cuerpo, "?token=")
+	if !ok {
+		t.Fatalf("el correo no tiene enlace: %s", cuerpo)
+	}
+	token, _, _ = strings.Cut(token, "\n")
+	return token
+}
+
+func TestRestablecerContrasena(t *testing.T) {
+	ana := cuentaVerificacionPrueba(t)
+	correos := capturarCorreos(t)
+
+	// La respuesta es la misma para un correo que no existe, y no se envia nada
+	desconocido := enviarFormulario(olvideContrasena, url.Values{"mail": {"nadie@correo.com"}})
+	pedido := enviarFormulario(olvideContrasena, url.Values{"mail": {"ana@correo.com"}})
+	if desconocido.Body.String() != pedido.Body.String() || len(correos.enviados) != 1 {
+		t.Fatalf("respuestas %q y %q con %d correos", desconocido.Body, pedido.Body, len(correos.enviados))
+	}
+	token := tokenDelCorreo(t, correos)
+
+	w := httptest.NewRecorder()
+	restablecerContrasena(w, httptest.NewRequest(http.MethodGet, "/restablecer-contrasena?token="+token, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), token) {
+		t.Errorf("formulario = %d", w.Code)
+	}
+	if w := enviarFormulario(restablecerContrasena, url.Values{"token": {token}, "contrasena": {""}}); w.Code != http.StatusBadRequest {
+		t.Errorf("contraseña vacía = %d", w.Code)
+	}
+	if w := enviarFormulario(restablecerContrasena, url.Values{"token": {token}, "contrasena": {"clave-nueva"}}); w.Code != http.StatusOK {
+		t.Fatalf("restablecer = %d: %s", w.Code, w.Body)
+	}
+	if !ana.ComprobarContrasena("clave-nueva") || ana.ComprobarContrasena("clave-ana") {
+		t.Error("la contraseña no cambió")
+	}
+
+	// El enlace es de un solo uso
+	if w := enviarFormulario(restablecerContrasena, url.Values{"token": {token}, "contrasena": {"otra-clave"}}); w.Code != http.StatusBadRequest ||
+		!strings.Contains(w.Body.String(), "ya fue utilizado") || !ana.ComprobarContrasena("clave-nueva") {
+		t.Errorf("segundo uso = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestVerificarCorreo(t *testing.T) {
+	ana := cuentaVerificacionPrueba(t)
+	correos := capturarCorreos(t)
+	verificar := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		verificarCorreo(w, httptest.NewRequest(http.MethodGet, "/verificar-correo?token="+url.QueryEscape(token), nil))
+		return w
+	}
+
+	// Un enlace vencido o de otro tipo no verifica el correo
+	vencido, err := nuevoToken(ana.CuentaID, TokenVerificacion, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restablecer, _ := nuevoToken(ana.CuentaID, TokenRestablecer, time.Hour)
+	for _, token := range []string{vencido, restablecer, "inventado"} {
+		if w := verificar(token); w.Code != http.StatusBadRequest || ana.MailVerificado {
+			t.Errorf("token %q = %d", token, w.Code)
+		}
+	}
+	if w := verificar(vencido); !strings.Contains(w.Body.String(), "expirado") {
+		t.Errorf("token vencido: %s", w.Body)
+	}
+
+	if err := enviarVerificacion(ana); err != nil {
+		t.Fatal(err)
+	}
+	token := tokenDelCorreo(t, correos)
+	if w := verificar(token); w.Code != http.StatusOK || !ana.MailVerificado {
+		t.Fatalf("verificar = %d: %s", w.Code, w.Body)
+	}
+	if w := verificar(token); w.Code != http.StatusBadRequest {
+		t.Errorf("segundo uso = %d", w.Code)
+	}
+	// Verificar el correo no consume el enlace para restablecer la contrasena
+	if _, err := buscarToken(restablecer, TokenRestablecer); err != nil {
+		t.Errorf("el token de restablecimiento dejó de servir: %v", err)
+	}
+}
+
+func TestCanjearTokenSimultaneo(t *testing.T) {
+	ana := cuentaVerificacionPrueba(t)
+	token, err := nuevoToken(ana.CuentaID, TokenRestablecer, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var canjeados atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := canjearToken(token, TokenRestablecer); err == nil {
+				canjeados.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if canjeados.Load() != 1 {
+		t.Errorf("el token se canjeó %d veces", canjeados.Load())
+	}
+}